@@ -3,10 +3,12 @@ package api
 import (
 	"net/http"
 	"os"
-	"strings"
+	"sync"
 
 	"csz.net/tgstate/conf"
 	"csz.net/tgstate/control"
+	"csz.net/tgstate/i18n"
+	"csz.net/tgstate/store"
 )
 
 func Vercel(w http.ResponseWriter, r *http.Request) {
@@ -15,20 +17,81 @@ func Vercel(w http.ResponseWriter, r *http.Request) {
 	conf.Pass = os.Getenv("pass")
 	conf.Mode = os.Getenv("mode")
 	conf.BaseUrl = os.Getenv("url")
-	// 获取请求路径
-	path := r.URL.Path
-	// 如果请求路径以 "/img/" 开头
-	if strings.HasPrefix(path, conf.FileRoute) {
-		control.D(w, r)
-		return // 结束处理，确保不执行默认处理
+	conf.ChunkSize = 4 * 1024 * 1024 // Vercel 请求体限制约 4.5MB
+	// 函数返回后无法继续后台上传，也无法轮询结果，因此同步等待上传完成
+	conf.UploadWait = 0
+	conf.UploadConcurrency = 1
+	conf.UploadQueue = 8
+	conf.Maintenance, _ = control.ParseMaintenance(os.Getenv("maintenance"))
+	conf.MaintenanceMessage = os.Getenv("maintenancemsg")
+	conf.Lang = os.Getenv("lang")
+	i18n.Default = i18n.Normalize(conf.Lang)
+	conf.NoIndex = os.Getenv("noindex") == "true"
+	conf.RobotsFile = os.Getenv("robots")
+	conf.Sitemap = os.Getenv("sitemap") != "false"
+	conf.SignKey = os.Getenv("signkey")
+	conf.LegacyErrors = os.Getenv("legacyerrors") != "false"
+	if conf.Visibility = os.Getenv("visibility"); !store.ValidVisibility(conf.Visibility) {
+		conf.Visibility = store.VisibilityUnlisted
 	}
-	switch path {
-	case "/api":
-		// 调用 control 包中的 UploadImageAPI 处理函数
-		control.Middleware(control.UploadImageAPI)(w, r)
-	case "/pwd":
-		control.Pwd(w, r)
-	default:
-		control.Middleware(control.Index)(w, r)
+	conf.LegacyPaths = os.Getenv("legacy")
+	conf.LegacyProxy = os.Getenv("legacyproxy") == "true"
+	conf.ApiKeys = os.Getenv("apikeys")
+	conf.AuthIPs = os.Getenv("authips")
+	conf.OidcIssuer = os.Getenv("oidcissuer")
+	control.LoadAuth(os.Getenv("auth"))
+	conf.ThemeDir = os.Getenv("theme")
+	control.LoadTheme()
+	conf.DataDir = os.Getenv("data")
+	if conf.DataDir == "" {
+		conf.DataDir = "/tmp/tgstate"
 	}
+	routesOnce.Do(initRoutes)
+	control.RequestID(routes).ServeHTTP(w, r)
+}
+
+var (
+	routes     *control.Router
+	routesOnce sync.Once
+)
+
+// initRoutes 注册路由，只读镜像模式仅提供下载
+func initRoutes() {
+	routes = control.NewRouter()
+	get, post := http.MethodGet, http.MethodPost
+	download := func(h http.HandlerFunc) http.HandlerFunc {
+		return control.NoIndex(control.Maintenance(control.MaintenanceDownload, control.Auth(control.AuthDownload, h)))
+	}
+	routes.Handle(conf.FileRoute+"{id}", download(control.D), get)
+	routes.Handle(conf.FileRoute+"{id}/subs", download(control.DSubs), get)
+	routes.Handle(conf.ViewRoute+"{id}", download(control.View), get)
+	routes.Handle(control.HashRoute+"{sha}", download(control.Hash), get)
+	routes.Handle(control.FaviconRoute, control.Favicon, get)
+	routes.Handle(control.RobotsRoute, control.Robots, get)
+	routes.Handle(control.SitemapRoute, control.Auth(control.AuthDownload, control.Sitemap), get)
+	routes.Handle(control.StaticRoute+"{path...}", control.Static, get)
+	routes.Handle(control.QrRoute+"{id}", control.Qr, get)
+	routes.Handle(control.ShortRoute+"{slug}", control.Maintenance(control.MaintenanceDownload, control.Auth(control.AuthDownload, control.Short)), get)
+	routes.Handle(control.PasteRoute+"{id}", download(control.Paste), get)
+	routes.Handle(control.PasteRoute+"{id}/raw", download(control.PasteRaw), get)
+	for _, prefix := range control.LegacyPrefixes() {
+		routes.Handle(prefix+"{path...}", download(control.Legacy), get)
+	}
+	if conf.Mode == "r" {
+		return
+	}
+	// 接口同时注册在 /api/v1 下，旧路径作为弃用的别名保留
+	api := func(pattern string, h http.HandlerFunc, methods ...string) {
+		routes.Handle(control.V1Path(pattern), h, methods...)
+		routes.Handle(pattern, control.Deprecated(h), methods...)
+	}
+	api("/api", control.Maintenance(control.MaintenanceUpload, control.Auth(control.AuthUpload, control.UploadBody(control.UploadImageAPI))), post)
+	api("/api/paste", control.Maintenance(control.MaintenanceUpload, control.Auth(control.AuthUpload, control.SmallBody(control.PasteAPI))), post)
+	api("/api/shorten", control.Auth(control.AuthUpload, control.SmallBody(control.ShortenAPI)), post)
+	api(control.HealthRoute, control.Health, get)
+	routes.Handle("/api/openapi.json", control.OpenAPI, get)
+	routes.Handle("/api/docs", control.ApiDocs, get)
+	routes.Handle("/paste", control.Auth(control.AuthPage, control.PasteForm), get)
+	routes.Handle("/pwd", control.Pwd, get, post)
+	routes.Handle("/", control.Auth(control.AuthPage, control.Index), get)
 }
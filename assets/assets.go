@@ -5,4 +5,10 @@ import "embed"
 var (
 	//go:embed templates
 	Templates embed.FS
+
+	//go:embed static
+	Static embed.FS
+
+	//go:embed openapi.json
+	OpenAPI []byte
 )
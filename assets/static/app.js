@@ -0,0 +1,9 @@
+// 切换深色模式并记住选择
+function toggleColorScheme() {
+    var root = document.documentElement;
+    var mode = root.getAttribute("data-theme") ||
+        (window.matchMedia("(prefers-color-scheme: dark)").matches ? "dark" : "light");
+    mode = mode === "dark" ? "light" : "dark";
+    root.setAttribute("data-theme", mode);
+    localStorage.setItem("color-scheme", mode);
+}
@@ -0,0 +1,473 @@
+// 上传页面：拖放、粘贴或选择文件后依次上传，显示进度及外链
+(function () {
+    var limit = tgState.limit;
+    var origin = window.location.origin + tgState.base;
+    var base = origin + tgState.prefix;
+    var text = tgState.text;
+    var list = document.getElementById("fileList");
+    var input = document.getElementById("uploadFile");
+    var zone = document.getElementById("dropZone");
+    var visibility = document.getElementById("visibility");
+    // 文件依次上传，避免同时占用过多带宽
+    var queue = Promise.resolve();
+    var historyBox = document.getElementById("history");
+    var historyList = document.getElementById("historyList");
+    // 上传记录保存在浏览器中，没有账号时也能找回之前的链接
+    var historyKey = "upload-history";
+    var historyMax = 100;
+    // 与服务端单次检查的上限一致
+    var checkBatch = 50;
+    // 已知的链接状态，重新显示记录时沿用
+    var statuses = {};
+    // 当前显示的各记录的状态标签
+    var badges = {};
+
+    function formatSize(n) {
+        var units = ["B", "KB", "MB", "GB"];
+        var i = 0;
+        while (n >= 1024 && i < units.length - 1) {
+            n /= 1024;
+            i++;
+        }
+        return (i ? n.toFixed(1) : n) + " " + units[i];
+    }
+
+    function el(tag, className, content) {
+        var e = document.createElement(tag);
+        if (className) {
+            e.className = className;
+        }
+        if (content) {
+            e.textContent = content;
+        }
+        return e;
+    }
+
+    // addItem 在列表中添加文件，返回更新进度与结果的方法
+    function addItem(file) {
+        var li = el("li", "file-item");
+        var thumb;
+        if (file.type.startsWith("image/")) {
+            thumb = el("img", "file-thumb");
+            thumb.src = URL.createObjectURL(file);
+            thumb.onload = function () {
+                URL.revokeObjectURL(thumb.src);
+            };
+        } else {
+            var ext = file.name.lastIndexOf(".") > 0 ? file.name.split(".").pop().toUpperCase() : "FILE";
+            thumb = el("div", "file-thumb", ext.slice(0, 5));
+        }
+        var body = el("div", "file-body");
+        var meta = el("div", "file-meta", formatSize(file.size) + " · " + text.queued);
+        var bar = el("span");
+        var progress = el("div", "progress");
+        progress.appendChild(bar);
+        body.appendChild(el("div", "file-name", file.webkitRelativePath || file.name));
+        body.appendChild(meta);
+        body.appendChild(progress);
+        li.appendChild(thumb);
+        li.appendChild(body);
+        list.insertBefore(li, list.firstChild);
+        return {
+            progress: function (p) {
+                var pct = Math.min(100, Math.round(p * 100));
+                bar.style.width = pct + "%";
+                meta.textContent = formatSize(file.size) + " · " + (pct < 100 ? text.uploading + " " + pct + "%" : text.processing);
+            },
+            done: function (res) {
+                li.classList.add("done");
+                meta.textContent = formatSize(file.size) + " · " + text.done;
+                body.appendChild(links(res, file));
+                addHistory(res, file);
+            },
+            fail: function (msg) {
+                li.classList.add("error");
+                meta.textContent = text.failed + (msg ? " (" + msg + ")" : "");
+            }
+        };
+    }
+
+    // links 生成外链及各种格式的复制按钮
+    function links(res, file) {
+        var link = origin + res.message;
+        var isImage = file.type.startsWith("image/");
+        var wrap = el("div");
+        var a = el("a", "", link);
+        a.href = link;
+        a.target = "_blank";
+        wrap.appendChild(a);
+        var view = el("a", "", text.preview);
+        view.href = origin + res.message.replace(/\/d\//, "/v/");
+        view.target = "_blank";
+        view.style.marginLeft = "8px";
+        wrap.appendChild(view);
+        var formats = [["URL", link]];
+        if (isImage) {
+            formats.push(["Markdown", "![" + file.name + "](" + link + ")"]);
+            formats.push(["HTML", '<img src="' + link + '" alt="' + file.name + '">']);
+            formats.push(["BBCode", "[img]" + link + "[/img]"]);
+        } else {
+            formats.push(["Markdown", "[" + file.name + "](" + link + ")"]);
+            formats.push(["HTML", '<a href="' + link + '">' + file.name + "</a>"]);
+            formats.push(["BBCode", "[url=" + link + "]" + file.name + "[/url]"]);
+        }
+        var buttons = el("div", "copy-links");
+        formats.forEach(function (f) {
+            var b = el("button", "copy-code", f[0]);
+            b.type = "button";
+            b.onclick = function () {
+                copy(f[1]);
+                b.textContent = text.copied;
+                setTimeout(function () {
+                    b.textContent = f[0];
+                }, 1000);
+            };
+            buttons.appendChild(b);
+        });
+        wrap.appendChild(buttons);
+        var qr = el("div", "qr-code");
+        var img = el("img");
+        img.src = origin + res.message.replace(/\/d\//, "/qr/") + "?size=128";
+        img.alt = "QR Code";
+        img.loading = "lazy";
+        qr.appendChild(img);
+        wrap.appendChild(qr);
+        return wrap;
+    }
+
+    function loadHistory() {
+        try {
+            return JSON.parse(localStorage.getItem(historyKey)) || [];
+        } catch (e) {
+            return [];
+        }
+    }
+
+    function saveHistory(items) {
+        try {
+            localStorage.setItem(historyKey, JSON.stringify(items.slice(0, historyMax)));
+        } catch (e) {
+            // 隐私模式或存储已满时不保存
+        }
+    }
+
+    function fileId(path) {
+        var m = /\/d\/([^/?#]+)/.exec(path);
+        return m ? m[1] : "";
+    }
+
+    function addHistory(res, file) {
+        var id = fileId(res.message);
+        if (!id) {
+            return;
+        }
+        var items = loadHistory().filter(function (h) {
+            return h.id !== id;
+        });
+        items.unshift({ id: id, path: res.message, name: file.name, size: file.size, type: file.type, time: Date.now() });
+        statuses[id] = "ok";
+        saveHistory(items);
+        renderHistory(items);
+    }
+
+    // renderHistory 显示上传记录
+    function renderHistory(items) {
+        historyList.textContent = "";
+        historyBox.hidden = items.length === 0;
+        badges = {};
+        items.forEach(function (h) {
+            var li = el("li", "file-item done");
+            var thumb;
+            if (h.type && h.type.startsWith("image/")) {
+                thumb = el("img", "file-thumb");
+                thumb.src = origin + h.path;
+                thumb.loading = "lazy";
+            } else {
+                var ext = h.name.lastIndexOf(".") > 0 ? h.name.split(".").pop().toUpperCase() : "FILE";
+                thumb = el("div", "file-thumb", ext.slice(0, 5));
+            }
+            var body = el("div", "file-body");
+            var meta = el("div", "file-meta", formatSize(h.size) + " · " + new Date(h.time).toLocaleString());
+            badges[h.id] = el("span", "badge");
+            setBadge(badges[h.id], statuses[h.id]);
+            meta.appendChild(badges[h.id]);
+            body.appendChild(el("div", "file-name", h.name));
+            body.appendChild(meta);
+            body.appendChild(links({ message: h.path }, { name: h.name, type: h.type || "" }));
+            li.appendChild(thumb);
+            li.appendChild(body);
+            historyList.appendChild(li);
+        });
+    }
+
+    function setBadge(b, status) {
+        if (b && text.status[status]) {
+            b.className = "badge " + status;
+            b.textContent = text.status[status];
+        }
+    }
+
+    // checkHistory 向服务端确认记录中的链接是否仍可访问并显示状态
+    function checkHistory(items) {
+        for (var i = 0; i < items.length; i += checkBatch) {
+            var ids = items.slice(i, i + checkBatch).map(function (h) {
+                return h.id;
+            });
+            var headers = { "Content-Type": "application/json" };
+            if (tgState.csrf) {
+                headers["X-CSRF-Token"] = tgState.csrf;
+            }
+            fetch(base + "/api/check", { method: "POST", headers: headers, body: JSON.stringify({ ids: ids }) })
+                .then(function (resp) {
+                    return resp.ok ? resp.json() : { files: [] };
+                })
+                .then(function (res) {
+                    (res.files || []).forEach(function (f) {
+                        statuses[f.id] = f.status;
+                        setBadge(badges[f.id], f.status);
+                    });
+                })
+                .catch(function () { });
+        }
+    }
+
+    function copy(value) {
+        if (navigator.clipboard && window.isSecureContext) {
+            navigator.clipboard.writeText(value);
+            return;
+        }
+        var t = document.createElement("textarea");
+        t.value = value;
+        document.body.appendChild(t);
+        t.select();
+        document.execCommand("copy");
+        t.remove();
+    }
+
+    // post 上传单个文件或分块，排队中（202）时轮询结果；hash 不为空时由服务端校验收到的内容
+    function post(blob, name, onProgress, hash) {
+        return new Promise(function (resolve, reject) {
+            var form = new FormData();
+            form.append("image", blob, name);
+            var xhr = new XMLHttpRequest();
+            xhr.open("POST", base + "/api" + (visibility && visibility.value ? "?visibility=" + visibility.value : ""));
+            if (tgState.csrf) {
+                xhr.setRequestHeader("X-CSRF-Token", tgState.csrf);
+            }
+            if (hash) {
+                xhr.setRequestHeader("X-Content-Sha256", hash);
+            }
+            xhr.responseType = "json";
+            xhr.upload.onprogress = function (e) {
+                if (e.lengthComputable) {
+                    onProgress(e.loaded);
+                }
+            };
+            xhr.onload = function () {
+                var res = xhr.response || {};
+                if (xhr.status === 202 && xhr.getResponseHeader("Location")) {
+                    poll(window.location.origin + xhr.getResponseHeader("Location")).then(resolve, reject);
+                } else if (res.code == 1) {
+                    resolve(res);
+                } else {
+                    reject(res.message || xhr.statusText);
+                }
+            };
+            xhr.onerror = function () {
+                reject("");
+            };
+            xhr.send(form);
+        });
+    }
+
+    function poll(url) {
+        return new Promise(function (resolve) {
+            setTimeout(resolve, 2000);
+        }).then(function () {
+            return fetch(url).then(function (resp) {
+                if (resp.status === 202) {
+                    return poll(url);
+                }
+                return resp.json().then(function (res) {
+                    if (res.code != 1) {
+                        throw res.message;
+                    }
+                    return res;
+                });
+            });
+        });
+    }
+
+    // 计算文件或分块的 sha256，非安全上下文中不可用时返回空
+    function chunkHash(chunk) {
+        if (!window.crypto || !window.crypto.subtle || !chunk.arrayBuffer) {
+            return Promise.resolve("");
+        }
+        return chunk.arrayBuffer()
+            .then(function (buf) { return window.crypto.subtle.digest("SHA-256", buf); })
+            .then(function (sum) {
+                return Array.from(new Uint8Array(sum)).map(function (b) { return b.toString(16).padStart(2, "0"); }).join("");
+            })
+            .catch(function () { return ""; });
+    }
+
+    // uploadFile 小于分块大小的文件直接上传，否则分块上传后再上传 v2 分块清单
+    function uploadFile(file, item) {
+        if (file.size <= limit) {
+            return chunkHash(file).then(function (hash) {
+                return post(file, file.name, function (n) {
+                    item.progress(n / file.size);
+                }, hash);
+            });
+        }
+        var manifest = {
+            format: "tgstate-blob",
+            version: 2,
+            name: file.name,
+            size: file.size,
+            mime: file.type,
+            chunk_size: limit,
+            chunks: []
+        };
+        function next(start) {
+            if (start >= file.size) {
+                var blob = new Blob([JSON.stringify(manifest)], { type: "application/json" });
+                return post(blob, "fileAll.json", function () { });
+            }
+            var chunk = file.slice(start, Math.min(start + limit, file.size));
+            return chunkHash(chunk).then(function (hash) {
+                return post(chunk, "blob", function (n) {
+                    item.progress((start + n) / file.size);
+                }, hash).then(function (res) {
+                    var c = { id: res.message.replace(/^.*\/d\//, ""), size: chunk.size };
+                    if (hash) {
+                        c.sha256 = hash;
+                    }
+                    manifest.chunks.push(c);
+                    return next(start + chunk.size);
+                });
+            });
+        }
+        return next(0);
+    }
+
+    function addFiles(files) {
+        Array.prototype.forEach.call(files, function (file) {
+            var item = addItem(file);
+            queue = queue.then(function () {
+                item.progress(0);
+                return uploadFile(file, item).then(item.done, item.fail);
+            });
+        });
+    }
+
+    var history = loadHistory();
+    renderHistory(history);
+    checkHistory(history);
+    document.getElementById("clearHistory").addEventListener("click", function () {
+        if (window.confirm(text.clearHistory)) {
+            localStorage.removeItem(historyKey);
+            renderHistory([]);
+        }
+    });
+    // addFolder 依次上传文件夹中的文件，完成后按相对路径创建相册
+    function addFolder(files) {
+        files = Array.prototype.filter.call(files, function (file) {
+            return !/(^|\/)(\.DS_Store|Thumbs\.db)$/.test(file.webkitRelativePath);
+        });
+        if (!files.length) {
+            return;
+        }
+        var name = files[0].webkitRelativePath.split("/")[0];
+        var entries = [];
+        files.forEach(function (file) {
+            var item = addItem(file);
+            var path = file.webkitRelativePath;
+            queue = queue.then(function () {
+                item.progress(0);
+                return uploadFile(file, item).then(function (res) {
+                    item.done(res);
+                    entries.push({ path: path.slice(path.indexOf("/") + 1), id: fileId(res.message) });
+                }, item.fail);
+            });
+        });
+        queue = queue.then(function () {
+            if (entries.length) {
+                return createAlbum(name, entries);
+            }
+        });
+    }
+
+    // createAlbum 创建相册并在列表顶部显示相册链接
+    function createAlbum(name, entries) {
+        var li = el("li", "file-item");
+        var body = el("div", "file-body");
+        var meta = el("div", "file-meta", entries.length + " · " + text.processing);
+        body.appendChild(el("div", "file-name", name + "/"));
+        body.appendChild(meta);
+        li.appendChild(el("div", "file-thumb", text.album));
+        li.appendChild(body);
+        list.insertBefore(li, list.firstChild);
+        var headers = { "Content-Type": "application/json" };
+        if (tgState.csrf) {
+            headers["X-CSRF-Token"] = tgState.csrf;
+        }
+        return fetch(base + "/api/album", { method: "POST", headers: headers, body: JSON.stringify({ name: name, files: entries }) })
+            .then(function (resp) {
+                return resp.json();
+            })
+            .then(function (res) {
+                if (res.code != 1) {
+                    throw res.message;
+                }
+                var link = origin + res.message;
+                li.classList.add("done");
+                meta.textContent = entries.length + " · " + text.done;
+                var a = el("a", "", link);
+                a.href = link;
+                a.target = "_blank";
+                body.appendChild(a);
+            })
+            .catch(function (msg) {
+                li.classList.add("error");
+                meta.textContent = text.failed + (typeof msg === "string" && msg ? " (" + msg + ")" : "");
+            });
+    }
+
+    var folder = document.getElementById("uploadFolder");
+    if (folder) {
+        folder.addEventListener("change", function () {
+            addFolder(folder.files);
+            folder.value = "";
+        });
+    }
+    input.addEventListener("change", function () {
+        addFiles(input.files);
+        input.value = "";
+    });
+    ["dragenter", "dragover"].forEach(function (type) {
+        zone.addEventListener(type, function (e) {
+            e.preventDefault();
+            zone.classList.add("dragover");
+        });
+    });
+    ["dragleave", "drop"].forEach(function (type) {
+        zone.addEventListener(type, function (e) {
+            e.preventDefault();
+            zone.classList.remove("dragover");
+        });
+    });
+    zone.addEventListener("drop", function (e) {
+        addFiles(e.dataTransfer.files);
+    });
+    document.addEventListener("paste", function (e) {
+        var files = [];
+        for (var i = 0; i < e.clipboardData.items.length; i++) {
+            var f = e.clipboardData.items[i].getAsFile();
+            if (f) {
+                files.push(f);
+            }
+        }
+        addFiles(files);
+    });
+})();
@@ -0,0 +1,316 @@
+// Package client 封装 tgState HTTP 接口，供其他 Go 程序将 tgState 作为存储使用
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"csz.net/tgstate/conf"
+)
+
+// APIError 接口返回的业务错误
+type APIError struct {
+	Message    string
+	StatusCode int    // HTTP 状态码
+	Code       string // 错误类型，旧版错误格式时为空
+	RequestID  string // 服务端的请求ID，旧版错误格式时为空
+}
+
+func (e *APIError) Error() string {
+	return "tgstate: " + e.Message
+}
+
+// FileInfo 文件信息
+type FileInfo struct {
+	ID          string
+	Size        int64
+	ContentType string
+	Name        string
+}
+
+// FileApiPath 文件接口路径，与 control.FileApiRoute 一致
+const FileApiPath = "/api/v1/file/"
+
+// Client tgState 客户端
+type Client struct {
+	BaseUrl    string       // 实例地址，如 https://example.com
+	Pass       string       // 访问密码，未设置时留空
+	HttpClient *http.Client // 为空时使用 http.DefaultClient
+
+	mu      sync.Mutex
+	session *http.Cookie // 登录后获得的会话 cookie
+	csrf    string       // 会话对应的 CSRF 令牌
+}
+
+// New 创建客户端
+func New(baseUrl, pass string) *Client {
+	return &Client{BaseUrl: strings.TrimSuffix(baseUrl, "/"), Pass: pass}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HttpClient != nil {
+		return c.HttpClient
+	}
+	return http.DefaultClient
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseUrl+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.Pass != "" {
+		session, csrf, err := c.login(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if session != nil {
+			req.AddCookie(session)
+			req.Header.Set("X-CSRF-Token", csrf)
+		}
+	}
+	return req, nil
+}
+
+// login 使用访问密码登录并缓存会话 cookie 及 CSRF 令牌，会话过期前重新登录，服务器未设置密码时返回 nil
+func (c *Client) login(ctx context.Context) (*http.Cookie, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.session != nil && (c.session.Expires.IsZero() || time.Until(c.session.Expires) > time.Minute) {
+		return c.session, c.csrf, nil
+	}
+	hc := *c.httpClient()
+	hc.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	// 先打开登录页面获取 CSRF 令牌，令牌同时写在 cookie 与表单中
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseUrl+"/pwd", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	resp.Body.Close()
+	var csrf *http.Cookie
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "csrf" {
+			csrf = cookie
+		}
+	}
+	if csrf == nil {
+		// 服务器未设置访问密码，登录页面不存在
+		return nil, "", nil
+	}
+	form := url.Values{"p": {c.Pass}, "csrf": {csrf.Value}}
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, c.BaseUrl+"/pwd", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: csrf.Name, Value: csrf.Value})
+	resp, err = hc.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, "", &APIError{Message: "wrong password"}
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "p" {
+			c.session = &http.Cookie{Name: cookie.Name, Value: cookie.Value, Expires: cookie.Expires}
+			c.csrf = resp.Header.Get("X-CSRF-Token")
+			return c.session, c.csrf, nil
+		}
+	}
+	return nil, "", fmt.Errorf("tgstate: login failed: %s", resp.Status)
+}
+
+// do 发送请求并解析 UploadResponse
+func (c *Client) do(req *http.Request) (*conf.UploadResponse, error) {
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	var res conf.UploadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	if res.Code != 1 {
+		return nil, &APIError{Message: res.Message}
+	}
+	return &res, nil
+}
+
+// responseError 解析非 200 响应中的错误信息，兼容新旧两种错误格式
+func responseError(resp *http.Response) error {
+	// 旧版格式的 code 为数字
+	var res struct {
+		Code      interface{} `json:"code"`
+		Message   string      `json:"message"`
+		RequestID string      `json:"request_id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil || res.Message == "" {
+		return fmt.Errorf("tgstate: unexpected status %s", resp.Status)
+	}
+	code, _ := res.Code.(string)
+	return &APIError{Message: res.Message, StatusCode: resp.StatusCode, Code: code, RequestID: res.RequestID}
+}
+
+// Upload 上传本地文件
+func (c *Client) Upload(ctx context.Context, path string) (*conf.UploadResponse, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return c.UploadReader(ctx, filepath.Base(path), f)
+}
+
+// UploadReader 以流式 multipart 请求上传数据，不在内存中缓存整个文件
+func (c *Client) UploadReader(ctx context.Context, name string, r io.Reader) (*conf.UploadResponse, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("image", name)
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	req, err := c.newRequest(ctx, http.MethodPost, "/api/v1/upload", pr)
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return c.do(req)
+}
+
+// Paste 创建文本粘贴
+func (c *Client) Paste(ctx context.Context, content, lang string) (*conf.UploadResponse, error) {
+	return c.postJSON(ctx, "/api/v1/paste", conf.PasteRequest{Content: content, Lang: lang})
+}
+
+// Shorten 创建短链接，slug 为空时随机生成
+func (c *Client) Shorten(ctx context.Context, target, slug string) (*conf.UploadResponse, error) {
+	return c.postJSON(ctx, "/api/v1/shorten", conf.ShortenRequest{Url: target, Slug: slug})
+}
+
+func (c *Client) postJSON(ctx context.Context, path string, v interface{}) (*conf.UploadResponse, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, path, strings.NewReader(string(b)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req)
+}
+
+// filePath 将文件ID或 /d/ 路径转换为下载路径
+func filePath(id string) string {
+	return conf.FileRoute + url.PathEscape(strings.TrimPrefix(id, conf.FileRoute))
+}
+
+// Download 下载文件，调用方负责关闭返回的 ReadCloser
+func (c *Client) Download(ctx context.Context, id string) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, filePath(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("tgstate: unexpected status %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// DownloadTo 下载文件并写入 w
+func (c *Client) DownloadTo(ctx context.Context, id string, w io.Writer) (int64, error) {
+	body, err := c.Download(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	return io.Copy(w, body)
+}
+
+// Delete 删除文件，Telegram 中的消息一并删除
+func (c *Client) Delete(ctx context.Context, id string) error {
+	path := FileApiPath + url.PathEscape(strings.TrimPrefix(id, conf.FileRoute))
+	req, err := c.newRequest(ctx, http.MethodDelete, path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+	var res conf.UploadResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("tgstate: unexpected status %s", resp.Status)
+	}
+	if res.Code != 1 {
+		return &APIError{Message: res.Message}
+	}
+	return nil
+}
+
+// Info 通过 HEAD 请求获取文件信息
+func (c *Client) Info(ctx context.Context, id string) (*FileInfo, error) {
+	req, err := c.newRequest(ctx, http.MethodHead, filePath(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.New("tgstate: file not found")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tgstate: unexpected status %s", resp.Status)
+	}
+	info := &FileInfo{
+		ID:          strings.TrimPrefix(id, conf.FileRoute),
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	info.Size, _ = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		info.Name = params["filename"]
+	}
+	return info, nil
+}
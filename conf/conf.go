@@ -5,12 +5,235 @@ var ChannelName string
 var Pass string
 var Mode string
 var BaseUrl string
-var TgBotApiProxy string  // 新增变量，用于存储 Telegram Bot API 代理地址
+var TgBotApiProxy string       // Telegram Bot API 代理或自建 Bot API 服务的地址，如 http://127.0.0.1:8081
+var Compress bool              // 是否启用响应压缩
+var DataDir string             // 元数据存储目录
+var WebhookUrl string          // 事件推送地址
+var WebhookSecret string       // 事件推送签名密钥
+var WebhookDownloads int       // 每下载多少次推送一次下载事件，0 为不推送
+var TenantsFile string         // 多租户配置文件
+var RedisUrl string            // Redis 地址，设置后元数据、缓存与限流状态保存在 Redis 中
+var RateLimit int              // 每个IP每分钟允许的上传次数，0 为不限制
+var TrustProxy bool            // 是否信任反向代理传递的客户端IP
+var CfZone string              // Cloudflare Zone ID，用于清除 CDN 缓存
+var CfToken string             // Cloudflare API Token
+var Redirect string            // 下载重定向模式：off 代理下载，on 默认重定向，param 仅在 redirect=1 时重定向
+var RedirectProxy string       // 重定向使用的改写代理地址，为空时直接重定向到 Telegram
+var UploadConcurrency int      // 同时上传到 Telegram 的任务数
+var UploadQueue int            // 上传队列长度，队列满时拒绝新的上传
+var UploadWait int             // 上传排队等待秒数，超时后转为后台上传
+var ChunkSize int64            // 大文件分块大小（字节）
+var MirrorChannel string       // 备份频道，上传的文件会复制一份到此频道
+var AdminChat string           // 管理员的 Telegram 会话ID，用于审核及通知
+var ModChat string             // 审核会话，新上传的文件会发送到此会话并附带审核按钮
+var BotUsers string            // 允许使用机器人命令及内联查询的 Telegram 用户ID，逗号分隔
+var AnonUpload bool            // 允许未登录上传，文件审核通过前不能公开下载
+var AlertCache int64           // 磁盘缓存超过该字节数时通知管理员，0 为不检查
+var AlertBandwidth int64       // 最近一小时发送的字节数超过该值时通知管理员，0 为不检查
+var AlertErrors int            // 5xx 响应占比超过该百分比时通知管理员，0 为不检查
+var CacheGcDryRun bool         // 启动时只打印将清理的缓存文件，不实际删除
+var UpstreamConnectTimeout int // 连接 Telegram 文件服务器的超时秒数
+var UpstreamReadTimeout int    // 等待上游数据的超时秒数，0 为不限制
+var UpstreamMaxIdleConns int   // 每个上游主机保持的空闲连接数
+var UpstreamMaxConns int       // 每个上游主机的最大连接数，0 为不限制
+var TorrentTrackers string     // 生成种子时写入的 Tracker 地址，逗号分隔
+var IpfsApi string             // IPFS 节点 HTTP API 地址，如 http://127.0.0.1:5001
+var RetentionDays int          // 删除上传超过该天数的文件，0 为不限制
+var RetentionIdleDays int      // 删除超过该天数未被下载的文件，0 为不限制
+var RetentionDryRun bool       // 保留策略只记录将删除的文件，不实际删除
+var TrashDays int              // 删除的文件在回收站中保留的天数，0 为直接删除
+var MaxUploadSize int64        // 单个上传文件的大小上限（字节），0 为不限制
+var AllowedExts string         // 允许上传的扩展名，逗号分隔，为空时 p 模式不限制
+var Maintenance string         // 维护模式：off、upload（暂停上传）、download（暂停下载）或 all
+var MaintenanceMessage string  // 维护期间显示的提示，为空时使用默认提示
+var MigratePrivate bool        // 迁移时允许访问内网、回环及链路本地地址
+var Lang string                // 默认语言 en 或 zh，为空时按 Accept-Language 识别，接口消息默认英文
+var NoIndex bool               // 在下载及预览响应中添加 X-Robots-Tag: noindex，并在 robots.txt 中禁止抓取
+var RobotsFile string          // 自定义 robots.txt 文件路径
+var ReadHeaderTimeout int      // 读取请求头的超时时间（秒）
+var ReadTimeout int            // 读取整个请求的超时时间（秒），0 为不限制
+var WriteTimeout int           // 写入响应的超时时间（秒），0 为不限制
+var IdleTimeout int            // 空闲连接的超时时间（秒）
+var ApiTimeout int             // 搜索、认证日志等只读接口的处理超时时间（秒），0 为不限制
+var TelegramRate int           // 每个频道每分钟最多发送的文件数，0 为不限制
+var TelegramMaxWait int        // 预计等待发送额度超过该秒数时拒绝上传，0 为一直排队
+var SignKey string             // 签名下载地址使用的密钥，为空时由 Bot Token 派生
+var ThemeDir string            // 主题目录，可包含 theme.json、templates 与 static，覆盖内置页面
+var LegacyPaths string         // 旧图床地址的路径前缀，逗号分隔，匹配的请求按迁移对照表跳转到新地址
+var LegacyProxy bool           // 旧地址直接返回文件内容，而不是 301 跳转到新地址
+var MemCacheSize int64         // 小文件内存缓存的容量（字节），0 为关闭
+var Auth string                // 各路由分组的认证方式，如 upload=pass,apikey;download=sign，分组为 upload、admin、page、download
+var ApiKeys string             // 允许的接口密钥，逗号分隔，通过 X-Api-Key 请求头或 key 参数传递
+var AuthIPs string             // ip 认证方式允许的地址或 CIDR，逗号分隔
+var OidcIssuer string          // oidc 认证方式使用的身份提供方地址，Bearer 令牌由其 userinfo 接口校验
+var OidcAllow string           // oidc 认证允许的用户，逗号分隔的 sub、邮箱或 @域名，邮箱需经过验证
+var SessionHours int           // 登录有效期（小时），0 时为 7 天
+var LoginMaxFails int          // 认证失败达到该次数后暂时锁定客户端IP，0 为不锁定
+var LoginLockout int           // 锁定时长（分钟）
+var LogRanges bool             // 记录每个 206 响应请求的范围，用于分析播放及断点续传行为
+var LegacyErrors bool          // 旧版接口出错时仍以 200 返回 code 为 0 的 UploadResponse，/api/v1 不受影响
+var Visibility string          // 上传时未指定 visibility 参数的默认可见性：public、unlisted 或 private
+var Sitemap bool               // 是否提供 /sitemap.xml，列出公开文件的预览页面，开启 noindex 时不提供
+var Explore bool               // 是否开启 /explore 页面及 /feed.xml 订阅，展示最近上传的公开文件
+var OCI bool                   // 实验功能：是否在 /v2/ 提供 OCI 镜像仓库接口，镜像层及清单存储在 Telegram
+var RepoAlbum string           // 作为软件源的相册ID，其中的 .deb 及 .rpm 在 /repo/ 生成 APT 及 YUM 索引，为空时不提供
+var OTLPEndpoint string        // OTLP/HTTP 追踪数据的接收地址，如 http://localhost:4318，为空时不记录追踪
+var OTLPHeaders string         // 导出追踪数据时附加的请求头，k=v,k2=v2 形式
+var KeyDailyLimit int64        // 每个接口密钥每天（UTC）上传与下载的合计字节数上限，0 为不限制
+var KeyMonthlyLimit int64      // 每个接口密钥每月（UTC）上传与下载的合计字节数上限，0 为不限制
+var BasePath string            // 部署在子路径时的路径前缀，如 /tg，为空时部署在根路径
+var Socket string              // 监听的 Unix 套接字路径，设置后不再监听 TCP 端口
+var SocketMode string          // Unix 套接字文件的权限，八进制
+var TLSCert string             // TLS 证书文件，与 TLSKey 同时设置后以 HTTPS 提供服务并启用 HTTP/2
+var TLSKey string              // TLS 私钥文件
+var HTTP3 bool                 // 是否同时在 UDP 端口上提供 HTTP/3，需要启用 TLS
+var DownloadReplicas string    // 额外的文件下载地址，逗号分隔，需为同一 Bot API 的反向代理
+var BlobParallel int           // 分块文件同时下载的分块数，各分块轮流从主地址及额外地址下载
 
 type UploadResponse struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 	ImgUrl  string `json:"url"`
+	Sha256  string `json:"sha256,omitempty"` // 文件内容哈希，可通过 /h/{sha256} 访问
+	View    string `json:"view,omitempty"`   // 预览页面地址
+}
+
+// ApiError 接口错误响应，状态码与错误类型对应
+type ApiError struct {
+	Code      string      `json:"code"`                 // 错误类型，由 HTTP 状态码得出，如 bad_request、request_entity_too_large
+	Message   string      `json:"message"`              // 按请求的语言翻译的错误信息
+	Details   interface{} `json:"details,omitempty"`    // 附加信息，如大小上限、允许的扩展名
+	RequestID string      `json:"request_id,omitempty"` // 与 X-Request-Id 响应头一致，便于按日志排查
 }
 
 const FileRoute = "/d/"
+
+// ViewRoute 文件预览页面路径
+const ViewRoute = "/v/"
+
+// PasteRequest 创建文本粘贴请求
+type PasteRequest struct {
+	Content string `json:"content"`
+	Lang    string `json:"lang"`
+}
+
+// FileInfo 文件信息
+type FileInfo struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	Mime       string `json:"mime,omitempty"`
+	Sha256     string `json:"sha256,omitempty"`
+	Tenant     string `json:"tenant,omitempty"`
+	CreatedAt  int64  `json:"created_at,omitempty"`
+	Downloads  int64  `json:"downloads"`
+	LastAccess int64  `json:"last_access,omitempty"`
+	Duration   int    `json:"duration,omitempty"`   // 音视频时长（秒）
+	DeletedAt  int64  `json:"deleted_at,omitempty"` // 移入回收站的时间
+	Pending    bool   `json:"pending,omitempty"`    // 匿名上传等待审核
+	Visibility string `json:"visibility"`           // public、unlisted 或 private
+	Url        string `json:"url"`
+	View       string `json:"view"`
+}
+
+// SearchResponse 文件搜索结果
+type SearchResponse struct {
+	Total int        `json:"total"`
+	Files []FileInfo `json:"files"`
+}
+
+// SignResponse 带有效期的签名下载地址
+type SignResponse struct {
+	Code    int    `json:"code"`
+	Url     string `json:"url"`
+	Expires int64  `json:"expires"` // 过期时间，Unix 秒
+}
+
+// ShortenRequest 创建短链接请求
+type ShortenRequest struct {
+	Url  string `json:"url"`
+	Slug string `json:"slug"`
+}
+
+// CheckRequest 批量检查链接状态的请求，IDs 为 /d/ 后的文件ID
+type CheckRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// LinkStatus 单个链接的状态：ok、pending（待审核）、deleted（已删除）或 missing（已失效）
+type LinkStatus struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// CheckResponse 批量检查链接状态的结果，顺序与请求一致
+type CheckResponse struct {
+	Files []LinkStatus `json:"files"`
+}
+
+// AlbumRequest 创建相册请求，文件夹上传完成后提交已上传文件的相对路径
+type AlbumRequest struct {
+	Name  string       `json:"name"`
+	Files []AlbumEntry `json:"files"`
+}
+
+// AlbumEntry 相册中的文件，ID 为上传返回的 /d/ 后的文件ID
+type AlbumEntry struct {
+	Path string `json:"path"`
+	ID   string `json:"id"`
+}
+
+// FileVersion 命名文件的一个版本，Url 为 /d/{slug}@{version} 的完整地址
+type FileVersion struct {
+	Version   int    `json:"version"`
+	ID        string `json:"id"`
+	Url       string `json:"url"`
+	Size      int64  `json:"size"`
+	Sha256    string `json:"sha256,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// VersionsResponse 命名文件的版本列表，按版本号从旧到新排列
+type VersionsResponse struct {
+	Slug     string        `json:"slug"`
+	Current  int           `json:"current"`
+	Versions []FileVersion `json:"versions"`
+}
+
+// RollbackRequest 回滚命名文件的请求，以指定版本的内容生成新的当前版本
+type RollbackRequest struct {
+	Version int `json:"version"`
+}
+
+// Artifact 发布的构建产物，Url 为 /artifacts/{name}/{version}/{file} 的完整地址，
+// 在其后加上 .sha256 为校验文件
+type Artifact struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	File      string `json:"file"`
+	Url       string `json:"url"`
+	Size      int64  `json:"size"`
+	Sha256    string `json:"sha256"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// KeyUsage 接口密钥的流量统计，单位字节，Key 只包含密钥的前几位，Limit 为 0 时不限制
+type KeyUsage struct {
+	Key           string `json:"key"`
+	Day           string `json:"day"`
+	DayUpload     int64  `json:"day_upload"`
+	DayDownload   int64  `json:"day_download"`
+	DayLimit      int64  `json:"day_limit"`
+	Month         string `json:"month"`
+	MonthUpload   int64  `json:"month_upload"`
+	MonthDownload int64  `json:"month_download"`
+	MonthLimit    int64  `json:"month_limit"`
+}
+
+// ScheduleRequest 定时发送文件链接到频道的请求，At 为 Unix 秒、RFC3339 时间、
+// "2006-01-02 15:04" 格式的本地时间或 "+2h" 格式的相对时间
+type ScheduleRequest struct {
+	File string `json:"file"`
+	At   string `json:"at"`
+	Text string `json:"text"`
+}
@@ -0,0 +1,69 @@
+package control
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+)
+
+// 元数据导出、恢复接口路径
+const (
+	ExportRoute  = "/api/admin/export"
+	RestoreRoute = "/api/admin/import"
+)
+
+// 恢复接口请求体上限
+const restoreMaxSize = 256 << 20
+
+// Export 导出元数据，format=csv 时导出 CSV，kind=links 时导出短链接
+func Export(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	d := store.Default().Export()
+	name := "tgstate-" + time.Unix(d.ExportedAt, 0).Format("20060102150405")
+	q := r.URL.Query()
+	if q.Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		var err error
+		if q.Get("kind") == "links" {
+			w.Header().Set("Content-Disposition", `attachment; filename="`+name+`-links.csv"`)
+			err = store.WriteLinksCSV(w, d.Links)
+		} else {
+			w.Header().Set("Content-Disposition", `attachment; filename="`+name+`-files.csv"`)
+			err = store.WriteFilesCSV(w, d.Files)
+		}
+		if err != nil {
+			log.Printf("导出元数据失败: %v", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.json"`)
+	writeJson(w, http.StatusOK, d)
+}
+
+// Restore 从导出的 JSON 恢复元数据
+func Restore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	var d store.Dump
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, restoreMaxSize)).Decode(&d); err != nil {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid dump")
+		return
+	}
+	n, err := store.Default().Import(d)
+	if err != nil {
+		log.Printf("恢复元数据失败: %v", err)
+		errJsonMsg(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJson(w, http.StatusOK, conf.UploadResponse{Code: 1, Message: strconv.Itoa(n)})
+}
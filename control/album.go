@@ -0,0 +1,241 @@
+package control
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// AlbumRoute 相册页面路径，/a/{id}/zip 打包下载相册中的全部文件
+const AlbumRoute = "/a/"
+
+// AlbumApiRoute 创建相册接口路径
+const AlbumApiRoute = "/api/album"
+
+// albumMaxFiles 单个相册最多包含的文件数，与展开压缩包的上限一致
+const albumMaxFiles = zipMaxEntries
+
+// albumNode 相册目录树中的目录或文件
+type albumNode struct {
+	Name     string
+	Url      string // 文件的预览地址，目录为空
+	Size     string
+	Children []*albumNode
+}
+
+// albumView 相册页面数据
+type albumView struct {
+	pageData
+	Name    string
+	Count   int
+	Size    string
+	Created string
+	Zip     string
+	Tree    []*albumNode
+}
+
+// albumPath 清理文件夹上传时的相对路径，拒绝绝对路径及包含 .. 的路径
+func albumPath(p string) (string, bool) {
+	p = strings.ReplaceAll(p, "\\", "/")
+	if p == "" || strings.HasPrefix(p, "/") || len(p) > 1024 {
+		return "", false
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if seg == ".." {
+			return "", false
+		}
+	}
+	p = path.Clean(p)
+	return p, p != "."
+}
+
+// AlbumAPI 按相对路径将已上传的文件组成相册，用于浏览器中的文件夹上传
+func AlbumAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	var req conf.AlbumRequest
+	if err := decodeRequest(r, &req); err != nil {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	if len(req.Files) == 0 {
+		errJsonMsg(w, r, http.StatusBadRequest, "Album is empty")
+		return
+	}
+	if len(req.Files) > albumMaxFiles {
+		writeError(w, r, http.StatusRequestEntityTooLarge, http.StatusOK, "Too many files", map[string]int{"limit": albumMaxFiles})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if len(name) > 255 || strings.ContainsAny(name, "/\\") {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid album name")
+		return
+	}
+	album := store.Album{Name: name, Files: make([]store.AlbumFile, 0, len(req.Files))}
+	seen := map[string]bool{}
+	for _, e := range req.Files {
+		p, ok := albumPath(e.Path)
+		if !ok || seen[p] {
+			errJsonMsg(w, r, http.StatusBadRequest, "Invalid file path")
+			return
+		}
+		seen[p] = true
+		// 只能使用已登记的文件，未登记的ID无法确认其存在
+		f, ok := store.Default().GetFile(strings.TrimPrefix(e.ID, "blob-"))
+		if !utils.ValidFileID(e.ID) || !ok || f.DeletedAt > 0 || f.Tenant != "" {
+			errJsonMsg(w, r, http.StatusBadRequest, "File not found")
+			return
+		}
+		album.Files = append(album.Files, store.AlbumFile{Path: p, ID: e.ID, Size: f.Size})
+	}
+	for i := 0; i < 5; i++ {
+		album.ID = utils.RandString(8)
+		err := store.Default().PutAlbum(album)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, store.ErrExists) {
+			log.Printf("保存相册失败: %v", err)
+			errJsonMsg(w, r, http.StatusInternalServerError, "error")
+			return
+		}
+		album.ID = ""
+	}
+	if album.ID == "" {
+		errJsonMsg(w, r, http.StatusInternalServerError, "error")
+		return
+	}
+	auditTarget(r, album.ID, album.Name)
+	link := AlbumRoute + album.ID
+	writeJson(w, http.StatusOK, conf.UploadResponse{
+		Code:    1,
+		Message: link,
+		ImgUrl:  publicBaseUrl(r) + link,
+	})
+}
+
+// Album 相册页面，以目录树展示其中的文件
+func Album(w http.ResponseWriter, r *http.Request) {
+	album, ok := store.Default().GetAlbum(PathValue(r, "id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	data := albumView{
+		Name:  album.Name,
+		Count: len(album.Files),
+		Zip:   AlbumRoute + album.ID + "/zip",
+		Tree:  albumTree(r, album.Files),
+	}
+	var total int64
+	for _, f := range album.Files {
+		total += f.Size
+	}
+	data.Size = humanSize(total)
+	if album.CreatedAt > 0 {
+		data.Created = time.Unix(album.CreatedAt, 0).Format("2006-01-02 15:04")
+	}
+	renderTemplate(w, r, "album.tmpl", data)
+}
+
+// albumTree 按相对路径生成目录树，目录在前，同级按名称排序，不列出请求不能访问的文件
+func albumTree(r *http.Request, files []store.AlbumFile) []*albumNode {
+	root := &albumNode{}
+	for _, f := range files {
+		if status, _ := unavailable(r, f.ID); status != 0 {
+			continue
+		}
+		dir := root
+		segs := strings.Split(f.Path, "/")
+		for _, seg := range segs[:len(segs)-1] {
+			var next *albumNode
+			for _, c := range dir.Children {
+				if c.Name == seg && c.Url == "" {
+					next = c
+					break
+				}
+			}
+			if next == nil {
+				next = &albumNode{Name: seg}
+				dir.Children = append(dir.Children, next)
+			}
+			dir = next
+		}
+		dir.Children = append(dir.Children, &albumNode{Name: segs[len(segs)-1], Url: conf.ViewRoute + f.ID, Size: humanSize(f.Size)})
+	}
+	var sortTree func(nodes []*albumNode)
+	sortTree = func(nodes []*albumNode) {
+		sort.Slice(nodes, func(i, j int) bool {
+			if di, dj := nodes[i].Url == "", nodes[j].Url == ""; di != dj {
+				return di
+			}
+			return nodes[i].Name < nodes[j].Name
+		})
+		for _, n := range nodes {
+			sortTree(n.Children)
+		}
+	}
+	sortTree(root.Children)
+	return root.Children
+}
+
+// AlbumZip 将相册中的文件按相对路径打包下载，压缩包边生成边发送
+func AlbumZip(w http.ResponseWriter, r *http.Request) {
+	album, ok := store.Default().GetAlbum(PathValue(r, "id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	name := album.Name
+	if name == "" {
+		name = album.ID
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+	if r.Method == http.MethodHead {
+		return
+	}
+	zw := zip.NewWriter(w)
+	for _, f := range album.Files {
+		if status, _ := unavailable(r, f.ID); status != 0 {
+			continue
+		}
+		if err := albumZipFile(r, zw, name+"/"+f.Path, f.ID); err != nil {
+			// 响应已开始发送，只能中止压缩包
+			if r.Context().Err() == nil {
+				log.Printf("打包相册 %s 失败: %v", album.ID, err)
+			}
+			return
+		}
+	}
+	zw.Close()
+}
+
+// albumZipFile 写入压缩包中的单个文件，图片视频等已压缩的内容不再压缩
+func albumZipFile(r *http.Request, zw *zip.Writer, name, id string) error {
+	content, err := openContent(r.Context(), strings.TrimPrefix(id, "blob-"))
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+	hdr := &zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()}
+	if rec, ok := store.Default().GetFile(strings.TrimPrefix(id, "blob-")); ok && rec.CreatedAt > 0 {
+		hdr.Modified = time.Unix(rec.CreatedAt, 0)
+	}
+	fw, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, content)
+	return err
+}
@@ -0,0 +1,122 @@
+package control
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/i18n"
+	"csz.net/tgstate/metrics"
+	"csz.net/tgstate/utils"
+)
+
+// alertInterval 检查告警条件的间隔，错误率按该时间段内的响应计算
+const alertInterval = 5 * time.Minute
+
+// alertMinResponses 计算错误率时最少的响应数，请求太少时不告警
+const alertMinResponses = 20
+
+func init() {
+	metrics.Gauge("tgstate_cache_disk_bytes", "Bytes held by the disk cache", cacheDiskUsage)
+}
+
+// cacheDiskUsage 磁盘缓存目录中文件的总大小，包括下载中的文件
+func cacheDiskUsage() int64 {
+	entries, err := os.ReadDir(getFileCache().cacheDir)
+	if err != nil {
+		return 0
+	}
+	var total int64
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && !info.IsDir() {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// alertSample 某一时刻的累计指标
+type alertSample struct {
+	sent   int64 // 发送的字节数
+	total  int64 // 响应数
+	errors int64 // 5xx 响应数
+}
+
+// takeAlertSample 读取当前的累计指标
+func takeAlertSample() alertSample {
+	s := alertSample{
+		sent:   metrics.Value("tgstate_http_sent_bytes_total"),
+		errors: metrics.Value(`tgstate_http_responses_total{code="5xx"}`),
+	}
+	for class := 1; class <= 5; class++ {
+		s.total += metrics.Value(`tgstate_http_responses_total{code="` + strconv.Itoa(class) + `xx"}`)
+	}
+	return s
+}
+
+// alerter 比较采样与阈值，同一告警在恢复正常前只通知一次
+type alerter struct {
+	samples []alertSample // 最近一小时的采样，间隔为 alertInterval
+	active  map[string]bool
+}
+
+// fire 记录告警状态，刚超过阈值时返回 true
+func (a *alerter) fire(key string, over bool) bool {
+	if a.active == nil {
+		a.active = make(map[string]bool)
+	}
+	was := a.active[key]
+	a.active[key] = over
+	return over && !was
+}
+
+// check 加入新的采样，返回需要发送的告警
+func (a *alerter) check(s alertSample, cache int64) []string {
+	t := func(msg string, args ...interface{}) string { return i18n.T(i18n.Default, msg, args...) }
+	a.samples = append(a.samples, s)
+	if keep := int(time.Hour/alertInterval) + 1; len(a.samples) > keep {
+		a.samples = a.samples[len(a.samples)-keep:]
+	}
+	var alerts []string
+	if a.fire("cache", conf.AlertCache > 0 && cache > conf.AlertCache) {
+		alerts = append(alerts, t("Disk cache is %s, above the %s threshold", humanSize(cache), humanSize(conf.AlertCache)))
+	}
+	// 不足一小时时按已有的采样计算
+	sent := s.sent - a.samples[0].sent
+	if a.fire("bandwidth", conf.AlertBandwidth > 0 && sent > conf.AlertBandwidth) {
+		alerts = append(alerts, t("Sent %s in the last hour, above the %s threshold", humanSize(sent), humanSize(conf.AlertBandwidth)))
+	}
+	var total, errors int64
+	if n := len(a.samples); n >= 2 {
+		prev := a.samples[n-2]
+		total, errors = s.total-prev.total, s.errors-prev.errors
+	}
+	over := conf.AlertErrors > 0 && total >= alertMinResponses && errors*100 > int64(conf.AlertErrors)*total
+	if a.fire("errors", over) {
+		alerts = append(alerts, t("%d of %d responses in the last 5 minutes were server errors", errors, total))
+	}
+	return alerts
+}
+
+// StartAlerts 定期检查磁盘缓存、带宽及错误率，超过阈值时通过机器人通知 adminchat
+func StartAlerts() {
+	if conf.AdminChat == "" || (conf.AlertCache <= 0 && conf.AlertBandwidth <= 0 && conf.AlertErrors <= 0) {
+		return
+	}
+	go func() {
+		var a alerter
+		ticker := time.NewTicker(alertInterval)
+		defer ticker.Stop()
+		for {
+			for _, msg := range a.check(takeAlertSample(), cacheDiskUsage()) {
+				log.Printf("告警: %s", msg)
+				if err := utils.SendAdmin("⚠️ " + msg); err != nil {
+					log.Printf("发送告警失败: %v", err)
+				}
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,135 @@
+package control
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// AppendRoute 追加写入日志文件的接口路径，/api/append/{name}，日志文件通过 /d/{name} 下载
+const AppendRoute = "/api/append/"
+
+// appendNameMaxLen 日志文件名称的最大长度，远小于 Telegram 文件ID以免与之混淆
+const appendNameMaxLen = 64
+
+// validAppendName 日志文件名称只能使用文件ID允许的字符，且不能与已登记的文件重名
+func validAppendName(name string) bool {
+	if len(name) > appendNameMaxLen || !utils.ValidFileID(name) {
+		return false
+	}
+	_, exists := store.Default().GetFile(name)
+	return !exists
+}
+
+// AppendLog 将请求体追加到日志文件末尾，超过单个分块大小的内容拆分为多条消息；
+// 同时追加时各请求的内容不会交错，先上传完成的在前
+func AppendLog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	name := PathValue(r, "name")
+	if !validAppendName(name) {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid log name")
+		return
+	}
+	var chunks []store.LogChunk
+	// 失败时删除已上传的分块，不留下无法访问的消息
+	discard := func() {
+		for _, c := range chunks {
+			go discardUpload(c.ID)
+		}
+	}
+	buf := make([]byte, utils.MaxChunkSize)
+	for {
+		n, err := io.ReadFull(r.Body, buf)
+		if n > 0 {
+			id := appendChunk(r, buf[:n])
+			if id == "" {
+				discard()
+				errJsonMsg(w, r, http.StatusBadGateway, "Failed to upload to Telegram")
+				return
+			}
+			sum := sha256.Sum256(buf[:n])
+			chunks = append(chunks, store.LogChunk{ID: id, Size: int64(n), Sha256: hex.EncodeToString(sum[:])})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			discard()
+			if isBodyTooLarge(err) {
+				errJsonMsg(w, r, http.StatusRequestEntityTooLarge, "File size exceeds limit")
+				return
+			}
+			errJsonMsg(w, r, http.StatusBadRequest, "Invalid request")
+			return
+		}
+	}
+	if len(chunks) == 0 {
+		errJsonMsg(w, r, http.StatusBadRequest, "Content is empty")
+		return
+	}
+	l, err := store.Default().AppendChunks(name, appendMime(r), chunks)
+	if err != nil {
+		log.Printf("保存日志文件失败: %v", err)
+		discard()
+		errJsonMsg(w, r, http.StatusInternalServerError, "error")
+		return
+	}
+	auditTarget(r, name, name)
+	link := conf.FileRoute + name
+	w.Header().Set("X-Log-Size", strconv.FormatInt(l.Size, 10))
+	writeJson(w, http.StatusOK, conf.UploadResponse{
+		Code:    1,
+		Message: link,
+		ImgUrl:  publicBaseUrl(r) + link,
+	})
+}
+
+// appendChunk 上传一个分块并等待完成，失败时返回空字符串
+func appendChunk(r *http.Request, data []byte) string {
+	job := utils.NewUploadJob(conf.ChannelName, utils.BlobChunkName, bytes.NewReader(data))
+	job.Ctx = r.Context()
+	if err := utils.SubmitUpload(job); err != nil {
+		log.Printf("提交日志分块失败: %v", err)
+		return ""
+	}
+	select {
+	case <-job.Done():
+	case <-r.Context().Done():
+		// 客户端断开时取消尚未开始的任务
+		if job.Cancel() {
+			return ""
+		}
+		<-job.Done()
+	}
+	return job.FileID
+}
+
+// appendMime 首次追加时请求的内容类型，未指定或为表单时按纯文本处理
+func appendMime(r *http.Request) string {
+	ct := r.Header.Get("Content-Type")
+	if mt, _, err := mime.ParseMediaType(ct); err != nil || strings.HasPrefix(mt, "multipart/") || mt == "application/x-www-form-urlencoded" {
+		return "text/plain; charset=utf-8"
+	}
+	return ct
+}
+
+// serveAppendLog 按追加顺序拼接输出日志文件，支持 Range 请求以便只读取新增的内容
+func serveAppendLog(w http.ResponseWriter, r *http.Request, l store.AppendLog) {
+	idx := &utils.BlobIndex{Name: l.Name, Size: l.Size, Mime: l.Mime}
+	for _, c := range l.Chunks {
+		idx.Chunks = append(idx.Chunks, utils.BlobChunk{ID: c.ID, Size: c.Size, Sha256: c.Sha256})
+	}
+	// 内容随追加变化，不允许缓存
+	w.Header().Set("Cache-Control", "no-cache")
+	serveBlob(w, r, l.Name, idx)
+}
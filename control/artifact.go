@@ -0,0 +1,172 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// ArtifactRoute 构建产物路径，PUT /artifacts/{name}/{version}/{file} 发布，GET 下载，
+// 文件名后加 .sha256 为校验文件，/artifacts/{name} 及 /artifacts/{name}/{version} 列出已发布的内容
+const ArtifactRoute = "/artifacts/"
+
+// artifactSumExt 校验文件的扩展名
+const artifactSumExt = ".sha256"
+
+var artifactNameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._+-]{0,127}$`)
+
+// PublishArtifact 以请求体发布构建产物，已发布的版本不可覆盖，
+// 相同内容复用已上传的文件
+func PublishArtifact(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	name, version, file := PathValue(r, "name"), PathValue(r, "version"), PathValue(r, "file")
+	if !artifactNameRe.MatchString(name) || !artifactNameRe.MatchString(version) || !artifactNameRe.MatchString(file) || strings.HasSuffix(file, artifactSumExt) {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid artifact path")
+		return
+	}
+	st := store.Default()
+	// 上传前先检查，避免白白上传
+	if _, ok := st.GetArtifact(name, version, file); ok {
+		errJsonMsg(w, r, http.StatusConflict, "Artifact already exists")
+		return
+	}
+	if exts := uploadExts(); exts != "" && !extAllowed(file, exts) {
+		writeError(w, r, http.StatusUnsupportedMediaType, http.StatusOK, tr(r, "Invalid file type. Only %s are allowed.", exts), map[string]string{"allowed": exts})
+		return
+	}
+	if r.ContentLength == 0 {
+		errJsonMsg(w, r, http.StatusBadRequest, "Content is empty")
+		return
+	}
+	maxSize := maxUploadSize()
+	if maxSize > 0 && r.ContentLength > maxSize {
+		writeError(w, r, http.StatusRequestEntityTooLarge, http.StatusOK, "File size exceeds limit", map[string]int64{"limit": maxSize})
+		return
+	}
+	want, ok := uploadChecksum(r)
+	if !ok {
+		invalidChecksum(w, r)
+		return
+	}
+	body := &countingReader{r: r.Body, limit: maxSize, want: want}
+	job := utils.NewUploadJob(conf.ChannelName, file, body)
+	job.Ctx = r.Context()
+	if err := utils.SubmitUpload(job); err != nil {
+		submitError(w, r, conf.ChannelName, err)
+		return
+	}
+	<-job.Done()
+	if body.mismatch {
+		checksumError(w, r, body)
+		return
+	}
+	if body.exceeded {
+		errJsonMsg(w, r, http.StatusRequestEntityTooLarge, "File size exceeds limit")
+		return
+	}
+	res := uploadResult(store.File{ID: job.FileID, Name: file, Size: body.n, Sha256: body.sum()}, "")
+	if res.Code != 1 {
+		errJsonMsg(w, r, http.StatusBadGateway, "Failed to upload to Telegram")
+		return
+	}
+	a := store.Artifact{Name: name, Version: version, File: file, ID: strings.TrimPrefix(res.Message, conf.FileRoute), Size: body.n, Sha256: res.Sha256}
+	if err := st.PutArtifact(a); err != nil {
+		// 同时发布时只保留先完成的，删除本次新上传的内容
+		if a.ID == job.FileID {
+			go func() {
+				if err := deleteFile(context.Background(), a.ID); err != nil {
+					log.Printf("删除构建产物 %s 失败: %v", a.ID, err)
+				}
+			}()
+		}
+		if errors.Is(err, store.ErrExists) {
+			errJsonMsg(w, r, http.StatusConflict, "Artifact already exists")
+			return
+		}
+		log.Printf("保存构建产物失败: %v", err)
+		errJsonMsg(w, r, http.StatusInternalServerError, "error")
+		return
+	}
+	auditTarget(r, a.ID, name+"/"+version+"/"+file)
+	info := artifactInfo(r, a)
+	w.Header().Set("Location", info.Url)
+	writeJson(w, http.StatusCreated, info)
+}
+
+// Artifact 下载构建产物或其校验文件，内容不可变，允许长期缓存
+func Artifact(w http.ResponseWriter, r *http.Request) {
+	name, version, file := PathValue(r, "name"), PathValue(r, "version"), PathValue(r, "file")
+	st := store.Default()
+	a, ok := st.GetArtifact(name, version, file)
+	if !ok {
+		base := strings.TrimSuffix(file, artifactSumExt)
+		if base == file {
+			http.NotFound(w, r)
+			return
+		}
+		if a, ok = st.GetArtifact(name, version, base); !ok {
+			http.NotFound(w, r)
+			return
+		}
+		// sha256sum -c 可直接校验的格式
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Write([]byte(a.Sha256 + "  " + a.File + "\n"))
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.File}))
+	w.Header().Set("X-Checksum-Sha256", a.Sha256)
+	// 相同内容可能以其他文件名上传过，内容类型按产物文件名确定
+	if rec, ok := st.GetFile(a.ID); ok && rec.ContentType == "" {
+		if mt := utils.TypeByName(a.File); mt != utils.TypeByName(rec.Name) {
+			w = &siteWriter{ResponseWriter: w, mime: mt}
+		}
+	}
+	serveFile(w, r, a.ID)
+}
+
+// ArtifactIndex 列出构建产物，指定版本时只列出该版本的文件，按发布时间排序
+func ArtifactIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	name, version := PathValue(r, "name"), PathValue(r, "version")
+	list := []conf.Artifact{}
+	for _, a := range store.Default().Artifacts() {
+		if a.Name == name && (version == "" || a.Version == version) {
+			list = append(list, artifactInfo(r, a))
+		}
+	}
+	if len(list) == 0 {
+		errJson(w, r, http.StatusNotFound, "Artifact not found")
+		return
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].CreatedAt != list[j].CreatedAt {
+			return list[i].CreatedAt < list[j].CreatedAt
+		}
+		return list[i].Version+"/"+list[i].File < list[j].Version+"/"+list[j].File
+	})
+	writeJson(w, http.StatusOK, list)
+}
+
+// artifactInfo 生成构建产物的响应
+func artifactInfo(r *http.Request, a store.Artifact) conf.Artifact {
+	return conf.Artifact{
+		Name:      a.Name,
+		Version:   a.Version,
+		File:      a.File,
+		Url:       publicBaseUrl(r) + ArtifactRoute + a.Name + "/" + a.Version + "/" + a.File,
+		Size:      a.Size,
+		Sha256:    a.Sha256,
+		CreatedAt: a.CreatedAt,
+	}
+}
@@ -0,0 +1,194 @@
+package control
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+)
+
+// AuditLogRoute 审计日志接口路径
+const AuditLogRoute = "/api/admin/audit"
+
+// 审计日志接口单次最多返回的条数
+const auditMaxLimit = 1000
+
+type auditCtxKey struct{}
+
+// auditNote 处理函数补充的审计信息
+type auditNote struct {
+	action string
+	actor  string
+	target string
+	detail string
+	skip   bool
+}
+
+// getAuditNote 获取请求的审计信息，未经过 Audit 时返回 nil
+func getAuditNote(r *http.Request) *auditNote {
+	note, _ := r.Context().Value(auditCtxKey{}).(*auditNote)
+	return note
+}
+
+// auditTarget 记录操作对象及说明
+func auditTarget(r *http.Request, target, detail string) {
+	if note := getAuditNote(r); note != nil {
+		note.target, note.detail = target, detail
+	}
+}
+
+// auditAction 按请求内容修改操作类型，如文件接口的 delete、restore
+func auditAction(r *http.Request, action string) {
+	if note := getAuditNote(r); note != nil {
+		note.action = action
+	}
+}
+
+// auditActor 指定操作者，用于租户等不经过认证链的请求
+func auditActor(r *http.Request, actor string) {
+	if note := getAuditNote(r); note != nil {
+		note.actor = actor
+	}
+}
+
+// auditSkip 不记录本次请求，如分段上传的中间请求
+func auditSkip(r *http.Request) {
+	if note := getAuditNote(r); note != nil {
+		note.skip = true
+	}
+}
+
+// requestActor 根据请求携带的凭据识别操作者
+func requestActor(r *http.Request) string {
+	if method, actor, valid := presentedCredential(r); valid {
+		if actor != "" {
+			return method + ":" + actor
+		}
+		return method
+	}
+	if cookie, err := r.Cookie("p"); err == nil && passEnabled() && validSession(cookie.Value, "", conf.Pass) {
+		return "session"
+	}
+	if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") && oidcAuth(r) {
+		return "oidc"
+	}
+	return "anonymous"
+}
+
+// auditSystem 记录定时任务等非请求触发的修改操作
+func auditSystem(action, target, detail string) {
+	store.Default().AddAudit(store.AuditEntry{
+		Time:   time.Now().Unix(),
+		Action: action,
+		Actor:  "system",
+		Target: target,
+		Detail: detail,
+	})
+}
+
+// auditWriter 记录响应状态码
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (aw *auditWriter) WriteHeader(status int) {
+	if aw.status == 0 {
+		aw.status = status
+	}
+	aw.ResponseWriter.WriteHeader(status)
+}
+
+func (aw *auditWriter) Write(p []byte) (int, error) {
+	if aw.status == 0 {
+		aw.status = http.StatusOK
+	}
+	return aw.ResponseWriter.Write(p)
+}
+
+func (aw *auditWriter) Flush() {
+	if f, ok := aw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Audit 在审计日志中记录修改操作（非 GET、HEAD、OPTIONS 请求）的操作者、IP 及结果
+func Audit(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if safeMethod(r.Method) {
+			next(w, r)
+			return
+		}
+		note := &auditNote{action: action}
+		aw := &auditWriter{ResponseWriter: w}
+		next(aw, r.WithContext(context.WithValue(r.Context(), auditCtxKey{}, note)))
+		if note.skip {
+			return
+		}
+		if note.actor == "" {
+			note.actor = requestActor(r)
+		}
+		if aw.status == 0 {
+			aw.status = http.StatusOK
+		}
+		store.Default().AddAudit(store.AuditEntry{
+			Time:   time.Now().Unix(),
+			Action: note.action,
+			Actor:  note.actor,
+			IP:     clientIP(r),
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Status: aw.status,
+			Target: note.target,
+			Detail: note.detail,
+		})
+	}
+}
+
+// AuditLog 查询审计日志，新的在前
+//
+// 参数 action、actor、ip、target 精确匹配，since、until 为 Unix 秒，limit 与 offset 用于分页
+func AuditLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > auditMaxLimit {
+		limit = 100
+	}
+	offset, err := strconv.Atoi(q.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	since, _ := strconv.ParseInt(q.Get("since"), 10, 64)
+	until, _ := strconv.ParseInt(q.Get("until"), 10, 64)
+	match := func(e store.AuditEntry) bool {
+		for key, v := range map[string]string{"action": e.Action, "actor": e.Actor, "ip": e.IP, "target": e.Target} {
+			if q.Has(key) && q.Get(key) != v {
+				return false
+			}
+		}
+		return (since == 0 || e.Time >= since) && (until == 0 || e.Time <= until)
+	}
+	entries := store.Default().AuditEntries()
+	var matched []store.AuditEntry
+	for i := len(entries) - 1; i >= 0; i-- {
+		if match(entries[i]) {
+			matched = append(matched, entries[i])
+		}
+	}
+	res := struct {
+		Total   int                `json:"total"`
+		Entries []store.AuditEntry `json:"entries"`
+	}{Total: len(matched), Entries: []store.AuditEntry{}}
+	for i := offset; i < len(matched) && i < offset+limit; i++ {
+		res.Entries = append(res.Entries, matched[i])
+	}
+	writeJson(w, http.StatusOK, res)
+}
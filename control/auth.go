@@ -0,0 +1,379 @@
+package control
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+)
+
+// 路由分组，每组可以配置不同的认证方式
+const (
+	AuthUpload   = "upload"   // 上传、粘贴及短链接
+	AuthAdmin    = "admin"    // 文件管理、导出、设置等接口
+	AuthPage     = "page"     // 首页等管理页面
+	AuthDownload = "download" // 下载及预览
+)
+
+// 未配置时各分组使用的认证方式，下载默认公开
+var defaultAuth = map[string]string{
+	AuthUpload:   "pass",
+	AuthAdmin:    "pass",
+	AuthPage:     "pass",
+	AuthDownload: "none",
+}
+
+// Authenticator 认证方式，请求通过认证时返回 true
+type Authenticator func(r *http.Request) bool
+
+// authenticators 可用的认证方式
+var authenticators = map[string]Authenticator{
+	"none":   func(*http.Request) bool { return true },
+	"pass":   passAuth,
+	"apikey": apiKeyAuth,
+	"sign":   signAuth,
+	"ip":     ipAuth,
+	"oidc":   oidcAuth,
+}
+
+// authChain 分组的认证链，任一方式通过即放行
+type authChain struct {
+	names []string
+	auths []Authenticator
+}
+
+var (
+	authGroups = make(map[string]authChain)
+	authMu     sync.RWMutex
+)
+
+// LoadAuth 解析认证配置，格式如 upload=pass,apikey;download=sign，未列出的分组使用默认配置
+func LoadAuth(spec string) error {
+	groups := make(map[string]authChain)
+	for group, names := range defaultAuth {
+		chain, err := parseAuthChain(names)
+		if err != nil {
+			return err
+		}
+		groups[group] = chain
+	}
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		group, names, ok := strings.Cut(part, "=")
+		group = strings.TrimSpace(group)
+		if _, known := defaultAuth[group]; !ok || !known {
+			return fmt.Errorf("无效的认证分组: %s", part)
+		}
+		chain, err := parseAuthChain(names)
+		if err != nil {
+			return err
+		}
+		groups[group] = chain
+	}
+	authMu.Lock()
+	authGroups = groups
+	authMu.Unlock()
+	return nil
+}
+
+// parseAuthChain 解析逗号分隔的认证方式
+func parseAuthChain(names string) (authChain, error) {
+	var chain authChain
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		a, ok := authenticators[name]
+		if !ok {
+			return chain, fmt.Errorf("未知的认证方式: %s", name)
+		}
+		chain.names = append(chain.names, name)
+		chain.auths = append(chain.auths, a)
+	}
+	if len(chain.auths) == 0 {
+		return chain, fmt.Errorf("认证方式不能为空: %q", names)
+	}
+	return chain, nil
+}
+
+// getAuthChain 获取分组的认证链，未加载配置时使用默认配置
+func getAuthChain(group string) authChain {
+	authMu.RLock()
+	chain, ok := authGroups[group]
+	authMu.RUnlock()
+	if !ok {
+		chain, _ = parseAuthChain(defaultAuth[group])
+	}
+	return chain
+}
+
+// allow 请求是否通过认证链中的任一方式
+func (c authChain) allow(r *http.Request) bool {
+	for _, a := range c.auths {
+		if a(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// has 认证链是否包含指定方式
+func (c authChain) has(name string) bool {
+	for _, n := range c.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Auth 按分组配置的认证链校验请求，包含密码认证时跳转到密码页面，否则返回 401
+func Auth(group string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		chain := getAuthChain(group)
+		// 携带密码参数或接口密钥的请求计入失败次数，锁定期间直接拒绝
+		method, actor, valid := presentedCredential(r)
+		if method != "" && chain.has(method) {
+			if authLocked(r) {
+				lockedResponse(w)
+				return
+			}
+			if valid {
+				authSucceeded(r, method, actor)
+			} else {
+				authFailed(r, method, actor, "invalid credentials")
+			}
+		}
+		if chain.allow(r) {
+			// 未通过有效的密码参数或接口密钥认证时，修改请求可能是其他站点借用会话 cookie 发起的，需要校验 CSRF 令牌；
+			// 只携带无效的凭据不能跳过校验
+			if !(valid && chain.has(method)) && chain.has("pass") && passEnabled() {
+				if cookie, err := r.Cookie("p"); err == nil && validSession(cookie.Value, "", conf.Pass) && !csrfValid(r, cookie.Value) {
+					http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+					return
+				}
+			}
+			next(w, r)
+			return
+		}
+		if chain.has("pass") {
+			http.Redirect(w, r, "/pwd", http.StatusSeeOther)
+			return
+		}
+		if chain.has("oidc") {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tgState"`)
+		}
+		httpError(w, r, http.StatusUnauthorized, "Unauthorized")
+	}
+}
+
+// passEnabled 是否设置了访问密码
+func passEnabled() bool {
+	return conf.Pass != "" && conf.Pass != "none"
+}
+
+// passAuth 校验会话 cookie，接口请求也可以使用 pass 参数，未设置密码时放行
+func passAuth(r *http.Request) bool {
+	if !passEnabled() {
+		return true
+	}
+	if strings.HasPrefix(r.URL.Path, "/api") && checkPass(r.URL.Query().Get("pass"), conf.Pass) {
+		return true
+	}
+	cookie, err := r.Cookie("p")
+	return err == nil && validSession(cookie.Value, "", conf.Pass)
+}
+
+// apiKeyAuth 校验 X-Api-Key 请求头或 key 参数
+func apiKeyAuth(r *http.Request) bool {
+	return matchApiKey(r) != ""
+}
+
+// matchApiKey 返回请求中携带的有效接口密钥，没有或无效时为空
+func matchApiKey(r *http.Request) string {
+	key := r.Header.Get("X-Api-Key")
+	if key == "" {
+		key = r.URL.Query().Get("key")
+	}
+	if key == "" {
+		return ""
+	}
+	for _, k := range apiKeys() {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return k
+		}
+	}
+	return ""
+}
+
+// apiKeys 配置的全部接口密钥
+func apiKeys() []string {
+	var keys []string
+	for _, k := range strings.Split(conf.ApiKeys, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// signAuth 校验下载地址的签名参数
+func signAuth(r *http.Request) bool {
+	id := ""
+	for _, prefix := range []string{conf.FileRoute, conf.ViewRoute} {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			id = strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, prefix), subsSuffix)
+			break
+		}
+	}
+	if id == "" {
+		return false
+	}
+	valid, _ := validSignature(r, id)
+	return valid
+}
+
+// ipAuth 校验客户端IP是否在白名单中，白名单可以是单个地址或 CIDR
+func ipAuth(r *http.Request) bool {
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil {
+		return false
+	}
+	for _, s := range strings.Split(conf.AuthIPs, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if _, n, err := net.ParseCIDR(s); err == nil {
+			if n.Contains(ip) {
+				return true
+			}
+		} else if allowed := net.ParseIP(s); allowed != nil && allowed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// OIDC 令牌校验结果的缓存时间
+const oidcCacheTTL = 5 * time.Minute
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcAuth 使用身份提供方的 userinfo 接口校验 Bearer 令牌，用户需在 oidcallow 中，结果缓存一段时间
+func oidcAuth(r *http.Request) bool {
+	if conf.OidcIssuer == "" || conf.OidcAllow == "" {
+		return false
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if token == "" || token == r.Header.Get("Authorization") {
+		return false
+	}
+	sum := sha256.Sum256([]byte(token))
+	key := "oidc:" + hex.EncodeToString(sum[:])
+	if v, ok := store.Default().CacheGet(key); ok {
+		return v == "1"
+	}
+	endpoint, err := oidcUserinfo()
+	if err != nil {
+		// 身份提供方不可用时不缓存结果
+		return false
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, endpoint, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := oidcClient.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	var info oidcUser
+	ok := resp.StatusCode == http.StatusOK && json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&info) == nil && info.allowed()
+	v := "0"
+	if ok {
+		v = "1"
+	}
+	store.Default().CacheSet(key, v, oidcCacheTTL)
+	return ok
+}
+
+// oidcUser userinfo 接口返回的用户信息
+type oidcUser struct {
+	Sub           string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// allowed 用户的 sub、已验证的邮箱或邮箱域名是否在 oidcallow 中
+func (u oidcUser) allowed() bool {
+	email := ""
+	if u.EmailVerified {
+		email = strings.ToLower(u.Email)
+	}
+	for _, a := range strings.Split(conf.OidcAllow, ",") {
+		a = strings.TrimSpace(a)
+		switch {
+		case a == "":
+		case strings.HasPrefix(a, "@"):
+			if email != "" && strings.HasSuffix(email, strings.ToLower(a)) {
+				return true
+			}
+		case strings.Contains(a, "@"):
+			if email != "" && email == strings.ToLower(a) {
+				return true
+			}
+		case u.Sub != "" && a == u.Sub:
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	oidcEndpoint string
+	oidcMu       sync.Mutex
+)
+
+// oidcUserinfo 从发现文档读取 userinfo 接口地址
+func oidcUserinfo() (string, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if oidcEndpoint != "" {
+		return oidcEndpoint, nil
+	}
+	resp, err := oidcClient.Get(strings.TrimSuffix(conf.OidcIssuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("读取 OIDC 配置失败: %s", resp.Status)
+	}
+	var doc struct {
+		Userinfo string `json:"userinfo_endpoint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", err
+	}
+	if doc.Userinfo == "" {
+		return "", fmt.Errorf("OIDC 配置缺少 userinfo_endpoint")
+	}
+	oidcEndpoint = doc.Userinfo
+	return oidcEndpoint, nil
+}
@@ -0,0 +1,331 @@
+package control
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/tenant"
+)
+
+func TestLoadAuth(t *testing.T) {
+	defer LoadAuth("")
+	for _, spec := range []string{"upload", "nogroup=pass", "upload=unknown", "upload=", "upload= , "} {
+		if err := LoadAuth(spec); err == nil {
+			t.Errorf("%q: 期望错误", spec)
+		}
+	}
+	if err := LoadAuth(" upload = pass , apikey ; download=sign "); err != nil {
+		t.Fatal(err)
+	}
+	if c := getAuthChain(AuthUpload); len(c.names) != 2 || !c.has("apikey") {
+		t.Errorf("upload: %v", c.names)
+	}
+	if c := getAuthChain(AuthAdmin); len(c.names) != 1 || !c.has("pass") {
+		t.Errorf("admin 应使用默认配置: %v", c.names)
+	}
+}
+
+func TestAuthChain(t *testing.T) {
+	oldPass, oldKeys, oldIPs := conf.Pass, conf.ApiKeys, conf.AuthIPs
+	defer func() {
+		conf.Pass, conf.ApiKeys, conf.AuthIPs = oldPass, oldKeys, oldIPs
+		LoadAuth("")
+	}()
+	conf.Pass, conf.ApiKeys, conf.AuthIPs = "secret", "k1, k2", "10.0.0.0/8,192.168.1.5"
+	if err := LoadAuth("upload=pass,apikey;admin=ip;download=sign"); err != nil {
+		t.Fatal(err)
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	exp := time.Now().Add(time.Hour).Unix()
+	session, _ := newSession("", conf.Pass)
+	tests := []struct {
+		group string
+		url   string
+		setup func(r *http.Request)
+		want  int
+	}{
+		{AuthUpload, "/api", nil, http.StatusSeeOther},
+		{AuthUpload, "/api?pass=secret", nil, http.StatusOK},
+		{AuthUpload, "/api?key=k2", nil, http.StatusOK},
+		{AuthUpload, "/api", func(r *http.Request) { r.Header.Set("X-Api-Key", "k1") }, http.StatusOK},
+		{AuthUpload, "/api", func(r *http.Request) { r.Header.Set("X-Api-Key", "k3") }, http.StatusSeeOther},
+		{AuthUpload, "/paste", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "p", Value: session}) }, http.StatusOK},
+		{AuthUpload, "/paste", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "p", Value: "secret"}) }, http.StatusSeeOther},
+		{AuthAdmin, "/api/search", func(r *http.Request) { r.RemoteAddr = "10.1.2.3:1234" }, http.StatusOK},
+		{AuthAdmin, "/api/search", func(r *http.Request) { r.RemoteAddr = "192.168.1.5:1234" }, http.StatusOK},
+		{AuthAdmin, "/api/search", func(r *http.Request) { r.RemoteAddr = "192.168.1.6:1234" }, http.StatusUnauthorized},
+		{AuthAdmin, "/api/search?pass=secret", nil, http.StatusUnauthorized},
+		{AuthDownload, conf.FileRoute + "abc", nil, http.StatusUnauthorized},
+		{AuthDownload, conf.FileRoute + "abc?" + signedQuery("abc", exp), nil, http.StatusOK},
+		{AuthDownload, conf.FileRoute + "abc/subs?" + signedQuery("abc", exp), nil, http.StatusOK},
+		{AuthDownload, conf.FileRoute + "abd?" + signedQuery("abc", exp), nil, http.StatusUnauthorized},
+		{AuthPage, "/", nil, http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		if tt.setup != nil {
+			tt.setup(r)
+		}
+		w := httptest.NewRecorder()
+		Auth(tt.group, ok)(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s %s: 状态码 %d，期望 %d", tt.group, tt.url, w.Code, tt.want)
+		}
+	}
+}
+
+func TestOidcAllow(t *testing.T) {
+	users := map[string]string{
+		"alice":   `{"sub":"u1","email":"alice@example.com","email_verified":true}`,
+		"bob":     `{"sub":"u2","email":"bob@corp.example","email_verified":true}`,
+		"eve":     `{"sub":"u3","email":"eve@corp.example","email_verified":false}`,
+		"mallory": `{"sub":"u4","email":"mallory@other.example","email_verified":true}`,
+	}
+	var idp *httptest.Server
+	idp = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/.well-known/openid-configuration" {
+			w.Write([]byte(`{"userinfo_endpoint":"` + idp.URL + `/userinfo"}`))
+			return
+		}
+		body, ok := users[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer idp.Close()
+	oldIssuer, oldAllow := conf.OidcIssuer, conf.OidcAllow
+	defer func() {
+		conf.OidcIssuer, conf.OidcAllow = oldIssuer, oldAllow
+		oidcEndpoint = ""
+	}()
+	conf.OidcIssuer, conf.OidcAllow, oidcEndpoint = idp.URL, "", ""
+	check := func(token string) bool {
+		r := httptest.NewRequest(http.MethodGet, "/api", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		return oidcAuth(r)
+	}
+	if check("alice") {
+		t.Error("未设置 oidcallow 时不应通过")
+	}
+	conf.OidcAllow = "u4, Alice@Example.com,@corp.example"
+	for token, want := range map[string]bool{"alice": true, "bob": true, "eve": false, "mallory": true, "nobody": false} {
+		if got := check(token); got != want {
+			t.Errorf("%s: %v，期望 %v", token, got, want)
+		}
+	}
+}
+
+func TestOCIAuth(t *testing.T) {
+	oldPass, oldKeys := conf.Pass, conf.ApiKeys
+	defer func() {
+		conf.Pass, conf.ApiKeys = oldPass, oldKeys
+		LoadAuth("")
+	}()
+	conf.Pass, conf.ApiKeys = "secret", "k1"
+	if err := LoadAuth("upload=pass,apikey;download=pass"); err != nil {
+		t.Fatal(err)
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	tests := []struct {
+		group string
+		pass  string
+		want  int
+	}{
+		{AuthUpload, "", http.StatusUnauthorized},
+		{AuthUpload, "secret", http.StatusOK},
+		{AuthUpload, "k1", http.StatusOK},
+		{AuthUpload, "wrong", http.StatusUnauthorized},
+		{AuthDownload, "secret", http.StatusOK},
+		{AuthDownload, "k1", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPut, OCIRoute+"library/hello/manifests/v1", nil)
+		if tt.pass != "" {
+			r.SetBasicAuth("docker", tt.pass)
+		}
+		w := httptest.NewRecorder()
+		ociAuth(tt.group, ok)(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s %q: 状态码 %d，期望 %d", tt.group, tt.pass, w.Code, tt.want)
+		}
+		// 未通过认证时不跳转到密码页面
+		if w.Code == http.StatusUnauthorized && !strings.HasPrefix(w.Header().Get("WWW-Authenticate"), "Basic ") {
+			t.Errorf("%s %q: WWW-Authenticate %q", tt.group, tt.pass, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	token, exp := newSession("", "secret")
+	if time.Until(exp) <= 0 {
+		t.Fatalf("过期时间错误: %v", exp)
+	}
+	if !validSession(token, "", "secret") {
+		t.Error("有效令牌校验失败")
+	}
+	if validSession(token, "", "changed") {
+		t.Error("修改密码后令牌仍然有效")
+	}
+	if validSession(token, "t:a", "secret") {
+		t.Error("令牌不应对租户有效")
+	}
+	old := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	if validSession(old+"."+sessionSignature("", "secret", time.Now().Add(-time.Minute).Unix()), "", "secret") {
+		t.Error("过期令牌仍然有效")
+	}
+	for _, bad := range []string{"", "secret", "1.2", token + "0"} {
+		if validSession(bad, "", "secret") {
+			t.Errorf("%q: 无效令牌通过校验", bad)
+		}
+	}
+}
+
+func TestCsrf(t *testing.T) {
+	oldPass := conf.Pass
+	defer func() {
+		conf.Pass = oldPass
+		LoadAuth("")
+	}()
+	conf.Pass = "secret"
+	LoadAuth("")
+	session, _ := newSession("", conf.Pass)
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	tests := []struct {
+		method string
+		url    string
+		token  string
+		form   bool
+		want   int
+	}{
+		{http.MethodGet, "/api/search", "", false, http.StatusOK},
+		{http.MethodPost, "/api", "", false, http.StatusForbidden},
+		{http.MethodPost, "/api", "bad", false, http.StatusForbidden},
+		{http.MethodPost, "/api", csrfToken(session), false, http.StatusOK},
+		{http.MethodPost, "/api/paste", csrfToken(session), true, http.StatusOK},
+		{http.MethodPost, "/api?pass=secret", "", false, http.StatusOK},
+		// 无效的凭据不能跳过 CSRF 校验
+		{http.MethodPost, "/api?pass=wrong", "", false, http.StatusForbidden},
+		{http.MethodPost, "/api?key=x", "", false, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		var r *http.Request
+		if tt.form {
+			r = httptest.NewRequest(tt.method, tt.url, strings.NewReader("content=x&csrf="+tt.token))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			r = httptest.NewRequest(tt.method, tt.url, nil)
+			if tt.token != "" {
+				r.Header.Set(csrfHeader, tt.token)
+			}
+		}
+		r.AddCookie(&http.Cookie{Name: "p", Value: session})
+		w := httptest.NewRecorder()
+		Auth(AuthUpload, ok)(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s %s %q: 状态码 %d，期望 %d", tt.method, tt.url, tt.token, w.Code, tt.want)
+		}
+	}
+}
+
+func TestTenantCsrf(t *testing.T) {
+	tn := &tenant.Tenant{Name: "acme", Pass: "secret", ApiKeys: []string{"k1"}}
+	session, _ := newSession("t:"+tn.Name, tn.Pass)
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"/t/acme/api", false},
+		{"/t/acme/api?pass=wrong", false},
+		{"/t/acme/api?key=x", false},
+		{"/t/acme/api?pass=secret", true},
+		{"/t/acme/api?key=k1", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, tt.url, nil)
+		r.AddCookie(&http.Cookie{Name: tn.CookieName(), Value: session})
+		if got := tenantCsrfValid(r, tn); got != tt.want {
+			t.Errorf("%s: %v，期望 %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestRequestActor(t *testing.T) {
+	oldPass, oldKeys := conf.Pass, conf.ApiKeys
+	defer func() { conf.Pass, conf.ApiKeys = oldPass, oldKeys }()
+	conf.Pass, conf.ApiKeys = "secret", "k1secret"
+	session, _ := newSession("", conf.Pass)
+	tests := []struct {
+		url   string
+		setup func(r *http.Request)
+		want  string
+	}{
+		{"/api", nil, "anonymous"},
+		{"/api?pass=secret", nil, "pass"},
+		{"/api?pass=wrong", nil, "anonymous"},
+		{"/api", func(r *http.Request) { r.Header.Set("X-Api-Key", "k1secret") }, "apikey:k1se…"},
+		{"/api", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: "p", Value: session}) }, "session"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, tt.url, nil)
+		if tt.setup != nil {
+			tt.setup(r)
+		}
+		if got := requestActor(r); got != tt.want {
+			t.Errorf("%s: %q，期望 %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestKeyUsage(t *testing.T) {
+	oldKeys, oldDaily := conf.ApiKeys, conf.KeyDailyLimit
+	defer func() { conf.ApiKeys, conf.KeyDailyLimit = oldKeys, oldDaily }()
+	conf.ApiKeys, conf.KeyDailyLimit = "usage-key-1,usage-key-2", 100
+	h := KeyUsage(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write(bytes.Repeat([]byte("x"), 60))
+	}))
+	do := func(key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader("0123456789"))
+		r.Header.Set("X-Api-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	// 第二次请求后合计 140 字节，超过上限的第三次请求被拒绝
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if w := do("usage-key-1"); w.Code != want {
+			t.Fatalf("请求 %d: %d %s", i, w.Code, w.Body.String())
+		}
+	}
+	// 无效的密钥不计入统计，也不受限制
+	if w := do("wrong"); w.Code != http.StatusOK {
+		t.Errorf("无效密钥: %d", w.Code)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, UsageRoute, nil)
+	r.Header.Set("X-Api-Key", "usage-key-1")
+	w := httptest.NewRecorder()
+	Usage(w, r)
+	var res []conf.KeyUsage
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0].DayUpload != 20 || res[0].DayDownload != 120 || res[0].MonthDownload != 120 || res[0].DayLimit != 100 {
+		t.Errorf("usage = %+v", res)
+	}
+	w = httptest.NewRecorder()
+	Usage(w, httptest.NewRequest(http.MethodGet, UsageRoute+"?day=2024-13-01", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("无效日期: %d", w.Code)
+	}
+}
@@ -0,0 +1,103 @@
+package control
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+)
+
+// 统计登录失败次数的时间窗口
+const authFailWindow = 15 * time.Minute
+
+// 同一IP使用同一凭据认证成功时，每小时只记录一次
+const authOkInterval = time.Hour
+
+// keyActor 记录接口密钥时只保留前几位
+func keyActor(key string) string {
+	if len(key) > 4 {
+		return key[:4] + "…"
+	}
+	return key
+}
+
+// presentedCredential 请求中携带的密码参数或接口密钥，返回认证方式、记录的主体及校验结果
+func presentedCredential(r *http.Request) (method, actor string, valid bool) {
+	key := r.Header.Get("X-Api-Key")
+	if key == "" {
+		key = r.URL.Query().Get("key")
+	}
+	if key != "" {
+		return "apikey", keyActor(key), apiKeyAuth(r)
+	}
+	if pass := r.URL.Query().Get("pass"); pass != "" && strings.HasPrefix(r.URL.Path, "/api") && passEnabled() {
+		return "pass", "", checkPass(pass, conf.Pass)
+	}
+	return "", "", false
+}
+
+// lockoutEnabled 是否开启认证失败锁定
+func lockoutEnabled() bool {
+	return conf.LoginMaxFails > 0 && conf.LoginLockout > 0
+}
+
+// authLocked 客户端IP是否因多次认证失败被暂时锁定
+func authLocked(r *http.Request) bool {
+	if !lockoutEnabled() {
+		return false
+	}
+	_, ok := store.Default().CacheGet("lockout:" + clientIP(r))
+	return ok
+}
+
+// lockedResponse 返回锁定提示
+func lockedResponse(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(conf.LoginLockout*60))
+	http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+}
+
+// authEvent 写入认证日志
+func authEvent(r *http.Request, method, actor string, ok bool, reason string) {
+	store.Default().AddAuthEvent(store.AuthEvent{
+		Time:   time.Now().Unix(),
+		IP:     clientIP(r),
+		Method: method,
+		Actor:  actor,
+		Path:   r.URL.Path,
+		Ok:     ok,
+		Reason: reason,
+	})
+}
+
+// authSucceeded 记录认证成功，同一IP及凭据在间隔内只记录一次
+func authSucceeded(r *http.Request, method, actor string) {
+	key := "authok:" + method + ":" + actor + ":" + clientIP(r)
+	if _, ok := store.Default().CacheGet(key); ok {
+		return
+	}
+	store.Default().CacheSet(key, "1", authOkInterval)
+	authEvent(r, method, actor, true, "")
+}
+
+// authFailed 记录认证失败，时间窗口内失败次数达到上限时锁定该IP
+func authFailed(r *http.Request, method, actor, reason string) {
+	authEvent(r, method, actor, false, reason)
+	if !lockoutEnabled() {
+		return
+	}
+	ip := clientIP(r)
+	n, err := store.Default().Incr("authfail:"+ip, authFailWindow)
+	if err != nil {
+		log.Printf("认证失败计数失败: %v", err)
+		return
+	}
+	if n >= int64(conf.LoginMaxFails) {
+		store.Default().CacheSet("lockout:"+ip, "1", time.Duration(conf.LoginLockout)*time.Minute)
+		log.Printf("IP %s 认证失败 %d 次，锁定 %d 分钟", ip, n, conf.LoginLockout)
+		authEvent(r, method, actor, false, "locked")
+	}
+}
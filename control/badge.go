@@ -0,0 +1,87 @@
+package control
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"csz.net/tgstate/store"
+)
+
+// BadgeRoute 下载次数徽章路径，/badge/{id}.svg
+const BadgeRoute = "/badge/"
+
+// badgeColors 徽章支持的颜色名称，与 shields.io 一致
+var badgeColors = map[string]string{
+	"brightgreen": "4c1",
+	"green":       "97ca00",
+	"yellow":      "dfb317",
+	"orange":      "fe7d37",
+	"red":         "e05d44",
+	"blue":        "007ec6",
+	"grey":        "555",
+	"lightgrey":   "9f9f9f",
+}
+
+var badgeHexRe = regexp.MustCompile(`^(?:[0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// badgeCount 格式化下载次数，如 1234 为 1.2k
+func badgeCount(n int64) string {
+	switch {
+	case n >= 1000000:
+		return strconv.FormatFloat(float64(n)/1000000, 'f', 1, 64) + "M"
+	case n >= 1000:
+		return strconv.FormatFloat(float64(n)/1000, 'f', 1, 64) + "k"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// badgeWidth 按字符数估算文字宽度，Verdana 11px 平均约 7 像素
+func badgeWidth(s string) int {
+	return len([]rune(s))*7 + 10
+}
+
+// Badge 返回 shields.io 风格的下载次数徽章，label 与 color 参数可自定义左侧文字及右侧颜色
+func Badge(w http.ResponseWriter, r *http.Request) {
+	name := PathValue(r, "id")
+	if !strings.HasSuffix(name, ".svg") {
+		http.NotFound(w, r)
+		return
+	}
+	id := strings.TrimSuffix(name, ".svg")
+	f, ok := store.Default().GetFile(strings.TrimPrefix(id, "blob-"))
+	// 私有、待审核及回收站中的文件不公开下载次数
+	if status, _ := unavailable(r, id); !ok || status != 0 || f.Access() == store.VisibilityPrivate {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	label := q.Get("label")
+	if label == "" || len([]rune(label)) > 40 {
+		label = "downloads"
+	}
+	color := badgeColors["brightgreen"]
+	if c := q.Get("color"); badgeHexRe.MatchString(c) {
+		color = c
+	} else if c, ok := badgeColors[c]; ok {
+		color = c
+	}
+	value := badgeCount(f.Downloads)
+	lw, vw := badgeWidth(label), badgeWidth(value)
+	label, value = html.EscapeString(label), html.EscapeString(value)
+	w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+	// 徽章通常经 GitHub 等代理缓存，缩短缓存时间使计数及时更新
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">`+
+		`<title>%[4]s: %[5]s</title>`+
+		`<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`+
+		`<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>`+
+		`<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="#%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`+
+		`<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[7]d" y="14">%[4]s</text>`+
+		`<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[5]s</text><text x="%[8]d" y="14">%[5]s</text></g></svg>`,
+		lw+vw, lw, vw, label, value, color, lw/2, lw+vw/2)
+}
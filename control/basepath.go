@@ -0,0 +1,94 @@
+package control
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"csz.net/tgstate/conf"
+)
+
+// CleanBasePath 规范化子路径前缀，返回以 / 开头且不以 / 结尾的路径，根路径返回空
+func CleanBasePath(p string) string {
+	p = strings.Trim(strings.TrimSpace(p), "/")
+	if p == "" {
+		return ""
+	}
+	return path.Clean("/" + p)
+}
+
+// BasePath 部署在子路径时去掉请求路径中的前缀后交给后续处理，前缀之外的路径返回 404；
+// 响应中以 / 开头的 Location 会补上前缀，处理函数只需生成应用内的路径
+func BasePath(next http.Handler) http.Handler {
+	if conf.BasePath == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == conf.BasePath {
+			u := *r.URL
+			u.Path, u.RawPath = conf.BasePath+"/", ""
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+			return
+		}
+		p := strings.TrimPrefix(r.URL.Path, conf.BasePath)
+		if len(p) == len(r.URL.Path) || p[0] != '/' {
+			http.NotFound(w, r)
+			return
+		}
+		r2 := new(http.Request)
+		*r2 = *r
+		r2.URL = new(url.URL)
+		*r2.URL = *r.URL
+		r2.URL.Path = p
+		r2.URL.RawPath = ""
+		if raw := strings.TrimPrefix(r.URL.RawPath, conf.BasePath); raw != r.URL.RawPath {
+			r2.URL.RawPath = raw
+		}
+		next.ServeHTTP(&baseWriter{ResponseWriter: w}, r2)
+	})
+}
+
+// baseWriter 在写出响应头前为应用内的 Location 补上子路径前缀
+type baseWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (bw *baseWriter) fixLocation() {
+	if bw.wrote {
+		return
+	}
+	bw.wrote = true
+	h := bw.ResponseWriter.Header()
+	if loc := h.Get("Location"); strings.HasPrefix(loc, "/") && !strings.HasPrefix(loc, "//") {
+		h.Set("Location", conf.BasePath+loc)
+	}
+}
+
+func (bw *baseWriter) WriteHeader(status int) {
+	bw.fixLocation()
+	bw.ResponseWriter.WriteHeader(status)
+}
+
+func (bw *baseWriter) Write(p []byte) (int, error) {
+	bw.fixLocation()
+	return bw.ResponseWriter.Write(p)
+}
+
+// ReadFrom 保留底层连接的 sendfile 优化
+func (bw *baseWriter) ReadFrom(r io.Reader) (int64, error) {
+	bw.fixLocation()
+	if rf, ok := bw.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{bw.ResponseWriter}, r)
+}
+
+func (bw *baseWriter) Flush() {
+	bw.fixLocation()
+	if f, ok := bw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,135 @@
+package control
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/utils"
+)
+
+// 并行下载的分块输出后保留在缓存中的时间
+const blobPartKeep = 30 * time.Second
+
+// blobPart 需要输出的分块及其中的范围
+type blobPart struct {
+	chunk   utils.BlobChunk
+	skip, n int64
+	done    chan struct{}
+	path    string
+	err     error
+}
+
+// writeBlobParallel 同 writeBlob，最多同时下载 conf.BlobParallel 个分块到文件缓存后按顺序输出，
+// 各分块轮流从主地址及 replicas 配置的地址下载；只用于已知各分块大小的索引
+func writeBlobParallel(ctx context.Context, w io.Writer, idx *utils.BlobIndex, start, length int64) error {
+	var parts []*blobPart
+	var offset int64
+	for _, chunk := range idx.Chunks {
+		if length <= 0 {
+			break
+		}
+		if offset+chunk.Size <= start {
+			offset += chunk.Size
+			continue
+		}
+		skip := start - offset
+		if skip < 0 {
+			skip = 0
+		}
+		n := length
+		if chunk.Size-skip < n {
+			n = chunk.Size - skip
+		}
+		parts = append(parts, &blobPart{chunk: chunk, skip: skip, n: n, done: make(chan struct{})})
+		offset += chunk.Size
+		length -= n
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cache := getFileCache()
+	// 已下载未输出的分块占用一个名额，限制缓存中等待输出的分块数
+	slots := make(chan struct{}, conf.BlobParallel)
+	go func() {
+		for i, p := range parts {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			go func(i int, p *blobPart) {
+				defer close(p.done)
+				p.path, p.err = cache.getCachedReplica(ctx, p.chunk.ID, i)
+				// 输出已中止，下载完成的分块不会再被输出
+				if p.err == nil && ctx.Err() != nil {
+					cache.cleanupLater(p.chunk.ID, blobPartKeep)
+				}
+			}(i, p)
+		}
+	}()
+	// 提前返回时，已下载完成但未输出的分块同样只在缓存中保留一段时间
+	next := 0
+	defer func() {
+		for _, p := range parts[next:] {
+			select {
+			case <-p.done:
+				if p.err == nil {
+					cache.cleanupLater(p.chunk.ID, blobPartKeep)
+				}
+			default:
+			}
+		}
+	}()
+	for i, p := range parts {
+		next = i
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if p.err != nil {
+			return fmt.Errorf("chunk %s: %w", p.chunk.ID, p.err)
+		}
+		err := copyCachedChunk(w, p)
+		<-slots
+		cache.cleanupLater(p.chunk.ID, blobPartKeep)
+		next = i + 1
+		if err != nil {
+			return fmt.Errorf("chunk %s: %w", p.chunk.ID, err)
+		}
+	}
+	return nil
+}
+
+// copyCachedChunk 输出缓存中分块的指定范围，完整输出时先校验哈希，校验失败的缓存会被删除
+func copyCachedChunk(w io.Writer, p *blobPart) error {
+	f, err := os.Open(p.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if p.skip == 0 && p.n == p.chunk.Size && p.chunk.Sha256 != "" {
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != p.chunk.Sha256 {
+			getFileCache().cleanupFile(p.chunk.ID)
+			return fmt.Errorf("sha256 mismatch: %s", sum)
+		}
+	}
+	if _, err := f.Seek(p.skip, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := io.Copy(w, io.LimitReader(f, p.n))
+	if err == nil && n < p.n {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
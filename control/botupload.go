@@ -0,0 +1,69 @@
+package control
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/i18n"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// botUploadTimeout 通过机器人上传时下载及上传文件的超时时间
+const botUploadTimeout = 30 * time.Minute
+
+// parseBotUpload 解析 “地址 [文件名]” 格式的消息，只接受 http 及 https 地址，文件名只保留最后一段
+func parseBotUpload(text string) (*url.URL, string, bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, "", false
+	}
+	u, err := url.Parse(fields[0])
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, "", false
+	}
+	name := ""
+	if len(fields) == 2 {
+		name = path.Base(fields[1])
+		if name == "." || name == "/" || name == ".." {
+			name = ""
+		}
+	}
+	return u, name, true
+}
+
+// botUpload 下载消息中的地址指向的文件并存储，回复文件链接，不是地址的消息不回复
+func botUpload(user int64, text string) string {
+	u, name, ok := parseBotUpload(text)
+	if !ok {
+		return ""
+	}
+	t := func(msg string) string { return i18n.T(i18n.Default, msg) }
+	ctx, cancel := context.WithTimeout(context.Background(), botUploadTimeout)
+	defer cancel()
+	res, err := fetchUpload(ctx, u, name)
+	if err != nil {
+		log.Printf("通过机器人上传 %s 失败: %v", u, err)
+		return "⚠️ " + t("Upload failed") + ": " + t(err.Error())
+	}
+	id := strings.TrimPrefix(res.Message, conf.FileRoute)
+	store.Default().AddAudit(store.AuditEntry{
+		Time:   time.Now().Unix(),
+		Action: "upload",
+		Actor:  "telegram:" + strconv.FormatInt(user, 10),
+		Target: id,
+		Detail: u.String(),
+	})
+	return strings.TrimSuffix(conf.BaseUrl, "/") + res.Message
+}
+
+// StartBotUpload 注册机器人私聊消息的处理，授权用户发送地址即可上传文件
+func StartBotUpload() {
+	utils.HandleText(botUpload)
+}
@@ -0,0 +1,356 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"csz.net/tgstate/tracing"
+	"csz.net/tgstate/utils"
+)
+
+// 缓存分片数，不同文件的读写落在不同分片上互不阻塞
+const cacheShards = 32
+
+// 超过该秒数未访问的缓存文件将被清理
+const cacheExpire = 3600
+
+// 下载中的缓存文件后缀
+const cachePartSuffix = ".part"
+
+// cacheEntry 缓存的文件
+type cacheEntry struct {
+	path       string // 本地文件路径
+	lastAccess int64  // 最后访问时间
+}
+
+// fileLock 单个文件的下载锁，refs 为等待及持有的请求数
+type fileLock struct {
+	sync.Mutex
+	refs int
+}
+
+// cacheShard 缓存分片
+type cacheShard struct {
+	sync.Mutex
+	entries map[string]*cacheEntry // fileID -> 缓存文件
+	locks   map[string]*fileLock   // fileID -> 正在进行的下载
+}
+
+// 文件缓存结构
+type FileCache struct {
+	shards   [cacheShards]cacheShard
+	cacheDir string      // 缓存目录
+	delayed  *delayQueue // 下载完成后延迟清理的文件
+}
+
+var (
+	fileCache *FileCache
+	once      sync.Once
+)
+
+// 获取文件缓存单例
+func getFileCache() *FileCache {
+	once.Do(func() {
+		cacheDir := filepath.Join(".", "file_cache")
+		if _, err := os.Stat(cacheDir); os.IsNotExist(err) {
+			os.MkdirAll(cacheDir, 0755)
+		}
+		fileCache = &FileCache{cacheDir: cacheDir}
+		for i := range fileCache.shards {
+			fileCache.shards[i].entries = make(map[string]*cacheEntry)
+			fileCache.shards[i].locks = make(map[string]*fileLock)
+		}
+		fileCache.delayed = newDelayQueue(fileCache.cleanupFile)
+		// 启动定期清理协程
+		go fileCache.periodicCleanup()
+	})
+	return fileCache
+}
+
+// shard 返回文件所在的分片
+func (fc *FileCache) shard(fileID string) *cacheShard {
+	h := fnv.New32a()
+	h.Write([]byte(fileID))
+	return &fc.shards[h.Sum32()%cacheShards]
+}
+
+// lookup 查找缓存并更新访问时间
+func (fc *FileCache) lookup(fileID string) (string, bool) {
+	sh := fc.shard(fileID)
+	sh.Lock()
+	defer sh.Unlock()
+	e, ok := sh.entries[fileID]
+	if !ok {
+		return "", false
+	}
+	e.lastAccess = time.Now().Unix()
+	return e.path, true
+}
+
+// store 登记缓存文件
+func (fc *FileCache) store(fileID, filePath string, lastAccess int64) {
+	sh := fc.shard(fileID)
+	sh.Lock()
+	sh.entries[fileID] = &cacheEntry{path: filePath, lastAccess: lastAccess}
+	sh.Unlock()
+}
+
+// lockFile 获取单个文件的下载锁，同一文件的并发请求只下载一次
+func (fc *FileCache) lockFile(fileID string) func() {
+	sh := fc.shard(fileID)
+	sh.Lock()
+	l, ok := sh.locks[fileID]
+	if !ok {
+		l = &fileLock{}
+		sh.locks[fileID] = l
+	}
+	l.refs++
+	sh.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		sh.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(sh.locks, fileID)
+		}
+		sh.Unlock()
+	}
+}
+
+// 获取缓存文件，如果不存在则下载；ctx 取消时停止下载并删除临时文件
+func (fc *FileCache) getCachedFile(ctx context.Context, fileID string) (string, error) {
+	return fc.getCachedReplica(ctx, fileID, 0)
+}
+
+// getCachedReplica 同 getCachedFile，未缓存时从第 replica 个下载地址下载，失败后改用主地址
+func (fc *FileCache) getCachedReplica(ctx context.Context, fileID string, replica int) (string, error) {
+	// 文件ID直接用作缓存文件名，不能包含路径分隔符或 ..
+	if !utils.ValidFileID(fileID) {
+		return "", utils.ErrInvalidFileID
+	}
+	ctx, span := tracing.Start(ctx, "cache get", tracing.Internal)
+	defer span.End()
+	span.SetAttr("file.id", fileID)
+	// 检查缓存
+	if filePath, ok := fc.lookup(fileID); ok {
+		// 检查文件是否存在
+		if _, err := os.Stat(filePath); err == nil {
+			// 有新的请求，取消上一次下载完成后安排的清理
+			fc.delayed.cancel(fileID)
+			span.SetAttr("cache.hit", true)
+			return filePath, nil
+		}
+	}
+
+	unlock := fc.lockFile(fileID)
+	defer unlock()
+	// 等待期间其他请求可能已经下载完成
+	if filePath, ok := fc.lookup(fileID); ok {
+		if _, err := os.Stat(filePath); err == nil {
+			fc.delayed.cancel(fileID)
+			span.SetAttr("cache.hit", true)
+			return filePath, nil
+		}
+	}
+	span.SetAttr("cache.hit", false)
+
+	// 缓存不存在或文件已删除，下载文件
+	fileURL, _, err := utils.ResolveDownload(ctx, fileID)
+	if err != nil {
+		span.SetError(err)
+		return "", err
+	}
+	replicaURL := utils.ReplicaUrl(fileURL, replica)
+	filePath, err := fc.download(ctx, fileID, replicaURL)
+	if err != nil && replicaURL != fileURL && ctx.Err() == nil {
+		log.Printf("从副本下载失败【%s】: %v", fileID, err)
+		span.SetAttr("replica.failed", true)
+		filePath, err = fc.download(ctx, fileID, fileURL)
+	}
+	if err != nil {
+		span.SetError(err)
+		return "", err
+	}
+	// 更新缓存
+	fc.store(fileID, filePath, time.Now().Unix())
+	return filePath, nil
+}
+
+// download 从 Telegram 下载文件到缓存目录，返回缓存文件路径
+func (fc *FileCache) download(ctx context.Context, fileID, fileURL string) (filePath string, err error) {
+	_, span := tracing.Start(ctx, "cdn fetch", tracing.Client)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	// 先下载到临时文件，完成后再改名，避免进程中断留下不完整的缓存
+	filePath = filepath.Join(fc.cacheDir, fileID)
+	out, err := os.CreateTemp(fc.cacheDir, fileID+".*"+cachePartSuffix)
+	if err != nil {
+		return "", err
+	}
+	tmpPath := out.Name()
+	defer out.Close()
+
+	// 下载文件
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	resp, err := utils.Upstream().Do(req)
+	if err != nil {
+		os.Remove(tmpPath)
+		if ctx.Err() != nil {
+			return "", err
+		}
+		return "", utils.CdnFetchError(nil, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		os.Remove(tmpPath)
+		fe := utils.CdnFetchError(resp, fmt.Errorf("下载文件失败，状态码: %d", resp.StatusCode))
+		if fe.Reason == utils.FetchExpired {
+			utils.ForgetDownloadUrl(fileID)
+		}
+		return "", fe
+	}
+
+	// 分别记录写入磁盘的耗时，区分 CDN 慢还是磁盘慢
+	dw := &timedWriter{w: out}
+	n, err := io.Copy(dw, resp.Body)
+	span.SetAttr("http.response.body.size", n)
+	span.SetAttr("disk.write_ms", dw.d.Milliseconds())
+	if err == nil {
+		err = out.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, filePath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return filePath, nil
+}
+
+// timedWriter 累计写入耗时
+type timedWriter struct {
+	w io.Writer
+	d time.Duration
+}
+
+func (tw *timedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := tw.w.Write(p)
+	tw.d += time.Since(start)
+	return n, err
+}
+
+// 清理指定文件
+func (fc *FileCache) cleanupFile(fileID string) {
+	sh := fc.shard(fileID)
+	sh.Lock()
+	e, exists := sh.entries[fileID]
+	delete(sh.entries, fileID)
+	sh.Unlock()
+
+	if exists && e.path != "" {
+		os.Remove(e.path)
+		log.Printf("已清理缓存文件: %s", fileID)
+	}
+}
+
+// cleanupLater 延迟清理文件，期间再次下载完成会重新计时
+func (fc *FileCache) cleanupLater(fileID string, d time.Duration) {
+	fc.delayed.schedule(fileID, d)
+}
+
+// 定期清理过期缓存
+func (fc *FileCache) periodicCleanup() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expireTime := time.Now().Unix() - cacheExpire
+		removed := 0
+		for i := range fc.shards {
+			var filesToDelete []string
+			sh := &fc.shards[i]
+			sh.Lock()
+			for fileID, e := range sh.entries {
+				// 正在下载的文件不清理
+				if _, busy := sh.locks[fileID]; busy || e.lastAccess >= expireTime {
+					continue
+				}
+				filesToDelete = append(filesToDelete, e.path)
+				delete(sh.entries, fileID)
+			}
+			sh.Unlock()
+
+			// 删除文件
+			for _, filePath := range filesToDelete {
+				os.Remove(filePath)
+			}
+			removed += len(filesToDelete)
+		}
+		if removed > 0 {
+			log.Printf("已清理 %d 个过期缓存文件", removed)
+		}
+	}
+}
+
+// CacheGC 启动时清理缓存目录，删除未完成的下载及超过过期时间的文件，
+// 其余文件登记到缓存中继续使用；dryRun 时只打印将要删除的文件
+func CacheGC(dryRun bool) {
+	fc := getFileCache()
+	entries, err := os.ReadDir(fc.cacheDir)
+	if err != nil {
+		log.Printf("读取缓存目录失败: %v", err)
+		return
+	}
+	expireTime := time.Now().Unix() - cacheExpire
+	var removed, kept int
+	var freed int64
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		name := e.Name()
+		filePath := filepath.Join(fc.cacheDir, name)
+		// 不是合法文件ID的文件不会被命中，一并清理
+		if !strings.HasSuffix(name, cachePartSuffix) && utils.ValidFileID(name) && info.ModTime().Unix() >= expireTime {
+			fc.store(name, filePath, info.ModTime().Unix())
+			kept++
+			continue
+		}
+		removed++
+		freed += info.Size()
+		if dryRun {
+			log.Printf("[dry-run] 将删除缓存文件: %s (%d 字节)", name, info.Size())
+			continue
+		}
+		os.Remove(filePath)
+	}
+	if dryRun {
+		log.Printf("[dry-run] 缓存目录中有 %d 个文件可删除，共 %d 字节，保留 %d 个", removed, freed, kept)
+	} else if removed > 0 {
+		log.Printf("已清理 %d 个残留缓存文件，释放 %d 字节，保留 %d 个", removed, freed, kept)
+	}
+}
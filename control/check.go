@@ -0,0 +1,66 @@
+package control
+
+import (
+	"net/http"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/utils"
+)
+
+// CheckRoute 批量检查链接状态的接口路径
+const CheckRoute = "/api/check"
+
+// checkMaxIDs 单次最多检查的链接数，未缓存的文件需要逐个调用 getFile
+const checkMaxIDs = 50
+
+// 链接状态
+const (
+	linkOk      = "ok"
+	linkPending = "pending"
+	linkDeleted = "deleted"
+	linkMissing = "missing"
+)
+
+// Check 批量检查文件链接是否仍可访问，供上传页面中保存在浏览器的上传记录显示状态
+func Check(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	var req conf.CheckRequest
+	if err := decodeRequest(r, &req); err != nil {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	if len(req.IDs) > checkMaxIDs {
+		writeError(w, r, http.StatusBadRequest, http.StatusOK, "Too many ids", map[string]int{"limit": checkMaxIDs})
+		return
+	}
+	res := conf.CheckResponse{Files: make([]conf.LinkStatus, 0, len(req.IDs))}
+	for _, id := range req.IDs {
+		// 客户端已断开
+		if r.Context().Err() != nil {
+			return
+		}
+		res.Files = append(res.Files, conf.LinkStatus{ID: id, Status: linkStatus(id)})
+	}
+	writeJson(w, http.StatusOK, res)
+}
+
+// linkStatus 检查单个文件，已缓存的文件视为可访问，否则通过 getFile 确认 Telegram 中的文件仍然存在
+func linkStatus(id string) string {
+	switch {
+	case !utils.ValidFileID(id):
+		return linkMissing
+	case isTrashed(id):
+		return linkDeleted
+	case isPending(id):
+		return linkPending
+	}
+	fileID := strings.TrimPrefix(id, "blob-")
+	if _, ok := getFileCache().lookup(fileID); ok {
+		return linkOk
+	}
+	if _, _, ok := utils.GetDownloadInfo(fileID); !ok {
+		return linkMissing
+	}
+	return linkOk
+}
@@ -0,0 +1,36 @@
+package control
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ContentSha256Header 上传时可选的内容 sha256（十六进制），multipart 上传时为其中文件的内容；
+// 读完内容后与之比较，不一致时中止发送到 Telegram 并拒绝上传
+const ContentSha256Header = "X-Content-Sha256"
+
+var errChecksumMismatch = errors.New("content sha256 mismatch")
+
+// uploadChecksum 读取请求头中的内容 sha256，未提供时返回空，格式错误时 ok 为 false
+func uploadChecksum(r *http.Request) (sum string, ok bool) {
+	sum = strings.ToLower(strings.TrimSpace(r.Header.Get(ContentSha256Header)))
+	if sum == "" {
+		return "", true
+	}
+	if b, err := hex.DecodeString(sum); err != nil || len(b) != 32 {
+		return "", false
+	}
+	return sum, true
+}
+
+// checksumError 收到的内容与 X-Content-Sha256 不一致
+func checksumError(w http.ResponseWriter, r *http.Request, file *countingReader) {
+	writeError(w, r, http.StatusBadRequest, http.StatusOK, "Content checksum mismatch", map[string]string{"expected": file.want, "actual": file.sum()})
+}
+
+// invalidChecksum X-Content-Sha256 不是 64 位十六进制
+func invalidChecksum(w http.ResponseWriter, r *http.Request) {
+	errJsonMsg(w, r, http.StatusBadRequest, "Invalid X-Content-Sha256 header")
+}
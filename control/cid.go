@@ -0,0 +1,150 @@
+package control
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// ipfsBlockSize ipfs add 默认的分块大小，超过时生成 UnixFS DAG，CID 不再是内容的直接哈希
+const ipfsBlockSize = 256 * 1024
+
+// cidResult CID 接口返回
+type cidResult struct {
+	Code   int    `json:"code"`
+	ID     string `json:"id"`
+	Sha256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	Cid    string `json:"cid,omitempty"`    // CIDv1 raw + sha2-256，只有单块文件与 ipfs add --raw-leaves 的结果一致
+	Pinned string `json:"pinned,omitempty"` // 固定到 IPFS 节点后节点返回的 UnixFS CID
+}
+
+// Cid 返回文件的 CID，POST 时将文件固定到配置的 IPFS 节点（需要访问密码）
+func Cid(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		serveCid(w, r, id, false)
+	case http.MethodPost:
+		if conf.IpfsApi == "" {
+			errJsonMsg(w, r, http.StatusNotImplemented, "IPFS node is not configured")
+			return
+		}
+		Auth(AuthAdmin, func(w http.ResponseWriter, r *http.Request) {
+			serveCid(w, r, id, true)
+		})(w, r)
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+func serveCid(w http.ResponseWriter, r *http.Request, id string, pin bool) {
+	sha, size, err := contentSha256(r, id)
+	if err != nil {
+		if r.Context().Err() == nil {
+			log.Printf("计算文件哈希失败: %v", err)
+		}
+		errJsonMsg(w, r, http.StatusBadGateway, "Failed to fetch content")
+		return
+	}
+	res := cidResult{Code: 1, ID: id, Sha256: sha, Size: size}
+	if size <= ipfsBlockSize {
+		res.Cid = rawCid(sha)
+	}
+	if pin {
+		if res.Pinned, err = ipfsPin(r, id); err != nil {
+			log.Printf("固定到 IPFS 失败: %v", err)
+			errJsonMsg(w, r, http.StatusBadGateway, err.Error())
+			return
+		}
+	}
+	writeJson(w, http.StatusOK, res)
+}
+
+// contentSha256 获取文件内容的 sha256 及大小，未登记时读取文件计算并登记
+func contentSha256(r *http.Request, id string) (string, int64, error) {
+	st := store.Default()
+	f, ok := st.GetFile(id)
+	if ok && f.Sha256 != "" && f.Size > 0 {
+		return f.Sha256, f.Size, nil
+	}
+	content, err := openContent(r.Context(), id)
+	if err != nil {
+		return "", 0, err
+	}
+	defer content.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, content)
+	if err != nil {
+		return "", 0, err
+	}
+	sha := hex.EncodeToString(h.Sum(nil))
+	if ok {
+		f.Sha256, f.Size = sha, size
+		if err := st.PutFile(f); err != nil {
+			log.Printf("保存文件记录失败: %v", err)
+		}
+		st.PutHash(sha, id)
+	}
+	return sha, size, nil
+}
+
+// rawCid 生成 CIDv1（raw 编码，sha2-256），以 base32 多重编码表示
+func rawCid(sha string) string {
+	digest, _ := hex.DecodeString(sha)
+	b := append([]byte{0x01, 0x55, 0x12, 0x20}, digest...)
+	return "b" + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+}
+
+// ipfsPin 通过 IPFS HTTP API 添加并固定文件，返回节点计算的 CID
+func ipfsPin(r *http.Request, id string) (string, error) {
+	content, err := openContent(r.Context(), id)
+	if err != nil {
+		return "", err
+	}
+	defer content.Close()
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", content.Name)
+		if err == nil {
+			_, err = io.Copy(part, content)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	api := strings.TrimSuffix(conf.IpfsApi, "/") + "/api/v0/add?cid-version=1&raw-leaves=true&pin=true"
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, api, pr)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	resp, err := utils.Upstream().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("ipfs: status %d", resp.StatusCode)
+	}
+	var added struct {
+		Hash string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&added); err != nil {
+		return "", err
+	}
+	return added.Hash, nil
+}
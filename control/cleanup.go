@@ -0,0 +1,94 @@
+package control
+
+import (
+	"sync"
+	"time"
+)
+
+// delayQueue 延迟执行的任务，同一键只保留最后一次安排的时间，由单个协程按时间顺序执行，
+// 避免每个请求各自启动一个等待的协程
+type delayQueue struct {
+	mu   sync.Mutex
+	due  map[string]time.Time
+	wake chan struct{}
+	run  func(key string)
+}
+
+// newDelayQueue 创建延迟队列并启动执行协程
+func newDelayQueue(run func(key string)) *delayQueue {
+	q := &delayQueue{
+		due:  make(map[string]time.Time),
+		wake: make(chan struct{}, 1),
+		run:  run,
+	}
+	go q.loop()
+	return q
+}
+
+// schedule 安排 d 之后执行，已安排的键推迟到新的时间
+func (q *delayQueue) schedule(key string, d time.Duration) {
+	q.mu.Lock()
+	q.due[key] = time.Now().Add(d)
+	q.mu.Unlock()
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// cancel 取消尚未执行的任务
+func (q *delayQueue) cancel(key string) {
+	q.mu.Lock()
+	delete(q.due, key)
+	q.mu.Unlock()
+}
+
+// pending 等待执行的任务数
+func (q *delayQueue) pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.due)
+}
+
+// next 取出已到时间的任务，并返回距下一个任务的等待时间，没有任务时为 -1
+func (q *delayQueue) next(now time.Time) ([]string, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var ready []string
+	wait := time.Duration(-1)
+	for key, t := range q.due {
+		if !t.After(now) {
+			ready = append(ready, key)
+			delete(q.due, key)
+			continue
+		}
+		if d := t.Sub(now); wait < 0 || d < wait {
+			wait = d
+		}
+	}
+	return ready, wait
+}
+
+func (q *delayQueue) loop() {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		ready, wait := q.next(time.Now())
+		for _, key := range ready {
+			q.run(key)
+		}
+		if wait >= 0 {
+			timer.Reset(wait)
+		}
+		select {
+		case <-timer.C:
+		case <-q.wake:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+	}
+}
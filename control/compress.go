@@ -0,0 +1,185 @@
+package control
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"github.com/andybalholm/brotli"
+)
+
+// 小于该大小的响应不压缩
+const compressMinSize = 1024
+
+// 支持的编码及其优先级，数值越小越优先
+var encodingRank = map[string]int{"br": 0, "gzip": 1, "deflate": 2}
+
+// 可压缩的内容类型前缀
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/x-javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"image/svg+xml",
+}
+
+// Compress 根据 Accept-Encoding 对文本类响应进行 br/gzip/deflate 压缩
+func Compress(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !conf.Compress || r.Header.Get("Range") != "" || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, status: http.StatusOK}
+		defer cw.Close()
+		next(cw, r)
+	}
+}
+
+// negotiateEncoding 选择客户端可接受且服务端支持的编码，权重相同时按 br、gzip、deflate 优先
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, q := part, 1.0
+		if i := strings.Index(part, ";"); i >= 0 {
+			name = strings.TrimSpace(part[:i])
+			param := strings.TrimSpace(part[i+1:])
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		name = strings.ToLower(name)
+		rank, ok := encodingRank[name]
+		if !ok {
+			continue
+		}
+		if q > bestQ || (q == bestQ && best != "" && rank < encodingRank[best]) {
+			best, bestQ = name, q
+		}
+	}
+	if bestQ <= 0 {
+		return ""
+	}
+	return best
+}
+
+// isCompressible 判断内容类型是否值得压缩
+func isCompressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		return false
+	}
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter 延迟写出状态码，直到拿到第一段数据后再决定是否压缩
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	started  bool
+	w        io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.started {
+		return
+	}
+	cw.status = code
+	// 无响应体的状态码直接写出
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		cw.start(nil)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.started {
+		cw.start(b)
+	}
+	if cw.w != nil {
+		return cw.w.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// start 决定是否压缩并写出响应头
+func (cw *compressWriter) start(b []byte) {
+	cw.started = true
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(b) > 0 {
+		h.Set("Content-Type", http.DetectContentType(b))
+	}
+	h.Add("Vary", "Accept-Encoding")
+	compress := cw.status == http.StatusOK &&
+		h.Get("Content-Encoding") == "" &&
+		isCompressible(h.Get("Content-Type"))
+	if size, err := strconv.Atoi(h.Get("Content-Length")); err == nil && size < compressMinSize {
+		compress = false
+	}
+	if compress {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		// 压缩后的内容与原文不再逐字节相同，强 ETag 需降为弱 ETag
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		switch cw.encoding {
+		case "br":
+			cw.w = brotli.NewWriterLevel(cw.ResponseWriter, 5)
+		case "gzip":
+			cw.w = gzip.NewWriter(cw.ResponseWriter)
+		default:
+			// HTTP 的 deflate 编码是 zlib 格式（RFC 9110 8.4.1.2），而非原始 DEFLATE 流
+			cw.w = zlib.NewWriter(cw.ResponseWriter)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// Flush 实现 http.Flusher
+func (cw *compressWriter) Flush() {
+	if !cw.started {
+		cw.start(nil)
+	}
+	if f, ok := cw.w.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close 结束压缩流
+func (cw *compressWriter) Close() error {
+	if !cw.started {
+		cw.start(nil)
+	}
+	if cw.w != nil {
+		return cw.w.Close()
+	}
+	return nil
+}
@@ -1,245 +1,426 @@
 package control
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"html/template"
+	"hash"
 	"io"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
-	"csz.net/tgstate/assets"
 	"csz.net/tgstate/conf"
+	"csz.net/tgstate/i18n"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/tenant"
 	"csz.net/tgstate/utils"
 )
 
-// 文件缓存结构
-type FileCache struct {
-	sync.RWMutex
-	files      map[string]string // fileID -> 本地文件路径
-	lastAccess map[string]int64  // fileID -> 最后访问时间
-	cacheDir   string            // 缓存目录
+// pageData 页面模板数据
+type pageData struct {
+	Prefix    string // 访问路径前缀，租户页面为 /t/{name}
+	ChunkSize int64  // 网页分块上传的分块大小
+	Error     string // 表单提交失败时显示的提示
+	Csrf      string // 表单及上传请求携带的 CSRF 令牌
+	Anonymous bool   // 未登录的匿名访问，上传的文件需要审核
 }
 
-var (
-	fileCache *FileCache
-	once      sync.Once
-)
+// UploadImageAPI 上传图片api
+func UploadImageAPI(w http.ResponseWriter, r *http.Request) {
+	uploadFile(w, r, nil)
+}
 
-// 获取文件缓存单例
-func getFileCache() *FileCache {
-	once.Do(func() {
-		cacheDir := filepath.Join(".", "file_cache")
-		if _, err := os.Stat(cacheDir); os.IsNotExist(err) {
-			os.MkdirAll(cacheDir, 0755)
+// uploadFile 上传文件到默认频道，t 不为空时上传到租户频道并检查配额
+func uploadFile(w http.ResponseWriter, r *http.Request, t *tenant.Tenant) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodPost {
+		// 以流式读取上传的文件，避免整个文件缓存在内存或临时文件中
+		part, err := imagePart(r)
+		if err != nil {
+			errJsonMsg(w, r, http.StatusBadRequest, "Unable to get file")
+			// http.Error(w, "Unable to get file", http.StatusBadRequest)
+			return
 		}
-		fileCache = &FileCache{
-			files:      make(map[string]string),
-			lastAccess: make(map[string]int64),
-			cacheDir:   cacheDir,
+		defer part.Close()
+		fileName := part.FileName()
+		auditTarget(r, "", fileName)
+		if conf.Mode != "p" && r.ContentLength > imageModeLimit {
+			// 检查文件大小
+			writeError(w, r, http.StatusRequestEntityTooLarge, http.StatusOK, "File size exceeds 20MB limit", map[string]int64{"limit": imageModeLimit})
+			return
 		}
-		// 启动定期清理协程
-		go fileCache.periodicCleanup()
-	})
-	return fileCache
-}
-
-// 获取缓存文件，如果不存在则下载
-func (fc *FileCache) getCachedFile(fileID string) (string, error) {
-	// 检查缓存
-	fc.RLock()
-	filePath, exists := fc.files[fileID]
-	fc.RUnlock()
-
-	if exists {
-		// 检查文件是否存在
-		if _, err := os.Stat(filePath); err == nil {
-			// 更新最后访问时间
-			fc.Lock()
-			fc.lastAccess[fileID] = time.Now().Unix()
-			fc.Unlock()
-			return filePath, nil
+		maxSize := maxUploadSize()
+		if maxSize > 0 && r.ContentLength > maxSize {
+			writeError(w, r, http.StatusRequestEntityTooLarge, http.StatusOK, "File size exceeds limit", map[string]int64{"limit": maxSize})
+			return
 		}
+		// 检查文件类型
+		allowedExts := uploadExts()
+		// expand=1 时展开 zip 压缩包，扩展名按其中的文件检查
+		// 匿名上传需要逐个登记为待审核，不展开压缩包
+		expandZipFile := r.URL.Query().Get("expand") == "1" && strings.EqualFold(filepath.Ext(fileName), ".zip") && !isAnonymous(r)
+		var src io.Reader = part
+		if allowedExts != "" && !expandZipFile {
+			checkName := fileName
+			if conf.Mode == "p" {
+				// 网页端分块上传：分块不检查扩展名，清单按其中记录的原文件名检查
+				switch fileName {
+				case utils.BlobChunkName:
+					checkName = ""
+				case utils.BlobManifestName:
+					head, _ := io.ReadAll(io.LimitReader(part, blobIndexMaxSize))
+					if idx, err := utils.ParseBlobIndex(head); err == nil && utils.IsBlobIndex(head) {
+						checkName = idx.Name
+					}
+					src = io.MultiReader(bytes.NewReader(head), part)
+				}
+			}
+			if checkName != "" && !extAllowed(checkName, allowedExts) {
+				writeError(w, r, http.StatusUnsupportedMediaType, http.StatusOK, tr(r, "Invalid file type. Only %s are allowed.", allowedExts), map[string]string{"allowed": allowedExts})
+				// http.Error(w, "Invalid file type. Only .jpg, .jpeg, and .png are allowed.", http.StatusBadRequest)
+				return
+			}
+		}
+		// type 与 header 参数指定下载时的内容类型及响应头
+		headers, err := parseFileHeaders(r.URL.Query().Get("type"), r.URL.Query()["header"])
+		if err != nil {
+			errJsonMsg(w, r, http.StatusBadRequest, "Invalid content type or header")
+			return
+		}
+		// visibility 参数指定公开、仅链接或私有，未指定时使用默认可见性
+		visibility, ok := uploadVisibility(r)
+		if !ok {
+			errJsonMsg(w, r, http.StatusBadRequest, "Invalid visibility")
+			return
+		}
+		want, ok := uploadChecksum(r)
+		if !ok {
+			invalidChecksum(w, r)
+			return
+		}
+		channel, prefix, tenantName := conf.ChannelName, "", ""
+		file := &countingReader{r: src, limit: maxSize, want: want}
+		if t != nil {
+			// 请求体大小是文件大小的上限，分块传输时在读取过程中限制
+			if t.MaxFileSize > 0 && r.ContentLength > t.MaxFileSize {
+				errJsonMsg(w, r, http.StatusRequestEntityTooLarge, "File size exceeds tenant limit")
+				return
+			}
+			if t.MaxFileSize > 0 && (file.limit == 0 || t.MaxFileSize < file.limit) {
+				file.limit = t.MaxFileSize
+			}
+			if t.Quota > 0 {
+				remaining := t.Quota - store.Default().TenantUsage(t.Name)
+				if remaining <= 0 || r.ContentLength > remaining {
+					errJsonMsg(w, r, http.StatusRequestEntityTooLarge, "Tenant storage quota exceeded")
+					return
+				}
+				// 分块传输时 ContentLength 为 -1，在读取过程中按剩余配额限制
+				if file.limit == 0 || remaining < file.limit {
+					file.limit, file.quota = remaining, true
+				}
+			}
+			channel, prefix, tenantName = t.Target, t.Prefix(), t.Name
+		}
+		if expandZipFile {
+			expandZip(w, r, file, allowedExts, channel, prefix, tenantName)
+			return
+		}
+		job := utils.NewUploadJob(channel, fileName, file)
+		job.Ctx = r.Context()
+		if err := utils.SubmitUpload(job); err != nil {
+			submitError(w, r, channel, err)
+			return
+		}
+		// uploadwait 不大于 0 时一直等待上传完成，不转为后台上传
+		// 匿名上传在返回前登记为待审核，同样一直等待
+		var wait <-chan time.Time
+		if conf.UploadWait > 0 && !isAnonymous(r) {
+			wait = time.After(time.Duration(conf.UploadWait) * time.Second)
+		}
+		select {
+		case <-job.Done():
+		case <-wait:
+			// Telegram 繁忙时转为后台上传，客户端轮询结果
+			if job.Cancel() {
+				spoolUpload(w, r, file, fileName, channel, prefix, tenantName, headers)
+				return
+			}
+			<-job.Done()
+		}
+		if file.mismatch {
+			checksumError(w, r, file)
+			return
+		}
+		if file.exceeded {
+			tooLargeError(w, r, file)
+			return
+		}
+		// 匿名上传的文件需要审核，网页端分块随清单一起审核
+		res := uploadResult(store.File{
+			ID:      job.FileID,
+			Name:    fileName,
+			Size:    file.n,
+			Sha256:  file.sum(),
+			Tenant:     tenantName,
+			Pending:    isAnonymous(r) && fileName != utils.BlobChunkName,
+			Visibility: visibility,
+		}, prefix)
+		if res.Code != 1 {
+			errJsonMsg(w, r, http.StatusBadGateway, "Failed to upload to Telegram")
+			return
+		}
+		// 复用了已有文件，if-new=1 时不返回成功
+		if ifNew(r) && res.Message != prefix+conf.FileRoute+job.FileID {
+			duplicateError(w, r, res)
+			return
+		}
+		res = withHeaders(res, prefix, headers)
+		auditTarget(r, strings.TrimPrefix(res.Message, prefix+conf.FileRoute), fileName)
+		writeJson(w, http.StatusOK, res)
+		return
 	}
 
-	// 缓存不存在或文件已删除，下载文件
-	fileURL, ok := utils.GetDownloadUrl(fileID)
-	if !ok {
-		return "", fmt.Errorf("获取文件下载链接失败")
-	}
-
-	// 创建缓存文件
-	filePath = filepath.Join(fc.cacheDir, fileID)
-	out, err := os.Create(filePath)
-	if err != nil {
-		return "", err
-	}
-	defer out.Close()
+	// 如果不是POST请求，返回错误响应
+	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+}
 
-	// 下载文件
-	resp, err := http.Get(fileURL)
-	if err != nil {
-		os.Remove(filePath)
-		return "", err
+// uploadExts 当前允许上传的扩展名，图片模式未配置时只允许图片
+func uploadExts() string {
+	exts := allowedExts()
+	if exts == "" && conf.Mode != "p" {
+		exts = ".jpg,.jpeg,.png"
 	}
-	defer resp.Body.Close()
+	return exts
+}
 
-	if resp.StatusCode != http.StatusOK {
-		os.Remove(filePath)
-		return "", fmt.Errorf("下载文件失败，状态码: %d", resp.StatusCode)
+// extAllowed 判断文件扩展名是否在逗号分隔的列表中，不区分大小写
+func extAllowed(fileName, exts string) bool {
+	ext := strings.ToLower(filepath.Ext(fileName))
+	for _, allowed := range strings.Split(exts, ",") {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed != "" && !strings.HasPrefix(allowed, ".") {
+			allowed = "." + allowed
+		}
+		if ext != "" && ext == allowed {
+			return true
+		}
 	}
+	return false
+}
 
-	_, err = io.Copy(out, resp.Body)
+// imagePart 从 multipart 请求中找到 image 文件字段
+func imagePart(r *http.Request) (*multipart.Part, error) {
+	mr, err := r.MultipartReader()
 	if err != nil {
-		os.Remove(filePath)
-		return "", err
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "image" && part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
 	}
+}
 
-	// 更新缓存
-	fc.Lock()
-	fc.files[fileID] = filePath
-	fc.lastAccess[fileID] = time.Now().Unix()
-	fc.Unlock()
+var errFileTooLarge = errors.New("file too large")
+
+// countingReader 统计读取的字节数并计算 sha256，设置 limit 时超出后返回错误，
+// 设置 want 时读完后与内容的 sha256 比较，不一致时返回错误而不是 io.EOF
+type countingReader struct {
+	r        io.Reader
+	n        int64
+	limit    int64
+	exceeded bool
+	quota    bool // limit 为租户剩余配额而非文件大小上限
+	h        hash.Hash
+	want     string
+	mismatch bool
+}
 
-	return filePath, nil
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.h == nil {
+		c.h = sha256.New()
+	}
+	c.h.Write(p[:n])
+	if isBodyTooLarge(err) {
+		c.exceeded = true
+		return n, errFileTooLarge
+	}
+	if c.limit > 0 && c.n > c.limit {
+		c.exceeded = true
+		return n, errFileTooLarge
+	}
+	if err == io.EOF && c.want != "" && c.sum() != c.want {
+		// 上传到 Telegram 的请求随之中止，不会留下不完整的文件
+		c.mismatch = true
+		return n, errChecksumMismatch
+	}
+	return n, err
 }
 
-// 清理指定文件
-func (fc *FileCache) cleanupFile(fileID string) {
-	fc.Lock()
-	filePath, exists := fc.files[fileID]
-	if exists {
-		delete(fc.files, fileID)
-		delete(fc.lastAccess, fileID)
+// tooLargeError 上传内容超出大小上限或租户剩余配额
+func tooLargeError(w http.ResponseWriter, r *http.Request, file *countingReader) {
+	if file.quota {
+		errJsonMsg(w, r, http.StatusRequestEntityTooLarge, "Tenant storage quota exceeded")
+		return
 	}
-	fc.Unlock()
-	
-	if exists && filePath != "" {
-		os.Remove(filePath)
-		log.Printf("已清理缓存文件: %s", fileID)
+	errJsonMsg(w, r, http.StatusRequestEntityTooLarge, "File size exceeds limit")
+}
+
+// sum 返回已读取内容的 sha256
+func (c *countingReader) sum() string {
+	if c.h == nil {
+		c.h = sha256.New()
 	}
+	return hex.EncodeToString(c.h.Sum(nil))
 }
 
-// 定期清理过期缓存
-func (fc *FileCache) periodicCleanup() {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
-	
-	for range ticker.C {
-		now := time.Now().Unix()
-		expireTime := now - 3600 // 1小时未访问的文件将被清理
-		
-		var filesToDelete []string
-		var idsToDelete []string
-		
-		fc.RLock()
-		for fileID, lastAccess := range fc.lastAccess {
-			if lastAccess < expireTime {
-				if filePath, ok := fc.files[fileID]; ok {
-					filesToDelete = append(filesToDelete, filePath)
-					idsToDelete = append(idsToDelete, fileID)
+// uploadResult 登记上传结果并生成响应
+//
+// 流式上传在读完之后才知道内容哈希，同一租户下已有相同内容且可见性相同时返回已有文件并删除刚上传的消息
+func uploadResult(f store.File, prefix string) conf.UploadResponse {
+	if f.Visibility == "" {
+		f.Visibility = defaultVisibility()
+	}
+	if f.ID == "" {
+		utils.Emit(utils.Event{Event: utils.EventError, Name: f.Name, Message: "upload failed"})
+		return conf.UploadResponse{Code: 0, Message: "error"}
+	}
+	if f.Sha256 != "" {
+		st := store.Default()
+		if first, err := st.PutHash(f.Sha256, f.ID); err == nil && first != f.ID {
+			if old, ok := st.GetFile(first); ok && old.Tenant == f.Tenant {
+				switch {
+				case old.DeletedAt == 0 && !old.Pending && old.Access() == f.Visibility:
+					go discardUpload(f.ID)
+					return fileResponse(first, f.Sha256, prefix)
+				case old.DeletedAt > 0 || old.Pending:
+					// 回收站中及待审核的文件不再复用，哈希改为指向新上传的文件
+					if err := st.SetHash(f.Sha256, f.ID); err != nil {
+						log.Printf("保存内容哈希失败: %v", err)
+					}
 				}
+				// 可见性不同时单独登记，哈希仍指向已有的文件
 			}
 		}
-		fc.RUnlock()
-		
-		// 删除文件
-		for _, filePath := range filesToDelete {
-			os.Remove(filePath)
-		}
-		
-		// 更新缓存映射
-		fc.Lock()
-		for _, fileID := range idsToDelete {
-			delete(fc.files, fileID)
-			delete(fc.lastAccess, fileID)
-		}
-		fc.Unlock()
-		
-		if len(idsToDelete) > 0 {
-			log.Printf("已清理 %d 个过期缓存文件", len(idsToDelete))
-		}
 	}
+	recordUpload(f, prefix+conf.FileRoute+f.ID)
+	return fileResponse(f.ID, f.Sha256, prefix)
 }
 
-// UploadImageAPI 上传图片api
-func UploadImageAPI(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	if r.Method == http.MethodPost {
-		// 获取上传的文件
-		file, header, err := r.FormFile("image")
-		if err != nil {
-			errJsonMsg("Unable to get file", w)
-			// http.Error(w, "Unable to get file", http.StatusBadRequest)
-			return
-		}
-		defer file.Close()
-		if conf.Mode != "p" && r.ContentLength > 20*1024*1024 {
-			// 检查文件大小
-			errJsonMsg("File size exceeds 20MB limit", w)
-			return
-		}
-		// 检查文件类型
-		allowedExts := []string{".jpg", ".jpeg", ".png"}
-		ext := filepath.Ext(header.Filename)
-		valid := false
-		for _, allowedExt := range allowedExts {
-			if ext == allowedExt {
-				valid = true
-				break
-			}
-		}
-		if conf.Mode != "p" && !valid {
-			errJsonMsg("Invalid file type. Only .jpg, .jpeg, and .png are allowed.", w)
-			// http.Error(w, "Invalid file type. Only .jpg, .jpeg, and .png are allowed.", http.StatusBadRequest)
-			return
-		}
-		res := conf.UploadResponse{
-			Code:    0,
-			Message: "error",
-		}
-		img := conf.FileRoute + utils.UpDocument(utils.TgFileData(header.Filename, file))
-		if img != conf.FileRoute {
-			res = conf.UploadResponse{
-				Code:    1,
-				Message: img,
-				ImgUrl:  strings.TrimSuffix(conf.BaseUrl, "/") + img,
-			}
+// tr 按请求的语言翻译消息
+func tr(r *http.Request, msg string, args ...interface{}) string {
+	return i18n.T(i18n.FromRequest(r), msg, args...)
+}
+
+// writeJson 以指定状态码返回JSON
+func writeJson(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// recordUpload 登记上传的文件，发布上传事件并发送到审核会话，Sha256 非空时同时登记内容哈希
+func recordUpload(f store.File, link string) {
+	if f.Visibility == "" {
+		f.Visibility = defaultVisibility()
+	}
+	if err := store.Default().PutFile(f); err != nil {
+		log.Printf("保存文件记录失败: %v", err)
+	}
+	if f.Sha256 != "" {
+		if _, err := store.Default().PutHash(f.Sha256, f.ID); err != nil {
+			log.Printf("保存内容哈希失败: %v", err)
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(res)
+	}
+	utils.Emit(utils.Event{
+		Event: utils.EventUpload,
+		ID:    f.ID,
+		Url:   strings.TrimSuffix(conf.BaseUrl, "/") + link,
+		Name:  f.Name,
+		Size:  f.Size,
+	})
+	if f.Name != utils.BlobChunkName {
+		go notifyUpload(f, link)
+	}
+}
+
+// countDownload 统计下载次数，只统计完整下载或从头开始的分段请求
+func countDownload(r *http.Request, id string) {
+	if r.Method == http.MethodHead {
 		return
 	}
+	if rh := r.Header.Get("Range"); rh != "" && !strings.HasPrefix(rh, "bytes=0-") {
+		return
+	}
+	utils.Emit(utils.Event{
+		Event:     utils.EventDownload,
+		ID:        id,
+		Url:       strings.TrimSuffix(conf.BaseUrl, "/") + conf.FileRoute + id,
+		Downloads: store.Default().HitFile(id),
+	})
+}
 
-	// 如果不是POST请求，返回错误响应
-	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+// D 下载文件，路由为 /d/{id}
+func D(w http.ResponseWriter, r *http.Request) {
+	download(w, r, false)
 }
-func errJsonMsg(msg string, w http.ResponseWriter) {
-	// 这里示例直接返回JSON响应
-	response := conf.UploadResponse{
-		Code:    0,
-		Message: msg,
+
+// DSubs 下载视频关联的字幕，路由为 /d/{id}/subs
+func DSubs(w http.ResponseWriter, r *http.Request) {
+	download(w, r, true)
+}
+
+func download(w http.ResponseWriter, r *http.Request, subs bool) {
+	id := PathValue(r, "id")
+	// 命名文件的历史版本以 /d/{slug}@{version} 访问
+	if vid, ok := versionFileID(id); ok {
+		id = vid
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	// 文件ID会用作缓存文件名，拒绝包含路径分隔符等字符的ID
+	if !utils.ValidFileID(id) {
+		http.NotFound(w, r)
+		return
+	}
+	if !checkSignature(w, r, id) {
+		return
+	}
+	// 追加写入的日志文件以名称访问
+	if l, ok := store.Default().GetAppendLog(id); ok && !subs {
+		serveAppendLog(w, r, l)
+		return
+	}
+	if subs {
+		serveSubs(w, r, id)
+		return
+	}
+	serveFile(w, r, id)
 }
-func D(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
-	id := strings.TrimPrefix(path, conf.FileRoute)
-	if id == "" {
-		// 设置响应的状态码为 404
-		w.WriteHeader(http.StatusNotFound)
-		// 写入响应内容
-		w.Write([]byte("404 Not Found"))
+
+func serveFile(w http.ResponseWriter, r *http.Request, id string) {
+	if !checkAvailable(w, r, id) {
+		return
+	}
+	// 已知内容哈希时支持条件请求
+	if checkDigest(w, r, id) {
 		return
 	}
 
@@ -253,35 +434,66 @@ func D(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// 从缓存获取文件
-	filePath, err := cache.getCachedFile(id)
-	if err != nil {
-		log.Printf("获取文件失败: %v", err)
-		http.Error(w, "Failed to fetch content", http.StatusInternalServerError)
-		return
-	}
-	
-	// 打开文件
-	file, err := os.Open(filePath)
-	if err != nil {
-		log.Printf("打开文件失败: %v", err)
-		http.Error(w, "Failed to open file", http.StatusInternalServerError)
+	// 重定向模式下大文件直接跳转到 Telegram 下载地址
+	if wantRedirect(r) && redirectDownload(w, r, id) {
 		return
 	}
-	defer file.Close()
-	
-	// 获取文件信息
-	fileInfo, err := file.Stat()
-	if err != nil {
-		log.Printf("获取文件信息失败: %v", err)
-		http.Error(w, "Failed to get file info", http.StatusInternalServerError)
-		return
+
+	// 热门小文件直接从内存返回
+	var file io.ReadSeeker
+	var fileSize int64
+	mem := getMemCache()
+	if data, ok := mem.get(id); ok {
+		countDownload(r, id)
+		file, fileSize = bytes.NewReader(data), int64(len(data))
+	} else {
+		// 从缓存获取文件
+		filePath, err := cache.getCachedFile(r.Context(), id)
+		if err != nil {
+			// 客户端已断开，下载已中止
+			if r.Context().Err() != nil {
+				return
+			}
+			log.Printf("获取文件失败: %v", err)
+			utils.Emit(utils.Event{Event: utils.EventError, ID: id, Message: err.Error()})
+			fetchError(w, r, err)
+			return
+		}
+		countDownload(r, id)
+
+		// 打开文件
+		f, err := os.Open(filePath)
+		if err != nil {
+			log.Printf("打开文件失败: %v", err)
+			http.Error(w, "Failed to open file", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		// 获取文件信息
+		fileInfo, err := f.Stat()
+		if err != nil {
+			log.Printf("获取文件信息失败: %v", err)
+			http.Error(w, "Failed to get file info", http.StatusInternalServerError)
+			return
+		}
+		file, fileSize = f, fileInfo.Size()
+
+		// 小文件读入内存，之后的请求不再读磁盘
+		if mem != nil && fileSize <= memCacheMaxFile {
+			mem.miss()
+			if data, err := io.ReadAll(f); err == nil && int64(len(data)) == fileSize {
+				mem.put(id, data)
+				file = bytes.NewReader(data)
+			} else {
+				f.Seek(0, io.SeekStart)
+			}
+		}
 	}
-	fileSize := fileInfo.Size()
-	
+
 	// 读取文件头部以检测内容类型
 	buffer := make([]byte, 512)
-	_, err = file.Read(buffer)
+	n, err := file.Read(buffer)
 	if err != nil && err != io.EOF {
 		log.Printf("读取文件头部失败: %v", err)
 		http.Error(w, "Failed to read file", http.StatusInternalServerError)
@@ -289,75 +501,140 @@ func D(w http.ResponseWriter, r *http.Request) {
 	}
 	// 重置文件指针
 	file.Seek(0, io.SeekStart)
-	
-	// 检测内容类型
-	contentType := http.DetectContentType(buffer)
+
+	// 分块上传的索引文件，按顺序拼接各个分块
+	if utils.IsBlobIndex(buffer) {
+		serveBlobIndex(w, r, id, file)
+		return
+	}
+
+	// 检测内容类型：上传时指定的类型优先，其次按扩展名，最后检测文件头部
+	name := ""
+	if rec, ok := store.Default().GetFile(id); ok {
+		name = rec.Name
+	}
+	contentType := utils.DetectType(name, buffer[:n])
+	if ct := applyFileHeaders(w, id); ct != "" {
+		contentType = ct
+	}
+	// SVG 可以包含脚本，直接打开时禁止执行
+	if strings.HasPrefix(contentType, "image/svg+xml") {
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	}
 	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Content-Length", strconv.FormatInt(fileSize, 10))
 	
-	// 判断是否为视频文件
+	// 判断是否为视频或音频文件
 	isVideo := strings.HasPrefix(contentType, "video/")
-	
-	// 只有视频文件才处理Range请求
-	if isVideo {
-		w.Header().Set("Accept-Ranges", "bytes")
-		rangeHeader := r.Header.Get("Range")
-		if rangeHeader != "" {
-			// 解析Range头
-			ranges, err := parseRange(rangeHeader, fileSize)
-			if err != nil || len(ranges) != 1 {
-				// 如果Range头无效，发送整个文件
-				io.Copy(w, file)
-				return
-			}
-			
-			// 获取请求的范围
-			ra := ranges[0]
-			
-			// 设置文件指针到请求的起始位置
-			file.Seek(ra.start, io.SeekStart)
-			
-			// 设置部分内容响应
-			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", ra.start, ra.end, fileSize))
-			w.Header().Set("Content-Length", strconv.FormatInt(ra.length, 10))
-			w.WriteHeader(http.StatusPartialContent)
-			
-			// 发送请求的部分内容
-			io.CopyN(w, file, ra.length)
-			
-			// 检查是否是最后一个Range请求（通常是视频播放结束）
-			if ra.end >= fileSize-1 || ra.end >= fileSize-1024*1024 { // 文件结尾或接近结尾
-				// 延迟清理文件，给予一些缓冲时间
-				go func() {
-					time.Sleep(10 * time.Second) // 等待10秒，确保没有新请求
-					cache.cleanupFile(id)
-				}()
+	isAudio := strings.HasPrefix(contentType, "audio/") || contentType == "application/ogg"
+	// Telegram 提供了时长时告知播放器，未下载完也能显示进度条
+	if f, ok := store.Default().GetFile(id); ok && f.Duration > 0 {
+		w.Header().Set("X-Content-Duration", strconv.Itoa(f.Duration))
+	}
+
+	// 缓存在本地的文件都支持单个 Range 请求，便于断点续传及 WebSeed 分片下载
+	w.Header().Set("Accept-Ranges", "bytes")
+	ranges, err := parseRange(r.Header.Get("Range"), fileSize)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", fileSize))
+		http.Error(w, "Requested Range Not Satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if len(ranges) == 1 {
+		// 获取请求的范围
+		ra := ranges[0]
+
+		// 设置文件指针到请求的起始位置
+		file.Seek(ra.start, io.SeekStart)
+
+		// 设置部分内容响应
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", ra.start, ra.end, fileSize))
+		w.Header().Set("Content-Length", strconv.FormatInt(ra.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+
+		// 发送请求的部分内容
+		io.CopyN(w, file, ra.length)
+		recordDownload(r, id, ranges, fileSize)
+
+		// 检查是否是最后一个Range请求（通常是视频播放结束或下载完成）
+		// 音频常被来回拖动进度（如播客），不在请求后清理，由缓存回收处理
+		if !isAudio && (ra.end >= fileSize-1 || (isVideo && ra.end >= fileSize-1024*1024)) { // 文件结尾或接近结尾
+			// 延迟清理文件，等待10秒，确保没有新请求
+			cache.cleanupLater(id, 10*time.Second)
+		}
+		return
+	}
+
+	if len(ranges) > 1 && len(ranges) <= maxRanges {
+		// 下载加速器等会在一个请求中索取多个范围
+		err := serveMultipartRanges(w, r, ranges, fileSize, contentType, func(pw io.Writer, ra httpRange) error {
+			if _, err := file.Seek(ra.start, io.SeekStart); err != nil {
+				return err
 			}
-			
-			return
+			_, err := io.CopyN(pw, file, ra.length)
+			return err
+		})
+		if err != nil && r.Context().Err() == nil {
+			log.Printf("输出多段范围失败: %v", err)
 		}
-	} else {
-		// 非视频文件不支持Range请求
-		w.Header().Set("Accept-Ranges", "none")
+		recordDownload(r, id, ranges, fileSize)
+		return
 	}
-	
-	// 非Range请求或非视频文件，发送整个文件
+
+	// 非Range请求或范围过多，发送整个文件
 	io.Copy(w, file)
-	
-	// 对于非视频文件，请求完成后标记为可清理
-	if !isVideo {
-		go func() {
-			time.Sleep(5 * time.Second) // 等待5秒，确保浏览器已完成处理
-			cache.cleanupFile(id)
-		}()
+	recordDownload(r, id, nil, fileSize)
+
+	// 对于非音视频文件，请求完成后标记为可清理
+	if !isVideo && !isAudio {
+		cache.cleanupLater(id, 5*time.Second) // 等待5秒，确保浏览器已完成处理
+	}
+}
+
+// 小于该大小的文件仍由服务器代理，分块索引文件也在此范围内
+const redirectMinSize = 64 * 1024
+
+// wantRedirect 根据配置及 redirect 参数判断是否重定向下载
+func wantRedirect(r *http.Request) bool {
+	switch conf.Redirect {
+	case "on":
+		return r.URL.Query().Get("redirect") != "0"
+	case "param":
+		return r.URL.Query().Get("redirect") == "1"
 	}
+	return false
 }
 
-// 处理分块文件
+// redirectDownload 重定向到 Telegram 下载地址，返回 false 时继续代理下载
+func redirectDownload(w http.ResponseWriter, r *http.Request, id string) bool {
+	fileURL, size, ok := utils.GetDownloadInfoContext(r.Context(), id)
+	if !ok || size < redirectMinSize {
+		return false
+	}
+	countDownload(r, id)
+	// 下载地址有时效，不允许缓存跳转结果
+	w.Header().Set("Cache-Control", "private, no-store")
+	http.Redirect(w, r, utils.RedirectUrl(fileURL), http.StatusFound)
+	return true
+}
+
+// 处理旧版 blob-{id} 链接，id 为分块索引文件
 func handleBlobFile(w http.ResponseWriter, r *http.Request, blobID string) {
-	// 获取分块文件信息
-	// 这里需要根据您的实际情况实现
-	// ...
+	id := strings.TrimPrefix(blobID, "blob-")
+	filePath, err := getFileCache().getCachedFile(r.Context(), id)
+	if err != nil {
+		log.Printf("获取分块索引失败: %v", err)
+		fetchError(w, r, err)
+		return
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		http.Error(w, "Failed to open file", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	countDownload(r, id)
+	serveBlobIndex(w, r, id, file)
 }
 
 // 处理Range请求
@@ -391,32 +668,180 @@ func handleRangeRequest(w http.ResponseWriter, r *http.Request, data []byte) {
 	w.Write(data[ra.start:ra.end+1])
 }
 
-// 处理分块文件的下载
-func handleBlobDownload(w http.ResponseWriter, r *http.Request, lines []string, startLine int, fileSize string) {
-	// 目前不支持分块文件的Range请求，直接发送所有块
-	for i := startLine; i < len(lines); i++ {
-		fileStatus := false
-		var fileUrl string
-		var reTry = 0
-		for !fileStatus {
-			if reTry > 0 {
-				time.Sleep(5 * time.Second)
+// 解析 tgstate-blob 索引文件并输出拼接后的内容
+func serveBlobIndex(w http.ResponseWriter, r *http.Request, id string, file io.Reader) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+	idx, err := utils.ParseBlobIndex(data)
+	if err != nil {
+		http.Error(w, "Invalid blob index", http.StatusInternalServerError)
+		return
+	}
+	serveBlob(w, r, id, idx)
+}
+
+// serveBlob 按顺序拼接输出各分块，已知各分块大小时支持 Range 请求
+func serveBlob(w http.ResponseWriter, r *http.Request, id string, idx *utils.BlobIndex) {
+	contentType := idx.Mime
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": idx.Name}))
+	if ct := applyFileHeaders(w, id); ct != "" {
+		contentType = ct
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	// 已知各分块大小时支持 Range 请求
+	start, length := int64(0), idx.Size
+	var ranges []httpRange
+	if idx.Seekable() {
+		w.Header().Set("Accept-Ranges", "bytes")
+		var err error
+		if ranges, err = parseRange(r.Header.Get("Range"), idx.Size); err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", idx.Size))
+			http.Error(w, "Requested Range Not Satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		} else if len(ranges) == 1 {
+			start, length = ranges[0].start, ranges[0].length
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", ranges[0].start, ranges[0].end, idx.Size))
+			w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+			w.WriteHeader(http.StatusPartialContent)
+		} else if len(ranges) > 1 && len(ranges) <= maxRanges {
+			ctx := r.Context()
+			err := serveMultipartRanges(w, r, ranges, idx.Size, contentType, func(pw io.Writer, ra httpRange) error {
+				return writeBlob(ctx, pw, idx, ra.start, ra.length)
+			})
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("输出分块文件失败: %v", err)
+				}
+				panic(http.ErrAbortHandler)
 			}
-			reTry = reTry + 1
-			fileUrl, fileStatus = utils.GetDownloadUrl(strings.ReplaceAll(lines[i], " ", ""))
-		}
-		blobResp, err := http.Get(fileUrl)
-		if err != nil {
-			http.Error(w, "Failed to fetch content", http.StatusInternalServerError)
+			recordDownload(r, id, ranges, idx.Size)
 			return
 		}
-		_, err = io.Copy(w, blobResp.Body)
-		blobResp.Body.Close()
-		if err != nil {
-			log.Println("写入响应主体数据时发生错误:", err)
-			return
+	} else {
+		w.Header().Set("Accept-Ranges", "none")
+	}
+	if start == 0 && length == idx.Size {
+		w.Header().Set("Content-Length", strconv.FormatInt(idx.Size, 10))
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	handleBlobDownload(w, r, idx, start, length)
+	if len(ranges) != 1 {
+		// 范围过多时已按完整文件输出
+		ranges = nil
+	}
+	recordDownload(r, id, ranges, idx.Size)
+}
+
+// 处理分块文件的下载，从 start 开始输出 length 字节，完整输出的分块会校验哈希
+func handleBlobDownload(w http.ResponseWriter, r *http.Request, idx *utils.BlobIndex, start, length int64) {
+	ctx := r.Context()
+	if err := writeBlob(ctx, w, idx, start, length); err != nil {
+		// 客户端断开时直接结束，不再继续拉取后续分块
+		if ctx.Err() == nil {
+			log.Printf("输出分块文件失败: %v", err)
+			utils.Emit(utils.Event{Event: utils.EventError, Message: err.Error()})
+		}
+		// 响应头已发出，只能中断连接让客户端感知
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// writeBlob 将分块文件从 start 开始的 length 字节写入 w，完整输出的分块会校验哈希
+func writeBlob(ctx context.Context, w io.Writer, idx *utils.BlobIndex, start, length int64) error {
+	if conf.BlobParallel > 1 && idx.Seekable() {
+		return writeBlobParallel(ctx, w, idx, start, length)
+	}
+	var offset int64
+	for _, chunk := range idx.Chunks {
+		if chunk.Size == 0 {
+			// 旧版索引未记录分块大小，此时只能完整输出
+			if err := copyBlobChunk(ctx, w, chunk, 0, 0, false); err != nil {
+				return fmt.Errorf("chunk %s: %w", chunk.ID, err)
+			}
+			continue
 		}
+		if length <= 0 {
+			return nil
+		}
+		// 跳过请求范围之前的分块
+		if offset+chunk.Size <= start {
+			offset += chunk.Size
+			continue
+		}
+		skip := start - offset
+		if skip < 0 {
+			skip = 0
+		}
+		n := length
+		if chunk.Size-skip < n {
+			n = chunk.Size - skip
+		}
+		whole := skip == 0 && n == chunk.Size
+		if err := copyBlobChunk(ctx, w, chunk, skip, n, whole); err != nil {
+			return fmt.Errorf("chunk %s: %w", chunk.ID, err)
+		}
+		offset += chunk.Size
+		length -= n
 	}
+	return nil
+}
+
+// copyBlobChunk 输出分块中从 skip 开始的 n 字节，未知分块大小时输出整个分块
+func copyBlobChunk(ctx context.Context, w io.Writer, chunk utils.BlobChunk, skip, n int64, verify bool) error {
+	var fileUrl string
+	var ok bool
+	for reTry := 0; !ok; reTry++ {
+		if reTry > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+			}
+		}
+		fileUrl, ok = utils.GetDownloadUrl(chunk.ID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileUrl, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := utils.Upstream().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if skip > 0 {
+		if _, err := io.CopyN(io.Discard, resp.Body, skip); err != nil {
+			return err
+		}
+	}
+	body := io.Reader(resp.Body)
+	if chunk.Size > 0 {
+		body = io.LimitReader(body, n)
+	}
+	if !verify || chunk.Sha256 == "" {
+		_, err = io.Copy(w, body)
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), body); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != chunk.Sha256 {
+		return fmt.Errorf("sha256 mismatch: %s", sum)
+	}
+	return nil
 }
 
 // 解析Range头
@@ -453,7 +878,7 @@ func parseRange(s string, size int64) ([]httpRange, error) {
 		if start == "" {
 			// 如果没有开始位置，例如 -100，表示最后100个字节
 			i, err := strconv.ParseInt(end, 10, 64)
-			if err != nil {
+			if err != nil || i <= 0 || size <= 0 {
 				return nil, errors.New("invalid range")
 			}
 			if i > size {
@@ -502,115 +927,46 @@ func parseRange(s string, size int64) ([]httpRange, error) {
 
 // Index 首页
 func Index(w http.ResponseWriter, r *http.Request) {
-	htmlPath := "templates/images.tmpl"
+	renderIndex(w, r, pageData{Csrf: sessionCsrf(r, "p"), Anonymous: isAnonymous(r)})
+}
+
+// renderIndex 渲染上传页面
+func renderIndex(w http.ResponseWriter, r *http.Request, data pageData) {
+	data.ChunkSize = conf.ChunkSize
+	page := "images.tmpl"
 	if conf.Mode == "p" {
-		htmlPath = "templates/files.tmpl"
-	}
-	file, err := assets.Templates.ReadFile(htmlPath)
-	if err != nil {
-		http.Error(w, "HTML file not found", http.StatusNotFound)
-		return
-	}
-	// 读取头部模板
-	headerFile, err := assets.Templates.ReadFile("templates/header.tmpl")
-	if err != nil {
-		http.Error(w, "Header template not found", http.StatusNotFound)
-		return
+		page = "files.tmpl"
 	}
+	renderTemplate(w, r, page, data, "footer.tmpl")
+}
 
-	// 读取页脚模板
-	footerFile, err := assets.Templates.ReadFile("templates/footer.tmpl")
-	if err != nil {
-		http.Error(w, "Footer template not found", http.StatusNotFound)
+// Pwd 访问密码页面，密码正确时写入签名的会话 cookie
+func Pwd(w http.ResponseWriter, r *http.Request) {
+	if !passEnabled() {
+		http.NotFound(w, r)
 		return
 	}
+	login(w, r, "", "p", "", conf.Pass)
+}
 
-	// 创建HTML模板并包括头部
-	tmpl := template.New("html")
-	tmpl, err = tmpl.Parse(string(headerFile))
-	if err != nil {
-		http.Error(w, "Error parsing header template", http.StatusInternalServerError)
-		return
+// pageLang 页面使用的语言，未识别时使用中文
+func pageLang(r *http.Request) string {
+	if lang := i18n.FromRequest(r); lang != "" {
+		return lang
 	}
+	return i18n.Zh
+}
 
-	// 包括主HTML内容
-	tmpl, err = tmpl.Parse(string(file))
+// renderTemplate 使用公共头部渲染指定模板，extra 为额外引入的模板（如页脚）
+func renderTemplate(w http.ResponseWriter, r *http.Request, name string, data interface{}, extra ...string) {
+	tmpl, err := parseTemplates(pageLang(r), append([]string{"header.tmpl", name}, extra...)...)
 	if err != nil {
+		log.Printf("解析模板 %s 失败: %v", name, err)
 		http.Error(w, "Error parsing HTML template", http.StatusInternalServerError)
 		return
 	}
-
-	// 包括页脚
-	tmpl, err = tmpl.Parse(string(footerFile))
-	if err != nil {
-		http.Error(w, "Error parsing footer template", http.StatusInternalServerError)
-		return
-	}
-
-	// 直接将HTML内容发送给客户端
-	w.Header().Set("Content-Type", "text/html")
-	err = tmpl.Execute(w, nil)
-	if err != nil {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.Execute(w, data); err != nil {
 		http.Error(w, "Error rendering HTML template", http.StatusInternalServerError)
 	}
 }
-
-func Pwd(w http.ResponseWriter, r *http.Request) {
-	// 输出 HTML 表单
-	if r.Method != http.MethodPost {
-		file, err := assets.Templates.ReadFile("templates/pwd.tmpl")
-		if err != nil {
-			http.Error(w, "HTML file not found", http.StatusNotFound)
-			return
-		}
-		// 读取头部模板
-		headerFile, err := assets.Templates.ReadFile("templates/header.tmpl")
-		if err != nil {
-			http.Error(w, "Header template not found", http.StatusNotFound)
-			return
-		}
-
-		// 创建HTML模板并包括头部
-		tmpl := template.New("html")
-		if tmpl, err = tmpl.Parse(string(headerFile)); err != nil {
-			http.Error(w, "Error parsing Header template", http.StatusInternalServerError)
-			return
-		}
-
-		// 包括主HTML内容
-		if tmpl, err = tmpl.Parse(string(file)); err != nil {
-			http.Error(w, "Error parsing File template", http.StatusInternalServerError)
-			return
-		}
-
-		// 直接将HTML内容发送给客户端
-		w.Header().Set("Content-Type", "text/html")
-		if err := tmpl.Execute(w, nil); err != nil {
-			http.Error(w, "Error rendering HTML template", http.StatusInternalServerError)
-		}
-		return
-	}
-	// 设置cookie
-	cookie := http.Cookie{
-		Name:  "p",
-		Value: r.FormValue("p"),
-	}
-	http.SetCookie(w, &cookie)
-	http.Redirect(w, r, "/", http.StatusSeeOther)
-}
-
-func Middleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// 只有当密码设置并且不为"none"时，才进行检查
-		if conf.Pass != "" && conf.Pass != "none" {
-			if strings.HasPrefix(r.URL.Path, "/api") && r.URL.Query().Get("pass") == conf.Pass {
-				return
-			}
-			if cookie, err := r.Cookie("p"); err != nil || cookie.Value != conf.Pass {
-				http.Redirect(w, r, "/pwd", http.StatusSeeOther)
-				return
-			}
-		}
-		next(w, r)
-	}
-}
@@ -0,0 +1,501 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/metrics"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		size   int64
+		err    bool
+		want   []httpRange
+	}{
+		{"", 100, false, nil},
+		{"bytes=0-9", 100, false, []httpRange{{0, 9, 10}}},
+		{"bytes=90-", 100, false, []httpRange{{90, 99, 10}}},
+		{"bytes=-10", 100, false, []httpRange{{90, 99, 10}}},
+		{"bytes=-200", 100, false, []httpRange{{0, 99, 100}}},
+		{"bytes=0-0,5-9", 100, false, []httpRange{{0, 0, 1}, {5, 9, 5}}},
+		{"bytes=0-99,5-9", 100, false, []httpRange{{0, 99, 100}}},
+		{"bytes= 1-2 , ", 100, false, []httpRange{{1, 2, 2}}},
+		{"bytes=-0", 100, true, nil},
+		{"bytes=-1", 0, true, nil},
+		{"bytes=100-", 100, true, nil},
+		{"bytes=5-200", 100, true, nil},
+		{"bytes=9-5", 100, true, nil},
+		{"bytes=a-b", 100, true, nil},
+		{"bytes=5", 100, true, nil},
+		{"items=0-9", 100, true, nil},
+	}
+	for _, tt := range tests {
+		got, err := parseRange(tt.header, tt.size)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q/%d: 期望错误，得到 %v", tt.header, tt.size, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q/%d: %v", tt.header, tt.size, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q/%d: 得到 %v，期望 %v", tt.header, tt.size, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q/%d: 得到 %v，期望 %v", tt.header, tt.size, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header, want string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"deflate, gzip", "gzip"},
+		{"GZIP", "gzip"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0, gzip;q=0", ""},
+		{"gzip;q=0.8, deflate;q=0.9", "deflate"},
+		{"*, gzip;q=0.1", "gzip"},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.header); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q，期望 %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestBencode(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{"spam", "4:spam"},
+		{"", "0:"},
+		{int64(-3), "i-3e"},
+		{[]interface{}{"a", int64(1)}, "l1:ai1ee"},
+		{map[string]interface{}{"b": int64(2), "a": "x"}, "d1:a1:x1:bi2ee"},
+		{map[string]interface{}{"info": map[string]interface{}{"length": int64(5)}}, "d4:infod6:lengthi5eee"},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		bencode(&b, tt.v)
+		if b.String() != tt.want {
+			t.Errorf("bencode(%v) = %q，期望 %q", tt.v, b.String(), tt.want)
+		}
+	}
+}
+
+func TestToWebVTT(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"WEBVTT\n\n00:00.000 --> 00:01.000\nhi\n", "WEBVTT\n\n00:00.000 --> 00:01.000\nhi\n", true},
+		{"\xef\xbb\xbf1\r\n00:00:01,500 --> 00:00:02,000\r\nhi, there\r\n", "WEBVTT\n\n1\n00:00:01.500 --> 00:00:02.000\nhi, there\n", true},
+		{"not a subtitle", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := toWebVTT([]byte(tt.in))
+		if ok != tt.ok || string(got) != tt.want {
+			t.Errorf("toWebVTT(%q) = %q, %v，期望 %q, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestServeMultipartRanges(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+	ranges, err := parseRange("bytes=0-3,10-12,-2", int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := bytes.NewReader(data)
+	r := httptest.NewRequest(http.MethodGet, "/d/x", nil)
+	w := httptest.NewRecorder()
+	err = serveMultipartRanges(w, r, ranges, int64(len(data)), "text/plain", func(pw io.Writer, ra httpRange) error {
+		file.Seek(ra.start, io.SeekStart)
+		_, err := io.CopyN(pw, file, ra.length)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("状态码 %d", w.Code)
+	}
+	if n, _ := strconv.Atoi(w.Header().Get("Content-Length")); n != w.Body.Len() {
+		t.Errorf("Content-Length %d，实际 %d", n, w.Body.Len())
+	}
+	mt, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if err != nil || mt != "multipart/byteranges" {
+		t.Fatalf("Content-Type %q", w.Header().Get("Content-Type"))
+	}
+	mr := multipart.NewReader(w.Body, params["boundary"])
+	want := []struct{ rng, body string }{{"bytes 0-3/20", "0123"}, {"bytes 10-12/20", "abc"}, {"bytes 18-19/20", "ij"}}
+	for _, wt := range want {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		if part.Header.Get("Content-Range") != wt.rng || string(body) != wt.body || part.Header.Get("Content-Type") != "text/plain" {
+			t.Errorf("得到 %q %q，期望 %q %q", part.Header.Get("Content-Range"), body, wt.rng, wt.body)
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("多余的分段: %v", err)
+	}
+}
+
+func TestRangeBucket(t *testing.T) {
+	tests := []struct {
+		length int64
+		want   string
+	}{
+		{1, "<=64KiB"},
+		{64 << 10, "<=64KiB"},
+		{64<<10 + 1, "<=1MiB"},
+		{16 << 20, "<=16MiB"},
+		{1 << 30, ">128MiB"},
+	}
+	for _, tt := range tests {
+		if got := rangeBucket(tt.length); got != tt.want {
+			t.Errorf("%d: 得到 %q，期望 %q", tt.length, got, tt.want)
+		}
+	}
+	if got := formatRanges([]httpRange{{0, 9, 10}, {20, 29, 10}}); got != "0-9,20-29" {
+		t.Errorf("formatRanges: %q", got)
+	}
+}
+
+func TestParseBotUpload(t *testing.T) {
+	cases := []struct {
+		text, url, name string
+		ok              bool
+	}{
+		{"https://example.com/a.png", "https://example.com/a.png", "", true},
+		{" https://example.com/a.png  b.png ", "https://example.com/a.png", "b.png", true},
+		{"https://example.com/a.png ../../etc/passwd", "https://example.com/a.png", "passwd", true},
+		{"ftp://example.com/a.png", "", "", false},
+		{"hello", "", "", false},
+		{"https://example.com/a b c", "", "", false},
+		{"", "", "", false},
+	}
+	for _, c := range cases {
+		u, name, ok := parseBotUpload(c.text)
+		if ok != c.ok {
+			t.Errorf("parseBotUpload(%q) ok = %v", c.text, ok)
+			continue
+		}
+		if ok && (u.String() != c.url || name != c.name) {
+			t.Errorf("parseBotUpload(%q) = %q, %q", c.text, u, name)
+		}
+	}
+}
+
+func TestParseScheduleTime(t *testing.T) {
+	now := time.Date(2030, 1, 1, 10, 0, 0, 0, time.Local)
+	cases := []struct {
+		in   string
+		want time.Time
+		ok   bool
+	}{
+		{"+2h", now.Add(2 * time.Hour), true},
+		{"1893456000", time.Unix(1893456000, 0), true},
+		{"2030-01-02T03:04:05Z", time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC), true},
+		{"2030-01-02 08:30", time.Date(2030, 1, 2, 8, 30, 0, 0, time.Local), true},
+		{"2030-01-02T08:30", time.Date(2030, 1, 2, 8, 30, 0, 0, time.Local), true},
+		{"+-1h", time.Time{}, false},
+		{"tomorrow", time.Time{}, false},
+	}
+	for _, c := range cases {
+		got, err := parseScheduleTime(c.in, now)
+		if (err == nil) != c.ok || !got.Equal(c.want) {
+			t.Errorf("parseScheduleTime(%q) = %v, %v", c.in, got, err)
+		}
+	}
+	if id := scheduleFileID("https://example.com/d/abc123?sig=1"); id != "abc123" {
+		t.Errorf("scheduleFileID = %q", id)
+	}
+}
+
+func TestAlerter(t *testing.T) {
+	oldCache, oldBw, oldErr := conf.AlertCache, conf.AlertBandwidth, conf.AlertErrors
+	defer func() { conf.AlertCache, conf.AlertBandwidth, conf.AlertErrors = oldCache, oldBw, oldErr }()
+	conf.AlertCache, conf.AlertBandwidth, conf.AlertErrors = 1000, 5000, 10
+	var a alerter
+	if got := a.check(alertSample{}, 500); len(got) != 0 {
+		t.Fatalf("first sample alerts = %v", got)
+	}
+	// 缓存超限、带宽超限、100 个响应中有 20 个错误
+	if got := a.check(alertSample{sent: 6000, total: 100, errors: 20}, 2000); len(got) != 3 {
+		t.Fatalf("alerts = %v", got)
+	}
+	// 仍然超限时不重复通知
+	if got := a.check(alertSample{sent: 7000, total: 200, errors: 40}, 2000); len(got) != 0 {
+		t.Fatalf("repeated alerts = %v", got)
+	}
+	// 恢复后再次超限时重新通知，响应太少时不计算错误率
+	a.check(alertSample{sent: 7000, total: 300, errors: 40}, 100)
+	if got := a.check(alertSample{sent: 7000, total: 305, errors: 45}, 2000); len(got) != 1 {
+		t.Fatalf("alerts after recovery = %v", got)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	before := metrics.Value(`tgstate_http_responses_total{code="5xx"}`)
+	sent := metrics.Value("tgstate_http_sent_bytes_total")
+	h := Observe(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusBadGateway)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := metrics.Value(`tgstate_http_responses_total{code="5xx"}`); got != before+1 {
+		t.Errorf("5xx responses = %d, want %d", got, before+1)
+	}
+	if got := metrics.Value("tgstate_http_sent_bytes_total"); got != sent+5 {
+		t.Errorf("sent bytes = %d, want %d", got, sent+5)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	h := Observe(Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["x"]++
+	})))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/boom", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+	// 主动中断的请求原样抛出，由 net/http 处理
+	abort := Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", v)
+		}
+	}()
+	abort.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestBasePath(t *testing.T) {
+	if got := CleanBasePath(" tg/files/ "); got != "/tg/files" {
+		t.Errorf("CleanBasePath = %q", got)
+	}
+	conf.BasePath = "/tg"
+	defer func() { conf.BasePath = "" }()
+	h := BasePath(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new?x=1", http.StatusFound)
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	serve := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w
+	}
+	if w := serve("/tg/d/abc"); w.Code != http.StatusOK || w.Body.String() != "/d/abc" {
+		t.Errorf("/tg/d/abc: %d %q", w.Code, w.Body.String())
+	}
+	if w := serve("/tg"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/tg/" {
+		t.Errorf("/tg: %d %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := serve("/tg/old"); w.Header().Get("Location") != "/tg/new?x=1" {
+		t.Errorf("Location = %q", w.Header().Get("Location"))
+	}
+	for _, target := range []string{"/d/abc", "/tgx/d/abc"} {
+		if w := serve(target); w.Code != http.StatusNotFound {
+			t.Errorf("%s: %d", target, w.Code)
+		}
+	}
+	if u := staticUrl("no-such.css"); u != "/tg"+StaticRoute+"no-such.css" {
+		t.Errorf("staticUrl = %q", u)
+	}
+	staticVersions.Delete("no-such.css")
+}
+
+func TestDelayQueue(t *testing.T) {
+	ran := make(chan string, 10)
+	q := newDelayQueue(func(key string) { ran <- key })
+	q.schedule("a", 30*time.Millisecond)
+	q.schedule("b", 10*time.Millisecond)
+	q.schedule("a", 50*time.Millisecond) // 推迟，只执行一次
+	q.schedule("c", 20*time.Millisecond)
+	q.cancel("c")
+	if n := q.pending(); n != 2 {
+		t.Fatalf("pending = %d, want 2", n)
+	}
+	var got []string
+	timeout := time.After(time.Second)
+	for len(got) < 2 {
+		select {
+		case key := <-ran:
+			got = append(got, key)
+		case <-timeout:
+			t.Fatalf("ran %v before timeout", got)
+		}
+	}
+	if got[0] != "b" || got[1] != "a" {
+		t.Errorf("ran %v, want [b a]", got)
+	}
+	select {
+	case key := <-ran:
+		t.Errorf("unexpected run of %q", key)
+	case <-time.After(80 * time.Millisecond):
+	}
+}
+
+func TestInvalidFileID(t *testing.T) {
+	for _, id := range []string{"", "..", "../../etc/passwd", "a/b", `a\b`, "a.b", "a\x00b", strings.Repeat("a", 201)} {
+		if _, err := getFileCache().getCachedFile(context.Background(), id); !errors.Is(err, utils.ErrInvalidFileID) {
+			t.Errorf("getCachedFile(%q) = %v", id, err)
+		}
+	}
+	for _, p := range []string{"/d/a%5C..%5C..%5Cetc%5Cpasswd", "/d/a%5Cb", "/d/a.b/subs"} {
+		r := httptest.NewRequest(http.MethodGet, p, nil)
+		w := httptest.NewRecorder()
+		testRoutes().ServeHTTP(w, r)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s 状态码 %d", p, w.Code)
+		}
+	}
+	if !utils.ValidFileID("BQACAgUAAxkDAAIB_2X-abc") {
+		t.Error("合法文件ID被拒绝")
+	}
+}
+
+func TestExplore(t *testing.T) {
+	st := store.Default()
+	now := time.Now().Unix()
+	for i := 0; i < explorePageSize+2; i++ {
+		st.PutFile(store.File{ID: "explore-pub-" + strconv.Itoa(i), Name: "p.png", Visibility: store.VisibilityPublic, CreatedAt: now + int64(i)})
+	}
+	st.PutFile(store.File{ID: "explore-unlisted", Name: "u.png", CreatedAt: now})
+	st.PutFile(store.File{ID: "explore-text", Name: "t.txt", Visibility: store.VisibilityPublic, CreatedAt: now})
+	st.PutFile(store.File{ID: "explore-pending", Name: "q.png", Visibility: store.VisibilityPublic, Pending: true, CreatedAt: now})
+	get := func(query string) string {
+		w := httptest.NewRecorder()
+		Explore(w, httptest.NewRequest(http.MethodGet, ExploreRoute+query, nil))
+		return w.Body.String()
+	}
+	first := get("")
+	if strings.Count(first, "<img ") != explorePageSize || !strings.Contains(first, `"`+conf.FileRoute+"explore-pub-"+strconv.Itoa(explorePageSize+1)+`"`) || !strings.Contains(first, "?page=2") {
+		t.Errorf("first page:\n%s", first)
+	}
+	second := get("?page=2")
+	if strings.Count(second, "<img ") != 2 || strings.Contains(second, "?page=3") || !strings.Contains(second, "?page=1") {
+		t.Errorf("second page:\n%s", second)
+	}
+	for _, hidden := range []string{"explore-unlisted", "explore-text", "explore-pending"} {
+		if strings.Contains(first+second, hidden) {
+			t.Errorf("%s listed", hidden)
+		}
+	}
+}
+
+func TestFeed(t *testing.T) {
+	st := store.Default()
+	st.PutFile(store.File{ID: "feed-release", Name: "app-1.0.zip", Size: 1234, Visibility: store.VisibilityPublic, CreatedAt: time.Now().Unix() + 3600})
+	st.PutFile(store.File{ID: "feed-private", Name: "secret.zip", Visibility: store.VisibilityPrivate, CreatedAt: time.Now().Unix() + 3600})
+	w := httptest.NewRecorder()
+	Feed(w, httptest.NewRequest(http.MethodGet, FeedRoute, nil))
+	var feed rssFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("feed: %v\n%s", err, w.Body.String())
+	}
+	if len(feed.Channel.Items) == 0 || len(feed.Channel.Items) > feedSize {
+		t.Fatalf("items = %d", len(feed.Channel.Items))
+	}
+	item := feed.Channel.Items[0]
+	if item.Title != "app-1.0.zip" || !strings.HasSuffix(item.Enclosure.Url, conf.FileRoute+"feed-release") || item.Enclosure.Length != "1234" || item.Enclosure.Type != "application/zip" {
+		t.Errorf("first item = %+v", item)
+	}
+	if strings.Contains(w.Body.String(), "feed-private") {
+		t.Error("private file listed")
+	}
+}
+
+func TestSitemap(t *testing.T) {
+	defer func(s, n bool) { conf.Sitemap, conf.NoIndex = s, n }(conf.Sitemap, conf.NoIndex)
+	store.Default().PutFile(store.File{ID: "sitemap-pub", Name: "a.png", Visibility: store.VisibilityPublic, CreatedAt: 1700000000})
+	store.Default().PutFile(store.File{ID: "sitemap-unlisted", Name: "b.png", CreatedAt: 1700000000})
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if path == SitemapRoute {
+			Sitemap(w, r)
+		} else {
+			Robots(w, r)
+		}
+		return w
+	}
+	conf.Sitemap, conf.NoIndex = true, false
+	body := get(SitemapRoute).Body.String()
+	if !strings.Contains(body, conf.ViewRoute+"sitemap-pub</loc>") || !strings.Contains(body, "<lastmod>2023-11-14</lastmod>") || strings.Contains(body, "sitemap-unlisted") {
+		t.Errorf("sitemap:\n%s", body)
+	}
+	if !strings.Contains(get(RobotsRoute).Body.String(), "Sitemap: ") {
+		t.Error("robots.txt without sitemap")
+	}
+	conf.Sitemap = false
+	if w := get(SitemapRoute); w.Code != http.StatusNotFound || strings.Contains(get(RobotsRoute).Body.String(), "Sitemap") {
+		t.Errorf("disabled sitemap = %d", w.Code)
+	}
+}
+
+func TestBadge(t *testing.T) {
+	st := store.Default()
+	st.PutFile(store.File{ID: "badge-file", Name: "app.zip", Downloads: 1234})
+	st.PutFile(store.File{ID: "badge-private", Name: "app.zip", Visibility: store.VisibilityPrivate})
+	rt := NewRouter()
+	rt.Handle(BadgeRoute+"{id}", Badge, http.MethodGet)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+	w := get(BadgeRoute + "badge-file.svg?label=%3Cb%3E&color=blue")
+	body := w.Body.String()
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/svg+xml; charset=utf-8" {
+		t.Fatalf("badge = %d %s", w.Code, body)
+	}
+	if !strings.Contains(body, ">1.2k<") || !strings.Contains(body, "&lt;b&gt;") || !strings.Contains(body, `fill="#007ec6"`) {
+		t.Errorf("badge = %s", body)
+	}
+	for _, path := range []string{"badge-file", "badge-private.svg", "missing.svg"} {
+		if w := get(BadgeRoute + path); w.Code != http.StatusNotFound {
+			t.Errorf("%s = %d", path, w.Code)
+		}
+	}
+}
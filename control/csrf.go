@@ -0,0 +1,87 @@
+package control
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/http"
+
+	"csz.net/tgstate/conf"
+)
+
+// CSRF 令牌的表单字段、请求头及登录页面使用的 cookie
+const (
+	csrfField  = "csrf"
+	csrfHeader = "X-CSRF-Token"
+	csrfCookie = "csrf"
+)
+
+// csrfToken 由会话令牌派生的 CSRF 令牌，其他站点无法读取会话 cookie，也就无法构造令牌
+func csrfToken(session string) string {
+	mac := hmac.New(sha256.New, signKey())
+	mac.Write([]byte("tgstate-csrf\n" + session))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// safeMethod 不修改数据的请求方法无需校验
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// submittedCsrf 读取请求头或表单中的 CSRF 令牌，multipart 上传只读取请求头，避免提前解析请求体
+func submittedCsrf(r *http.Request) string {
+	if token := r.Header.Get(csrfHeader); token != "" {
+		return token
+	}
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/x-www-form-urlencoded" {
+		return r.PostFormValue(csrfField)
+	}
+	return ""
+}
+
+// csrfValid 使用会话 cookie 认证的修改请求需要携带对应的 CSRF 令牌
+func csrfValid(r *http.Request, session string) bool {
+	if safeMethod(r.Method) {
+		return true
+	}
+	token := submittedCsrf(r)
+	return token != "" && hmac.Equal([]byte(token), []byte(csrfToken(session)))
+}
+
+// sessionCsrf 页面中使用的 CSRF 令牌，未登录时为空
+func sessionCsrf(r *http.Request, cookie string) string {
+	if c, err := r.Cookie(cookie); err == nil && c.Value != "" {
+		return csrfToken(c.Value)
+	}
+	return ""
+}
+
+// loginCsrf 登录表单使用的令牌，保存在 cookie 中并与表单提交的值比对
+func loginCsrf(w http.ResponseWriter, r *http.Request, path string) string {
+	if c, err := r.Cookie(csrfCookie); err == nil && len(c.Value) == 32 {
+		return c.Value
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     conf.BasePath + path,
+		HttpOnly: true,
+		Secure:   isSecure(r),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// loginCsrfValid 校验登录表单提交的令牌与 cookie 一致
+func loginCsrfValid(r *http.Request) bool {
+	c, err := r.Cookie(csrfCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return hmac.Equal([]byte(r.PostFormValue(csrfField)), []byte(c.Value))
+}
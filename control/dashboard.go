@@ -0,0 +1,68 @@
+package control
+
+import (
+	"log"
+	"net/http"
+
+	"csz.net/tgstate/store"
+)
+
+// DashboardRoute 管理面板路径
+const DashboardRoute = "/admin"
+
+// AuthLogRoute 认证日志接口路径
+const AuthLogRoute = "/api/admin/authlog"
+
+// 管理面板显示的认证事件条数
+const adminAuthEvents = 100
+
+// dashboardData 管理面板数据
+type dashboardData struct {
+	pageData
+	AuthEvents []store.AuthEvent // 最近的认证事件，新的在前
+	Pending    []store.File      // 等待审核的匿名上传
+}
+
+// recentAuthEvents 最近的 n 条认证事件，新的在前
+func recentAuthEvents(n int) []store.AuthEvent {
+	events := store.Default().AuthEvents()
+	if len(events) > n {
+		events = events[len(events)-n:]
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events
+}
+
+// Dashboard 管理面板，POST 提交审核操作后返回面板
+func Dashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		action, id := r.PostFormValue("action"), r.PostFormValue("id")
+		if err := moderate(r.Context(), action, id); err != nil {
+			log.Printf("审核文件失败【%s】: %v", id, err)
+		}
+		auditAction(r, action)
+		auditTarget(r, id, "")
+		http.Redirect(w, r, DashboardRoute, http.StatusSeeOther)
+		return
+	}
+	renderTemplate(w, r, "dashboard.tmpl", dashboardData{
+		pageData:   pageData{Csrf: sessionCsrf(r, "p")},
+		AuthEvents: recentAuthEvents(adminAuthEvents),
+		Pending:    pendingFiles(),
+	})
+}
+
+// AuthLog 返回认证日志，新的在前
+func AuthLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	events := recentAuthEvents(1 << 30)
+	if events == nil {
+		events = []store.AuthEvent{}
+	}
+	writeJson(w, http.StatusOK, events)
+}
@@ -0,0 +1,151 @@
+package control
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/metrics"
+)
+
+// DebugRoute 运行时诊断接口路径前缀
+//
+// 不使用 net/http/pprof，其 init 会在默认路由上注册无需认证的 /debug/pprof/
+const DebugRoute = "/api/admin/debug/"
+
+// 采集 CPU profile 及执行追踪的默认及最长秒数
+const (
+	debugDefaultSeconds = 30
+	debugMaxSeconds     = 300
+)
+
+func init() {
+	metrics.Gauge("tgstate_goroutines", "Number of goroutines", func() int64 {
+		return int64(runtime.NumGoroutine())
+	})
+	metrics.Gauge("tgstate_cache_cleanup_pending", "Cached files waiting for delayed cleanup after a download", func() int64 {
+		return int64(getFileCache().delayed.pending())
+	})
+}
+
+// startTime 进程启动时间
+var startTime = time.Now()
+
+// debugVarsReport 运行时统计
+type debugVarsReport struct {
+	GoVersion  string           `json:"go_version"`
+	Uptime     int64            `json:"uptime"` // 运行秒数
+	Goroutines int              `json:"goroutines"`
+	NumCPU     int              `json:"num_cpu"`
+	GOMAXPROCS int              `json:"gomaxprocs"`
+	MemStats   runtime.MemStats `json:"memstats"`
+	Metrics    map[string]int64 `json:"metrics"`
+}
+
+// debugSeconds 读取 seconds 参数，限制在 1 到 debugMaxSeconds 之间
+func debugSeconds(r *http.Request) time.Duration {
+	n, err := strconv.Atoi(r.URL.Query().Get("seconds"))
+	if err != nil || n <= 0 {
+		n = debugDefaultSeconds
+	}
+	if n > debugMaxSeconds {
+		n = debugMaxSeconds
+	}
+	return time.Duration(n) * time.Second
+}
+
+// debugWait 等待采集结束，客户端断开时提前结束
+func debugWait(r *http.Request, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-r.Context().Done():
+	}
+}
+
+// debugAttachment 设置二进制 profile 的响应头
+func debugAttachment(w http.ResponseWriter, name string) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+}
+
+// Debug 运行时诊断，用于排查缓存占用内存及协程泄漏：
+//
+//	vars           运行时统计及全部指标（JSON）
+//	pprof/         可用的 profile 列表
+//	pprof/profile  CPU profile，seconds 参数指定采集秒数
+//	pprof/trace    执行追踪，seconds 参数指定采集秒数
+//	pprof/{name}   heap、goroutine、allocs 等 profile，debug=1 或 2 时输出文本，gc=1 时先执行 GC
+func Debug(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	name := PathValue(r, "name")
+	switch {
+	case name == "vars":
+		report := debugVarsReport{
+			GoVersion:  runtime.Version(),
+			Uptime:     int64(time.Since(startTime) / time.Second),
+			Goroutines: runtime.NumGoroutine(),
+			NumCPU:     runtime.NumCPU(),
+			GOMAXPROCS: runtime.GOMAXPROCS(0),
+			Metrics:    metrics.Values(),
+		}
+		runtime.ReadMemStats(&report.MemStats)
+		writeJson(w, http.StatusOK, report)
+	case name == "pprof" || name == "pprof/":
+		profiles := pprof.Profiles()
+		sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name() < profiles[j].Name() })
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, p := range profiles {
+			fmt.Fprintf(w, "%d\t%s\n", p.Count(), p.Name())
+		}
+		fmt.Fprintln(w, "-\tprofile")
+		fmt.Fprintln(w, "-\ttrace")
+	case name == "pprof/profile":
+		debugAttachment(w, "profile")
+		if err := pprof.StartCPUProfile(w); err != nil {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, "CPU profiling already in progress", http.StatusConflict)
+			return
+		}
+		debugWait(r, debugSeconds(r))
+		pprof.StopCPUProfile()
+	case name == "pprof/trace":
+		debugAttachment(w, "trace")
+		if err := trace.Start(w); err != nil {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, "Tracing already in progress", http.StatusConflict)
+			return
+		}
+		debugWait(r, debugSeconds(r))
+		trace.Stop()
+	case strings.HasPrefix(name, "pprof/"):
+		p := pprof.Lookup(strings.TrimPrefix(name, "pprof/"))
+		if p == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if p.Name() == "heap" && r.URL.Query().Get("gc") == "1" {
+			runtime.GC()
+		}
+		debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+		if debug > 0 {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			debugAttachment(w, p.Name())
+		}
+		p.WriteTo(w, debug)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,106 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// 小于该大小的文件才检查是否为分块清单
+const blobIndexMaxSize = 1024 * 1024
+
+// deleteFile 删除 Telegram 中的消息（分块文件包括全部分块）并清除元数据与缓存
+//
+// 未记录消息位置的旧文件无法从 Telegram 删除，此时仍清除本地数据并返回 utils.ErrNoMessage
+func deleteFile(ctx context.Context, id string) error {
+	st := store.Default()
+	ids := messageIDs(ctx, id)
+	var tgErr error
+	for i, fid := range ids {
+		if err := utils.DeleteMessage(fid); err != nil {
+			log.Printf("删除消息失败【%s】: %v", fid, err)
+			if i == 0 || !errors.Is(err, utils.ErrNoMessage) {
+				tgErr = err
+			}
+		}
+		if err := st.DeleteFile(fid); err != nil {
+			return err
+		}
+		getFileCache().cleanupFile(fid)
+		getMemCache().remove(fid)
+		st.CacheDel("url:" + fid)
+	}
+	os.Remove(filepath.Join(conf.DataDir, "torrent", id+".torrent"))
+	utils.Emit(utils.Event{
+		Event: utils.EventDelete,
+		ID:    id,
+		Url:   strings.TrimSuffix(conf.BaseUrl, "/") + conf.FileRoute + id,
+	})
+	return tgErr
+}
+
+// messageIDs 返回文件对应的全部文件ID，分块文件包括清单及各分块
+func messageIDs(ctx context.Context, id string) []string {
+	ids := []string{id}
+	if f, ok := store.Default().GetFile(id); ok && f.Subs != "" {
+		ids = append(ids, f.Subs)
+	}
+	if _, size, ok := utils.GetDownloadInfo(id); ok && size <= blobIndexMaxSize {
+		if idx, err := loadBlobIndex(ctx, id); err == nil {
+			for _, c := range idx.Chunks {
+				ids = append(ids, c.ID)
+			}
+		}
+	}
+	return ids
+}
+
+// discardUpload 删除刚上传但与已有文件重复的消息，该文件尚未登记，不发布删除事件
+func discardUpload(id string) {
+	for _, fid := range messageIDs(context.Background(), id) {
+		if err := utils.DeleteMessage(fid); err != nil {
+			log.Printf("删除重复上传的消息失败【%s】: %v", fid, err)
+		}
+		getFileCache().cleanupFile(fid)
+		getMemCache().remove(fid)
+	}
+}
+
+// Delete 删除文件，启用回收站时先移入回收站，permanent=1 或已在回收站中时彻底删除；
+// Telegram 中的消息未知时只清除本地数据
+func Delete(w http.ResponseWriter, r *http.Request, id string) {
+	auditAction(r, "delete")
+	st := store.Default()
+	f, known := st.GetFile(id)
+	if _, ok := st.GetMessage(id); !known && !ok {
+		errJson(w, r, http.StatusNotFound, "File not found")
+		return
+	}
+	if known && f.DeletedAt == 0 && conf.TrashDays > 0 && r.URL.Query().Get("permanent") != "1" {
+		if err := trashFile(id); err != nil {
+			log.Printf("保存文件记录失败: %v", err)
+			errJsonMsg(w, r, http.StatusInternalServerError, "error")
+			return
+		}
+		auditAction(r, "trash")
+		writeJson(w, http.StatusOK, conf.UploadResponse{Code: 1, Message: "trashed"})
+		return
+	}
+	err := deleteFile(r.Context(), id)
+	switch {
+	case err == nil:
+		writeJson(w, http.StatusOK, conf.UploadResponse{Code: 1, Message: "deleted"})
+	case errors.Is(err, utils.ErrNoMessage):
+		writeJson(w, http.StatusOK, conf.UploadResponse{Code: 1, Message: tr(r, "Deleted locally, the Telegram message is unknown")})
+	default:
+		errJson(w, r, http.StatusBadGateway, tr(r, "Failed to delete the Telegram message: %v", err))
+	}
+}
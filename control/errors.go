@@ -0,0 +1,77 @@
+package control
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/utils"
+)
+
+// requestIDRe 沿用反向代理传入的请求ID时允许的格式
+var requestIDRe = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+type requestIDKey struct{}
+
+// RequestID 为每个请求分配ID并写入 X-Request-Id 响应头，信任反向代理时沿用其传入的ID
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if !conf.TrustProxy || !requestIDRe.MatchString(id) {
+			id = utils.RandString(16)
+		}
+		w.Header().Set("X-Request-Id", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// requestID 返回请求ID，未经过 RequestID 时为空
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// legacyErrors 是否以旧版格式返回错误，/api/v1 下的接口始终使用新格式
+func legacyErrors(r *http.Request) bool {
+	return conf.LegacyErrors && !isV1(r)
+}
+
+// errorCode 由状态码得出错误类型，如 413 为 request_entity_too_large
+func errorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// writeError 以 JSON 返回错误，旧版格式以 legacyStatus 返回 code 为 0 的 UploadResponse
+func writeError(w http.ResponseWriter, r *http.Request, status, legacyStatus int, msg string, details interface{}) {
+	if legacyErrors(r) {
+		writeJson(w, legacyStatus, conf.UploadResponse{Code: 0, Message: tr(r, msg)})
+		return
+	}
+	writeJson(w, status, conf.ApiError{
+		Code:      errorCode(status),
+		Message:   tr(r, msg),
+		Details:   details,
+		RequestID: requestID(r),
+	})
+}
+
+// errJsonMsg 返回错误消息，按请求的语言翻译；旧版格式与之前一致以 200 返回
+func errJsonMsg(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	writeError(w, r, status, http.StatusOK, msg, nil)
+}
+
+// errJson 以 status 返回错误，旧版格式同样使用该状态码
+func errJson(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	writeError(w, r, status, status, msg, nil)
+}
+
+// httpError 返回中间件及路由的错误，接口返回 JSON，页面返回纯文本
+func httpError(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	if isApiPath(r.URL.Path) {
+		errJson(w, r, status, msg)
+		return
+	}
+	http.Error(w, msg, status)
+}
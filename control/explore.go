@@ -0,0 +1,80 @@
+package control
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// ExploreRoute 公开图片列表页面路径
+const ExploreRoute = "/explore"
+
+// explorePageSize 每页显示的图片数
+const explorePageSize = 30
+
+// exploreItem 列表中的图片
+type exploreItem struct {
+	Name string
+	Src  string
+	View string
+}
+
+// exploreView 公开图片列表页面数据
+type exploreView struct {
+	pageData
+	Items []exploreItem
+	Page  int
+	Prev  string
+	Next  string
+}
+
+// publicFiles 列出公开且已审核的文件，images 为 true 时只列出图片，新的在前
+func publicFiles(images bool) []store.File {
+	var files []store.File
+	for _, f := range store.Default().Files() {
+		if f.Access() != store.VisibilityPublic || f.DeletedAt > 0 || f.Pending || f.Tenant != "" || f.Name == utils.BlobChunkName {
+			continue
+		}
+		if images && !strings.HasPrefix(utils.TypeByName(f.Name), "image/") {
+			continue
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].CreatedAt != files[j].CreatedAt {
+			return files[i].CreatedAt > files[j].CreatedAt
+		}
+		return files[i].ID < files[j].ID
+	})
+	return files
+}
+
+// Explore 分页展示最近上传的公开图片，page 参数从 1 开始
+func Explore(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	files := publicFiles(true)
+	data := exploreView{Page: page, Items: []exploreItem{}}
+	start := (page - 1) * explorePageSize
+	for i := start; i >= 0 && i < len(files) && i < start+explorePageSize; i++ {
+		data.Items = append(data.Items, exploreItem{
+			Name: files[i].Name,
+			Src:  conf.FileRoute + files[i].ID,
+			View: conf.ViewRoute + files[i].ID,
+		})
+	}
+	if page > 1 {
+		data.Prev = ExploreRoute + "?page=" + strconv.Itoa(page-1)
+	}
+	if start+explorePageSize < len(files) {
+		data.Next = ExploreRoute + "?page=" + strconv.Itoa(page+1)
+	}
+	renderTemplate(w, r, "explore.tmpl", data)
+}
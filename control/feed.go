@@ -0,0 +1,100 @@
+package control
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/utils"
+)
+
+// FeedRoute 公开文件的 RSS 订阅地址
+const FeedRoute = "/feed.xml"
+
+// feedSize 订阅中最多包含的文件数
+const feedSize = 50
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string      `xml:"title"`
+	Link          string      `xml:"link"`
+	Description   string      `xml:"description"`
+	Self          rssAtomLink `xml:"atom:link"`
+	LastBuildDate string      `xml:"lastBuildDate,omitempty"`
+	Items         []rssItem   `xml:"item"`
+}
+
+// rssAtomLink 订阅自身的地址，部分阅读器据此识别订阅
+type rssAtomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title     string       `xml:"title"`
+	Link      string       `xml:"link"`
+	Guid      string       `xml:"guid"`
+	PubDate   string       `xml:"pubDate,omitempty"`
+	Enclosure rssEnclosure `xml:"enclosure"`
+}
+
+// rssEnclosure 文件的下载地址、大小及内容类型
+type rssEnclosure struct {
+	Url    string `xml:"url,attr"`
+	Length string `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// Feed 以 RSS 2.0 输出最近上传的公开文件，附件为文件的下载地址
+func Feed(w http.ResponseWriter, r *http.Request) {
+	base := publicBaseUrl(r)
+	feed := rssFeed{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       theme.Name,
+			Link:        base + "/",
+			Description: tr(r, "Recent public uploads"),
+			Self:        rssAtomLink{Href: base + FeedRoute, Rel: "self", Type: "application/rss+xml"},
+			Items:       []rssItem{},
+		},
+	}
+	files := publicFiles(false)
+	if len(files) > feedSize {
+		files = files[:feedSize]
+	}
+	for i, f := range files {
+		item := rssItem{
+			Title: f.Name,
+			Link:  base + conf.ViewRoute + f.ID,
+			Guid:  base + conf.FileRoute + f.ID,
+			Enclosure: rssEnclosure{
+				Url:    base + conf.FileRoute + f.ID,
+				Length: strconv.FormatInt(f.Size, 10),
+				Type:   utils.TypeByName(f.Name),
+			},
+		}
+		if f.CreatedAt > 0 {
+			item.PubDate = time.Unix(f.CreatedAt, 0).UTC().Format(time.RFC1123Z)
+			if i == 0 {
+				feed.Channel.LastBuildDate = item.PubDate
+			}
+		}
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(feed)
+}
@@ -0,0 +1,57 @@
+package control
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"csz.net/tgstate/i18n"
+	"csz.net/tgstate/utils"
+)
+
+// fetchFailure 下载失败的原因对应的状态码及提示
+type fetchFailure struct {
+	status  int
+	title   string
+	message string
+}
+
+var fetchFailures = map[string]fetchFailure{
+	utils.FetchExpired:     {http.StatusServiceUnavailable, "File reference expired", "Telegram no longer accepts the stored reference to this file or it is temporarily unavailable. It is being refreshed, please retry shortly."},
+	utils.FetchDeleted:     {http.StatusGone, "File no longer exists on Telegram", "The Telegram message holding this file was deleted, so its content can no longer be downloaded."},
+	utils.FetchTooBig:      {http.StatusBadGateway, "File too large to fetch", "Telegram refuses to hand files of this size to bots, so it cannot be downloaded through this server."},
+	utils.FetchRateLimited: {http.StatusServiceUnavailable, "Telegram is rate limiting requests", "Too many files were requested from Telegram at once. Please retry after the indicated time."},
+	utils.FetchUnavailable: {http.StatusServiceUnavailable, "Telegram is unreachable", "The storage backend could not be reached. This is usually temporary, please retry shortly."},
+}
+
+// fetchError 获取文件内容失败时按原因返回错误，浏览器返回错误页面，其余客户端返回 JSON；
+// 可重试的错误附带 Retry-After
+func fetchError(w http.ResponseWriter, r *http.Request, err error) {
+	f := fetchFailure{http.StatusInternalServerError, "Failed to fetch content", "Failed to fetch content"}
+	reason, retry := "", 0
+	var fe *utils.FetchError
+	if errors.As(err, &fe) {
+		f, reason, retry = fetchFailures[fe.Reason], fe.Reason, fe.RetryAfter
+	}
+	if retry > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		lang := pageLang(r)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(f.status)
+		renderTemplate(w, r, "fetcherror.tmpl", struct {
+			Title, Message string
+			RetryAfter     int
+		}{i18n.T(lang, f.title), i18n.T(lang, f.message), retry})
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	var details interface{}
+	if reason != "" {
+		details = map[string]interface{}{"reason": reason, "retry_after": retry}
+	}
+	writeError(w, r, f.status, f.status, f.message, details)
+}
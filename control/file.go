@@ -0,0 +1,194 @@
+package control
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// FileApiRoute 文件相关接口路径，/api/file/{id} 用于获取信息（GET）及删除（DELETE），其他接口为 /api/file/{id}/{action}
+const FileApiRoute = "/api/file/"
+
+// FileApi 返回文件相关接口的处理函数，action 为空时处理 /api/file/{id}，否则处理 /api/file/{id}/{action}
+func FileApi(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fileApi(w, r, action)
+	}
+}
+
+func fileApi(w http.ResponseWriter, r *http.Request, action string) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	raw := PathValue(r, "id")
+	if !utils.ValidFileID(raw) {
+		http.NotFound(w, r)
+		return
+	}
+	id := strings.TrimPrefix(raw, "blob-")
+	auditTarget(r, id, "")
+	if action == "" {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			Info(w, r, id)
+		case http.MethodDelete:
+			Delete(w, r, id)
+		default:
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	auditAction(r, action)
+	switch action {
+	case "sign":
+		Sign(w, r, id)
+	case "headers":
+		SmallBody(func(w http.ResponseWriter, r *http.Request) {
+			Headers(w, r, id)
+		})(w, r)
+	case "subs":
+		SmallBody(func(w http.ResponseWriter, r *http.Request) {
+			Subs(w, r, id)
+		})(w, r)
+	case "torrent":
+		Torrent(w, r, id)
+	case "cid":
+		Cid(w, r, id)
+	case "restore":
+		Untrash(w, r, id)
+	case "approve", "reject":
+		Moderate(w, r, id, action)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// fileContent 文件内容及基本信息
+type fileContent struct {
+	io.ReadCloser
+	Name string
+	Size int64
+}
+
+// openContent 打开文件内容，分块文件按顺序拼接各个分块
+func openContent(ctx context.Context, id string) (*fileContent, error) {
+	filePath, err := getFileCache().getCachedFile(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	name := id
+	if rec, ok := store.Default().GetFile(id); ok && rec.Name != "" {
+		name = rec.Name
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	if !utils.IsBlobIndex(head[:n]) {
+		f.Seek(0, io.SeekStart)
+		return &fileContent{ReadCloser: f, Name: name, Size: info.Size()}, nil
+	}
+	data, err := os.ReadFile(filePath)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := utils.ParseBlobIndex(data)
+	if err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeBlob(ctx, pw, idx, 0, idx.Size))
+	}()
+	return &fileContent{ReadCloser: pr, Name: idx.Name, Size: idx.Size}, nil
+}
+
+// ReadFileAt 从 off 开始读取文件内容，分块文件只下载涉及的分块，
+// 下载的内容保存在文件缓存中，供挂载的只读文件系统按需读取
+func ReadFileAt(ctx context.Context, id string, p []byte, off int64) (int, error) {
+	filePath, err := getFileCache().getCachedFile(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	head := make([]byte, 512)
+	n, _ := f.ReadAt(head, 0)
+	if !utils.IsBlobIndex(head[:n]) {
+		return f.ReadAt(p, off)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+	idx, err := utils.ParseBlobIndex(data)
+	if err != nil {
+		return 0, err
+	}
+	var read int
+	var offset int64
+	for _, chunk := range idx.Chunks {
+		if read == len(p) {
+			break
+		}
+		size := chunk.Size
+		if size <= 0 {
+			// 旧版索引未记录分块大小，以下载后的大小为准
+			st, err := chunkStat(ctx, chunk.ID)
+			if err != nil {
+				return read, err
+			}
+			size = st
+		}
+		if offset+size <= off {
+			offset += size
+			continue
+		}
+		cp, err := getFileCache().getCachedFile(ctx, chunk.ID)
+		if err != nil {
+			return read, err
+		}
+		cf, err := os.Open(cp)
+		if err != nil {
+			return read, err
+		}
+		m, err := cf.ReadAt(p[read:], off+int64(read)-offset)
+		cf.Close()
+		read += m
+		if err != nil && err != io.EOF {
+			return read, err
+		}
+		offset += size
+	}
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+// chunkStat 下载分块并返回其大小
+func chunkStat(ctx context.Context, id string) (int64, error) {
+	cp, err := getFileCache().getCachedFile(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(cp)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
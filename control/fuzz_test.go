@@ -0,0 +1,53 @@
+package control
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"csz.net/tgstate/conf"
+)
+
+func FuzzParseRange(f *testing.F) {
+	for _, s := range []string{"", "bytes=0-0", "bytes=0-", "bytes=-1", "bytes=10-20,30-40", "bytes=0-,5-9", "bytes=,", "bytes=9223372036854775807-", "bytes=-9223372036854775808", "bytes= 1 - 2 ", "bytes=+1-+2"} {
+		f.Add(s, int64(100))
+	}
+	f.Add("bytes=0-", int64(0))
+	f.Add("bytes=-5", int64(-1))
+	f.Fuzz(func(t *testing.T, header string, size int64) {
+		ranges, err := parseRange(header, size)
+		if err != nil {
+			return
+		}
+		for _, ra := range ranges {
+			if ra.start < 0 || ra.end < ra.start || ra.end >= size || ra.length != ra.end-ra.start+1 {
+				t.Fatalf("parseRange(%q, %d) = %+v", header, size, ranges)
+			}
+		}
+	})
+}
+
+// FuzzFilePath 下载路由的路径来自客户端，任意路径都不能导致 panic
+func FuzzFilePath(f *testing.F) {
+	for _, s := range []string{"", "abc", "abc/subs", "/subs", "../../etc/passwd", "blob-abc", "a/b/c", "%00", "abc?x=1", "abc/subs/subs"} {
+		f.Add(s, "")
+	}
+	f.Add("abc", "bytes=0-10")
+	f.Fuzz(func(t *testing.T, path, rangeHeader string) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		r := (&http.Request{
+			Method: http.MethodGet,
+			URL:    &url.URL{Path: conf.FileRoute + path},
+			Header: http.Header{"Range": {rangeHeader}},
+		}).WithContext(ctx)
+		w := httptest.NewRecorder()
+		testRoutes().ServeHTTP(w, r)
+		if w.Code == http.StatusPartialContent && w.Header().Get("Content-Range") == "" && w.Header().Get("Content-Type") == "" {
+			t.Fatalf("206 without Content-Range for %q", path)
+		}
+	})
+}
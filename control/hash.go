@@ -0,0 +1,104 @@
+package control
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+)
+
+// HashRoute 按内容哈希访问文件的路径
+const HashRoute = "/h/"
+
+var sha256Re = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Hash 按 sha256 访问文件，内容不可变，允许长期缓存
+func Hash(w http.ResponseWriter, r *http.Request) {
+	sha := strings.ToLower(PathValue(r, "sha"))
+	if !sha256Re.MatchString(sha) {
+		http.NotFound(w, r)
+		return
+	}
+	id, ok := store.Default().GetHash(sha)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	serveFile(w, r, id)
+}
+
+// dedupResult 同一租户下已上传过相同内容且可见性相同时返回已有文件的响应，visibility 为空时为默认可见性
+func dedupResult(sha, prefix, tenantName, visibility string) (conf.UploadResponse, bool) {
+	if visibility == "" {
+		visibility = defaultVisibility()
+	}
+	st := store.Default()
+	id, ok := st.GetHash(sha)
+	if !ok {
+		return conf.UploadResponse{}, false
+	}
+	if f, ok := st.GetFile(id); !ok || f.Tenant != tenantName || f.DeletedAt > 0 || f.Pending || f.Access() != visibility {
+		return conf.UploadResponse{}, false
+	}
+	return fileResponse(id, sha, prefix), true
+}
+
+// ifNew 上传请求是否带有 if-new=1，内容已存在时返回 409 而不是复用已有文件
+func ifNew(r *http.Request) bool {
+	return r.URL.Query().Get("if-new") == "1"
+}
+
+// duplicateError 返回 409 及已有文件的地址，便于备份脚本发现重复发送的内容
+func duplicateError(w http.ResponseWriter, r *http.Request, res conf.UploadResponse) {
+	if legacyErrors(r) {
+		writeJson(w, http.StatusConflict, conf.UploadResponse{Code: 0, Message: tr(r, "File already exists"), ImgUrl: res.ImgUrl, Sha256: res.Sha256, View: res.View})
+		return
+	}
+	writeError(w, r, http.StatusConflict, http.StatusConflict, "File already exists", map[string]string{
+		"path":   res.Message,
+		"url":    res.ImgUrl,
+		"sha256": res.Sha256,
+	})
+}
+
+// fileResponse 生成文件的上传成功响应
+func fileResponse(id, sha, prefix string) conf.UploadResponse {
+	img := prefix + conf.FileRoute + id
+	return conf.UploadResponse{
+		Code:    1,
+		Message: img,
+		ImgUrl:  strings.TrimSuffix(conf.BaseUrl, "/") + img,
+		Sha256:  sha,
+		View:    strings.TrimSuffix(conf.BaseUrl, "/") + prefix + conf.ViewRoute + id,
+	}
+}
+
+// checkDigest 已知文件哈希时设置 ETag 及 Repr-Digest，If-None-Match 命中时返回 304 并返回 true
+func checkDigest(w http.ResponseWriter, r *http.Request, id string) bool {
+	f, ok := store.Default().GetFile(id)
+	if !ok || f.Sha256 == "" {
+		return false
+	}
+	sum, err := hex.DecodeString(f.Sha256)
+	if err != nil {
+		return false
+	}
+	etag := `"` + f.Sha256 + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum)+":")
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+			if t == etag || t == "*" {
+				w.WriteHeader(http.StatusNotModified)
+				return true
+			}
+		}
+	}
+	return false
+}
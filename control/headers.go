@@ -0,0 +1,143 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+)
+
+// fileHeaderNames 允许按文件设置的响应头
+var fileHeaderNames = map[string]bool{
+	"Cache-Control":                true,
+	"Content-Disposition":          true,
+	"Content-Language":             true,
+	"Access-Control-Allow-Origin":  true,
+	"Cross-Origin-Resource-Policy": true,
+	"X-Robots-Tag":                 true,
+	"Link":                         true,
+}
+
+var (
+	errFileHeader   = errors.New("invalid file header")
+	errFileNotFound = errors.New("file not found")
+)
+
+// fileHeaders 按文件设置的内容类型及响应头，优先于内容检测
+type fileHeaders struct {
+	ContentType string            `json:"content_type,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// parseFileHeaders 解析上传接口的 type 与 header 参数，header 形如 "Cache-Control: max-age=60"
+func parseFileHeaders(contentType string, lines []string) (fileHeaders, error) {
+	h := fileHeaders{ContentType: strings.TrimSpace(contentType)}
+	for _, line := range lines {
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return h, errFileHeader
+		}
+		if h.Headers == nil {
+			h.Headers = make(map[string]string)
+		}
+		h.Headers[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+	}
+	return h, h.normalize()
+}
+
+// normalize 检查内容类型及响应头，并统一响应头名称的大小写
+func (h *fileHeaders) normalize() error {
+	if h.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(h.ContentType); err != nil {
+			return errFileHeader
+		}
+	}
+	headers := make(map[string]string, len(h.Headers))
+	for k, v := range h.Headers {
+		k = http.CanonicalHeaderKey(strings.TrimSpace(k))
+		if !fileHeaderNames[k] || strings.ContainsAny(v, "\r\n") {
+			return errFileHeader
+		}
+		headers[k] = v
+	}
+	h.Headers = headers
+	if len(h.Headers) == 0 {
+		h.Headers = nil
+	}
+	return nil
+}
+
+func (h fileHeaders) empty() bool {
+	return h.ContentType == "" && len(h.Headers) == 0
+}
+
+// setFileHeaders 保存文件的内容类型及响应头
+func setFileHeaders(id string, h fileHeaders) error {
+	st := store.Default()
+	f, ok := st.GetFile(id)
+	if !ok {
+		return errFileNotFound
+	}
+	f.ContentType, f.Headers = h.ContentType, h.Headers
+	return st.PutFile(f)
+}
+
+// withHeaders 上传成功后保存指定的内容类型及响应头
+func withHeaders(res conf.UploadResponse, prefix string, h fileHeaders) conf.UploadResponse {
+	if res.Code != 1 || h.empty() {
+		return res
+	}
+	if err := setFileHeaders(strings.TrimPrefix(res.Message, prefix+conf.FileRoute), h); err != nil {
+		log.Printf("保存文件记录失败: %v", err)
+	}
+	return res
+}
+
+// applyFileHeaders 写出文件记录中的响应头，返回指定的内容类型，未指定时为空
+func applyFileHeaders(w http.ResponseWriter, id string) string {
+	f, ok := store.Default().GetFile(id)
+	if !ok {
+		return ""
+	}
+	for k, v := range f.Headers {
+		w.Header().Set(k, v)
+	}
+	return f.ContentType
+}
+
+// Headers GET 查看文件的内容类型及响应头，PUT 以 JSON 替换
+func Headers(w http.ResponseWriter, r *http.Request, id string) {
+	st := store.Default()
+	f, ok := st.GetFile(id)
+	if !ok {
+		errJson(w, r, http.StatusNotFound, "File not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		writeJson(w, http.StatusOK, fileHeaders{ContentType: f.ContentType, Headers: f.Headers})
+	case http.MethodPut:
+		var h fileHeaders
+		if err := json.NewDecoder(r.Body).Decode(&h); err != nil {
+			errJsonMsg(w, r, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		if err := h.normalize(); err != nil {
+			errJsonMsg(w, r, http.StatusBadRequest, "Invalid content type or header")
+			return
+		}
+		if err := setFileHeaders(id, h); err != nil {
+			log.Printf("保存文件记录失败: %v", err)
+			errJsonMsg(w, r, http.StatusInternalServerError, "error")
+			return
+		}
+		writeJson(w, http.StatusOK, h)
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,50 @@
+package control
+
+import (
+	"net/http"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/utils"
+)
+
+// HealthRoute 健康检查接口路径
+const HealthRoute = "/api/health"
+
+// healthQueue 上传队列状态
+type healthQueue struct {
+	Depth    int   `json:"depth"`
+	Capacity int   `json:"capacity"`
+	InFlight int64 `json:"in_flight"`
+}
+
+// healthReport 健康检查结果
+type healthReport struct {
+	Status      string             `json:"status"` // ok、throttled 或 maintenance
+	Mode        string             `json:"mode"`
+	Maintenance string             `json:"maintenance"`
+	Queue       healthQueue        `json:"queue"`
+	Telegram    []utils.SendBudget `json:"telegram"` // 各频道的发送额度
+}
+
+// Health 返回服务状态、上传队列及 Telegram 发送额度，无需认证
+func Health(w http.ResponseWriter, r *http.Request) {
+	report := healthReport{
+		Status:      "ok",
+		Mode:        conf.Mode,
+		Maintenance: maintenanceMode(),
+		Telegram:    utils.SendBudgets(),
+	}
+	if conf.Mode != "r" {
+		report.Queue.Depth, report.Queue.Capacity, report.Queue.InFlight = utils.QueueStats()
+	}
+	for _, b := range report.Telegram {
+		if b.RetryAfter > 0 {
+			report.Status = "throttled"
+		}
+	}
+	if report.Maintenance != MaintenanceOff {
+		report.Status = "maintenance"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJson(w, http.StatusOK, report)
+}
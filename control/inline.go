@@ -0,0 +1,76 @@
+package control
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// 内联查询每页返回的结果数，Telegram 限制为 50
+const inlinePageSize = 20
+
+// inlineResults 按文件名、ID 或 sha256 搜索文件，按短链接名称或目标搜索短链接，文件按上传时间倒序在前
+func inlineResults(query string, offset int) ([]utils.InlineResult, string) {
+	query = strings.TrimSpace(query)
+	base := strings.TrimSuffix(conf.BaseUrl, "/")
+	var files []store.File
+	for _, f := range store.Default().Files() {
+		// 回收站中、待审核的文件及网页端上传的分块不对外分享
+		if f.Name == utils.BlobChunkName || f.DeletedAt > 0 || f.Pending || !matchFile(f, query) {
+			continue
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].CreatedAt != files[j].CreatedAt {
+			return files[i].CreatedAt > files[j].CreatedAt
+		}
+		return files[i].ID < files[j].ID
+	})
+	var results []utils.InlineResult
+	for _, f := range files {
+		link := base + conf.FileRoute + f.ID
+		results = append(results, utils.InlineResult{
+			ID:          "f" + modKey(f.ID),
+			Title:       f.Name,
+			Description: humanSize(f.Size),
+			Url:         link,
+			Text:        f.Name + "\n" + link,
+		})
+	}
+	if query != "" {
+		keyword := strings.ToLower(query)
+		links := store.Default().Links()
+		sort.Slice(links, func(i, j int) bool { return links[i].Slug < links[j].Slug })
+		for _, l := range links {
+			if !strings.Contains(strings.ToLower(l.Slug), keyword) && !strings.Contains(strings.ToLower(l.Target), keyword) {
+				continue
+			}
+			link := base + ShortRoute + l.Slug
+			results = append(results, utils.InlineResult{
+				ID:          "s" + modKey(l.Slug),
+				Title:       l.Slug,
+				Description: l.Target,
+				Url:         link,
+				Text:        link,
+			})
+		}
+	}
+	if offset >= len(results) {
+		return nil, ""
+	}
+	end := offset + inlinePageSize
+	if end >= len(results) {
+		return results[offset:], ""
+	}
+	return results[offset:end], strconv.Itoa(end)
+}
+
+// StartInline 注册机器人的内联查询，用户可以在任意会话中通过 @bot 关键字分享已存储的文件
+func StartInline() {
+	utils.HandleInline(inlineResults)
+}
@@ -0,0 +1,1014 @@
+package control
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/tgtest"
+	"csz.net/tgstate/utils"
+)
+
+// tg 模拟的 Bot API，上传及下载都不访问 Telegram
+var tg *tgtest.Server
+
+func TestMain(m *testing.M) {
+	// 文件缓存在当前目录的 file_cache 中，元数据保存在 DataDir
+	dir, err := os.MkdirTemp("", "tgstate-test-*")
+	if err != nil {
+		panic(err)
+	}
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	tg = tgtest.NewServer("123456:test")
+	conf.BotToken, conf.TgBotApiProxy = tg.Token, tg.URL
+	conf.ChannelName, conf.Mode, conf.DataDir = "@test", "p", dir
+	conf.ChunkSize = utils.MinChunkSize
+	code := m.Run()
+	tg.Close()
+	os.Chdir(wd)
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// testUpload 通过上传接口上传文件，返回文件ID
+func testUpload(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	return testUploadQuery(t, name, data, "")
+}
+
+// testUploadQuery 上传文件并附带查询参数，如 visibility=private
+func testUploadQuery(t *testing.T, name string, data []byte, query string) string {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("image", name)
+	fw.Write(data)
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/api?"+query, &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	UploadImageAPI(w, r)
+	if !strings.Contains(w.Body.String(), `"code":1`) {
+		t.Fatalf("upload %s: %s", name, w.Body.String())
+	}
+	var res conf.UploadResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimPrefix(res.Message, conf.FileRoute)
+}
+
+// testGet 通过下载路由获取文件
+func testGet(id, rangeHeader string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, conf.FileRoute+id, nil)
+	if rangeHeader != "" {
+		r.Header.Set("Range", rangeHeader)
+	}
+	w := httptest.NewRecorder()
+	testRoutes().ServeHTTP(w, r)
+	return w
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestIntegrationUploadDownload(t *testing.T) {
+	data := []byte("hello tgState")
+	id := testUpload(t, "hello.txt", data)
+	if got, ok := tg.File(id); !ok || !bytes.Equal(got, data) {
+		t.Fatalf("telegram has %q, want %q", got, data)
+	}
+	f, ok := store.Default().GetFile(id)
+	if !ok || f.Name != "hello.txt" || f.Size != int64(len(data)) || f.Sha256 == "" {
+		t.Fatalf("file record = %+v", f)
+	}
+	w := testGet(id, "")
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), data) {
+		t.Fatalf("download = %d %q", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	// 相同内容返回已有的文件
+	if again := testUpload(t, "copy.txt", data); again != id {
+		t.Errorf("duplicate upload returned %q, want %q", again, id)
+	}
+	if w := testGet("missing", ""); w.Code == http.StatusOK {
+		t.Errorf("missing file status = %d", w.Code)
+	}
+}
+
+func TestIntegrationUploadChecksum(t *testing.T) {
+	data := randomBytes(t, 4096)
+	sum := sha256.Sum256(data)
+	post := func(checksum string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("image", "checked.bin")
+		fw.Write(data)
+		mw.Close()
+		r := httptest.NewRequest(http.MethodPost, "/api/v1", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		r.Header.Set(ContentSha256Header, checksum)
+		w := httptest.NewRecorder()
+		UploadImageAPI(w, r)
+		return w
+	}
+	if w := post("not-a-sum"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid header = %d %s", w.Code, w.Body.String())
+	}
+	sends := tg.Hits("sendDocument")
+	w := post(strings.Repeat("0", 64))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), hex.EncodeToString(sum[:])) {
+		t.Fatalf("mismatch = %d %s", w.Code, w.Body.String())
+	}
+	// 校验失败时发往 Telegram 的请求被中止
+	if got := tg.Hits("sendDocument"); got != sends {
+		t.Errorf("sendDocument hits = %d, want %d", got, sends)
+	}
+	w = post(strings.ToUpper(hex.EncodeToString(sum[:])))
+	if !strings.Contains(w.Body.String(), `"code":1`) {
+		t.Fatalf("matching checksum = %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestIntegrationSpoolTooLarge(t *testing.T) {
+	data := randomBytes(t, 4096)
+	for _, quota := range []bool{false, true} {
+		file := &countingReader{r: bytes.NewReader(data), limit: 1024, quota: quota}
+		r := httptest.NewRequest(http.MethodPost, "/api/v1", nil)
+		w := httptest.NewRecorder()
+		spoolUpload(w, r, file, "large.bin", conf.ChannelName, "", "", fileHeaders{})
+		want := "File size exceeds limit"
+		if quota {
+			want = "Tenant storage quota exceeded"
+		}
+		if w.Code != http.StatusRequestEntityTooLarge || !strings.Contains(w.Body.String(), want) {
+			t.Errorf("quota %v: %d %s", quota, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestIntegrationUploadError(t *testing.T) {
+	// 上传失败时返回错误，不再 panic
+	job := utils.NewUploadJob(conf.ChannelName, "err.bin", iotest.ErrReader(io.ErrUnexpectedEOF))
+	if err := utils.SubmitUpload(job); err != nil {
+		t.Fatal(err)
+	}
+	<-job.Done()
+	if job.FileID != "" || job.Err == nil {
+		t.Errorf("failed upload = %q, %v", job.FileID, job.Err)
+	}
+	conf.Mode = "r"
+	defer func() { conf.Mode = "p" }()
+	if _, err := utils.UpDocument(utils.TgFileData("ro.txt", strings.NewReader("x"))); err != utils.ErrReadOnly {
+		t.Errorf("read-only upload = %v", err)
+	}
+}
+
+func TestIntegrationRange(t *testing.T) {
+	data := randomBytes(t, 100<<10)
+	id := testUpload(t, "range.bin", data)
+	w := testGet(id, "bytes=100-199")
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), data[100:200]) {
+		t.Fatalf("range status = %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if cr := w.Header().Get("Content-Range"); cr != "bytes 100-199/102400" {
+		t.Errorf("Content-Range = %q", cr)
+	}
+	if w := testGet(id, "bytes=-10"); !bytes.Equal(w.Body.Bytes(), data[len(data)-10:]) {
+		t.Errorf("suffix range = %d bytes", w.Body.Len())
+	}
+	w = testGet(id, "bytes=0-9,20-29")
+	if w.Code != http.StatusPartialContent || !strings.HasPrefix(w.Header().Get("Content-Type"), "multipart/byteranges") {
+		t.Errorf("multi range = %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w := testGet(id, "bytes=200000-"); w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Errorf("unsatisfiable range status = %d", w.Code)
+	}
+}
+
+func TestIntegrationBlob(t *testing.T) {
+	size := 2*utils.MinChunkSize + utils.MinChunkSize/2
+	data := randomBytes(t, size)
+	id, err := utils.UpBlob("big.bin", bytes.NewReader(data), int64(size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tg.Name(id) != utils.BlobManifestName {
+		t.Fatalf("manifest name = %q", tg.Name(id))
+	}
+	w := testGet(id, "")
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), data) {
+		t.Fatalf("blob download = %d, %d bytes", w.Code, w.Body.Len())
+	}
+	// 跨越分块边界的范围
+	start := utils.MinChunkSize - 10
+	w = testGet(id, "bytes="+strconv.Itoa(start)+"-"+strconv.Itoa(start+19))
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), data[start:start+20]) {
+		t.Fatalf("blob range = %d, %d bytes", w.Code, w.Body.Len())
+	}
+}
+
+func TestIntegrationBlobReplicas(t *testing.T) {
+	var proxied, broken int32
+	target, _ := url.Parse(tg.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&proxied, 1)
+		proxy.ServeHTTP(w, r)
+	}))
+	defer good.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&broken, 1)
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer bad.Close()
+	conf.DownloadReplicas, conf.BlobParallel = good.URL+","+bad.URL+"/", 3
+	defer func() { conf.DownloadReplicas, conf.BlobParallel = "", 1 }()
+
+	size := 3*utils.MinChunkSize + 100
+	data := randomBytes(t, size)
+	id, err := utils.UpBlob("replicas.bin", bytes.NewReader(data), int64(size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := testGet(id, "")
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), data) {
+		t.Fatalf("blob download = %d, %d bytes", w.Code, w.Body.Len())
+	}
+	// 分块轮流分配到主地址及两个副本，失败的副本改用主地址
+	if atomic.LoadInt32(&proxied) == 0 || atomic.LoadInt32(&broken) == 0 {
+		t.Errorf("replica hits: good %d, broken %d", proxied, broken)
+	}
+	start := 2*utils.MinChunkSize - 10
+	w = testGet(id, "bytes="+strconv.Itoa(start)+"-"+strconv.Itoa(start+99))
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), data[start:start+100]) {
+		t.Fatalf("blob range = %d, %d bytes", w.Code, w.Body.Len())
+	}
+}
+
+// slowFailWriter 等待其余分块下载完成后写入失败，模拟客户端断开
+type slowFailWriter struct{}
+
+func (slowFailWriter) Write(p []byte) (int, error) {
+	time.Sleep(200 * time.Millisecond)
+	return 0, io.ErrClosedPipe
+}
+
+func TestIntegrationBlobParallelAbort(t *testing.T) {
+	conf.BlobParallel = 3
+	defer func() { conf.BlobParallel = 1 }()
+	size := 3*utils.MinChunkSize + 100
+	id, err := utils.UpBlob("abort.bin", bytes.NewReader(randomBytes(t, size)), int64(size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := tg.File(id)
+	idx, err := utils.ParseBlobIndex(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeBlobParallel(context.Background(), slowFailWriter{}, idx, 0, int64(size)); err == nil {
+		t.Fatal("write to a closed client succeeded")
+	}
+	// 输出中止后已下载的分块都应安排清理
+	cache := getFileCache()
+	scheduled := func() bool {
+		cache.delayed.mu.Lock()
+		defer cache.delayed.mu.Unlock()
+		for _, chunk := range idx.Chunks {
+			if _, cached := cache.lookup(chunk.ID); cached {
+				if _, ok := cache.delayed.due[chunk.ID]; !ok {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !scheduled() {
+		if time.Now().After(deadline) {
+			t.Fatal("downloaded chunks left in the cache without cleanup")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestIntegrationCacheEviction(t *testing.T) {
+	data := randomBytes(t, 4096)
+	id := testUpload(t, "evict.bin", data)
+	fetches := tg.Hits("file")
+	cache := getFileCache()
+	testGet(id, "")
+	if cache.delayed.pending() == 0 {
+		t.Error("no cleanup scheduled after a full download")
+	}
+	// 缓存命中时取消已安排的清理
+	testGet(id, "bytes=0-99")
+	if n := tg.Hits("file") - fetches; n != 1 {
+		t.Fatalf("telegram downloads = %d, want 1 (second request from cache)", n)
+	}
+	if _, ok := cache.lookup(id); !ok {
+		t.Fatal("file not cached after download")
+	}
+	cache.cleanupFile(id)
+	if _, ok := cache.lookup(id); ok {
+		t.Fatal("file still cached after cleanup")
+	}
+	if w := testGet(id, ""); !bytes.Equal(w.Body.Bytes(), data) {
+		t.Fatalf("download after eviction = %d bytes", w.Body.Len())
+	}
+	if n := tg.Hits("file") - fetches; n != 2 {
+		t.Errorf("telegram downloads = %d, want 2 after eviction", n)
+	}
+}
+
+func TestIntegrationCheck(t *testing.T) {
+	ok := testUpload(t, "check.txt", []byte("check ok"))
+	trashed := testUpload(t, "check-trash.txt", []byte("check trash"))
+	if err := trashFile(trashed); err != nil {
+		t.Fatal(err)
+	}
+	// 未登记但 Telegram 中存在的文件
+	unknown := tg.Put("legacy.txt", []byte("legacy"))
+	gone := tg.Put("gone.txt", []byte("gone"))
+	tg.Remove(gone)
+	body, _ := json.Marshal(conf.CheckRequest{IDs: []string{ok, trashed, unknown, gone, "../x"}})
+	r := httptest.NewRequest(http.MethodPost, CheckRoute, bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	Check(w, r)
+	var res conf.CheckResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("check = %d %s", w.Code, w.Body.String())
+	}
+	want := []string{linkOk, linkDeleted, linkOk, linkMissing, linkMissing}
+	if len(res.Files) != len(want) {
+		t.Fatalf("check = %+v", res.Files)
+	}
+	for i, f := range res.Files {
+		if f.Status != want[i] {
+			t.Errorf("%s status = %s, want %s", f.ID, f.Status, want[i])
+		}
+	}
+}
+
+func TestIntegrationAlbum(t *testing.T) {
+	cat := testUpload(t, "cat.txt", []byte("meow"))
+	dog := testUpload(t, "dog.txt", []byte("woof"))
+	post := func(req conf.AlbumRequest) conf.UploadResponse {
+		body, _ := json.Marshal(req)
+		r := httptest.NewRequest(http.MethodPost, AlbumApiRoute, bytes.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		AlbumAPI(w, r)
+		var res conf.UploadResponse
+		json.Unmarshal(w.Body.Bytes(), &res)
+		return res
+	}
+	if res := post(conf.AlbumRequest{Name: "pets", Files: []conf.AlbumEntry{{Path: "../cat.txt", ID: cat}}}); res.Code != 0 {
+		t.Errorf("path outside album accepted: %+v", res)
+	}
+	if res := post(conf.AlbumRequest{Name: "pets", Files: []conf.AlbumEntry{{Path: "cat.txt", ID: "missing"}}}); res.Code != 0 {
+		t.Errorf("unknown file accepted: %+v", res)
+	}
+	res := post(conf.AlbumRequest{Name: "pets", Files: []conf.AlbumEntry{{Path: "a/cat.txt", ID: cat}, {Path: "dog.txt", ID: dog}}})
+	if res.Code != 1 || !strings.HasPrefix(res.Message, AlbumRoute) {
+		t.Fatalf("album = %+v", res)
+	}
+	rt := NewRouter()
+	rt.Handle(AlbumRoute+"{id}", Album, http.MethodGet)
+	rt.Handle(AlbumRoute+"{id}/zip", AlbumZip, http.MethodGet)
+	w := httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, res.Message, nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), conf.ViewRoute+dog) {
+		t.Errorf("album page = %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, res.Message+"/zip", nil))
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("zip = %d %v", w.Code, err)
+	}
+	got := map[string]string{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		got[f.Name] = string(b)
+	}
+	if len(got) != 2 || got["pets/a/cat.txt"] != "meow" || got["pets/dog.txt"] != "woof" {
+		t.Errorf("zip entries = %v", got)
+	}
+}
+
+func TestIntegrationVisibility(t *testing.T) {
+	oldKeys := conf.ApiKeys
+	defer func() {
+		conf.ApiKeys = oldKeys
+		LoadAuth("")
+	}()
+	conf.ApiKeys = "k1"
+	if err := LoadAuth("upload=apikey;admin=apikey"); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("secret plans")
+	public := testUpload(t, "plans.txt", data)
+	private := testUploadQuery(t, "plans.txt", data, "visibility=private")
+	// 可见性不同的相同内容不复用已有文件
+	if private == public {
+		t.Fatalf("private upload reused public file %s", public)
+	}
+	if f, _ := store.Default().GetFile(public); f.Access() != store.VisibilityUnlisted {
+		t.Errorf("default visibility = %q", f.Access())
+	}
+	if w := testGet(public, ""); w.Code != http.StatusOK {
+		t.Errorf("unlisted download = %d", w.Code)
+	}
+	if w := testGet(private, ""); w.Code != http.StatusForbidden {
+		t.Errorf("private download without auth = %d", w.Code)
+	}
+	r := httptest.NewRequest(http.MethodGet, conf.FileRoute+private, nil)
+	r.Header.Set("X-Api-Key", "k1")
+	w := httptest.NewRecorder()
+	testRoutes().ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "private" {
+		t.Errorf("private download with key = %d %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+	signed := conf.FileRoute + private + "?" + signedQuery(private, time.Now().Add(time.Minute).Unix())
+	w = httptest.NewRecorder()
+	testRoutes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, signed, nil))
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), data) {
+		t.Errorf("signed private download = %d", w.Code)
+	}
+}
+
+func TestIntegrationIfNew(t *testing.T) {
+	data := []byte("nightly backup")
+	id := testUploadQuery(t, "backup.tar", data, "if-new=1")
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("image", "backup-2.tar")
+	fw.Write(data)
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/upload?if-new=1", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	UploadImageAPI(w, r)
+	var res conf.ApiError
+	json.Unmarshal(w.Body.Bytes(), &res)
+	details, _ := res.Details.(map[string]interface{})
+	if w.Code != http.StatusConflict || details["path"] != conf.FileRoute+id {
+		t.Fatalf("duplicate with if-new = %d %s", w.Code, w.Body.String())
+	}
+	// 未指定 if-new 时仍复用已有文件
+	if again := testUpload(t, "backup-3.tar", data); again != id {
+		t.Errorf("duplicate upload returned %q, want %q", again, id)
+	}
+}
+
+func TestIntegrationAppendLog(t *testing.T) {
+	appendLog := func(name, data string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, AppendRoute+name, strings.NewReader(data))
+		w := httptest.NewRecorder()
+		AppendLog(w, withPathValues(r, map[string]string{"name": name}))
+		return w
+	}
+	for _, line := range []string{"first line\n", "second line\n"} {
+		if w := appendLog("app-log", line); w.Code != http.StatusOK {
+			t.Fatalf("append = %d %s", w.Code, w.Body.String())
+		}
+	}
+	w := testGet("app-log", "")
+	if w.Code != http.StatusOK || w.Body.String() != "first line\nsecond line\n" {
+		t.Fatalf("log download = %d %q", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	// 按已读取的长度只获取新增的内容
+	if w := testGet("app-log", "bytes=11-"); w.Code != http.StatusPartialContent || w.Body.String() != "second line\n" {
+		t.Errorf("log range = %d %q", w.Code, w.Body.String())
+	}
+	id := testUpload(t, "existing.txt", []byte("not a log"))
+	if w := appendLog(id, "x"); w.Code != http.StatusBadRequest {
+		t.Errorf("append to uploaded file = %d", w.Code)
+	}
+	if w := appendLog("empty-log", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("empty append = %d", w.Code)
+	}
+}
+
+func TestIntegrationNamedFile(t *testing.T) {
+	put := func(slug, data, query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, NamedFileRoute+slug+"?"+query, strings.NewReader(data))
+		w := httptest.NewRecorder()
+		PutNamedFile(w, withPathValues(r, map[string]string{"slug": slug}))
+		return w
+	}
+	for i, data := range []string{"release 1", "release 2", "release 2"} {
+		w := put("latest", data, "name=latest.zip")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"message":"/s/latest"`) {
+			t.Fatalf("put %d = %d %s", i, w.Code, w.Body.String())
+		}
+	}
+	l, _ := store.Default().GetLink("latest")
+	cur, _ := l.Current()
+	// 相同内容不产生新版本
+	if len(l.Versions) != 2 || cur.Version != 2 || l.Target != conf.FileRoute+cur.ID {
+		t.Fatalf("link = %+v", l)
+	}
+	if w := testGet(cur.ID, ""); w.Body.String() != "release 2" {
+		t.Errorf("current content = %q", w.Body.String())
+	}
+	if w := put("latest", "release 3", "delete=1"); w.Code != http.StatusOK || w.Header().Get("X-Version") != "3" {
+		t.Fatalf("put with delete = %d %s", w.Code, w.Body.String())
+	}
+	if l, _ := store.Default().GetLink("latest"); len(l.Versions) != 1 {
+		t.Errorf("versions after delete = %+v", l.Versions)
+	}
+	store.Default().PutLink(store.Link{Slug: "plain", Target: "https://example.com"})
+	if w := put("plain", "x", ""); w.Code != http.StatusConflict {
+		t.Errorf("overwrite short link = %d", w.Code)
+	}
+}
+
+func TestIntegrationVersions(t *testing.T) {
+	for _, data := range []string{"config v1", "config v2"} {
+		r := httptest.NewRequest(http.MethodPut, NamedFileRoute+"config", strings.NewReader(data))
+		w := httptest.NewRecorder()
+		PutNamedFile(w, withPathValues(r, map[string]string{"slug": "config"}))
+		if w.Code != http.StatusOK {
+			t.Fatalf("put = %d %s", w.Code, w.Body.String())
+		}
+	}
+	if w := testGet("config@1", ""); w.Code != http.StatusOK || w.Body.String() != "config v1" {
+		t.Errorf("version 1 = %d %q", w.Code, w.Body.String())
+	}
+	if w := testGet("config@9", ""); w.Code != http.StatusNotFound {
+		t.Errorf("missing version = %d", w.Code)
+	}
+	r := httptest.NewRequest(http.MethodPost, NamedFileRoute+"config/rollback", strings.NewReader("version=1"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	RollbackNamedFile(w, withPathValues(r, map[string]string{"slug": "config"}))
+	var res conf.VersionsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Current != 3 || len(res.Versions) != 3 {
+		t.Fatalf("rollback = %d %s", w.Code, w.Body.String())
+	}
+	if res.Versions[2].ID != res.Versions[0].ID || !strings.HasSuffix(res.Versions[0].Url, "/d/config@1") {
+		t.Errorf("versions = %+v", res.Versions)
+	}
+	if l, _ := store.Default().GetLink("config"); l.Target != conf.FileRoute+res.Versions[0].ID {
+		t.Errorf("target after rollback = %q", l.Target)
+	}
+	r = httptest.NewRequest(http.MethodPost, NamedFileRoute+"config/rollback", strings.NewReader(`{"version":7}`))
+	r.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	RollbackNamedFile(w, withPathValues(r, map[string]string{"slug": "config"}))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("rollback to missing version = %d", w.Code)
+	}
+}
+
+func TestIntegrationIfMatch(t *testing.T) {
+	put := func(data string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPut, NamedFileRoute+"shared", strings.NewReader(data))
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		PutNamedFile(w, withPathValues(r, map[string]string{"slug": "shared"}))
+		return w
+	}
+	// If-Match 要求命名文件已存在
+	if w := put("draft", "If-Match", "*"); w.Code != http.StatusPreconditionFailed {
+		t.Fatalf("If-Match on missing file = %d", w.Code)
+	}
+	w := put("draft", "If-None-Match", "*")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("create = %d %s", w.Code, w.Body.String())
+	}
+	if w := put("again", "If-None-Match", "*"); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("If-None-Match on existing file = %d", w.Code)
+	}
+	w = put("writer a", "If-Match", etag)
+	if w.Code != http.StatusOK || w.Header().Get("ETag") == etag {
+		t.Fatalf("conditional put = %d %s", w.Code, w.Body.String())
+	}
+	// 第二个写入者仍使用旧的 ETag
+	if w := put("writer b", "If-Match", etag); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale If-Match = %d %s", w.Code, w.Body.String())
+	}
+	if l, _ := store.Default().GetLink("shared"); len(l.Versions) != 2 {
+		t.Errorf("versions = %+v", l.Versions)
+	}
+}
+
+func TestIntegrationSite(t *testing.T) {
+	// 相同内容之前以其他文件名上传过
+	testUpload(t, "style.txt", []byte("p{color:red}"))
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"dist/index.html":      "<h1>home</h1>",
+		"dist/css/app.css":     "body{margin:0}",
+		"dist/css/p.css":       "p{color:red}",
+		"dist/docs/index.html": "<h1>docs</h1>",
+	} {
+		fw, _ := zw.Create(name)
+		fw.Write([]byte(content))
+	}
+	zw.Close()
+	r := httptest.NewRequest(http.MethodPost, SiteApiRoute+"demo", &buf)
+	w := httptest.NewRecorder()
+	DeploySite(w, withPathValues(r, map[string]string{"name": "demo"}))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"message":"/site/demo/"`) {
+		t.Fatalf("deploy = %d %s", w.Code, w.Body.String())
+	}
+	rt := NewRouter()
+	rt.Handle(SiteRoute+"{name}/{path...}", Site, http.MethodGet)
+	tests := []struct {
+		path, location, body, mime string
+		code                       int
+	}{
+		{"/site/demo", "/site/demo/", "", "", 301},
+		{"/site/demo/", "", "<h1>home</h1>", "text/html", 200},
+		{"/site/demo/css/app.css", "", "body{margin:0}", "text/css", 200},
+		{"/site/demo/css/p.css", "", "p{color:red}", "text/css", 200},
+		{"/site/demo/docs", "/site/demo/docs/", "", "", 301},
+		{"/site/demo/docs/", "", "<h1>docs</h1>", "text/html", 200},
+		{"/site/demo/missing.js", "", "", "", 404},
+		{"/site/other/", "", "", "", 404},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.code || w.Header().Get("Location") != tt.location {
+			t.Errorf("%s = %d %q", tt.path, w.Code, w.Header().Get("Location"))
+			continue
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s body = %q", tt.path, w.Body.String())
+		}
+		if !strings.HasPrefix(w.Header().Get("Content-Type"), tt.mime) {
+			t.Errorf("%s Content-Type = %q", tt.path, w.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestIntegrationOCI(t *testing.T) {
+	rt := NewRouter()
+	rt.Handle(OCIRoute+"{path...}", OCI)
+	do := func(method, path, contentType string, body []byte) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, bytes.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		return w
+	}
+	digest := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+	if w := do(http.MethodGet, "/v2/", "", nil); w.Code != http.StatusOK || w.Header().Get("Docker-Distribution-API-Version") != "registry/2.0" {
+		t.Fatalf("base = %d %v", w.Code, w.Header())
+	}
+	// 分两次发送的镜像层
+	layer := randomBytes(t, 64<<10)
+	w := do(http.MethodPost, "/v2/library/hello/blobs/uploads/", "", nil)
+	loc := w.Header().Get("Location")
+	if w.Code != http.StatusAccepted || loc == "" {
+		t.Fatalf("start upload = %d %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPatch, loc, "application/octet-stream", layer[:1000]); w.Code != http.StatusAccepted || w.Header().Get("Range") != "0-999" {
+		t.Fatalf("patch = %d %q", w.Code, w.Header().Get("Range"))
+	}
+	if w := do(http.MethodPut, loc+"?digest="+digest(layer), "application/octet-stream", layer[1000:]); w.Code != http.StatusCreated {
+		t.Fatalf("finish upload = %d %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodGet, "/v2/library/hello/blobs/"+digest(layer), "", nil); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), layer) {
+		t.Errorf("get blob = %d, %d bytes", w.Code, w.Body.Len())
+	}
+	// 一次上传的配置
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	if w := do(http.MethodPost, "/v2/library/hello/blobs/uploads/?digest="+digest([]byte("other")), "", config); w.Code != http.StatusBadRequest {
+		t.Errorf("wrong digest = %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/v2/library/hello/blobs/uploads/?digest="+digest(config), "", config); w.Code != http.StatusCreated {
+		t.Fatalf("monolithic upload = %d %s", w.Code, w.Body.String())
+	}
+	manifest := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"digest":"` + digest(config) + `"},"layers":[{"digest":"` + digest(layer) + `"}]}`)
+	const mediaType = "application/vnd.oci.image.manifest.v1+json"
+	w = do(http.MethodPut, "/v2/library/hello/manifests/v1", mediaType, manifest)
+	if w.Code != http.StatusCreated || w.Header().Get("Docker-Content-Digest") != digest(manifest) {
+		t.Fatalf("put manifest = %d %s", w.Code, w.Body.String())
+	}
+	for _, ref := range []string{"v1", digest(manifest)} {
+		w := do(http.MethodGet, "/v2/library/hello/manifests/"+ref, "", nil)
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), manifest) || w.Header().Get("Content-Type") != mediaType {
+			t.Errorf("get manifest %s = %d %q", ref, w.Code, w.Header().Get("Content-Type"))
+		}
+	}
+	if w := do(http.MethodGet, "/v2/library/hello/manifests/v2", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("missing tag = %d", w.Code)
+	}
+}
+
+func TestIntegrationArtifact(t *testing.T) {
+	rt := NewRouter()
+	rt.Handle(ArtifactRoute+"{name}", ArtifactIndex, http.MethodGet)
+	rt.Handle(ArtifactRoute+"{name}/{version}", ArtifactIndex, http.MethodGet)
+	rt.Handle(ArtifactRoute+"{name}/{version}/{file}", Artifact, http.MethodGet)
+	rt.Handle(ArtifactRoute+"{name}/{version}/{file}", PublishArtifact, http.MethodPut)
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest(method, path, bytes.NewReader(body)))
+		return w
+	}
+	data := randomBytes(t, 1000)
+	sum := sha256.Sum256(data)
+	sha := hex.EncodeToString(sum[:])
+	if w := do(http.MethodPut, "/artifacts/tool/1.0.0/tool-linux-amd64.tar.gz", data); w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), sha) {
+		t.Fatalf("publish = %d %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPut, "/artifacts/tool/1.0.0/tool-linux-amd64.tar.gz", []byte("other")); w.Code != http.StatusConflict {
+		t.Errorf("republish = %d, want 409", w.Code)
+	}
+	if w := do(http.MethodPut, "/artifacts/tool/1.0.0/tool.sha256", []byte("x")); w.Code != http.StatusBadRequest {
+		t.Errorf("sidecar name = %d, want 400", w.Code)
+	}
+	w := do(http.MethodGet, "/artifacts/tool/1.0.0/tool-linux-amd64.tar.gz", nil)
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), data) {
+		t.Fatalf("get = %d", w.Code)
+	}
+	if cc := w.Header().Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+		t.Errorf("Cache-Control = %q", cc)
+	}
+	w = do(http.MethodGet, "/artifacts/tool/1.0.0/tool-linux-amd64.tar.gz.sha256", nil)
+	if want := sha + "  tool-linux-amd64.tar.gz\n"; w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("sidecar = %d %q", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodPut, "/artifacts/tool/1.1.0/tool-linux-amd64.tar.gz", []byte("v1.1")); w.Code != http.StatusCreated {
+		t.Fatalf("publish 1.1.0 = %d", w.Code)
+	}
+	var list []conf.Artifact
+	w = do(http.MethodGet, "/artifacts/tool/1.0.0", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != 1 || list[0].Sha256 != sha {
+		t.Errorf("version index = %d %s", w.Code, w.Body.String())
+	}
+	w = do(http.MethodGet, "/artifacts/tool", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list) != 2 {
+		t.Errorf("index = %d %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodGet, "/artifacts/tool/2.0.0/tool-linux-amd64.tar.gz", nil); w.Code != http.StatusNotFound {
+		t.Errorf("missing = %d", w.Code)
+	}
+}
+
+// testDeb 生成只包含 control 文件的 .deb
+func testDeb(t *testing.T, control string) []byte {
+	t.Helper()
+	var tgz bytes.Buffer
+	gz := gzip.NewWriter(&tgz)
+	tw := tar.NewWriter(gz)
+	tw.WriteHeader(&tar.Header{Name: "./control", Mode: 0644, Size: int64(len(control))})
+	tw.Write([]byte(control))
+	tw.Close()
+	gz.Close()
+	var b bytes.Buffer
+	b.WriteString("!<arch>\n")
+	for _, m := range []struct {
+		name string
+		data []byte
+	}{{"debian-binary", []byte("2.0\n")}, {"control.tar.gz", tgz.Bytes()}, {"data.tar.gz", []byte("x")}} {
+		fmt.Fprintf(&b, "%-16s%-12d%-6d%-6d%-8s%-10d`\n", m.name, 0, 0, 0, "100644", len(m.data))
+		b.Write(m.data)
+		if len(m.data)%2 == 1 {
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes()
+}
+
+// testRPMHeader 按 rpm 头部格式编码字符串及 int32 标签
+func testRPMHeader(strs map[uint32]string, ints map[uint32]int32) []byte {
+	var index, data bytes.Buffer
+	entry := func(tag, typ uint32, count uint32) {
+		binary.Write(&index, binary.BigEndian, []uint32{tag, typ, uint32(data.Len()), count})
+	}
+	for tag, v := range ints {
+		for data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+		entry(tag, rpmTypeInt32, 1)
+		binary.Write(&data, binary.BigEndian, v)
+	}
+	for tag, v := range strs {
+		entry(tag, rpmTypeString, 1)
+		data.WriteString(v + "\x00")
+	}
+	var b bytes.Buffer
+	b.Write(rpmHeaderMagic)
+	binary.Write(&b, binary.BigEndian, []uint32{0, uint32(index.Len() / 16), uint32(data.Len())})
+	b.Write(index.Bytes())
+	b.Write(data.Bytes())
+	return b.Bytes()
+}
+
+func TestIntegrationRepo(t *testing.T) {
+	deb := testDeb(t, "Package: hello\nVersion: 1.0-1\nArchitecture: amd64\nDescription: hello world\n")
+	sig := testRPMHeader(nil, map[uint32]int32{rpmSigTagPayloadSize: 10})
+	rpm := append(append([]byte{0xed, 0xab, 0xee, 0xdb}, make([]byte, 92)...), sig...)
+	for len(rpm)%8 != 0 {
+		rpm = append(rpm, 0)
+	}
+	start := len(rpm)
+	rpm = append(rpm, testRPMHeader(map[uint32]string{
+		rpmTagName: "hello", rpmTagVersion: "1.0", rpmTagRelease: "1", rpmTagArch: "x86_64",
+		rpmTagSummary: "hello world", rpmTagSourceRPM: "hello-1.0-1.src.rpm",
+	}, map[uint32]int32{rpmTagSize: 42})...)
+	end := len(rpm)
+	rpm = append(rpm, "payload"...)
+	album := store.Album{ID: "repotest", Name: "packages", Files: []store.AlbumFile{
+		{Path: "pool/hello_1.0-1_amd64.deb", ID: testUpload(t, "hello_1.0-1_amd64.deb", deb)},
+		{Path: "pool/hello-1.0-1.x86_64.rpm", ID: testUpload(t, "hello-1.0-1.x86_64.rpm", rpm)},
+		{Path: "README.txt", ID: testUpload(t, "README.txt", []byte("readme"))},
+	}}
+	if err := store.Default().PutAlbum(album); err != nil {
+		t.Fatal(err)
+	}
+	defer func(old string) { conf.RepoAlbum = old }(conf.RepoAlbum)
+	conf.RepoAlbum = album.ID
+	rt := NewRouter()
+	rt.Handle(RepoRoute+"{path...}", Repo, http.MethodGet)
+	get := func(p string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest(http.MethodGet, RepoRoute+p, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s = %d %s", p, w.Code, w.Body.String())
+		}
+		return w
+	}
+	debSum := sha256.Sum256(deb)
+	packages := get("Packages").Body.String()
+	for _, want := range []string{"Package: hello\n", "Filename: pool/hello_1.0-1_amd64.deb\n", "Size: " + strconv.Itoa(len(deb)) + "\n", "SHA256: " + hex.EncodeToString(debSum[:]) + "\n"} {
+		if !strings.Contains(packages, want) {
+			t.Errorf("Packages missing %q:\n%s", want, packages)
+		}
+	}
+	pkgSum := sha256.Sum256([]byte(packages))
+	release := get("Release").Body.String()
+	if !strings.Contains(release, " "+hex.EncodeToString(pkgSum[:])+" "+strconv.Itoa(len(packages))+" Packages\n") || !strings.Contains(release, "Architectures: amd64\n") {
+		t.Errorf("Release:\n%s", release)
+	}
+	if !strings.Contains(get("repodata/repomd.xml").Body.String(), `<location href="repodata/primary.xml.gz">`) {
+		t.Error("repomd.xml missing primary")
+	}
+	zr, err := gzip.NewReader(get("repodata/primary.xml.gz").Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var primary struct {
+		Packages []struct {
+			Name     string `xml:"name"`
+			Arch     string `xml:"arch"`
+			Location struct {
+				Href string `xml:"href,attr"`
+			} `xml:"location"`
+			Format struct {
+				HeaderRange struct {
+					Start int `xml:"start,attr"`
+					End   int `xml:"end,attr"`
+				} `xml:"header-range"`
+			} `xml:"format"`
+		} `xml:"package"`
+	}
+	if err := xml.NewDecoder(zr).Decode(&primary); err != nil {
+		t.Fatal(err)
+	}
+	if len(primary.Packages) != 1 {
+		t.Fatalf("primary = %+v", primary)
+	}
+	p := primary.Packages[0]
+	if p.Name != "hello" || p.Arch != "x86_64" || p.Location.Href != "pool/hello-1.0-1.x86_64.rpm" || p.Format.HeaderRange.Start != start || p.Format.HeaderRange.End != end {
+		t.Errorf("package = %+v, header range %d-%d", p, start, end)
+	}
+	if w := get("pool/hello_1.0-1_amd64.deb"); !bytes.Equal(w.Body.Bytes(), deb) {
+		t.Error("package content differs")
+	}
+}
+
+func TestIntegrationList(t *testing.T) {
+	data := []byte("listing content")
+	testUpload(t, "list me.txt", data)
+	listTree.Build(store.Default())
+	rt := NewRouter()
+	rt.Handle(ListRoute+"{path...}", List, http.MethodGet)
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+	w := do(http.MethodGet, "/list/")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<a href="./files/">files/</a>`) {
+		t.Fatalf("root = %d %s", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodGet, "/list/files"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/list/files/" {
+		t.Errorf("files = %d %q", w.Code, w.Header().Get("Location"))
+	}
+	w = do(http.MethodGet, "/list/files/")
+	if !strings.Contains(w.Body.String(), `<a href="./list%20me.txt">list me.txt</a>`) || !strings.Contains(w.Body.String(), "<td>"+strconv.Itoa(len(data))+"</td>") {
+		t.Fatalf("files/ = %s", w.Body.String())
+	}
+	w = do(http.MethodHead, "/list/files/list%20me.txt")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Length") != strconv.Itoa(len(data)) || w.Header().Get("Last-Modified") == "" || w.Body.Len() != 0 {
+		t.Errorf("HEAD = %d %v", w.Code, w.Header())
+	}
+	if w := do(http.MethodGet, "/list/files/list%20me.txt"); !bytes.Equal(w.Body.Bytes(), data) {
+		t.Errorf("GET = %d %q", w.Code, w.Body.String())
+	}
+	if w := do(http.MethodGet, "/list/files/missing.txt"); w.Code != http.StatusNotFound {
+		t.Errorf("missing = %d", w.Code)
+	}
+}
+
+func TestIntegrationFetchError(t *testing.T) {
+	id := testUpload(t, "gone.txt", []byte("soon deleted"))
+	tg.Remove(id)
+	getFileCache().cleanupFile(id)
+	getMemCache().remove(id)
+	w := testGet(id, "")
+	var res conf.ApiError
+	json.Unmarshal(w.Body.Bytes(), &res)
+	details, _ := res.Details.(map[string]interface{})
+	if w.Code != http.StatusGone || details["reason"] != utils.FetchDeleted || w.Header().Get("Retry-After") != "" {
+		t.Fatalf("GET = %d %v %s", w.Code, w.Header(), w.Body.String())
+	}
+	r := httptest.NewRequest(http.MethodGet, conf.FileRoute+id, nil)
+	r.Header.Set("Accept", "text/html")
+	w = httptest.NewRecorder()
+	testRoutes().ServeHTTP(w, r)
+	if w.Code != http.StatusGone || !strings.Contains(w.Body.String(), "<h1>文件在 Telegram 上已不存在</h1>") {
+		t.Errorf("page = %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestIntegrationRefreshFileID(t *testing.T) {
+	data := []byte("stale reference")
+	id := testUpload(t, "stale.txt", data)
+	tg.Expire(id)
+	getFileCache().cleanupFile(id)
+	getMemCache().remove(id)
+	utils.ForgetDownloadUrl(id)
+	if w := testGet(id, ""); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), data) {
+		t.Fatalf("GET = %d %s", w.Code, w.Body.String())
+	}
+	m, _ := store.Default().GetMessage(id)
+	if m.FileID == "" || m.FileID == id {
+		t.Fatalf("message = %+v", m)
+	}
+	// 之后直接使用新的文件ID，不再转发
+	forwards := tg.Hits("forwardMessage")
+	getFileCache().cleanupFile(id)
+	getMemCache().remove(id)
+	utils.ForgetDownloadUrl(id)
+	if w := testGet(id, ""); w.Code != http.StatusOK || tg.Hits("forwardMessage") != forwards {
+		t.Errorf("GET = %d, forwards %d -> %d", w.Code, forwards, tg.Hits("forwardMessage"))
+	}
+}
@@ -0,0 +1,52 @@
+package control
+
+import (
+	"net/http"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+)
+
+// LegacyPrefixes 解析 legacy 参数配置的旧地址路径前缀，如 /images/ 或 /images/2023/*
+func LegacyPrefixes() []string {
+	var prefixes []string
+	seen := map[string]bool{}
+	for _, p := range strings.Split(conf.LegacyPaths, ",") {
+		p = strings.TrimSuffix(strings.TrimSpace(p), "*")
+		if p == "" {
+			continue
+		}
+		if !strings.HasPrefix(p, "/") {
+			p = "/" + p
+		}
+		if !strings.HasSuffix(p, "/") {
+			p += "/"
+		}
+		// 不能覆盖首页
+		if p == "/" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		prefixes = append(prefixes, p)
+	}
+	return prefixes
+}
+
+// Legacy 按迁移对照表处理旧图床地址，默认 301 跳转到新地址，legacyproxy 时直接返回文件
+func Legacy(w http.ResponseWriter, r *http.Request) {
+	id, ok := store.Default().GetLegacy(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if conf.LegacyProxy {
+		serveFile(w, r, id)
+		return
+	}
+	target := conf.FileRoute + id
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
@@ -0,0 +1,70 @@
+package control
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"csz.net/tgstate/conf"
+)
+
+const (
+	// formOverhead multipart 边界及其他表单字段预留的大小
+	formOverhead = 1 << 20
+	// smallBodyLimit 短链接、配置等 JSON 接口的请求体上限
+	smallBodyLimit = 2 << 20
+	// imageModeLimit 非 p 模式下单个文件的大小上限
+	imageModeLimit = 20 * 1024 * 1024
+)
+
+// isBodyTooLarge 判断错误是否由 http.MaxBytesReader 超出限制引起
+func isBodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+// uploadLimit 当前单个上传文件的大小上限，0 为不限制
+func uploadLimit() int64 {
+	n := maxUploadSize()
+	if conf.Mode != "p" && (n == 0 || n > imageModeLimit) {
+		n = imageModeLimit
+	}
+	return n
+}
+
+// BodyLimit 限制请求体大小，n 不大于 0 时不限制
+func BodyLimit(n int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if n > 0 && r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, n)
+		}
+		next(w, r)
+	}
+}
+
+// SmallBody 限制 JSON 及表单接口的请求体大小
+func SmallBody(next http.HandlerFunc) http.HandlerFunc {
+	return BodyLimit(smallBodyLimit, next)
+}
+
+// UploadBody 按上传大小上限限制请求体，上限可在运行时修改，因此每次请求时读取
+func UploadBody(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := uploadLimit()
+		if n > 0 {
+			n += formOverhead
+		}
+		BodyLimit(n, next)(w, r)
+	}
+}
+
+// Timeout 限制处理时间，超时返回 503；响应会被缓冲，只用于返回 JSON 的短请求
+//
+// 超时后 next 仍会继续执行，因此不能用于上传到 Telegram 等有副作用的请求，
+// 否则客户端收到失败的同时操作却已生效
+func Timeout(next http.HandlerFunc) http.HandlerFunc {
+	if conf.ApiTimeout <= 0 {
+		return next
+	}
+	return http.TimeoutHandler(next, time.Duration(conf.ApiTimeout)*time.Second, "Request timeout").ServeHTTP
+}
@@ -0,0 +1,100 @@
+package control
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+	"csz.net/tgstate/vfs"
+)
+
+// ListRoute 目录列表路径，格式与 Web 服务器的目录索引相同，可作为 rclone 的 HTTP 远程同步全部文件，
+// 目录结构与 mount 挂载的一致
+const ListRoute = "/list/"
+
+// listTree 目录列表使用的目录树，定期按元数据刷新
+var listTree = vfs.NewTree(store.Default)
+
+// listEntry 目录列表中的一项
+type listEntry struct {
+	Name     string
+	Href     string
+	Dir      bool
+	Size     int64
+	Modified string
+}
+
+// listView 目录列表页面数据
+type listView struct {
+	Path    string
+	Parent  bool
+	Entries []listEntry
+}
+
+// List 列出目录或下载其中的文件，HEAD 请求直接按元数据返回大小及修改时间，
+// rclone 逐个查询文件信息时不必从 Telegram 下载
+func List(w http.ResponseWriter, r *http.Request) {
+	p := PathValue(r, "path")
+	n := listTree.Lookup(p)
+	if n == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if n.Dir {
+		// 目录以 / 结尾，列表中的相对地址才能正确解析
+		if p != "" && !strings.HasSuffix(p, "/") {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		data := listView{Path: ListRoute + p, Parent: p != ""}
+		for _, c := range n.Children {
+			if !c.Dir {
+				if status, _ := unavailable(r, c.ID); status != 0 {
+					continue
+				}
+			}
+			e := listEntry{Name: c.Name, Href: "./" + url.PathEscape(c.Name), Dir: c.Dir, Size: c.Size, Modified: "-"}
+			if c.Dir {
+				e.Name += "/"
+				e.Href += "/"
+			}
+			if c.Mtime > 0 {
+				e.Modified = time.Unix(c.Mtime, 0).UTC().Format("2006-01-02 15:04")
+			}
+			data.Entries = append(data.Entries, e)
+		}
+		tmpl, err := parseTemplates(pageLang(r), "list.tmpl")
+		if err != nil {
+			log.Printf("解析模板 list.tmpl 失败: %v", err)
+			http.Error(w, "Error parsing HTML template", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		tmpl.Execute(w, data)
+		return
+	}
+	if strings.HasSuffix(p, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if n.Mtime > 0 {
+		w.Header().Set("Last-Modified", time.Unix(n.Mtime, 0).UTC().Format(http.TimeFormat))
+	}
+	if r.Method == http.MethodHead && n.Size > 0 {
+		if status, _ := unavailable(r, n.ID); status != 0 {
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Content-Type", utils.TypeByName(n.Name))
+		w.Header().Set("Content-Length", strconv.FormatInt(n.Size, 10))
+		w.Header().Set("Accept-Ranges", "bytes")
+		return
+	}
+	serveFile(w, r, n.ID)
+}
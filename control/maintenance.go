@@ -0,0 +1,78 @@
+package control
+
+import (
+	"net/http"
+	"strings"
+
+	"csz.net/tgstate/i18n"
+)
+
+// 维护模式的作用范围
+const (
+	MaintenanceOff      = "off"
+	MaintenanceUpload   = "upload"
+	MaintenanceDownload = "download"
+	MaintenanceAll      = "all"
+)
+
+// maintenanceRetryAfter 维护期间建议客户端重试的间隔（秒）
+const maintenanceRetryAfter = "600"
+
+// ParseMaintenance 解析维护模式，兼容 true/false
+func ParseMaintenance(v string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "", "off", "false", "0":
+		return MaintenanceOff, true
+	case "upload", "uploads", "true", "1":
+		return MaintenanceUpload, true
+	case "download", "downloads":
+		return MaintenanceDownload, true
+	case "all":
+		return MaintenanceAll, true
+	}
+	return "", false
+}
+
+// inMaintenance 判断 scope（upload 或 download）当前是否处于维护中
+func inMaintenance(scope string) bool {
+	m := maintenanceMode()
+	return m == MaintenanceAll || m == scope
+}
+
+// Maintenance 维护中间件，scope 处于维护时返回 503，OPTIONS 预检始终放行
+func Maintenance(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions || !inMaintenance(scope) {
+			next(w, r)
+			return
+		}
+		// 上传接口的 GET 请求（如页面、任务状态）不受影响
+		if scope == MaintenanceUpload && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			next(w, r)
+			return
+		}
+		maintenancePage(w, r, scope)
+	}
+}
+
+// maintenancePage 浏览器返回维护页面，其余客户端返回 JSON
+func maintenancePage(w http.ResponseWriter, r *http.Request, scope string) {
+	msg := maintenanceMessage()
+	if msg == "" {
+		if scope == MaintenanceDownload {
+			msg = "Downloads are temporarily unavailable for maintenance"
+		} else {
+			msg = "Uploads are paused for maintenance"
+		}
+	}
+	w.Header().Set("Retry-After", maintenanceRetryAfter)
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		renderTemplate(w, r, "maintenance.tmpl", struct{ Message string }{i18n.T(pageLang(r), msg)})
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	errJson(w, r, http.StatusServiceUnavailable, msg)
+}
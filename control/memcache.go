@@ -0,0 +1,113 @@
+package control
+
+import (
+	"container/list"
+	"sync"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/metrics"
+)
+
+// 小于该大小的文件可以放入内存缓存
+const memCacheMaxFile = 1024 * 1024
+
+// memItem 内存缓存的文件内容
+type memItem struct {
+	id   string
+	data []byte
+}
+
+// memCache 按最近使用淘汰的内存缓存，热门的缩略图、头像等小文件无需读磁盘或请求 Telegram
+type memCache struct {
+	sync.Mutex
+	capacity int64
+	used     int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+var (
+	memCacheInst *memCache
+	memCacheOnce sync.Once
+)
+
+// getMemCache 获取内存缓存单例，容量为 0 时返回 nil
+func getMemCache() *memCache {
+	memCacheOnce.Do(func() {
+		if conf.MemCacheSize <= 0 {
+			return
+		}
+		memCacheInst = &memCache{capacity: conf.MemCacheSize, ll: list.New(), items: make(map[string]*list.Element)}
+		metrics.Help("tgstate_memcache_hits_total", "Downloads served from the in-memory cache")
+		metrics.Help("tgstate_memcache_misses_total", "Downloads of small files not found in the in-memory cache")
+		metrics.Gauge("tgstate_memcache_bytes", "Bytes held by the in-memory cache", func() int64 {
+			memCacheInst.Lock()
+			defer memCacheInst.Unlock()
+			return memCacheInst.used
+		})
+		metrics.Gauge("tgstate_memcache_items", "Files held by the in-memory cache", func() int64 {
+			memCacheInst.Lock()
+			defer memCacheInst.Unlock()
+			return int64(len(memCacheInst.items))
+		})
+	})
+	return memCacheInst
+}
+
+// get 读取缓存的文件内容并记录命中情况
+func (c *memCache) get(id string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.items[id]; ok {
+		c.ll.MoveToFront(e)
+		metrics.Inc("tgstate_memcache_hits_total")
+		return e.Value.(*memItem).data, true
+	}
+	return nil, false
+}
+
+// miss 记录一次小文件未命中
+func (c *memCache) miss() {
+	if c != nil {
+		metrics.Inc("tgstate_memcache_misses_total")
+	}
+}
+
+// put 放入文件内容，超出容量时淘汰最久未使用的文件
+func (c *memCache) put(id string, data []byte) {
+	if c == nil || len(data) > memCacheMaxFile || int64(len(data)) > c.capacity {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.items[id]; ok {
+		c.used -= int64(len(e.Value.(*memItem).data))
+		c.ll.Remove(e)
+	}
+	c.items[id] = c.ll.PushFront(&memItem{id: id, data: data})
+	c.used += int64(len(data))
+	for c.used > c.capacity {
+		e := c.ll.Back()
+		it := e.Value.(*memItem)
+		c.ll.Remove(e)
+		delete(c.items, it.id)
+		c.used -= int64(len(it.data))
+	}
+}
+
+// remove 移除文件，文件被删除或清除缓存时调用
+func (c *memCache) remove(id string) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.items[id]; ok {
+		c.used -= int64(len(e.Value.(*memItem).data))
+		c.ll.Remove(e)
+		delete(c.items, id)
+	}
+}
@@ -0,0 +1,334 @@
+package control
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// MigrateRoute 从其他图床迁移文件的接口路径
+const MigrateRoute = "/api/migrate"
+
+// migrateMaxUrls 单次请求最多迁移的地址数
+const migrateMaxUrls = 500
+
+// migrateMaxRedirects 下载待迁移文件时最多跟随的重定向次数
+const migrateMaxRedirects = 5
+
+// migrateClient 下载待迁移文件，大文件下载较慢，超时设置得比较宽松
+//
+// 地址由调用方提供，连接时检查解析后的 IP（包括重定向后的地址），
+// 未开启 migrateprivate 时拒绝内网、回环及链路本地地址，避免被用来访问内部服务
+var migrateClient = &http.Client{
+	Timeout: 10 * time.Minute,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   migrateDialControl,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+		IdleConnTimeout:       90 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= migrateMaxRedirects {
+			return errMigrateRedirect
+		}
+		return nil
+	},
+}
+
+// migrateDialControl 在建立连接前检查目标地址
+func migrateDialControl(network, address string, c syscall.RawConn) error {
+	if conf.MigratePrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !publicIP(ip) {
+		return errMigratePrivate
+	}
+	return nil
+}
+
+// publicIP 判断是否为公网地址
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+var (
+	errMigrateScheme = errors.New("only http and https urls are supported")
+	errMigrateExt    = errors.New("file type not allowed")
+	errMigrateSize   = errors.New("file size exceeds limit")
+	errMigrateUpload = errors.New("upload failed")
+
+	errMigratePrivate  = errors.New("private address not allowed")
+	errMigrateRedirect = errors.New("too many redirects")
+)
+
+// migrateEntry 单个地址的迁移结果
+type migrateEntry struct {
+	Old   string `json:"old"`
+	New   string `json:"new,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// migrateResult 迁移接口的返回结果
+type migrateResult struct {
+	Code    int            `json:"code"`
+	Message string         `json:"message"`
+	Files   []migrateEntry `json:"files"`
+}
+
+// migrateUrl 下载地址指向的文件并上传到 Telegram，内容已存在时直接返回已有文件，并记录旧地址的对照
+func migrateUrl(ctx context.Context, src string) (conf.UploadResponse, error) {
+	u, err := url.Parse(src)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return conf.UploadResponse{}, errMigrateScheme
+	}
+	res, err := fetchUpload(ctx, u, "")
+	if err != nil {
+		return res, err
+	}
+	recordLegacy(u, res)
+	return res, nil
+}
+
+// fetchUpload 下载地址指向的文件并上传到 Telegram，name 为空时按响应头或地址确定文件名
+func fetchUpload(ctx context.Context, u *url.URL, name string) (conf.UploadResponse, error) {
+	src := u.String()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return conf.UploadResponse{}, err
+	}
+	resp, err := migrateClient.Do(req)
+	if err != nil {
+		return conf.UploadResponse{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return conf.UploadResponse{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if name == "" {
+		name = migrateFileName(u, resp.Header.Get("Content-Disposition"))
+	}
+	if allowedExts := uploadExts(); allowedExts != "" && !extAllowed(name, allowedExts) {
+		return conf.UploadResponse{}, errMigrateExt
+	}
+	limit := uploadLimit()
+	if limit > 0 && resp.ContentLength > limit {
+		return conf.UploadResponse{}, errMigrateSize
+	}
+	// 先下载到临时文件计算哈希，重复的文件无需再次上传
+	tmp, err := os.CreateTemp("", "tgstate-migrate-*")
+	if err != nil {
+		return conf.UploadResponse{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	file := &countingReader{r: resp.Body, limit: limit}
+	if _, err := io.Copy(tmp, file); err != nil {
+		if file.exceeded {
+			return conf.UploadResponse{}, errMigrateSize
+		}
+		return conf.UploadResponse{}, err
+	}
+	sha := file.sum()
+	if res, ok := dedupResult(sha, "", "", ""); ok {
+		return res, nil
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return conf.UploadResponse{}, err
+	}
+	job := utils.NewUploadJob(conf.ChannelName, name, tmp)
+	job.Ctx = ctx
+	if err := utils.SubmitUpload(job); err != nil {
+		return conf.UploadResponse{}, err
+	}
+	select {
+	case <-job.Done():
+	case <-ctx.Done():
+		if job.Cancel() {
+			return conf.UploadResponse{}, ctx.Err()
+		}
+		<-job.Done()
+	}
+	res := uploadResult(store.File{ID: job.FileID, Name: name, Size: file.n, Sha256: sha}, "")
+	if res.Code != 1 {
+		return res, errMigrateUpload
+	}
+	return res, nil
+}
+
+// recordLegacy 记录旧地址路径与新文件的对照，供 legacy 路径前缀跳转使用
+func recordLegacy(u *url.URL, res conf.UploadResponse) {
+	id := strings.TrimPrefix(res.Message, conf.FileRoute)
+	if u.Path == "" || id == "" {
+		return
+	}
+	if err := store.Default().PutLegacy(u.Path, id); err != nil {
+		log.Printf("保存迁移对照失败: %v", err)
+	}
+}
+
+// migrateFileName 优先使用 Content-Disposition 中的文件名，否则取地址路径的最后一段
+func migrateFileName(u *url.URL, disposition string) string {
+	if _, params, err := mime.ParseMediaType(disposition); err == nil && params["filename"] != "" {
+		return path.Base(params["filename"])
+	}
+	if name := path.Base(u.Path); name != "." && name != "/" {
+		return name
+	}
+	return "file"
+}
+
+// readUrlList 读取每行一个的地址列表，忽略空行及 # 开头的注释
+func readUrlList(r io.Reader) ([]string, error) {
+	var urls []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, sc.Err()
+}
+
+// MigrateList 迁移列表文件中的所有地址，并将 “旧地址<Tab>新地址” 追加写入 out
+//
+// out 中已有的地址会被跳过，中断后可重复执行
+func MigrateList(listPath, out string) (int, error) {
+	f, err := os.Open(listPath)
+	if err != nil {
+		return 0, err
+	}
+	urls, err := readUrlList(f)
+	f.Close()
+	if err != nil {
+		return 0, err
+	}
+	done := make(map[string]bool)
+	if prev, err := os.Open(out); err == nil {
+		sc := bufio.NewScanner(prev)
+		for sc.Scan() {
+			if i := strings.IndexByte(sc.Text(), '\t'); i > 0 {
+				done[sc.Text()[:i]] = true
+			}
+		}
+		prev.Close()
+	}
+	w, err := os.OpenFile(out, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer w.Close()
+	count := 0
+	for _, src := range urls {
+		if done[src] {
+			continue
+		}
+		res, err := migrateUrl(context.Background(), src)
+		if err != nil {
+			log.Printf("迁移 %s 失败: %v", src, err)
+			continue
+		}
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", src, res.ImgUrl); err != nil {
+			return count, err
+		}
+		done[src] = true
+		count++
+		log.Printf("已迁移 %s -> %s", src, res.ImgUrl)
+	}
+	return count, nil
+}
+
+// Migrate 迁移请求中的地址，请求体为每行一个地址的文本或 {"urls": [...]}，返回新旧地址对照
+//
+// format=tsv 时以 “旧地址<Tab>新地址” 的文本格式返回成功的条目
+func Migrate(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	var urls []string
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var req struct {
+			Urls []string `json:"urls"`
+		}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		urls = req.Urls
+	} else {
+		urls, err = readUrlList(r.Body)
+	}
+	if err != nil {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	if len(urls) == 0 {
+		errJsonMsg(w, r, http.StatusBadRequest, "No urls to migrate")
+		return
+	}
+	if len(urls) > migrateMaxUrls {
+		errJsonMsg(w, r, http.StatusBadRequest, tr(r, "At most %d urls per request", migrateMaxUrls))
+		return
+	}
+	base := publicBaseUrl(r)
+	res := migrateResult{Files: []migrateEntry{}}
+	migrated := 0
+	for _, src := range urls {
+		entry := migrateEntry{Old: strings.TrimSpace(src)}
+		up, err := migrateUrl(r.Context(), entry.Old)
+		if err != nil {
+			entry.Error = err.Error()
+		} else {
+			entry.New = base + up.Message
+			migrated++
+		}
+		res.Files = append(res.Files, entry)
+		if r.Context().Err() != nil {
+			return
+		}
+	}
+	if r.URL.Query().Get("format") == "tsv" {
+		w.Header().Set("Content-Type", "text/tab-separated-values; charset=utf-8")
+		for _, f := range res.Files {
+			if f.New != "" {
+				fmt.Fprintf(w, "%s\t%s\n", f.Old, f.New)
+			}
+		}
+		return
+	}
+	if migrated > 0 {
+		res.Code = 1
+	}
+	res.Message = tr(r, "%d of %d files migrated", migrated, len(res.Files))
+	writeJson(w, http.StatusOK, res)
+}
@@ -0,0 +1,259 @@
+package control
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/i18n"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// ModerationRoute 待审核文件列表接口路径
+const ModerationRoute = "/api/admin/moderation"
+
+var errNotPending = errors.New("file is not pending moderation")
+
+type anonCtxKey struct{}
+
+// AnonAuth 开启匿名上传时，未携带凭据且未通过认证的请求也放行并标记为匿名，否则与 Auth 相同
+func AnonAuth(group string, next http.HandlerFunc) http.HandlerFunc {
+	auth := Auth(group, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if method, _, _ := presentedCredential(r); !conf.AnonUpload || method != "" || getAuthChain(group).allow(r) {
+			auth(w, r)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), anonCtxKey{}, true)))
+	}
+}
+
+// isAnonymous 请求是否为未登录的匿名上传
+func isAnonymous(r *http.Request) bool {
+	anon, _ := r.Context().Value(anonCtxKey{}).(bool)
+	return anon
+}
+
+// isPending 文件是否在等待审核
+func isPending(id string) bool {
+	f, ok := store.Default().GetFile(strings.TrimPrefix(id, "blob-"))
+	return ok && f.Pending
+}
+
+// checkAvailable 回收站中的文件返回 410，待审核的文件只允许管理员访问，私有文件需要认证，已写出错误响应时返回 false
+func checkAvailable(w http.ResponseWriter, r *http.Request, id string) bool {
+	if status, msg := unavailable(r, id); status != 0 {
+		http.Error(w, msg, status)
+		return false
+	}
+	if isPrivate(id) {
+		// 私有文件不能由 CDN 等共享缓存保存
+		w.Header().Set("Cache-Control", "private")
+	}
+	return true
+}
+
+// unavailable 返回请求不能访问文件的状态码及原因，可以访问时状态码为 0
+func unavailable(r *http.Request, id string) (int, string) {
+	switch {
+	case isTrashed(id):
+		return http.StatusGone, "File deleted"
+	case isPending(id) && !getAuthChain(AuthAdmin).allow(r):
+		return http.StatusForbidden, "File pending moderation"
+	case isPrivate(id) && !canReadPrivate(r, id):
+		return http.StatusForbidden, "File is private"
+	}
+	return 0, ""
+}
+
+// modKey 按钮回调数据中的文件标识，Telegram 限制回调数据不超过 64 字节，文件ID可能超过该长度
+func modKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// pendingFiles 列出待审核的文件，新的在前
+func pendingFiles() []store.File {
+	var files []store.File
+	for _, f := range store.Default().Files() {
+		if f.Pending && f.DeletedAt == 0 {
+			files = append(files, f)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].CreatedAt != files[j].CreatedAt {
+			return files[i].CreatedAt > files[j].CreatedAt
+		}
+		return files[i].ID < files[j].ID
+	})
+	return files
+}
+
+// notifyUpload 将新上传的文件发送到 modchat 审核会话，附带通过、拒绝及删除按钮；
+// 未配置 modchat 时待审核的匿名上传发送到 adminchat，只附带通过及拒绝按钮
+func notifyUpload(f store.File, link string) {
+	chat := conf.ModChat
+	if chat == "" && f.Pending {
+		chat = conf.AdminChat
+	}
+	if chat == "" {
+		return
+	}
+	// 通知使用默认语言，与上传者的语言无关
+	t := func(msg string) string { return i18n.T(i18n.Default, msg) }
+	title := t("New upload")
+	if f.Pending {
+		title = t("New anonymous upload waiting for moderation")
+	}
+	text := title + "\n" + f.Name + " (" + humanSize(f.Size) + ")\n"
+	if f.Tenant != "" {
+		text += t("Tenant") + ": " + f.Tenant + "\n"
+	}
+	text += strings.TrimSuffix(conf.BaseUrl, "/") + link
+	key := modKey(f.ID)
+	buttons := []utils.Button{
+		{Text: "✅ " + t("Approve"), Data: "approve:" + key},
+		{Text: "❌ " + t("Reject"), Data: "reject:" + key},
+	}
+	if conf.ModChat != "" {
+		buttons = append(buttons, utils.Button{Text: "🗑 " + t("Delete"), Data: "delete:" + key})
+	}
+	if err := utils.SendChat(chat, text, buttons...); err != nil {
+		log.Printf("发送审核通知失败: %v", err)
+	}
+}
+
+// approveFile 审核通过，文件可以公开下载
+func approveFile(id string) error {
+	st := store.Default()
+	f, ok := st.GetFile(id)
+	if !ok || !f.Pending {
+		return errNotPending
+	}
+	f.Pending = false
+	return st.PutFile(f)
+}
+
+// rejectFile 审核不通过，直接删除 Telegram 中的消息及文件记录
+func rejectFile(ctx context.Context, id string) error {
+	if !isPending(id) {
+		return errNotPending
+	}
+	if err := deleteFile(ctx, id); err != nil && !errors.Is(err, utils.ErrNoMessage) {
+		return err
+	}
+	return nil
+}
+
+// moderate 执行审核操作，action 为 approve 或 reject
+func moderate(ctx context.Context, action, id string) error {
+	switch action {
+	case "approve":
+		return approveFile(id)
+	case "reject":
+		return rejectFile(ctx, id)
+	}
+	return errNotPending
+}
+
+// buttonAction 执行审核按钮的操作：approve 通过（已公开的文件仅确认），
+// reject 彻底删除，delete 移入回收站（未启用回收站时彻底删除），返回显示在消息中的结果
+func buttonAction(ctx context.Context, action string, f store.File) (string, error) {
+	t := func(msg string) string { return i18n.T(i18n.Default, msg) }
+	switch action {
+	case "approve":
+		if f.Pending {
+			if err := approveFile(f.ID); err != nil {
+				return "", err
+			}
+		}
+		return "✅ " + t("Approved"), nil
+	case "reject":
+		if err := deleteFile(ctx, f.ID); err != nil && !errors.Is(err, utils.ErrNoMessage) {
+			return "", err
+		}
+		return "❌ " + t("Rejected"), nil
+	case "delete":
+		if conf.TrashDays > 0 {
+			if err := trashFile(f.ID); err != nil {
+				return "", err
+			}
+			return "🗑 " + t("Moved to trash"), nil
+		}
+		if err := deleteFile(ctx, f.ID); err != nil && !errors.Is(err, utils.ErrNoMessage) {
+			return "", err
+		}
+		return "🗑 " + t("Deleted"), nil
+	}
+	return "", errNotPending
+}
+
+// moderationCallback 处理审核会话中按钮的回调，回调数据中的文件标识为 modKey
+func moderationCallback(action string) utils.CallbackHandler {
+	return func(key string) string {
+		for _, f := range store.Default().Files() {
+			if f.DeletedAt > 0 || modKey(f.ID) != key {
+				continue
+			}
+			result, err := buttonAction(context.Background(), action, f)
+			if err != nil {
+				log.Printf("审核文件失败【%s】: %v", f.ID, err)
+				return "⚠️ " + err.Error()
+			}
+			store.Default().AddAudit(store.AuditEntry{Time: time.Now().Unix(), Action: action, Actor: "telegram", Target: f.ID, Detail: f.Name})
+			return result
+		}
+		return i18n.T(i18n.Default, "Already handled")
+	}
+}
+
+// StartModeration 注册 Telegram 审核按钮的回调
+func StartModeration() {
+	for _, action := range []string{"approve", "reject", "delete"} {
+		utils.HandleCallback(action, moderationCallback(action))
+	}
+}
+
+// Moderate 审核待审核的文件，action 为 approve 或 reject
+func Moderate(w http.ResponseWriter, r *http.Request, id, action string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	err := moderate(r.Context(), action, id)
+	switch {
+	case err == nil:
+		msg := "approved"
+		if action == "reject" {
+			msg = "rejected"
+		}
+		writeJson(w, http.StatusOK, conf.UploadResponse{Code: 1, Message: msg})
+	case errors.Is(err, errNotPending):
+		errJson(w, r, http.StatusNotFound, "File is not pending moderation")
+	default:
+		log.Printf("审核文件失败【%s】: %v", id, err)
+		errJson(w, r, http.StatusBadGateway, tr(r, "Failed to delete the Telegram message: %v", err))
+	}
+}
+
+// Moderation 列出待审核的文件
+func Moderation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	files := pendingFiles()
+	res := conf.SearchResponse{Total: len(files), Files: []conf.FileInfo{}}
+	for _, f := range files {
+		res.Files = append(res.Files, fileInfo(r, f))
+	}
+	writeJson(w, http.StatusOK, res)
+}
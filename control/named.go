@@ -0,0 +1,239 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// NamedFileRoute 可覆盖的命名文件接口路径，PUT /api/file/by-slug/{slug} 替换内容，/s/{slug} 始终跳转到当前版本，
+// /d/{slug}@{version} 下载历史版本，{slug}/versions 列出版本，{slug}/rollback 回滚
+const NamedFileRoute = FileApiRoute + "by-slug/"
+
+// PutNamedFile 以请求体替换命名文件的内容，新内容上传为新的 Telegram 消息；
+// name 参数指定下载时的文件名，默认为 slug；delete=1 时删除之前的版本。
+// ETag 为当前版本内容的 sha256，If-Match 不匹配或 If-None-Match: * 时已存在返回 412，避免并发写入互相覆盖
+func PutNamedFile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	slug := PathValue(r, "slug")
+	if !slugRe.MatchString(slug) {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid slug")
+		return
+	}
+	st := store.Default()
+	// 普通短链接不能被覆盖，上传前先检查以免白白上传
+	l, ok := st.GetLink(slug)
+	if ok && len(l.Versions) == 0 {
+		errJsonMsg(w, r, http.StatusConflict, "Slug already exists")
+		return
+	}
+	match := versionPrecondition(r)
+	if match != nil && !match(l.Current()) {
+		errJson(w, r, http.StatusPreconditionFailed, "File was modified by another upload")
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = slug
+	}
+	if len(name) > 255 || strings.ContainsAny(name, "/\\") {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid file name")
+		return
+	}
+	if exts := uploadExts(); exts != "" && !extAllowed(name, exts) {
+		writeError(w, r, http.StatusUnsupportedMediaType, http.StatusOK, tr(r, "Invalid file type. Only %s are allowed.", exts), map[string]string{"allowed": exts})
+		return
+	}
+	if r.ContentLength == 0 {
+		errJsonMsg(w, r, http.StatusBadRequest, "Content is empty")
+		return
+	}
+	maxSize := maxUploadSize()
+	if maxSize > 0 && r.ContentLength > maxSize {
+		writeError(w, r, http.StatusRequestEntityTooLarge, http.StatusOK, "File size exceeds limit", map[string]int64{"limit": maxSize})
+		return
+	}
+	want, ok := uploadChecksum(r)
+	if !ok {
+		invalidChecksum(w, r)
+		return
+	}
+	file := &countingReader{r: r.Body, limit: maxSize, want: want}
+	job := utils.NewUploadJob(conf.ChannelName, name, file)
+	job.Ctx = r.Context()
+	if err := utils.SubmitUpload(job); err != nil {
+		submitError(w, r, conf.ChannelName, err)
+		return
+	}
+	<-job.Done()
+	if file.mismatch {
+		checksumError(w, r, file)
+		return
+	}
+	if file.exceeded {
+		errJsonMsg(w, r, http.StatusRequestEntityTooLarge, "File size exceeds limit")
+		return
+	}
+	res := uploadResult(store.File{ID: job.FileID, Name: name, Size: file.n, Sha256: file.sum()}, "")
+	if res.Code != 1 {
+		errJsonMsg(w, r, http.StatusBadGateway, "Failed to upload to Telegram")
+		return
+	}
+	id := strings.TrimPrefix(res.Message, conf.FileRoute)
+	l, ok = st.GetLink(slug)
+	// 内容与当前版本相同时不产生新版本
+	if cur, exists := l.Current(); !ok || !exists || cur.ID != id {
+		var dropped []store.LinkVersion
+		var err error
+		// 上传期间其他请求可能已写入新版本，在保存时再次检查条件
+		l, dropped, err = st.PutVersion(slug, store.LinkVersion{ID: id, Size: file.n, Sha256: res.Sha256}, conf.FileRoute, r.URL.Query().Get("delete") != "1", match)
+		if errors.Is(err, store.ErrExists) {
+			errJsonMsg(w, r, http.StatusConflict, "Slug already exists")
+			return
+		}
+		if errors.Is(err, store.ErrModified) {
+			// 删除本次新上传的内容，复用的已有文件保留
+			if id == job.FileID {
+				go deleteVersion(slug, store.LinkVersion{ID: id})
+			}
+			errJson(w, r, http.StatusPreconditionFailed, "File was modified by another upload")
+			return
+		}
+		if err != nil {
+			log.Printf("保存命名文件失败: %v", err)
+			errJsonMsg(w, r, http.StatusInternalServerError, "error")
+			return
+		}
+		for _, v := range dropped {
+			if v.ID != id {
+				go deleteVersion(slug, v)
+			}
+		}
+	}
+	cur, _ := l.Current()
+	auditTarget(r, slug, id)
+	link := ShortRoute + slug
+	w.Header().Set("X-Version", strconv.Itoa(cur.Version))
+	w.Header().Set("ETag", versionETag(cur))
+	writeJson(w, http.StatusOK, conf.UploadResponse{
+		Code:    1,
+		Message: link,
+		ImgUrl:  publicBaseUrl(r) + link,
+		Sha256:  res.Sha256,
+		View:    publicBaseUrl(r) + conf.ViewRoute + id,
+	})
+}
+
+// versionETag 命名文件版本的 ETag，与下载该文件时的 ETag 一致
+func versionETag(v store.LinkVersion) string {
+	return `"` + v.Sha256 + `"`
+}
+
+// versionPrecondition 按 If-Match 及 If-None-Match 生成对当前版本的条件，请求没有条件时返回 nil
+func versionPrecondition(r *http.Request) func(cur store.LinkVersion, ok bool) bool {
+	im, inm := r.Header.Get("If-Match"), r.Header.Get("If-None-Match")
+	if im == "" && inm == "" {
+		return nil
+	}
+	return func(cur store.LinkVersion, ok bool) bool {
+		if im != "" && !(ok && etagMatches(im, versionETag(cur))) {
+			return false
+		}
+		return inm == "" || !ok || !etagMatches(inm, versionETag(cur))
+	}
+}
+
+// etagMatches 条件请求头中是否包含 etag 或 *，弱 ETag 不匹配
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		if t = strings.TrimSpace(t); t == etag || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteVersion 删除不再保留的版本
+func deleteVersion(slug string, v store.LinkVersion) {
+	if err := deleteFile(context.Background(), v.ID); err != nil {
+		log.Printf("删除命名文件 %s 的版本 %d 失败: %v", slug, v.Version, err)
+	}
+}
+
+// versionFileID 将 {slug}@{version} 解析为该版本的文件ID
+func versionFileID(id string) (string, bool) {
+	slug, ver, ok := strings.Cut(id, "@")
+	if !ok {
+		return "", false
+	}
+	n, err := strconv.Atoi(ver)
+	if err != nil {
+		return "", false
+	}
+	l, ok := store.Default().GetLink(slug)
+	if !ok {
+		return "", false
+	}
+	v, ok := l.Version(n)
+	return v.ID, ok
+}
+
+// NamedFileVersions 列出命名文件的各个版本
+func NamedFileVersions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	l, ok := store.Default().GetLink(PathValue(r, "slug"))
+	if !ok || len(l.Versions) == 0 {
+		errJson(w, r, http.StatusNotFound, "File not found")
+		return
+	}
+	cur, _ := l.Current()
+	w.Header().Set("ETag", versionETag(cur))
+	writeJson(w, http.StatusOK, versionsResponse(r, l))
+}
+
+// RollbackNamedFile 以指定版本的内容生成新的当前版本，之后的版本仍然保留
+func RollbackNamedFile(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	var req conf.RollbackRequest
+	if err := decodeRequest(r, &req); err != nil || req.Version <= 0 {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	slug := PathValue(r, "slug")
+	l, err := store.Default().RollbackVersion(slug, req.Version, conf.FileRoute)
+	if errors.Is(err, store.ErrNotFound) {
+		errJson(w, r, http.StatusNotFound, "Version not found")
+		return
+	}
+	if err != nil {
+		log.Printf("回滚命名文件失败: %v", err)
+		errJsonMsg(w, r, http.StatusInternalServerError, "error")
+		return
+	}
+	auditTarget(r, slug, strconv.Itoa(req.Version))
+	writeJson(w, http.StatusOK, versionsResponse(r, l))
+}
+
+// versionsResponse 生成命名文件的版本列表
+func versionsResponse(r *http.Request, l store.Link) conf.VersionsResponse {
+	cur, _ := l.Current()
+	res := conf.VersionsResponse{Slug: l.Slug, Current: cur.Version, Versions: make([]conf.FileVersion, 0, len(l.Versions))}
+	for _, v := range l.Versions {
+		res.Versions = append(res.Versions, conf.FileVersion{
+			Version:   v.Version,
+			ID:        v.ID,
+			Url:       publicBaseUrl(r) + conf.FileRoute + l.Slug + "@" + strconv.Itoa(v.Version),
+			Size:      v.Size,
+			Sha256:    v.Sha256,
+			CreatedAt: v.CreatedAt,
+		})
+	}
+	return res
+}
@@ -0,0 +1,72 @@
+package control
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"csz.net/tgstate/metrics"
+)
+
+func init() {
+	metrics.Help("tgstate_http_responses_total", "HTTP responses by status class")
+	metrics.Help("tgstate_http_sent_bytes_total", "Bytes written to HTTP response bodies")
+}
+
+// statusWriter 记录响应状态码及写入的字节数
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	n      int64
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	n, err := sw.ResponseWriter.Write(p)
+	sw.n += int64(n)
+	return n, err
+}
+
+// ReadFrom 保留底层连接的 sendfile 优化，下载缓存文件时不经过用户态拷贝
+func (sw *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	var n int64
+	var err error
+	if rf, ok := sw.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{sw.ResponseWriter}, r)
+	}
+	sw.n += n
+	return n, err
+}
+
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Observe 统计全部请求的响应状态及发送的字节数，供指标接口及告警使用
+func Observe(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		metrics.Inc(`tgstate_http_responses_total{code="` + strconv.Itoa(sw.status/100) + `xx"}`)
+		metrics.Add("tgstate_http_sent_bytes_total", sw.n)
+	})
+}
@@ -0,0 +1,438 @@
+package control
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// OCIRoute OCI 镜像仓库接口路径（实验功能），只实现 distribution 规范中拉取及推送所需的接口，
+// 镜像层按分块存储到 Telegram，按内容哈希去重，不支持列出标签及删除
+const OCIRoute = "/v2/"
+
+// ociManifestMaxSize 清单的最大字节数
+const ociManifestMaxSize = 4 << 20
+
+// ociUploadExpire 未完成的推送会话的保留时间
+const ociUploadExpire = 24 * time.Hour
+
+var (
+	ociPathRe   = regexp.MustCompile(`^(.+)/(blobs/uploads|blobs|manifests)(?:/(.*))?$`)
+	ociNameRe   = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	ociTagRe    = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+	ociDigestRe = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+)
+
+// ociError 按 distribution 规范的格式返回错误
+func ociError(w http.ResponseWriter, status int, code, msg string) {
+	writeJson(w, status, map[string][]map[string]string{"errors": {{"code": code, "message": msg}}})
+}
+
+// OCI 镜像仓库接口，拉取使用下载的认证，推送使用上传的认证
+func OCI(w http.ResponseWriter, r *http.Request) {
+	scope, group := MaintenanceDownload, AuthDownload
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		scope, group = MaintenanceUpload, AuthUpload
+	}
+	Maintenance(scope, ociAuth(group, serveOCI))(w, r)
+}
+
+// ociAuth docker 客户端只支持 Basic 认证，其中的密码可以是访问密码或接口密钥；未通过认证时按规范返回 401
+// 并提示使用 Basic 认证而不跳转到密码页面，客户端收到 401 后才会发送凭据
+func ociAuth(group string, next http.HandlerFunc) http.HandlerFunc {
+	h := Auth(group, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		chain := getAuthChain(group)
+		_, pass, basic := r.BasicAuth()
+		usePass := basic && chain.has("pass") && passEnabled()
+		if usePass && checkPass(pass, conf.Pass) {
+			if authLocked(r) {
+				lockedResponse(w)
+				return
+			}
+			authSucceeded(r, "pass", "")
+			next(w, r)
+			return
+		}
+		if basic && r.Header.Get("X-Api-Key") == "" {
+			r.Header.Set("X-Api-Key", pass)
+		}
+		if chain.allow(r) {
+			h(w, r)
+			return
+		}
+		// 错误的 Basic 密码同样计入失败次数
+		method, actor, _ := presentedCredential(r)
+		if !chain.has(method) && usePass {
+			method, actor = "pass", ""
+		}
+		if chain.has(method) {
+			if authLocked(r) {
+				lockedResponse(w)
+				return
+			}
+			authFailed(r, method, actor, "invalid credentials")
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="tgState"`)
+		ociError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+	}
+}
+
+// serveOCI 按路径分发到镜像层、推送会话及清单的处理，仓库名可以包含 /
+func serveOCI(w http.ResponseWriter, r *http.Request) {
+	p := PathValue(r, "path")
+	if p == "" {
+		writeJson(w, http.StatusOK, struct{}{})
+		return
+	}
+	m := ociPathRe.FindStringSubmatch(p)
+	if m == nil {
+		ociError(w, http.StatusNotFound, "UNSUPPORTED", "unsupported endpoint")
+		return
+	}
+	name, kind, ref := m[1], m[2], m[3]
+	if !ociNameRe.MatchString(name) {
+		ociError(w, http.StatusBadRequest, "NAME_INVALID", "invalid repository name")
+		return
+	}
+	switch kind {
+	case "blobs/uploads":
+		ociUpload(w, r, name, ref)
+	case "blobs":
+		ociBlob(w, r, ref)
+	default:
+		ociManifest(w, r, name, ref)
+	}
+}
+
+// ociBlobID 按摘要查找已存储的镜像层
+func ociBlobID(digest string) (string, bool) {
+	st := store.Default()
+	id, ok := st.GetHash(strings.TrimPrefix(digest, "sha256:"))
+	if !ok {
+		return "", false
+	}
+	f, ok := st.GetFile(id)
+	return id, ok && f.DeletedAt == 0 && !f.Pending
+}
+
+// ociBlob 下载镜像层，镜像层在所有仓库间共享
+func ociBlob(w http.ResponseWriter, r *http.Request, digest string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		ociError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "unsupported operation")
+		return
+	}
+	if !ociDigestRe.MatchString(digest) {
+		ociError(w, http.StatusBadRequest, "DIGEST_INVALID", "invalid digest")
+		return
+	}
+	id, ok := ociBlobID(digest)
+	if !ok {
+		ociError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+		return
+	}
+	w.Header().Set("Docker-Content-Digest", digest)
+	serveFile(w, r, id)
+}
+
+// ociUploadPath 推送会话的暂存文件
+func ociUploadPath(id string) string {
+	return filepath.Join(conf.DataDir, "oci", id)
+}
+
+// ociUpload 推送镜像层：POST 创建会话（带 digest 参数时一次上传完成，带 mount 参数时复用已有的镜像层），
+// PATCH 追加内容，PUT 追加剩余内容并按 digest 校验后存储，DELETE 取消
+func ociUpload(w http.ResponseWriter, r *http.Request, name, id string) {
+	if id == "" {
+		if r.Method != http.MethodPost {
+			ociError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "unsupported operation")
+			return
+		}
+		if digest := r.URL.Query().Get("mount"); ociDigestRe.MatchString(digest) {
+			if _, ok := ociBlobID(digest); ok {
+				ociBlobCreated(w, name, digest)
+				return
+			}
+		}
+		ociCleanup()
+		id = utils.RandString(16)
+		os.MkdirAll(filepath.Dir(ociUploadPath(id)), 0755)
+		if err := os.WriteFile(ociUploadPath(id), nil, 0644); err != nil {
+			log.Printf("创建推送会话失败: %v", err)
+			ociError(w, http.StatusInternalServerError, "UNKNOWN", "error")
+			return
+		}
+		if digest := r.URL.Query().Get("digest"); digest != "" {
+			ociFinish(w, r, name, id, digest)
+			return
+		}
+		ociUploadStatus(w, name, id, 0, http.StatusAccepted)
+		return
+	}
+	if len(id) != 16 || !utils.ValidFileID(id) {
+		ociError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "blob upload unknown to registry")
+		return
+	}
+	info, err := os.Stat(ociUploadPath(id))
+	if err != nil {
+		ociError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "blob upload unknown to registry")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		ociUploadStatus(w, name, id, info.Size(), http.StatusNoContent)
+	case http.MethodPatch:
+		size, err := ociAppend(id, r.Body)
+		if err != nil {
+			ociAppendError(w, id, err)
+			return
+		}
+		ociUploadStatus(w, name, id, size, http.StatusAccepted)
+	case http.MethodPut:
+		ociFinish(w, r, name, id, r.URL.Query().Get("digest"))
+	case http.MethodDelete:
+		os.Remove(ociUploadPath(id))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		ociError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "unsupported operation")
+	}
+}
+
+// errOCITooLarge 镜像层超过上传大小上限
+var errOCITooLarge = errors.New("blob exceeds size limit")
+
+// ociAppend 将内容追加到推送会话，返回追加后的大小，超过上传大小上限时返回 errOCITooLarge
+func ociAppend(id string, body io.Reader) (int64, error) {
+	f, err := os.OpenFile(ociUploadPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	limit := maxUploadSize()
+	if limit > 0 {
+		body = io.LimitReader(body, limit-info.Size()+1)
+	}
+	n, err := io.Copy(f, body)
+	if err != nil {
+		return 0, err
+	}
+	if limit > 0 && info.Size()+n > limit {
+		return 0, errOCITooLarge
+	}
+	return info.Size() + n, nil
+}
+
+// ociAppendError 追加内容失败时返回错误并结束推送会话
+func ociAppendError(w http.ResponseWriter, id string, err error) {
+	os.Remove(ociUploadPath(id))
+	if err == errOCITooLarge {
+		ociError(w, http.StatusRequestEntityTooLarge, "SIZE_INVALID", err.Error())
+		return
+	}
+	ociError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+}
+
+// ociUploadStatus 返回推送会话的地址及已接收的范围
+func ociUploadStatus(w http.ResponseWriter, name, id string, size int64, status int) {
+	end := size - 1
+	if end < 0 {
+		end = 0
+	}
+	w.Header().Set("Location", OCIRoute+name+"/blobs/uploads/"+id)
+	w.Header().Set("Docker-Upload-UUID", id)
+	w.Header().Set("Range", "0-"+strconv.FormatInt(end, 10))
+	if status != http.StatusNoContent {
+		w.Header().Set("Content-Length", "0")
+	}
+	w.WriteHeader(status)
+}
+
+// ociBlobCreated 镜像层已存储
+func ociBlobCreated(w http.ResponseWriter, name, digest string) {
+	w.Header().Set("Location", OCIRoute+name+"/blobs/"+digest)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+}
+
+// ociFinish 追加剩余内容，校验摘要后存储到 Telegram，内容已存在时不再上传
+func ociFinish(w http.ResponseWriter, r *http.Request, name, id, digest string) {
+	path := ociUploadPath(id)
+	defer os.Remove(path)
+	if !ociDigestRe.MatchString(digest) {
+		ociError(w, http.StatusBadRequest, "DIGEST_INVALID", "invalid digest")
+		return
+	}
+	size, err := ociAppend(id, r.Body)
+	if err != nil {
+		ociAppendError(w, id, err)
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		ociError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "blob upload unknown to registry")
+		return
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		ociError(w, http.StatusInternalServerError, "UNKNOWN", "error")
+		return
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if "sha256:"+sum != digest {
+		ociError(w, http.StatusBadRequest, "DIGEST_INVALID", "provided digest did not match uploaded content")
+		return
+	}
+	if _, ok := ociBlobID(digest); !ok {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			ociError(w, http.StatusInternalServerError, "UNKNOWN", "error")
+			return
+		}
+		if _, err := ociStore("sha256-"+sum, f, size, sum); err != nil {
+			log.Printf("存储镜像层失败: %v", err)
+			ociError(w, http.StatusBadGateway, "UNKNOWN", "failed to upload to Telegram")
+			return
+		}
+	}
+	ociBlobCreated(w, name, digest)
+}
+
+// ociStore 按分块上传到 Telegram 并登记
+func ociStore(name string, r io.Reader, size int64, sum string) (string, error) {
+	id, err := utils.UpBlob(name, r, size)
+	if err != nil {
+		return "", err
+	}
+	recordUpload(store.File{ID: id, Name: name, Size: size, Sha256: sum}, conf.FileRoute+id)
+	return id, nil
+}
+
+// ociCleanup 删除过期未完成的推送会话
+func ociCleanup() {
+	entries, err := os.ReadDir(filepath.Join(conf.DataDir, "oci"))
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if info, err := e.Info(); err == nil && time.Since(info.ModTime()) > ociUploadExpire {
+			os.Remove(ociUploadPath(e.Name()))
+		}
+	}
+}
+
+// ociManifest 推送或拉取清单，ref 为标签或摘要
+func ociManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	if !ociDigestRe.MatchString(ref) && !ociTagRe.MatchString(ref) {
+		ociError(w, http.StatusBadRequest, "MANIFEST_INVALID", "invalid reference")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		ociGetManifest(w, r, name, ref)
+	case http.MethodPut:
+		ociPutManifest(w, r, name, ref)
+	default:
+		ociError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "unsupported operation")
+	}
+}
+
+func ociGetManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	m, ok := store.Default().GetOCIManifest(name, ref)
+	if !ok {
+		ociError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+		return
+	}
+	content, err := openContent(r.Context(), m.ID)
+	if err != nil {
+		log.Printf("获取镜像清单失败: %v", err)
+		ociError(w, http.StatusBadGateway, "UNKNOWN", "failed to fetch manifest")
+		return
+	}
+	defer content.Close()
+	data, err := io.ReadAll(io.LimitReader(content, ociManifestMaxSize))
+	if err != nil {
+		ociError(w, http.StatusBadGateway, "UNKNOWN", "failed to fetch manifest")
+		return
+	}
+	w.Header().Set("Content-Type", m.MediaType)
+	w.Header().Set("Docker-Content-Digest", m.Digest)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("ETag", `"`+m.Digest+`"`)
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(data)
+}
+
+func ociPutManifest(w http.ResponseWriter, r *http.Request, name, ref string) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, ociManifestMaxSize+1))
+	if err != nil {
+		ociError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+		return
+	}
+	if len(data) > ociManifestMaxSize {
+		ociError(w, http.StatusRequestEntityTooLarge, "SIZE_INVALID", "manifest too large")
+		return
+	}
+	var doc struct {
+		MediaType string `json:"mediaType"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		ociError(w, http.StatusBadRequest, "MANIFEST_INVALID", "manifest invalid")
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType = doc.MediaType
+	}
+	if mediaType == "" {
+		mediaType = "application/vnd.oci.image.manifest.v1+json"
+	}
+	sum := sha256.Sum256(data)
+	hexSum := hex.EncodeToString(sum[:])
+	digest := "sha256:" + hexSum
+	if ociDigestRe.MatchString(ref) && ref != digest {
+		ociError(w, http.StatusBadRequest, "DIGEST_INVALID", "provided digest did not match uploaded content")
+		return
+	}
+	id, ok := ociBlobID(digest)
+	if !ok {
+		if id, err = ociStore("sha256-"+hexSum+".json", bytes.NewReader(data), int64(len(data)), hexSum); err != nil {
+			log.Printf("存储镜像清单失败: %v", err)
+			ociError(w, http.StatusBadGateway, "UNKNOWN", "failed to upload to Telegram")
+			return
+		}
+	}
+	err = store.Default().PutOCIManifest(store.OCIManifest{Repo: name, Reference: ref, Digest: digest, MediaType: mediaType, Size: int64(len(data)), ID: id})
+	if err != nil {
+		log.Printf("保存镜像清单失败: %v", err)
+		ociError(w, http.StatusInternalServerError, "UNKNOWN", "error")
+		return
+	}
+	w.Header().Set("Location", OCIRoute+name+"/manifests/"+digest)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusCreated)
+}
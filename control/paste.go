@@ -0,0 +1,140 @@
+package control
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// 单个粘贴内容的最大字节数
+const pasteMaxSize = 1 << 20
+
+// PasteRoute 粘贴内容访问路径
+const PasteRoute = "/p/"
+
+var pasteLangRe = regexp.MustCompile(`^[a-zA-Z0-9+#-]{1,32}$`)
+
+// pasteView 粘贴页面模板数据
+type pasteView struct {
+	ID      string
+	Content string
+	Lang    string
+	RawUrl  string
+}
+
+// PasteAPI 创建粘贴内容，存储为 Telegram 文档
+func PasteAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, pasteMaxSize+64*1024)
+	var req conf.PasteRequest
+	if err := decodeRequest(r, &req); err != nil {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	content := req.Content
+	if strings.TrimSpace(content) == "" {
+		errJsonMsg(w, r, http.StatusBadRequest, "Content is empty")
+		return
+	}
+	if len(content) > pasteMaxSize {
+		errJsonMsg(w, r, http.StatusRequestEntityTooLarge, "Content exceeds 1MB limit")
+		return
+	}
+	lang := req.Lang
+	if lang != "" && !pasteLangRe.MatchString(lang) {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid language")
+		return
+	}
+	name := "paste-" + time.Now().Format("20060102150405") + ".txt"
+	id, err := utils.UpDocument(utils.TgFileData(name, strings.NewReader(content)))
+	if err != nil {
+		log.Printf("上传粘贴内容失败: %v", err)
+		errJsonMsg(w, r, http.StatusBadGateway, "error")
+		return
+	}
+	link := PasteRoute + id
+	if lang != "" {
+		link += "?lang=" + url.QueryEscape(lang)
+	}
+	sum := sha256.Sum256([]byte(content))
+	recordUpload(store.File{ID: id, Name: name, Size: int64(len(content)), Sha256: hex.EncodeToString(sum[:])}, link)
+	auditTarget(r, id, name)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conf.UploadResponse{
+		Code:    1,
+		Message: link,
+		ImgUrl:  strings.TrimSuffix(conf.BaseUrl, "/") + link,
+	})
+}
+
+// PasteForm 粘贴内容创建页面
+func PasteForm(w http.ResponseWriter, r *http.Request) {
+	renderTemplate(w, r, "paste.tmpl", pageData{Csrf: sessionCsrf(r, "p")})
+}
+
+// Paste 展示粘贴内容的高亮页面，路由为 /p/{id}
+func Paste(w http.ResponseWriter, r *http.Request) {
+	servePaste(w, r, false)
+}
+
+// PasteRaw 以纯文本返回粘贴内容，路由为 /p/{id}/raw
+func PasteRaw(w http.ResponseWriter, r *http.Request) {
+	servePaste(w, r, true)
+}
+
+func servePaste(w http.ResponseWriter, r *http.Request, raw bool) {
+	id := PathValue(r, "id")
+	if !utils.ValidFileID(id) {
+		http.NotFound(w, r)
+		return
+	}
+	filePath, err := getFileCache().getCachedFile(r.Context(), id)
+	if err != nil {
+		log.Printf("获取粘贴内容失败: %v", err)
+		fetchError(w, r, err)
+		return
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		http.Error(w, "Failed to open file", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, pasteMaxSize))
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+	if raw {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Write(data)
+		return
+	}
+	lang := r.URL.Query().Get("lang")
+	if !pasteLangRe.MatchString(lang) {
+		lang = ""
+	}
+	renderTemplate(w, r, "pasteview.tmpl", pasteView{
+		ID:      id,
+		Content: string(data),
+		Lang:    lang,
+		RawUrl:  PasteRoute + id + "/raw",
+	})
+}
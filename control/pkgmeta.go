@@ -0,0 +1,441 @@
+package control
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// errNotPackage 文件不是可识别的 .deb 或 .rpm 软件包
+var errNotPackage = errors.New("不是有效的软件包")
+
+// debControlMax control 文件的最大长度
+const debControlMax = 1 << 20
+
+// debControl 从 .deb 的 ar 归档中读取 control 文件，支持 control.tar.gz 及未压缩的 control.tar，
+// xz 及 zstd 压缩的需以 dpkg-deb -Zgzip 重新打包
+func debControl(r io.Reader) (string, error) {
+	magic := make([]byte, 8)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "!<arch>\n" {
+		return "", errNotPackage
+	}
+	hdr := make([]byte, 60)
+	for {
+		if _, err := io.ReadFull(r, hdr); err != nil {
+			return "", errNotPackage
+		}
+		name := strings.TrimSuffix(strings.TrimSpace(string(hdr[:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(hdr[48:58])), 10, 64)
+		if err != nil || size < 0 {
+			return "", errNotPackage
+		}
+		if strings.HasPrefix(name, "control.tar") {
+			var tr io.Reader = io.LimitReader(r, size)
+			switch name {
+			case "control.tar":
+			case "control.tar.gz":
+				gz, err := gzip.NewReader(tr)
+				if err != nil {
+					return "", errNotPackage
+				}
+				tr = gz
+			default:
+				return "", fmt.Errorf("不支持 %s，请使用 gzip 压缩", name)
+			}
+			return tarControl(tr)
+		}
+		// ar 中的成员按偶数字节对齐
+		if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+			return "", errNotPackage
+		}
+	}
+}
+
+// tarControl 读取 control.tar 中的 control 文件
+func tarControl(r io.Reader) (string, error) {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			return "", errNotPackage
+		}
+		if path.Clean(h.Name) != "control" {
+			continue
+		}
+		b, err := io.ReadAll(io.LimitReader(tr, debControlMax))
+		if err != nil {
+			return "", errNotPackage
+		}
+		control := strings.TrimSpace(string(b))
+		if debField(control, "Package") == "" {
+			return "", errNotPackage
+		}
+		return control, nil
+	}
+}
+
+// debField 读取 control 中单行字段的值
+func debField(control, name string) string {
+	for _, line := range strings.Split(control, "\n") {
+		if k, v, ok := strings.Cut(line, ":"); ok && strings.EqualFold(k, name) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// rpm 头部中用到的标签
+const (
+	rpmTagName           = 1000
+	rpmTagVersion        = 1001
+	rpmTagRelease        = 1002
+	rpmTagEpoch          = 1003
+	rpmTagSummary        = 1004
+	rpmTagDescription    = 1005
+	rpmTagBuildTime      = 1006
+	rpmTagBuildHost      = 1007
+	rpmTagSize           = 1009
+	rpmTagVendor         = 1011
+	rpmTagLicense        = 1014
+	rpmTagPackager       = 1015
+	rpmTagGroup          = 1016
+	rpmTagUrl            = 1020
+	rpmTagArch           = 1022
+	rpmTagFileModes      = 1030
+	rpmTagSourceRPM      = 1044
+	rpmTagArchiveSize    = 1046
+	rpmTagProvideName    = 1047
+	rpmTagRequireFlags   = 1048
+	rpmTagRequireName    = 1049
+	rpmTagRequireVersion = 1050
+	rpmTagProvideFlags   = 1112
+	rpmTagProvideVersion = 1113
+	rpmTagDirIndexes     = 1116
+	rpmTagBaseNames      = 1117
+	rpmTagDirNames       = 1118
+	rpmTagLongSize       = 5009
+
+	rpmSigTagPayloadSize = 1007
+)
+
+// rpm 头部的数据类型
+const (
+	rpmTypeInt16       = 3
+	rpmTypeInt32       = 4
+	rpmTypeInt64       = 5
+	rpmTypeString      = 6
+	rpmTypeStringArray = 8
+	rpmTypeI18NString  = 9
+)
+
+var rpmHeaderMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+
+type rpmEntry struct {
+	typ, off, count uint32
+}
+
+// rpmHeader rpm 的签名或主头部
+type rpmHeader struct {
+	entries map[uint32]rpmEntry
+	data    []byte
+}
+
+// readRPMHeader 读取一个头部结构，返回头部及其占用的字节数
+func readRPMHeader(r io.Reader) (*rpmHeader, int64, error) {
+	pre := make([]byte, 16)
+	if _, err := io.ReadFull(r, pre); err != nil || !bytes.Equal(pre[:4], rpmHeaderMagic) {
+		return nil, 0, errNotPackage
+	}
+	n, size := binary.BigEndian.Uint32(pre[8:]), binary.BigEndian.Uint32(pre[12:])
+	if n > 1<<16 || size > 64<<20 {
+		return nil, 0, errNotPackage
+	}
+	index := make([]byte, 16*n)
+	if _, err := io.ReadFull(r, index); err != nil {
+		return nil, 0, errNotPackage
+	}
+	h := &rpmHeader{entries: make(map[uint32]rpmEntry, n), data: make([]byte, size)}
+	if _, err := io.ReadFull(r, h.data); err != nil {
+		return nil, 0, errNotPackage
+	}
+	for i := uint32(0); i < n; i++ {
+		e := index[16*i:]
+		h.entries[binary.BigEndian.Uint32(e)] = rpmEntry{binary.BigEndian.Uint32(e[4:]), binary.BigEndian.Uint32(e[8:]), binary.BigEndian.Uint32(e[12:])}
+	}
+	return h, 16 + 16*int64(n) + int64(size), nil
+}
+
+// strings 读取字符串类型的标签，多语言字符串只取第一个
+func (h *rpmHeader) strings(tag uint32) []string {
+	e, ok := h.entries[tag]
+	if !ok || (e.typ != rpmTypeString && e.typ != rpmTypeStringArray && e.typ != rpmTypeI18NString) || int64(e.off) >= int64(len(h.data)) {
+		return nil
+	}
+	b := h.data[e.off:]
+	list := make([]string, 0, e.count)
+	for i := uint32(0); i < e.count; i++ {
+		end := bytes.IndexByte(b, 0)
+		if end < 0 {
+			break
+		}
+		list = append(list, string(b[:end]))
+		b = b[end+1:]
+	}
+	return list
+}
+
+func (h *rpmHeader) str(tag uint32) string {
+	if l := h.strings(tag); len(l) > 0 {
+		return l[0]
+	}
+	return ""
+}
+
+// ints 读取整数类型的标签
+func (h *rpmHeader) ints(tag uint32) []int64 {
+	e, ok := h.entries[tag]
+	if !ok {
+		return nil
+	}
+	var size int64
+	switch e.typ {
+	case rpmTypeInt16:
+		size = 2
+	case rpmTypeInt32:
+		size = 4
+	case rpmTypeInt64:
+		size = 8
+	default:
+		return nil
+	}
+	if int64(e.off)+size*int64(e.count) > int64(len(h.data)) {
+		return nil
+	}
+	list := make([]int64, e.count)
+	for i := range list {
+		b := h.data[int64(e.off)+size*int64(i):]
+		switch size {
+		case 2:
+			list[i] = int64(binary.BigEndian.Uint16(b))
+		case 4:
+			list[i] = int64(binary.BigEndian.Uint32(b))
+		default:
+			list[i] = int64(binary.BigEndian.Uint64(b))
+		}
+	}
+	return list
+}
+
+func (h *rpmHeader) int(tag uint32) int64 {
+	if l := h.ints(tag); len(l) > 0 {
+		return l[0]
+	}
+	return 0
+}
+
+// rpmPackage primary.xml 中的软件包，字段与 createrepo 生成的一致
+type rpmPackage struct {
+	XMLName     xml.Name    `xml:"package"`
+	Type        string      `xml:"type,attr"`
+	Name        string      `xml:"name"`
+	Arch        string      `xml:"arch"`
+	Version     rpmVersion  `xml:"version"`
+	Checksum    rpmChecksum `xml:"checksum"`
+	Summary     string      `xml:"summary"`
+	Description string      `xml:"description"`
+	Packager    string      `xml:"packager"`
+	Url         string      `xml:"url"`
+	Time        struct {
+		File  int64 `xml:"file,attr"`
+		Build int64 `xml:"build,attr"`
+	} `xml:"time"`
+	Size struct {
+		Package   int64 `xml:"package,attr"`
+		Installed int64 `xml:"installed,attr"`
+		Archive   int64 `xml:"archive,attr"`
+	} `xml:"size"`
+	Location struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+	Format rpmFormat `xml:"format"`
+	// Files 包含的全部文件，写入 filelists.xml
+	Files []rpmFile `xml:"-"`
+}
+
+type rpmVersion struct {
+	Epoch string `xml:"epoch,attr"`
+	Ver   string `xml:"ver,attr"`
+	Rel   string `xml:"rel,attr"`
+}
+
+type rpmChecksum struct {
+	Type  string `xml:"type,attr"`
+	PkgID string `xml:"pkgid,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type rpmFormat struct {
+	License     string `xml:"rpm:license"`
+	Vendor      string `xml:"rpm:vendor"`
+	Group       string `xml:"rpm:group"`
+	BuildHost   string `xml:"rpm:buildhost"`
+	SourceRPM   string `xml:"rpm:sourcerpm"`
+	HeaderRange struct {
+		Start int64 `xml:"start,attr"`
+		End   int64 `xml:"end,attr"`
+	} `xml:"rpm:header-range"`
+	Provides []rpmDep `xml:"rpm:provides>rpm:entry,omitempty"`
+	Requires []rpmDep `xml:"rpm:requires>rpm:entry,omitempty"`
+	// Files primary.xml 中只列出常用路径下的文件，供按路径解析依赖
+	Files []rpmFile `xml:"file,omitempty"`
+}
+
+type rpmDep struct {
+	Name  string `xml:"name,attr"`
+	Flags string `xml:"flags,attr,omitempty"`
+	Epoch string `xml:"epoch,attr,omitempty"`
+	Ver   string `xml:"ver,attr,omitempty"`
+	Rel   string `xml:"rel,attr,omitempty"`
+}
+
+type rpmFile struct {
+	Type string `xml:"type,attr,omitempty"`
+	Path string `xml:",chardata"`
+}
+
+// parseRPM 读取 rpm 的引导区、签名头部及主头部，sha256 为整个文件的哈希，由调用方计算
+func parseRPM(r io.Reader) (*rpmPackage, error) {
+	lead := make([]byte, 96)
+	if _, err := io.ReadFull(r, lead); err != nil || !bytes.Equal(lead[:4], []byte{0xed, 0xab, 0xee, 0xdb}) {
+		return nil, errNotPackage
+	}
+	sig, sigLen, err := readRPMHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	// 签名头部按 8 字节对齐
+	pad := (8 - sigLen%8) % 8
+	if _, err := io.CopyN(io.Discard, r, pad); err != nil {
+		return nil, errNotPackage
+	}
+	start := 96 + sigLen + pad
+	h, hLen, err := readRPMHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &rpmPackage{
+		Type:        "rpm",
+		Name:        h.str(rpmTagName),
+		Arch:        h.str(rpmTagArch),
+		Version:     rpmVersion{Epoch: strconv.FormatInt(h.int(rpmTagEpoch), 10), Ver: h.str(rpmTagVersion), Rel: h.str(rpmTagRelease)},
+		Summary:     h.str(rpmTagSummary),
+		Description: h.str(rpmTagDescription),
+		Packager:    h.str(rpmTagPackager),
+		Url:         h.str(rpmTagUrl),
+	}
+	if p.Name == "" || p.Version.Ver == "" {
+		return nil, errNotPackage
+	}
+	p.Time.Build = h.int(rpmTagBuildTime)
+	p.Size.Installed = h.int(rpmTagSize)
+	if p.Size.Installed == 0 {
+		p.Size.Installed = h.int(rpmTagLongSize)
+	}
+	p.Size.Archive = h.int(rpmTagArchiveSize)
+	if p.Size.Archive == 0 {
+		p.Size.Archive = sig.int(rpmSigTagPayloadSize)
+	}
+	f := &p.Format
+	f.License, f.Vendor, f.Group, f.BuildHost = h.str(rpmTagLicense), h.str(rpmTagVendor), h.str(rpmTagGroup), h.str(rpmTagBuildHost)
+	f.SourceRPM = h.str(rpmTagSourceRPM)
+	if f.SourceRPM == "" {
+		// 源码包没有 sourcerpm
+		p.Arch = "src"
+	}
+	f.HeaderRange.Start, f.HeaderRange.End = start, start+hLen
+	f.Provides = rpmDeps(h, rpmTagProvideName, rpmTagProvideFlags, rpmTagProvideVersion)
+	f.Requires = rpmDeps(h, rpmTagRequireName, rpmTagRequireFlags, rpmTagRequireVersion)
+	base, dirs, idx, modes := h.strings(rpmTagBaseNames), h.strings(rpmTagDirNames), h.ints(rpmTagDirIndexes), h.ints(rpmTagFileModes)
+	for i, name := range base {
+		if i >= len(idx) || idx[i] < 0 || idx[i] >= int64(len(dirs)) {
+			break
+		}
+		file := rpmFile{Path: dirs[idx[i]] + name}
+		// S_IFDIR
+		if i < len(modes) && modes[i]&0170000 == 0040000 {
+			file.Type = "dir"
+		}
+		p.Files = append(p.Files, file)
+		if rpmPrimaryFile(file.Path) {
+			f.Files = append(f.Files, file)
+		}
+	}
+	return p, nil
+}
+
+// rpmDeps 读取依赖或提供的能力，rpmlib() 内部依赖不写入索引
+func rpmDeps(h *rpmHeader, nameTag, flagTag, verTag uint32) []rpmDep {
+	names, flags, vers := h.strings(nameTag), h.ints(flagTag), h.strings(verTag)
+	var deps []rpmDep
+	seen := map[rpmDep]bool{}
+	for i, name := range names {
+		if strings.HasPrefix(name, "rpmlib(") {
+			continue
+		}
+		d := rpmDep{Name: name}
+		if i < len(flags) && i < len(vers) && vers[i] != "" {
+			d.Flags = rpmFlags(flags[i])
+			v := rpmEVR(vers[i])
+			d.Epoch, d.Ver, d.Rel = v.Epoch, v.Ver, v.Rel
+		}
+		if !seen[d] {
+			seen[d] = true
+			deps = append(deps, d)
+		}
+	}
+	return deps
+}
+
+// rpmFlags 依赖的比较方式
+func rpmFlags(flags int64) string {
+	switch flags & 0xe {
+	case 2:
+		return "LT"
+	case 4:
+		return "GT"
+	case 8:
+		return "EQ"
+	case 10:
+		return "LE"
+	case 12:
+		return "GE"
+	}
+	return ""
+}
+
+// rpmEVR 解析 epoch:version-release
+func rpmEVR(s string) rpmVersion {
+	v := rpmVersion{Epoch: "0"}
+	if e, rest, ok := strings.Cut(s, ":"); ok {
+		v.Epoch, s = e, rest
+	}
+	if i := strings.LastIndex(s, "-"); i >= 0 {
+		v.Ver, v.Rel = s[:i], s[i+1:]
+	} else {
+		v.Ver = s
+	}
+	return v
+}
+
+// rpmPrimaryFile 与 createrepo 一致，primary.xml 中只列出 /etc、bin 目录下的文件
+func rpmPrimaryFile(p string) bool {
+	return strings.HasPrefix(p, "/etc/") || strings.Contains(p, "bin/") || p == "/usr/lib/sendmail"
+}
@@ -0,0 +1,47 @@
+package control
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// PurgeRoute 清除缓存接口路径
+const PurgeRoute = "/api/purge/"
+
+// Purge 清除文件的本地缓存、下载链接缓存及 CDN 缓存
+func Purge(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	id := PathValue(r, "id")
+	if id == "" || strings.Contains(id, "/") {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid file id")
+		return
+	}
+	getFileCache().cleanupFile(id)
+	getMemCache().remove(id)
+	store.Default().CacheDel("url:" + id)
+
+	link := conf.FileRoute + id
+	if conf.BaseUrl != "" {
+		if err := utils.PurgeCdn([]string{strings.TrimSuffix(conf.BaseUrl, "/") + link}); err != nil {
+			log.Printf("清除 CDN 缓存失败: %v", err)
+			errJsonMsg(w, r, http.StatusBadGateway, err.Error())
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(conf.UploadResponse{
+		Code:    1,
+		Message: link,
+		ImgUrl:  strings.TrimSuffix(conf.BaseUrl, "/") + link,
+	})
+}
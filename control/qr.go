@@ -0,0 +1,55 @@
+package control
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// QrRoute 二维码访问路径
+const QrRoute = "/qr/"
+
+// Qr 返回文件外链的二维码图片
+func Qr(w http.ResponseWriter, r *http.Request) {
+	qrFile(w, r, PathValue(r, "id"), "")
+}
+
+// qrFile 生成文件外链的二维码，prefix 为租户路径前缀
+func qrFile(w http.ResponseWriter, r *http.Request, id, prefix string) {
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	size := 256
+	if s, err := strconv.Atoi(r.URL.Query().Get("size")); err == nil && s >= 64 && s <= 1024 {
+		size = s
+	}
+	png, err := qrcode.Encode(publicBaseUrl(r)+prefix+conf.FileRoute+id, qrcode.Medium, size)
+	if err != nil {
+		log.Printf("生成二维码失败: %v", err)
+		http.Error(w, "Failed to generate QR code", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(png)
+}
+
+// publicBaseUrl 获取对外访问地址，未配置 url 参数时根据请求推断，并带上子路径前缀
+func publicBaseUrl(r *http.Request) string {
+	if conf.BaseUrl != "" {
+		return strings.TrimSuffix(conf.BaseUrl, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + conf.BasePath
+}
@@ -0,0 +1,129 @@
+package control
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/metrics"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// UploadJobRoute 排队上传结果查询路径
+const UploadJobRoute = "/api/upload/"
+
+// 排队上传结果的保留时间
+const uploadJobTTL = time.Hour
+
+func setUploadJob(jobID string, res conf.UploadResponse) {
+	b, _ := json.Marshal(res)
+	store.Default().CacheSet("job:"+jobID, string(b), uploadJobTTL)
+}
+
+// spoolUpload 将上传内容暂存到磁盘并在后台排队上传，返回 202 及查询地址
+func spoolUpload(w http.ResponseWriter, r *http.Request, file *countingReader, name, channel, prefix, tenantName string, headers fileHeaders) {
+	dir := filepath.Join(conf.DataDir, "queue")
+	os.MkdirAll(dir, 0755)
+	jobID := utils.RandString(16)
+	path := filepath.Join(dir, jobID)
+	var size int64
+	f, err := os.Create(path)
+	if err == nil {
+		if size, err = io.Copy(f, file); err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+	}
+	if err != nil {
+		if f != nil {
+			f.Close()
+			os.Remove(path)
+		}
+		if file.mismatch {
+			checksumError(w, r, file)
+			return
+		}
+		if file.exceeded {
+			tooLargeError(w, r, file)
+			return
+		}
+		log.Printf("暂存上传文件失败: %v", err)
+		errJsonMsg(w, r, http.StatusInternalServerError, "error")
+		return
+	}
+	sha := file.sum()
+	visibility, _ := uploadVisibility(r)
+	// 内容已存在时不再重复上传
+	if res, ok := dedupResult(sha, prefix, tenantName, visibility); ok {
+		f.Close()
+		os.Remove(path)
+		if ifNew(r) {
+			duplicateError(w, r, res)
+			return
+		}
+		writeJson(w, http.StatusOK, withHeaders(res, prefix, headers))
+		return
+	}
+	job := utils.NewUploadJob(channel, name, f)
+	job.Ctx = r.Context()
+	if err := utils.SubmitUpload(job); err != nil {
+		f.Close()
+		os.Remove(path)
+		submitError(w, r, channel, err)
+		return
+	}
+	setUploadJob(jobID, conf.UploadResponse{Code: 0, Message: "queued"})
+	go func() {
+		<-job.Done()
+		f.Close()
+		os.Remove(path)
+		setUploadJob(jobID, withHeaders(uploadResult(store.File{ID: job.FileID, Name: name, Size: size, Sha256: sha, Tenant: tenantName, Visibility: visibility}, prefix), prefix, headers))
+	}()
+	w.Header().Set("Location", apiPath(r, UploadJobRoute+jobID))
+	writeJson(w, http.StatusAccepted, conf.UploadResponse{Code: 0, Message: "queued"})
+}
+
+// submitError 上传任务提交失败时返回 503 或 429 及建议的重试时间
+func submitError(w http.ResponseWriter, r *http.Request, channel string, err error) {
+	if err == utils.ErrThrottled {
+		retry := int(utils.SendWait(channel).Seconds()) + 1
+		w.Header().Set("Retry-After", strconv.Itoa(retry))
+		writeError(w, r, http.StatusTooManyRequests, http.StatusTooManyRequests, tr(r, "Telegram rate limit reached, retry in %d seconds", retry), map[string]int{"retry_after": retry})
+		return
+	}
+	w.Header().Set("Retry-After", "30")
+	errJson(w, r, http.StatusServiceUnavailable, "Upload queue is full")
+}
+
+// UploadJob 查询排队上传的结果，未完成时返回 202
+func UploadJob(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	jobID := PathValue(r, "job")
+	v, ok := store.Default().CacheGet("job:" + jobID)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	var res conf.UploadResponse
+	if err := json.Unmarshal([]byte(v), &res); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	status := http.StatusOK
+	if res.Code == 0 && res.Message == "queued" {
+		status = http.StatusAccepted
+	}
+	writeJson(w, status, res)
+}
+
+// Metrics 以 Prometheus 文本格式输出运行指标
+func Metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.Write(w)
+}
@@ -0,0 +1,63 @@
+package control
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+)
+
+// 超过该数量的多段 Range 请求按完整文件返回，避免大量小范围放大响应
+const maxRanges = 32
+
+// countingWriter 只统计写入的字节数
+type countingWriter int64
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// rangePartHeader 多段响应中每段的头部
+func rangePartHeader(ra httpRange, size int64, contentType string) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":  {contentType},
+		"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", ra.start, ra.end, size)},
+	}
+}
+
+// multipartRangesSize 计算 multipart/byteranges 响应的总长度
+func multipartRangesSize(ranges []httpRange, size int64, contentType, boundary string) int64 {
+	var n countingWriter
+	mw := multipart.NewWriter(&n)
+	mw.SetBoundary(boundary)
+	for _, ra := range ranges {
+		mw.CreatePart(rangePartHeader(ra, size, contentType))
+		n += countingWriter(ra.length)
+	}
+	mw.Close()
+	return int64(n)
+}
+
+// serveMultipartRanges 以 multipart/byteranges 输出多个范围，write 负责写出单个范围的内容
+func serveMultipartRanges(w http.ResponseWriter, r *http.Request, ranges []httpRange, size int64, contentType string, write func(w io.Writer, ra httpRange) error) error {
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.Header().Set("Content-Length", strconv.FormatInt(multipartRangesSize(ranges, size, contentType, mw.Boundary()), 10))
+	w.WriteHeader(http.StatusPartialContent)
+	if r.Method == http.MethodHead {
+		return nil
+	}
+	for _, ra := range ranges {
+		part, err := mw.CreatePart(rangePartHeader(ra, size, contentType))
+		if err != nil {
+			return err
+		}
+		if err := write(part, ra); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
@@ -0,0 +1,75 @@
+package control
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/metrics"
+)
+
+// 统计范围长度的分档上限及标签
+var rangeBuckets = []struct {
+	max   int64
+	label string
+}{
+	{64 << 10, "64KiB"},
+	{1 << 20, "1MiB"},
+	{16 << 20, "16MiB"},
+	{128 << 20, "128MiB"},
+}
+
+func init() {
+	metrics.Help("tgstate_downloads_full_total", "Downloads answered with the whole file")
+	metrics.Help("tgstate_downloads_partial_total", "Downloads answered with 206, by kind: start (range from byte 0), resume (range from a later offset) or multi")
+	metrics.Help("tgstate_downloads_partial_bytes_total", "Bytes sent in 206 responses")
+	metrics.Help("tgstate_downloads_range_length_total", "Requested ranges by length bucket")
+}
+
+// rangeBucket 范围长度所在的分档
+func rangeBucket(length int64) string {
+	for _, b := range rangeBuckets {
+		if length <= b.max {
+			return "<=" + b.label
+		}
+	}
+	return ">" + rangeBuckets[len(rangeBuckets)-1].label
+}
+
+// formatRanges 以 Range 头的格式输出范围，用于日志
+func formatRanges(ranges []httpRange) string {
+	parts := make([]string, len(ranges))
+	for i, ra := range ranges {
+		parts[i] = strconv.FormatInt(ra.start, 10) + "-" + strconv.FormatInt(ra.end, 10)
+	}
+	return strings.Join(parts, ",")
+}
+
+// recordDownload 统计下载响应，ranges 为空表示完整输出；开启 logranges 时记录每个 206 响应请求的范围
+func recordDownload(r *http.Request, id string, ranges []httpRange, size int64) {
+	if r.Method == http.MethodHead {
+		return
+	}
+	if len(ranges) == 0 {
+		metrics.Inc("tgstate_downloads_full_total")
+		return
+	}
+	kind := "start"
+	if len(ranges) > 1 {
+		kind = "multi"
+	} else if ranges[0].start > 0 {
+		kind = "resume"
+	}
+	metrics.Inc(`tgstate_downloads_partial_total{kind="` + kind + `"}`)
+	var sent int64
+	for _, ra := range ranges {
+		sent += ra.length
+		metrics.Inc(`tgstate_downloads_range_length_total{size="` + rangeBucket(ra.length) + `"}`)
+	}
+	metrics.Add("tgstate_downloads_partial_bytes_total", sent)
+	if conf.LogRanges {
+		log.Printf("部分下载 %s %s bytes=%s/%d (%s)", clientIP(r), id, formatRanges(ranges), size, r.UserAgent())
+	}
+}
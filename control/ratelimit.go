@@ -0,0 +1,58 @@
+package control
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+)
+
+// RateLimit 按客户端IP限制每分钟的请求次数，计数保存在元数据存储中以便多实例共享
+func RateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if rateLimit() <= 0 || r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+		window := time.Now().Unix() / 60
+		key := "rate:" + clientIP(r) + ":" + strconv.FormatInt(window, 10)
+		n, err := store.Default().Incr(key, time.Minute)
+		if err != nil {
+			// 存储不可用时放行，避免影响正常上传
+			log.Printf("限流计数失败: %v", err)
+		} else if n > int64(rateLimit()) {
+			w.Header().Set("Retry-After", strconv.FormatInt(60-time.Now().Unix()%60, 10))
+			httpError(w, r, http.StatusTooManyRequests, "Too many requests")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// clientIP 获取客户端IP，开启 trustproxy 或经 Unix 套接字连接时使用反向代理传递的地址
+func clientIP(r *http.Request) string {
+	if conf.TrustProxy || unixSocket(r) {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			return strings.TrimSpace(strings.Split(xff, ",")[0])
+		}
+		if ip := r.Header.Get("X-Real-IP"); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// unixSocket 请求是否经 Unix 套接字到达，此时连接只可能来自本机的反向代理
+func unixSocket(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
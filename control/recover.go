@@ -0,0 +1,30 @@
+package control
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"csz.net/tgstate/utils"
+)
+
+// Recover 处理请求时发生 panic 时记录调用栈、返回 500，并将报告发送到管理员会话，
+// 已开始写入响应时只能中断连接；http.ErrAbortHandler 为主动中断，不作报告
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			utils.ReportPanic(r.Method+" "+r.URL.Path, v, debug.Stack())
+			if sw, ok := w.(*statusWriter); ok && sw.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
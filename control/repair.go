@@ -0,0 +1,193 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// RepairRoute 分块文件检查及修复接口路径
+const RepairRoute = "/api/repair/"
+
+// 分块检查结果
+const (
+	chunkOk      = "ok"
+	chunkMissing = "missing" // getFile 失败，分块已不存在
+	chunkBadSize = "size"    // 大小与清单不一致
+	chunkCorrupt = "corrupt" // 哈希与清单不一致
+)
+
+// chunkStatus 单个分块的检查结果
+type chunkStatus struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`
+	Size     int64  `json:"size"`
+	Status   string `json:"status"`
+	Replaced string `json:"replaced,omitempty"` // 修复后的新分块ID
+}
+
+// repairReport 分块文件检查报告
+type repairReport struct {
+	Code   int           `json:"code"`
+	ID     string        `json:"id"`
+	Name   string        `json:"name"`
+	Size   int64         `json:"size"`
+	Broken int           `json:"broken"`
+	Chunks []chunkStatus `json:"chunks"`
+	Url    string        `json:"url,omitempty"` // 修复后新清单的链接
+}
+
+// Repair 检查分块文件的所有分块是否仍可下载
+//
+// GET 只检查，deep=1 时下载分块校验哈希；POST 上传本地副本（image 字段），
+// 用其中对应的部分替换损坏的分块并生成新的清单
+func Repair(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(PathValue(r, "id"), "blob-")
+	if id == "" || strings.Contains(id, "/") {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid file id")
+		return
+	}
+	idx, err := loadBlobIndex(r.Context(), id)
+	if err != nil {
+		errJsonMsg(w, r, http.StatusBadGateway, err.Error())
+		return
+	}
+	report := checkBlob(r.Context(), id, idx, r.Method == http.MethodPost || r.URL.Query().Get("deep") == "1")
+	if r.Method == http.MethodPost && report.Broken > 0 {
+		if !idx.Seekable() {
+			errJsonMsg(w, r, http.StatusConflict, "Blob index has no chunk sizes, cannot repair")
+			return
+		}
+		part, err := imagePart(r)
+		if err != nil {
+			errJsonMsg(w, r, http.StatusBadGateway, "Unable to get file")
+			return
+		}
+		newID, err := repairBlob(idx, report, part)
+		if err != nil {
+			log.Printf("修复分块文件 %s 失败: %v", id, err)
+			errJsonMsg(w, r, http.StatusBadGateway, err.Error())
+			return
+		}
+		report.Url = conf.FileRoute + newID
+		recordUpload(store.File{ID: newID, Name: idx.Name, Size: idx.Size}, report.Url)
+	}
+	writeJson(w, http.StatusOK, report)
+}
+
+// loadBlobIndex 读取并解析分块索引
+func loadBlobIndex(ctx context.Context, id string) (*utils.BlobIndex, error) {
+	filePath, err := getFileCache().getCachedFile(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if !utils.IsBlobIndex(data) {
+		return nil, fmt.Errorf("not a blob index")
+	}
+	return utils.ParseBlobIndex(data)
+}
+
+// checkBlob 检查每个分块，deep 时下载并校验哈希
+func checkBlob(ctx context.Context, id string, idx *utils.BlobIndex, deep bool) *repairReport {
+	report := &repairReport{Code: 1, ID: id, Name: idx.Name, Size: idx.Size}
+	for i, c := range idx.Chunks {
+		if ctx.Err() != nil {
+			break
+		}
+		st := chunkStatus{Index: i, ID: c.ID, Size: c.Size, Status: chunkOk}
+		fileURL, size, ok := utils.GetDownloadInfo(c.ID)
+		switch {
+		case !ok:
+			st.Status = chunkMissing
+		case c.Size > 0 && size > 0 && size != c.Size:
+			st.Status = chunkBadSize
+		case deep && c.Sha256 != "":
+			if sum, err := chunkSha256(ctx, fileURL); err != nil {
+				st.Status = chunkMissing
+			} else if sum != c.Sha256 {
+				st.Status = chunkCorrupt
+			}
+		}
+		if st.Status != chunkOk {
+			report.Broken++
+		}
+		report.Chunks = append(report.Chunks, st)
+	}
+	return report
+}
+
+// chunkSha256 下载分块并计算哈希
+func chunkSha256(ctx context.Context, fileURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := utils.Upstream().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status %d", resp.StatusCode)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, resp.Body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// repairBlob 从本地副本中截取损坏分块对应的部分重新上传，返回新清单的ID
+func repairBlob(idx *utils.BlobIndex, report *repairReport, local io.Reader) (string, error) {
+	var buf []byte
+	for i, c := range idx.Chunks {
+		if int64(cap(buf)) < c.Size {
+			buf = make([]byte, c.Size)
+		}
+		buf = buf[:c.Size]
+		if _, err := io.ReadFull(local, buf); err != nil {
+			return "", fmt.Errorf("local copy too short at chunk %d", i)
+		}
+		st := &report.Chunks[i]
+		if c.Sha256 != "" {
+			sum := sha256.Sum256(buf)
+			if hex.EncodeToString(sum[:]) != c.Sha256 {
+				return "", fmt.Errorf("local copy does not match chunk %d", i)
+			}
+		}
+		if st.Status == chunkOk {
+			continue
+		}
+		newID, err := utils.UpDocument(utils.TgFileData(utils.BlobChunkName, bytes.NewReader(buf)))
+		if err != nil {
+			return "", fmt.Errorf("upload chunk %d: %w", i, err)
+		}
+		st.Replaced = newID
+		idx.Chunks[i].ID = newID
+	}
+	newID, err := utils.UpDocument(utils.TgFileData(utils.BlobManifestName, bytes.NewReader(idx.Manifest())))
+	if err != nil {
+		return "", fmt.Errorf("upload manifest: %w", err)
+	}
+	return newID, nil
+}
@@ -0,0 +1,297 @@
+package control
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+)
+
+// RepoRoute 软件源路径，指定相册中的 .deb 以平铺软件源的形式提供 Packages 及 Release，
+// 即 deb [trusted=yes] https://host/repo/ ./；.rpm 在 repodata/ 下提供 repomd.xml，即 baseurl=https://host/repo/。
+// 索引不签名，需要签名时可下载 Release 及 repomd.xml 自行签名后另行发布
+const RepoRoute = "/repo/"
+
+// repoIndexTypes 生成的索引文件及其内容类型
+var repoIndexTypes = map[string]string{
+	"Packages":                  "text/plain; charset=utf-8",
+	"Packages.gz":               "application/gzip",
+	"Release":                   "text/plain; charset=utf-8",
+	"repodata/repomd.xml":       "application/xml",
+	"repodata/primary.xml.gz":   "application/gzip",
+	"repodata/filelists.xml.gz": "application/gzip",
+}
+
+// repoPackage 软件源中的一个软件包，元数据由文件内容解析，文件ID不变则内容不变
+type repoPackage struct {
+	Size    int64
+	Sha256  string
+	Md5     string
+	Control string      // .deb 的 control 文件
+	RPM     *rpmPackage // .rpm 的头部信息
+}
+
+// repoIndex 为相册生成的全部索引文件
+type repoIndex struct {
+	album    string
+	files    map[string][]byte
+	modified time.Time
+}
+
+var repoCache struct {
+	sync.Mutex
+	index *repoIndex
+	pkgs  map[string]*repoPackage // 按文件ID缓存解析结果，生成失败重试时不必重新下载
+}
+
+// Repo 提供软件源的索引及其中的软件包，索引在首次访问时下载全部软件包生成，之后缓存在内存中
+func Repo(w http.ResponseWriter, r *http.Request) {
+	album, ok := store.Default().GetAlbum(conf.RepoAlbum)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	p := PathValue(r, "path")
+	if ct, ok := repoIndexTypes[p]; ok {
+		idx, err := buildRepoIndex(r.Context(), album)
+		if err != nil {
+			if r.Context().Err() == nil {
+				log.Printf("生成软件源索引失败: %v", err)
+				http.Error(w, "Failed to build repository index", http.StatusBadGateway)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", ct)
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		http.ServeContent(w, r, "", idx.modified, bytes.NewReader(idx.files[p]))
+		return
+	}
+	for _, f := range album.Files {
+		if f.Path == p {
+			serveFile(w, r, f.ID)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// buildRepoIndex 生成相册的索引，相册创建后不再变化，生成成功后一直使用
+func buildRepoIndex(ctx context.Context, album store.Album) (*repoIndex, error) {
+	repoCache.Lock()
+	defer repoCache.Unlock()
+	if idx := repoCache.index; idx != nil && idx.album == album.ID {
+		return idx, nil
+	}
+	if repoCache.pkgs == nil {
+		repoCache.pkgs = map[string]*repoPackage{}
+	}
+	files := append([]store.AlbumFile(nil), album.Files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	var packages bytes.Buffer
+	archs := map[string]bool{}
+	primary := repoPrimary{Xmlns: "http://linux.duke.edu/metadata/common", XmlnsRpm: "http://linux.duke.edu/metadata/rpm"}
+	filelists := repoFilelists{Xmlns: "http://linux.duke.edu/metadata/filelists"}
+	for _, f := range files {
+		ext := strings.ToLower(path.Ext(f.Path))
+		if ext != ".deb" && ext != ".rpm" {
+			continue
+		}
+		pkg, ok := repoCache.pkgs[f.ID]
+		if !ok {
+			var err error
+			if pkg, err = parsePackage(ctx, f.ID, ext); err != nil {
+				return nil, err
+			}
+			repoCache.pkgs[f.ID] = pkg
+		}
+		switch {
+		case pkg == nil:
+			// 无法解析的文件不列入索引
+		case pkg.Control != "":
+			fmt.Fprintf(&packages, "%s\nFilename: %s\nSize: %d\nMD5sum: %s\nSHA256: %s\n\n", pkg.Control, f.Path, pkg.Size, pkg.Md5, pkg.Sha256)
+			if arch := debField(pkg.Control, "Architecture"); arch != "" {
+				archs[arch] = true
+			}
+		case pkg.RPM != nil:
+			p := *pkg.RPM
+			p.Checksum = rpmChecksum{Type: "sha256", PkgID: "YES", Value: pkg.Sha256}
+			p.Time.File = album.CreatedAt
+			p.Size.Package = pkg.Size
+			p.Location.Href = f.Path
+			primary.Packages = append(primary.Packages, p)
+			filelists.Packages = append(filelists.Packages, repoFilelistPackage{PkgID: pkg.Sha256, Name: p.Name, Arch: p.Arch, Version: p.Version, Files: p.Files})
+		}
+	}
+	idx := &repoIndex{album: album.ID, files: map[string][]byte{}, modified: time.Unix(album.CreatedAt, 0).UTC()}
+	idx.files["Packages"] = packages.Bytes()
+	idx.files["Packages.gz"] = gzipBytes(packages.Bytes())
+	idx.files["Release"] = repoRelease(album, archs, idx.files)
+
+	primary.Count, filelists.Count = len(primary.Packages), len(filelists.Packages)
+	repomd := repoMD{Xmlns: "http://linux.duke.edu/metadata/repo", XmlnsRpm: "http://linux.duke.edu/metadata/rpm", Revision: album.CreatedAt}
+	for _, d := range []struct {
+		typ string
+		v   interface{}
+	}{{"primary", primary}, {"filelists", filelists}} {
+		raw, err := xml.Marshal(d.v)
+		if err != nil {
+			return nil, err
+		}
+		raw = append([]byte(xml.Header), raw...)
+		gz := gzipBytes(raw)
+		name := "repodata/" + d.typ + ".xml.gz"
+		idx.files[name] = gz
+		repomd.Data = append(repomd.Data, repoMDData{
+			Type:         d.typ,
+			Checksum:     rpmChecksum{Type: "sha256", Value: sha256Hex(gz)},
+			OpenChecksum: rpmChecksum{Type: "sha256", Value: sha256Hex(raw)},
+			Location:     repoLocation{Href: name},
+			Timestamp:    album.CreatedAt,
+			Size:         int64(len(gz)),
+			OpenSize:     int64(len(raw)),
+		})
+	}
+	raw, err := xml.MarshalIndent(repomd, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	idx.files["repodata/repomd.xml"] = append([]byte(xml.Header), raw...)
+	repoCache.index = idx
+	return idx, nil
+}
+
+// parsePackage 下载并解析软件包，同时计算哈希，内容无法解析时返回 nil
+func parsePackage(ctx context.Context, id, ext string) (*repoPackage, error) {
+	fc, err := openContent(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	defer fc.Close()
+	md := md5.New()
+	cr := &countingReader{r: io.TeeReader(fc, md)}
+	pkg := &repoPackage{}
+	if ext == ".deb" {
+		pkg.Control, err = debControl(cr)
+	} else {
+		pkg.RPM, err = parseRPM(cr)
+	}
+	if err != nil {
+		log.Printf("解析软件包 %s 失败: %v", id, err)
+		pkg = nil
+	}
+	// 读完剩余内容以计算整个文件的哈希
+	if _, err := io.Copy(io.Discard, cr); err != nil {
+		return nil, err
+	}
+	if pkg != nil {
+		pkg.Size, pkg.Sha256, pkg.Md5 = cr.n, cr.sum(), hex.EncodeToString(md.Sum(nil))
+	}
+	return pkg, nil
+}
+
+// repoRelease 生成平铺软件源的 Release 文件
+func repoRelease(album store.Album, archs map[string]bool, files map[string][]byte) []byte {
+	var b bytes.Buffer
+	label := album.Name
+	if label == "" {
+		label = "tgState"
+	}
+	b.WriteString("Origin: tgState\nLabel: " + label + "\n")
+	b.WriteString("Date: " + time.Unix(album.CreatedAt, 0).UTC().Format("Mon, 02 Jan 2006 15:04:05 UTC") + "\n")
+	if len(archs) > 0 {
+		list := make([]string, 0, len(archs))
+		for a := range archs {
+			list = append(list, a)
+		}
+		sort.Strings(list)
+		b.WriteString("Architectures: " + strings.Join(list, " ") + "\n")
+	}
+	for _, h := range []struct {
+		name string
+		sum  func([]byte) string
+	}{
+		{"MD5Sum", func(d []byte) string { s := md5.Sum(d); return hex.EncodeToString(s[:]) }},
+		{"SHA256", sha256Hex},
+	} {
+		b.WriteString(h.name + ":\n")
+		for _, name := range []string{"Packages", "Packages.gz"} {
+			b.WriteString(" " + h.sum(files[name]) + " " + strconv.Itoa(len(files[name])) + " " + name + "\n")
+		}
+	}
+	return b.Bytes()
+}
+
+func sha256Hex(b []byte) string {
+	s := sha256.Sum256(b)
+	return hex.EncodeToString(s[:])
+}
+
+// gzipBytes 压缩索引文件，不写入修改时间，相同内容的压缩结果相同
+func gzipBytes(b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(b)
+	zw.Close()
+	return buf.Bytes()
+}
+
+type repoPrimary struct {
+	XMLName  xml.Name     `xml:"metadata"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	XmlnsRpm string       `xml:"xmlns:rpm,attr"`
+	Count    int          `xml:"packages,attr"`
+	Packages []rpmPackage `xml:"package"`
+}
+
+type repoFilelists struct {
+	XMLName  xml.Name              `xml:"filelists"`
+	Xmlns    string                `xml:"xmlns,attr"`
+	Count    int                   `xml:"packages,attr"`
+	Packages []repoFilelistPackage `xml:"package"`
+}
+
+type repoFilelistPackage struct {
+	PkgID   string     `xml:"pkgid,attr"`
+	Name    string     `xml:"name,attr"`
+	Arch    string     `xml:"arch,attr"`
+	Version rpmVersion `xml:"version"`
+	Files   []rpmFile  `xml:"file"`
+}
+
+type repoMD struct {
+	XMLName  xml.Name     `xml:"repomd"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	XmlnsRpm string       `xml:"xmlns:rpm,attr"`
+	Revision int64        `xml:"revision"`
+	Data     []repoMDData `xml:"data"`
+}
+
+type repoMDData struct {
+	Type         string       `xml:"type,attr"`
+	Checksum     rpmChecksum  `xml:"checksum"`
+	OpenChecksum rpmChecksum  `xml:"open-checksum"`
+	Location     repoLocation `xml:"location"`
+	Timestamp    int64        `xml:"timestamp"`
+	Size         int64        `xml:"size"`
+	OpenSize     int64        `xml:"open-size"`
+}
+
+type repoLocation struct {
+	Href string `xml:"href,attr"`
+}
@@ -0,0 +1,58 @@
+package control
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"csz.net/tgstate/assets"
+)
+
+// decodeRequest 将 JSON 或表单请求体解析到结构体，表单字段名取自 json 标签
+func decodeRequest(r *http.Request, v interface{}) error {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return json.NewDecoder(r.Body).Decode(v)
+	}
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	rv := reflect.ValueOf(v).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		switch {
+		case field.Type.Kind() == reflect.String:
+			rv.Field(i).SetString(r.FormValue(name))
+		case field.Type == reflect.TypeOf([]string(nil)):
+			// 重复的表单字段解析为切片
+			rv.Field(i).Set(reflect.ValueOf(r.Form[name]))
+		case field.Type.Kind() == reflect.Int:
+			if s := r.FormValue(name); s != "" {
+				n, err := strconv.Atoi(s)
+				if err != nil {
+					return err
+				}
+				rv.Field(i).SetInt(int64(n))
+			}
+		}
+	}
+	return nil
+}
+
+// OpenAPI 返回接口描述文档
+func OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(assets.OpenAPI)
+}
+
+// ApiDocs Swagger UI 页面
+func ApiDocs(w http.ResponseWriter, r *http.Request) {
+	renderTemplate(w, r, "swagger.tmpl", nil)
+}
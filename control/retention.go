@@ -0,0 +1,136 @@
+package control
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// RetentionRoute 保留策略接口路径
+const RetentionRoute = "/api/admin/retention"
+
+// retentionItem 按保留策略需要删除的文件
+type retentionItem struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Reason string `json:"reason"` // expired 超过保留天数，idle 长期未下载
+	Error  string `json:"error,omitempty"`
+}
+
+// retentionReport 保留策略执行结果
+type retentionReport struct {
+	Code   int             `json:"code"`
+	DryRun bool            `json:"dry_run"`
+	Days   int             `json:"days"`
+	Idle   int             `json:"idle"`
+	Files  []retentionItem `json:"files"`
+	Freed  int64           `json:"freed"`
+}
+
+// retentionCandidates 列出超过保留天数或长期未下载的文件
+func retentionCandidates(days, idle int) []retentionItem {
+	now := time.Now().Unix()
+	var items []retentionItem
+	for _, f := range store.Default().Files() {
+		// 网页端分块上传的每个分块都有单独的记录，但下载只统计清单，
+		// 分块随清单一起删除，不单独按保留策略处理
+		if f.Name == utils.BlobChunkName || f.DeletedAt > 0 {
+			continue
+		}
+		reason := ""
+		switch {
+		case days > 0 && f.CreatedAt > 0 && now-f.CreatedAt > int64(days)*86400:
+			reason = "expired"
+		case idle > 0 && now-lastUsed(f) > int64(idle)*86400:
+			reason = "idle"
+		}
+		if reason != "" {
+			items = append(items, retentionItem{ID: f.ID, Name: f.Name, Size: f.Size, Reason: reason})
+		}
+	}
+	return items
+}
+
+// lastUsed 文件最后一次下载的时间，未下载过时为上传时间
+func lastUsed(f store.File) int64 {
+	if f.LastAccess > f.CreatedAt {
+		return f.LastAccess
+	}
+	return f.CreatedAt
+}
+
+// runRetention 执行保留策略，dryRun 时只生成报告
+func runRetention(ctx context.Context, days, idle int, dryRun bool) retentionReport {
+	report := retentionReport{Code: 1, DryRun: dryRun, Days: days, Idle: idle, Files: []retentionItem{}}
+	for _, item := range retentionCandidates(days, idle) {
+		if ctx.Err() != nil {
+			break
+		}
+		if !dryRun {
+			if err := deleteFile(ctx, item.ID); err != nil {
+				item.Error = err.Error()
+			}
+		}
+		report.Freed += item.Size
+		report.Files = append(report.Files, item)
+	}
+	return report
+}
+
+// StartRetention 按配置的保留策略定期清理文件
+func StartRetention() {
+	if conf.RetentionDays <= 0 && conf.RetentionIdleDays <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			report := runRetention(context.Background(), conf.RetentionDays, conf.RetentionIdleDays, conf.RetentionDryRun)
+			if len(report.Files) > 0 {
+				action := "已删除"
+				if report.DryRun {
+					action = "[dry-run] 将删除"
+				}
+				log.Printf("保留策略：%s %d 个文件，共 %d 字节", action, len(report.Files), report.Freed)
+				for _, item := range report.Files {
+					log.Printf("保留策略：%s %s (%s) %s", action, item.ID, item.Name, item.Reason)
+					if !report.DryRun {
+						auditSystem("retention", item.ID, item.Reason)
+					}
+				}
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Retention GET 按保留策略预览将删除的文件，POST 立即执行；days、idle 参数可覆盖配置
+func Retention(w http.ResponseWriter, r *http.Request) {
+	days, idle := conf.RetentionDays, conf.RetentionIdleDays
+	if v, err := strconv.Atoi(r.URL.Query().Get("days")); err == nil {
+		days = v
+	}
+	if v, err := strconv.Atoi(r.URL.Query().Get("idle")); err == nil {
+		idle = v
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeJson(w, http.StatusOK, runRetention(r.Context(), days, idle, true))
+	case http.MethodPost:
+		if days <= 0 && idle <= 0 {
+			errJsonMsg(w, r, http.StatusConflict, "No retention policy configured")
+			return
+		}
+		writeJson(w, http.StatusOK, runRetention(r.Context(), days, idle, false))
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
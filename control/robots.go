@@ -0,0 +1,43 @@
+package control
+
+import (
+	"net/http"
+	"os"
+
+	"csz.net/tgstate/conf"
+)
+
+// RobotsRoute robots.txt 路径
+const RobotsRoute = "/robots.txt"
+
+// Robots 返回 robots.txt，优先使用 robots 参数指定的文件
+func Robots(w http.ResponseWriter, r *http.Request) {
+	body := "User-agent: *\nDisallow: /api\nDisallow: /pwd\n"
+	if sitemapEnabled() {
+		body += "Sitemap: " + publicBaseUrl(r) + SitemapRoute + "\n"
+	}
+	if conf.NoIndex {
+		body = "User-agent: *\nDisallow: /\n"
+	}
+	if conf.RobotsFile != "" {
+		b, err := os.ReadFile(conf.RobotsFile)
+		if err != nil {
+			http.Error(w, "Failed to read robots.txt", http.StatusInternalServerError)
+			return
+		}
+		body = string(b)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write([]byte(body))
+}
+
+// NoIndex 开启 noindex 时添加 X-Robots-Tag，避免文件地址被搜索引擎收录
+func NoIndex(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if conf.NoIndex {
+			w.Header().Set("X-Robots-Tag", "noindex, nofollow, noarchive")
+		}
+		next(w, r)
+	}
+}
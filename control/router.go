@@ -0,0 +1,211 @@
+package control
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"csz.net/tgstate/tracing"
+)
+
+// Router 按请求方法和路径模式分发请求
+//
+// 模式按 / 分段，{name} 匹配非空的一段，末尾的 {name...} 匹配剩余的路径。
+// 同一路径匹配多个模式时逐段比较，字面量优先于 {name}，{name} 优先于 {name...}。
+// 路径匹配但方法不允许时返回 405，/api 下的 404、405 以 JSON 返回。
+type Router struct {
+	routes []*route
+}
+
+type route struct {
+	pattern string
+	segs    []string
+	methods []string // 为空时接受任意方法
+	handler http.Handler
+}
+
+type pathValuesKey struct{}
+
+// NewRouter 创建路由
+func NewRouter() *Router {
+	return &Router{}
+}
+
+// Handle 注册路由，methods 为空时接受任意方法，允许 GET 时同时允许 HEAD
+func (rt *Router) Handle(pattern string, h http.HandlerFunc, methods ...string) {
+	if !strings.HasPrefix(pattern, "/") {
+		panic("路由模式必须以 / 开头: " + pattern)
+	}
+	rt.routes = append(rt.routes, &route{pattern: pattern, segs: splitPath(pattern), methods: methods, handler: h})
+}
+
+// PathValue 返回路由模式中 {name} 匹配到的路径
+func PathValue(r *http.Request, name string) string {
+	values, _ := r.Context().Value(pathValuesKey{}).(map[string]string)
+	return values[name]
+}
+
+// withPathValues 设置请求的路径参数
+func withPathValues(r *http.Request, values map[string]string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), pathValuesKey{}, values))
+}
+
+func splitPath(p string) []string {
+	return strings.Split(strings.TrimPrefix(p, "/"), "/")
+}
+
+// cleanPath 与 http.ServeMux 一致，清理 . 和 .. 并保留末尾的 /
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	np := path.Clean(p)
+	if p[len(p)-1] == '/' && np != "/" {
+		np += "/"
+	}
+	return np
+}
+
+// wildcard 返回参数名及是否匹配剩余路径，不是参数时 name 为空
+func wildcard(seg string) (name string, rest bool) {
+	if len(seg) < 3 || seg[0] != '{' || seg[len(seg)-1] != '}' {
+		return "", false
+	}
+	name = seg[1 : len(seg)-1]
+	if strings.HasSuffix(name, "...") {
+		return strings.TrimSuffix(name, "..."), true
+	}
+	return name, false
+}
+
+// match 匹配路径片段，返回路径参数
+func (rt *route) match(segs []string) (map[string]string, bool) {
+	values := map[string]string{}
+	for i, s := range rt.segs {
+		name, rest := wildcard(s)
+		if rest {
+			if i < len(segs) {
+				values[name] = strings.Join(segs[i:], "/")
+			} else {
+				values[name] = ""
+			}
+			return values, true
+		}
+		if i >= len(segs) {
+			return nil, false
+		}
+		switch {
+		case name != "":
+			if segs[i] == "" {
+				return nil, false
+			}
+			values[name] = segs[i]
+		case s != segs[i]:
+			return nil, false
+		}
+	}
+	return values, len(segs) == len(rt.segs)
+}
+
+// rank 片段的优先级，越小越优先
+func rank(seg string) int {
+	switch name, rest := wildcard(seg); {
+	case rest:
+		return 2
+	case name != "":
+		return 1
+	}
+	return 0
+}
+
+// moreSpecific a 是否比 b 更具体
+func moreSpecific(a, b *route) bool {
+	for i := 0; i < len(a.segs) && i < len(b.segs); i++ {
+		if ra, rb := rank(a.segs[i]), rank(b.segs[i]); ra != rb {
+			return ra < rb
+		}
+	}
+	return len(a.segs) > len(b.segs)
+}
+
+// allows 路由是否接受该方法
+func (rt *route) allows(method string) bool {
+	if len(rt.methods) == 0 {
+		return true
+	}
+	for _, m := range rt.methods {
+		if m == method || (m == http.MethodGet && method == http.MethodHead) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p := cleanPath(r.URL.Path); p != r.URL.Path {
+		u := *r.URL
+		u.Path, u.RawPath = p, ""
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+		return
+	}
+	rt.serve(w, r, r.URL.Path)
+}
+
+// serve 按路径 p 分发请求，嵌套的路由以去掉前缀后的路径匹配
+func (rt *Router) serve(w http.ResponseWriter, r *http.Request, p string) {
+	segs := splitPath(p)
+	var best *route
+	var bestValues map[string]string
+	allow := map[string]bool{}
+	for _, ro := range rt.routes {
+		values, ok := ro.match(segs)
+		if !ok {
+			continue
+		}
+		for _, m := range ro.methods {
+			allow[m] = true
+		}
+		if !ro.allows(r.Method) {
+			continue
+		}
+		if best == nil || moreSpecific(ro, best) {
+			best, bestValues = ro, values
+		}
+	}
+	if best != nil {
+		span := tracing.FromContext(r.Context())
+		span.SetName(r.Method + " " + best.pattern)
+		span.SetAttr("http.route", best.pattern)
+		best.handler.ServeHTTP(w, withPathValues(r, bestValues))
+		return
+	}
+	if len(allow) == 0 {
+		httpError(w, r, http.StatusNotFound, "404 page not found")
+		return
+	}
+	if allow[http.MethodGet] {
+		allow[http.MethodHead] = true
+	}
+	allow[http.MethodOptions] = true
+	methods := make([]string, 0, len(allow))
+	for m := range allow {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	httpError(w, r, http.StatusMethodNotAllowed, "Invalid request method")
+}
+
+// isApiPath 是否为 JSON 接口的路径
+func isApiPath(p string) bool {
+	return p == "/api" || strings.HasPrefix(p, "/api/")
+}
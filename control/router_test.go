@@ -0,0 +1,137 @@
+package control
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"csz.net/tgstate/conf"
+)
+
+// testRoutes 测试用的下载路由
+func testRoutes() *Router {
+	rt := NewRouter()
+	rt.Handle(conf.FileRoute+"{id}", D, http.MethodGet)
+	rt.Handle(conf.FileRoute+"{id}"+subsSuffix, DSubs, http.MethodGet)
+	return rt
+}
+
+func TestRouter(t *testing.T) {
+	rt := NewRouter()
+	handle := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(name + ":" + PathValue(r, "id") + ":" + PathValue(r, "rest")))
+		}
+	}
+	rt.Handle("/", handle("index"), http.MethodGet)
+	rt.Handle("/d/{id}", handle("file"), http.MethodGet)
+	rt.Handle("/d/{id}/subs", handle("subs"), http.MethodGet)
+	rt.Handle("/api/file/{id}", handle("info"), http.MethodGet, http.MethodDelete)
+	rt.Handle("/api/file/new", handle("new"), http.MethodPost)
+	rt.Handle("/static/{rest...}", handle("static"), http.MethodGet)
+	rt.Handle("/any", handle("any"))
+
+	tests := []struct {
+		method, path string
+		code         int
+		body, allow  string
+	}{
+		{"GET", "/", 200, "index::", ""},
+		{"GET", "/d/abc", 200, "file:abc:", ""},
+		{"HEAD", "/d/abc", 200, "", ""},
+		{"GET", "/d/abc/subs", 200, "subs:abc:", ""},
+		{"GET", "/d/", 404, "", ""},
+		{"GET", "/d/abc/x", 404, "", ""},
+		{"GET", "/api/file/new", 200, "info:new:", ""},
+		{"PUT", "/api/file/new", 405, "", "DELETE, GET, HEAD, OPTIONS, POST"},
+		{"POST", "/api/file/new", 200, "new::", ""},
+		{"DELETE", "/api/file/abc", 200, "info:abc:", ""},
+		{"PUT", "/api/file/abc", 405, "", "DELETE, GET, HEAD, OPTIONS"},
+		{"OPTIONS", "/api/file/abc", 204, "", "DELETE, GET, HEAD, OPTIONS"},
+		{"GET", "/static/css/a.css", 200, "static::css/a.css", ""},
+		{"GET", "/static/", 200, "static::", ""},
+		{"PATCH", "/any", 200, "any::", ""},
+		{"GET", "/missing", 404, "", ""},
+		{"GET", "/api/missing", 404, "", ""},
+		{"GET", "/d/../api", 301, "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s %s 状态码 %d，期望 %d", tt.method, tt.path, w.Code, tt.code)
+			continue
+		}
+		if tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s %s 返回 %q，期望 %q", tt.method, tt.path, w.Body.String(), tt.body)
+		}
+		if got := w.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%s %s Allow %q，期望 %q", tt.method, tt.path, got, tt.allow)
+		}
+		// 接口的 404、405 以 JSON 返回
+		if isApiPath(tt.path) && (w.Code == 404 || w.Code == 405) {
+			var res struct{ Message string }
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil || res.Message == "" {
+				t.Errorf("%s %s 返回 %q", tt.method, tt.path, w.Body.String())
+			}
+		}
+	}
+}
+
+func TestErrorModel(t *testing.T) {
+	defer func(v bool) { conf.LegacyErrors = v }(conf.LegacyErrors)
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		errJsonMsg(w, r, http.StatusRequestEntityTooLarge, "File size exceeds limit")
+	}))
+	tests := []struct {
+		legacy bool
+		path   string
+		code   int
+		body   string
+	}{
+		{true, "/api", 200, `{"code":0,"message":"File size exceeds limit","url":""}`},
+		{true, "/api/v1/upload", 413, `"code":"request_entity_too_large","message":"File size exceeds limit","request_id":"`},
+		{false, "/api", 413, `"code":"request_entity_too_large"`},
+	}
+	for _, tt := range tests {
+		conf.LegacyErrors = tt.legacy
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, nil))
+		if w.Code != tt.code || !strings.Contains(w.Body.String(), tt.body) {
+			t.Errorf("legacy=%v %s 返回 %d %s", tt.legacy, tt.path, w.Code, w.Body.String())
+		}
+		if id := w.Header().Get("X-Request-Id"); len(id) != 16 || (!tt.legacy && !strings.Contains(w.Body.String(), id)) {
+			t.Errorf("X-Request-Id %q 与响应 %s 不一致", id, w.Body.String())
+		}
+	}
+}
+
+func TestV1(t *testing.T) {
+	for p, want := range map[string]string{
+		"/api":          "/api/v1/upload",
+		"/api/paste":    "/api/v1/paste",
+		"/api/file/abc": "/api/v1/file/abc",
+	} {
+		if got := V1Path(p); got != want {
+			t.Errorf("V1Path(%q) = %q，期望 %q", p, got, want)
+		}
+	}
+	h := Deprecated(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", apiPath(r, UploadJobRoute+"j1"))
+	})
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodPost, "/api/paste", nil))
+	if w.Header().Get("Deprecation") != "true" || w.Header().Get("Link") != `</api/v1/paste>; rel="successor-version"` {
+		t.Errorf("弃用响应头 %v", w.Header())
+	}
+	if got := w.Header().Get("Location"); got != UploadJobRoute+"j1" {
+		t.Errorf("旧版 Location %q", got)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/paste", nil)
+	if got := apiPath(r, UploadJobRoute+"j1"); got != "/api/v1/upload/j1" {
+		t.Errorf("v1 Location %q", got)
+	}
+}
@@ -0,0 +1,198 @@
+package control
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/i18n"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// ScheduleRoute 定时发送文件链接到频道的接口路径
+const ScheduleRoute = "/api/schedule"
+
+// scheduleClaimTTL 多个实例共享存储时，发送前占用定时任务的时间，发送失败的任务在此之后重试
+const scheduleClaimTTL = 5 * time.Minute
+
+var (
+	errScheduleTime = errors.New("invalid schedule time")
+	errScheduleFile = errors.New("file not found")
+)
+
+// parseScheduleTime 解析计划发送的时间，支持 Unix 秒、RFC3339、本地时间及相对时间
+func parseScheduleTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "+") {
+		d, err := time.ParseDuration(s[1:])
+		if err != nil || d <= 0 {
+			return time.Time{}, errScheduleTime
+		}
+		return now.Add(d), nil
+	}
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil && sec > 0 {
+		return time.Unix(sec, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errScheduleTime
+}
+
+// scheduleFileID 从文件ID或文件链接中取出文件ID
+func scheduleFileID(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.Index(s, conf.FileRoute); i >= 0 {
+		s = s[i+len(conf.FileRoute):]
+	}
+	s, _, _ = strings.Cut(s, "?")
+	return s
+}
+
+// addSchedule 登记定时发送，文件需存在且可公开下载，时间早于当前时间时在下一次检查时立即发送
+func addSchedule(req conf.ScheduleRequest, creator string) (store.Schedule, error) {
+	id := scheduleFileID(req.File)
+	if f, ok := store.Default().GetFile(id); !ok || f.DeletedAt > 0 || f.Pending {
+		return store.Schedule{}, errScheduleFile
+	}
+	at, err := parseScheduleTime(req.At, time.Now())
+	if err != nil {
+		return store.Schedule{}, err
+	}
+	sc := store.Schedule{
+		ID:        utils.RandString(8),
+		File:      id,
+		Text:      strings.TrimSpace(req.Text),
+		At:        at.Unix(),
+		CreatedAt: time.Now().Unix(),
+		Creator:   creator,
+	}
+	return sc, store.Default().PutSchedule(sc)
+}
+
+// postSchedule 将文件链接发送到频道，发送后删除定时任务
+func postSchedule(sc store.Schedule) error {
+	f, ok := store.Default().GetFile(sc.File)
+	if !ok || f.DeletedAt > 0 {
+		// 文件已删除，不再发送
+		log.Printf("定时发送的文件已删除【%s】", sc.File)
+		return store.Default().DeleteSchedule(sc.ID)
+	}
+	text := f.Name + "\n" + strings.TrimSuffix(conf.BaseUrl, "/") + conf.FileRoute + f.ID
+	if sc.Text != "" {
+		text = sc.Text + "\n\n" + text
+	}
+	if err := utils.SendChat(conf.ChannelName, text); err != nil {
+		return err
+	}
+	auditSystem("post", f.ID, sc.ID)
+	return store.Default().DeleteSchedule(sc.ID)
+}
+
+// runSchedules 发送已到时间的文件链接
+func runSchedules(now time.Time) {
+	st := store.Default()
+	for _, sc := range st.Schedules() {
+		if sc.At > now.Unix() {
+			break
+		}
+		if n, err := st.Incr("schedule:"+sc.ID, scheduleClaimTTL); err != nil || n != 1 {
+			continue
+		}
+		if err := postSchedule(sc); err != nil {
+			log.Printf("定时发送失败【%s】: %v", sc.ID, err)
+		}
+	}
+}
+
+// StartSchedule 每分钟检查并发送到期的文件链接，并注册机器人的 /schedule 命令
+func StartSchedule() {
+	utils.HandleCommand("schedule", scheduleCommand)
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			runSchedules(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+// scheduleCommand 处理 "/schedule 文件ID或链接 时间 [说明]"，时间不能包含空格
+func scheduleCommand(user int64, args string) string {
+	t := func(msg string) string { return i18n.T(i18n.Default, msg) }
+	file, rest, _ := strings.Cut(args, " ")
+	at, text, _ := strings.Cut(strings.TrimSpace(rest), " ")
+	if file == "" || at == "" {
+		return t("Usage: /schedule <file id or link> <time> [text]")
+	}
+	sc, err := addSchedule(conf.ScheduleRequest{File: file, At: at, Text: text}, "telegram:"+strconv.FormatInt(user, 10))
+	if err != nil {
+		return "⚠️ " + t(err.Error())
+	}
+	store.Default().AddAudit(store.AuditEntry{
+		Time:   time.Now().Unix(),
+		Action: "schedule",
+		Actor:  sc.Creator,
+		Target: sc.File,
+		Detail: sc.ID,
+	})
+	return t("Scheduled for") + " " + time.Unix(sc.At, 0).Format("2006-01-02 15:04:05 MST")
+}
+
+// Schedule 管理定时发送：GET 列出，POST 新增，DELETE 按 id 参数取消
+func Schedule(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		schedules := store.Default().Schedules()
+		writeJson(w, http.StatusOK, map[string]interface{}{"total": len(schedules), "schedules": schedules})
+	case http.MethodPost:
+		var req conf.ScheduleRequest
+		if err := decodeRequest(r, &req); err != nil {
+			errJsonMsg(w, r, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		sc, err := addSchedule(req, requestActor(r))
+		switch {
+		case errors.Is(err, errScheduleFile):
+			errJson(w, r, http.StatusNotFound, "file not found")
+			return
+		case errors.Is(err, errScheduleTime):
+			errJsonMsg(w, r, http.StatusBadRequest, "invalid schedule time")
+			return
+		case err != nil:
+			log.Printf("保存定时发送失败: %v", err)
+			errJsonMsg(w, r, http.StatusInternalServerError, "error")
+			return
+		}
+		auditTarget(r, sc.File, sc.ID)
+		writeJson(w, http.StatusOK, map[string]interface{}{"code": 1, "schedule": sc})
+	case http.MethodDelete:
+		auditAction(r, "unschedule")
+		id := r.URL.Query().Get("id")
+		sc, ok := store.Default().GetSchedule(id)
+		if !ok {
+			errJson(w, r, http.StatusNotFound, "Schedule not found")
+			return
+		}
+		if err := store.Default().DeleteSchedule(id); err != nil {
+			log.Printf("删除定时发送失败: %v", err)
+			errJsonMsg(w, r, http.StatusInternalServerError, "error")
+			return
+		}
+		auditTarget(r, sc.File, sc.ID)
+		writeJson(w, http.StatusOK, conf.UploadResponse{Code: 1, Message: "ok"})
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
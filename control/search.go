@@ -0,0 +1,100 @@
+package control
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// SearchRoute 文件搜索接口路径
+const SearchRoute = "/api/search"
+
+// 单次搜索最多返回的条数
+const searchMaxLimit = 500
+
+// fileInfo 生成文件信息响应
+func fileInfo(r *http.Request, f store.File) conf.FileInfo {
+	base := publicBaseUrl(r)
+	return conf.FileInfo{
+		ID:         f.ID,
+		Name:       f.Name,
+		Size:       f.Size,
+		Mime:       utils.TypeByName(f.Name),
+		Sha256:     f.Sha256,
+		Tenant:     f.Tenant,
+		CreatedAt:  f.CreatedAt,
+		Downloads:  f.Downloads,
+		LastAccess: f.LastAccess,
+		Duration:   f.Duration,
+		DeletedAt:  f.DeletedAt,
+		Pending:    f.Pending,
+		Visibility: f.Access(),
+		Url:        base + conf.FileRoute + f.ID,
+		View:       base + conf.ViewRoute + f.ID,
+	}
+}
+
+// matchFile 文件名包含关键字（不区分大小写）、ID 相同或 sha256 前缀至少 8 位匹配，关键字为空时全部匹配
+func matchFile(f store.File, raw string) bool {
+	keyword := strings.ToLower(raw)
+	return keyword == "" || strings.Contains(strings.ToLower(f.Name), keyword) ||
+		f.ID == raw || len(keyword) >= 8 && strings.HasPrefix(f.Sha256, keyword)
+}
+
+// Info 返回单个文件的信息
+func Info(w http.ResponseWriter, r *http.Request, id string) {
+	f, ok := store.Default().GetFile(id)
+	if !ok {
+		errJson(w, r, http.StatusNotFound, "File not found")
+		return
+	}
+	writeJson(w, http.StatusOK, fileInfo(r, f))
+}
+
+// Search 按文件名、ID 或 sha256 前缀搜索已登记的文件，按上传时间倒序
+//
+// 参数 q 为关键字（不区分大小写），tenant 限定租户，limit 与 offset 用于分页
+func Search(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	q := r.URL.Query()
+	raw := strings.TrimSpace(q.Get("q"))
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > searchMaxLimit {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(q.Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	tenantName, byTenant := q.Get("tenant"), q.Has("tenant")
+	var matched []store.File
+	for _, f := range store.Default().Files() {
+		if f.DeletedAt > 0 || byTenant && f.Tenant != tenantName {
+			continue
+		}
+		if !matchFile(f, raw) {
+			continue
+		}
+		matched = append(matched, f)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].CreatedAt != matched[j].CreatedAt {
+			return matched[i].CreatedAt > matched[j].CreatedAt
+		}
+		return matched[i].ID < matched[j].ID
+	})
+	res := conf.SearchResponse{Total: len(matched), Files: []conf.FileInfo{}}
+	for i := offset; i < len(matched) && i < offset+limit; i++ {
+		res.Files = append(res.Files, fileInfo(r, matched[i]))
+	}
+	writeJson(w, http.StatusOK, res)
+}
@@ -0,0 +1,140 @@
+package control
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+)
+
+// 默认的登录有效期
+const sessionDefaultTTL = 7 * 24 * time.Hour
+
+// 每个IP每分钟允许的登录尝试次数
+const loginRateLimit = 10
+
+// sessionTTL 登录有效期，可通过 session 参数以小时为单位配置
+func sessionTTL() time.Duration {
+	if conf.SessionHours > 0 {
+		return time.Duration(conf.SessionHours) * time.Hour
+	}
+	return sessionDefaultTTL
+}
+
+// sessionSignature 计算会话签名，密钥包含密码，修改密码后已有的登录全部失效
+func sessionSignature(subject, pass string, exp int64) string {
+	key := sha256.Sum256(append(append(signKey(), "\ntgstate-session\n"...), pass...))
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(subject + "\n" + strconv.FormatInt(exp, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newSession 生成会话令牌，格式为 过期时间.签名，subject 区分默认站点与各租户
+func newSession(subject, pass string) (string, time.Time) {
+	exp := time.Now().Add(sessionTTL())
+	return strconv.FormatInt(exp.Unix(), 10) + "." + sessionSignature(subject, pass, exp.Unix()), exp
+}
+
+// validSession 校验会话令牌的签名及有效期
+func validSession(token, subject, pass string) bool {
+	expStr, sig, ok := strings.Cut(token, ".")
+	if !ok || pass == "" {
+		return false
+	}
+	exp, err := strconv.ParseInt(expStr, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(sessionSignature(subject, pass, exp)))
+}
+
+// checkPass 以固定时间比较密码
+func checkPass(input, pass string) bool {
+	return pass != "" && subtle.ConstantTimeCompare([]byte(input), []byte(pass)) == 1
+}
+
+// isSecure 请求是否经 HTTPS 访问，用于决定 cookie 的 Secure 属性
+func isSecure(r *http.Request) bool {
+	return r.TLS != nil || strings.HasPrefix(publicBaseUrl(r), "https://")
+}
+
+// setSession 登录成功后写入会话 cookie，并在响应头中返回对应的 CSRF 令牌供接口客户端使用
+func setSession(w http.ResponseWriter, r *http.Request, name, path, subject, pass string) {
+	token, exp := newSession(subject, pass)
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    token,
+		Path:     conf.BasePath + path,
+		Expires:  exp,
+		MaxAge:   int(sessionTTL().Seconds()),
+		HttpOnly: true,
+		Secure:   isSecure(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.Header().Set(csrfHeader, csrfToken(token))
+}
+
+// loginLimited 统计登录尝试次数，超出限制时返回 429 并返回 true
+func loginLimited(w http.ResponseWriter, r *http.Request) bool {
+	window := time.Now().Unix() / 60
+	n, err := store.Default().Incr("login:"+clientIP(r)+":"+strconv.FormatInt(window, 10), time.Minute)
+	if err != nil {
+		log.Printf("登录计数失败: %v", err)
+		return false
+	}
+	if n > loginRateLimit {
+		w.Header().Set("Retry-After", strconv.FormatInt(60-time.Now().Unix()%60, 10))
+		http.Error(w, "Too many login attempts", http.StatusTooManyRequests)
+		return true
+	}
+	return false
+}
+
+// login 处理密码表单，密码正确时写入会话并跳转，错误时重新显示表单
+func login(w http.ResponseWriter, r *http.Request, prefix, cookie, subject, pass string) {
+	path := prefix
+	if path == "" {
+		path = "/"
+	}
+	if r.Method != http.MethodPost {
+		renderTemplate(w, r, "pwd.tmpl", pageData{Prefix: prefix, Csrf: loginCsrf(w, r, path)})
+		return
+	}
+	if loginLimited(w, r) {
+		return
+	}
+	actor := strings.TrimPrefix(subject, "t:")
+	if authLocked(r) {
+		authEvent(r, "pass", actor, false, "locked")
+		lockedResponse(w)
+		return
+	}
+	// 登录表单的令牌与 cookie 不一致时可能是其他站点提交的表单
+	if !loginCsrfValid(r) {
+		renderLogin(w, r, http.StatusForbidden, pageData{Prefix: prefix, Csrf: loginCsrf(w, r, path), Error: tr(r, "The form has expired, please try again")})
+		return
+	}
+	if !checkPass(r.FormValue("p"), pass) {
+		authFailed(r, "pass", actor, "wrong password")
+		renderLogin(w, r, http.StatusUnauthorized, pageData{Prefix: prefix, Csrf: loginCsrf(w, r, path), Error: tr(r, "Wrong password")})
+		return
+	}
+	authEvent(r, "pass", actor, true, "")
+	setSession(w, r, cookie, path, subject, pass)
+	http.Redirect(w, r, prefix+"/", http.StatusSeeOther)
+}
+
+// renderLogin 以指定状态码重新显示登录表单
+func renderLogin(w http.ResponseWriter, r *http.Request, status int, data pageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	renderTemplate(w, r, "pwd.tmpl", data)
+}
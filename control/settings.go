@@ -0,0 +1,228 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+)
+
+// SettingsRoute 运行时配置接口路径
+const SettingsRoute = "/api/admin/config"
+
+// setting 可在运行时修改的配置项
+type setting struct {
+	get func() interface{}
+	set func(v string) error
+}
+
+// settingsMu 保护可在运行时修改的配置，读取时使用下方的访问函数
+var settingsMu sync.RWMutex
+
+// maxUploadSize 当前的上传大小上限
+func maxUploadSize() int64 {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return conf.MaxUploadSize
+}
+
+// allowedExts 当前允许上传的扩展名
+func allowedExts() string {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return conf.AllowedExts
+}
+
+// rateLimit 当前每分钟的上传次数限制
+func rateLimit() int {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return conf.RateLimit
+}
+
+// maintenanceMode 当前的维护模式
+func maintenanceMode() string {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return conf.Maintenance
+}
+
+// maintenanceMessage 当前的维护提示
+func maintenanceMessage() string {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	return conf.MaintenanceMessage
+}
+
+// settings 可修改的配置项，键为接口及存储中使用的名称
+var settings = map[string]setting{
+	"max_upload_size": {
+		get: func() interface{} { return conf.MaxUploadSize },
+		set: func(v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err == nil && n < 0 {
+				err = strconv.ErrRange
+			}
+			if err == nil {
+				conf.MaxUploadSize = n
+			}
+			return err
+		},
+	},
+	"allowed_exts": {
+		get: func() interface{} { return conf.AllowedExts },
+		set: func(v string) error {
+			conf.AllowedExts = strings.TrimSpace(v)
+			return nil
+		},
+	},
+	"rate_limit": {
+		get: func() interface{} { return conf.RateLimit },
+		set: func(v string) error {
+			n, err := strconv.Atoi(v)
+			if err == nil {
+				conf.RateLimit = n
+			}
+			return err
+		},
+	},
+	"maintenance": {
+		get: func() interface{} { return conf.Maintenance },
+		set: func(v string) error {
+			m, ok := ParseMaintenance(v)
+			if !ok {
+				return strconv.ErrSyntax
+			}
+			conf.Maintenance = m
+			return nil
+		},
+	},
+	"maintenance_message": {
+		get: func() interface{} { return conf.MaintenanceMessage },
+		set: func(v string) error {
+			conf.MaintenanceMessage = strings.TrimSpace(v)
+			return nil
+		},
+	},
+}
+
+// settingsReloadInterval 使用 Redis 时重新读取配置的间隔，使其他实例的修改生效
+const settingsReloadInterval = 30 * time.Second
+
+// LoadSettings 启动时应用保存的运行时配置，覆盖命令行参数；使用 Redis 时定期重新读取
+func LoadSettings() {
+	applySettings(store.Default().Settings(), false)
+	if conf.RedisUrl == "" {
+		return
+	}
+	go func() {
+		for range time.Tick(settingsReloadInterval) {
+			applySettings(store.Default().Settings(), true)
+		}
+	}()
+}
+
+// applySettings 应用保存的配置，changedOnly 时只应用与当前值不同的项并记录日志
+func applySettings(saved map[string]string, changedOnly bool) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	for key, v := range saved {
+		s, ok := settings[key]
+		if !ok {
+			continue
+		}
+		old := fmt.Sprint(s.get())
+		if err := s.set(v); err != nil {
+			log.Printf("配置 %s 的值 %q 无效: %v", key, v, err)
+			continue
+		}
+		if changedOnly && fmt.Sprint(s.get()) != old {
+			log.Printf("配置 %s 已由其他实例修改为 %q", key, v)
+		}
+	}
+}
+
+// currentSettings 当前生效的配置
+func currentSettings() map[string]interface{} {
+	settingsMu.RLock()
+	defer settingsMu.RUnlock()
+	m := make(map[string]interface{}, len(settings))
+	for key, s := range settings {
+		m[key] = s.get()
+	}
+	return m
+}
+
+// Settings GET 查看运行时配置，POST 以 JSON 对象修改部分配置并保存到元数据存储
+func Settings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost, http.MethodPatch:
+		var req map[string]interface{}
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024))
+		dec.UseNumber()
+		if err := dec.Decode(&req); err != nil {
+			errJsonMsg(w, r, http.StatusBadRequest, "Invalid request")
+			return
+		}
+		keys := make([]string, 0, len(req))
+		for key := range req {
+			if _, ok := settings[key]; !ok {
+				errJsonMsg(w, r, http.StatusBadRequest, tr(r, "Unknown setting: %s", key))
+				return
+			}
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		auditTarget(r, strings.Join(keys, ","), "")
+		if key, ok := updateSettings(req, keys); !ok {
+			errJsonMsg(w, r, http.StatusBadRequest, tr(r, "Invalid value for %s", key))
+			return
+		}
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJson(w, http.StatusOK, currentSettings())
+}
+
+// updateSettings 按顺序修改并保存配置，遇到无效值时停止并返回该项
+func updateSettings(req map[string]interface{}, keys []string) (string, bool) {
+	settingsMu.Lock()
+	defer settingsMu.Unlock()
+	for _, key := range keys {
+		v := settingString(req[key])
+		if err := settings[key].set(v); err != nil {
+			return key, false
+		}
+		if err := store.Default().PutSetting(key, v); err != nil {
+			log.Printf("保存配置失败: %v", err)
+		}
+		log.Printf("配置 %s 已修改为 %q", key, v)
+	}
+	return "", true
+}
+
+// settingString 将 JSON 值转为字符串，null 视为空字符串
+func settingString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,96 @@
+package control
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// ShortRoute 短链接访问路径
+const ShortRoute = "/s/"
+
+var slugRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// ShortenAPI 创建短链接
+func ShortenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	var req conf.ShortenRequest
+	if err := decodeRequest(r, &req); err != nil {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	target := strings.TrimSpace(req.Url)
+	if !validShortTarget(target) {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid target url")
+		return
+	}
+	slug := req.Slug
+	if slug != "" && !slugRe.MatchString(slug) {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid slug")
+		return
+	}
+	s := store.Default()
+	custom := slug != ""
+	for i := 0; i < 5; i++ {
+		if !custom {
+			slug = utils.RandString(6)
+		}
+		err := s.PutLink(store.Link{Slug: slug, Target: target})
+		if err == nil {
+			auditTarget(r, slug, target)
+			link := ShortRoute + slug
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(conf.UploadResponse{
+				Code:    1,
+				Message: link,
+				ImgUrl:  strings.TrimSuffix(conf.BaseUrl, "/") + link,
+			})
+			return
+		}
+		if err != store.ErrExists {
+			log.Printf("保存短链接失败: %v", err)
+			errJsonMsg(w, r, http.StatusInternalServerError, "error")
+			return
+		}
+		if custom {
+			errJsonMsg(w, r, http.StatusConflict, "Slug already exists")
+			return
+		}
+	}
+	errJsonMsg(w, r, http.StatusInternalServerError, "error")
+}
+
+// validShortTarget 仅允许 http(s) 地址或站内路径
+func validShortTarget(target string) bool {
+	if strings.HasPrefix(target, "/") && !strings.HasPrefix(target, "//") {
+		return true
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Short 短链接跳转
+func Short(w http.ResponseWriter, r *http.Request) {
+	slug := PathValue(r, "slug")
+	link, ok := store.Default().GetLink(slug)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	store.Default().HitLink(slug)
+	http.Redirect(w, r, link.Target, http.StatusFound)
+}
@@ -0,0 +1,85 @@
+package control
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"csz.net/tgstate/conf"
+)
+
+// 签名地址的默认及最长有效期
+const (
+	signDefaultTTL = time.Hour
+	signMaxTTL     = 30 * 24 * time.Hour
+)
+
+// signKey 签名密钥，未配置时由 Bot Token 派生，重启后签名仍然有效
+func signKey() []byte {
+	if conf.SignKey != "" {
+		return []byte(conf.SignKey)
+	}
+	sum := sha256.Sum256([]byte("tgstate-sign:" + conf.BotToken))
+	return sum[:]
+}
+
+// fileSignature 计算文件ID与过期时间的签名
+func fileSignature(id string, exp int64) string {
+	mac := hmac.New(sha256.New, signKey())
+	mac.Write([]byte(id + "\n" + strconv.FormatInt(exp, 10)))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// signedQuery 生成签名参数 exp 与 sig
+func signedQuery(id string, exp int64) string {
+	q := url.Values{}
+	q.Set("exp", strconv.FormatInt(exp, 10))
+	q.Set("sig", fileSignature(id, exp))
+	return q.Encode()
+}
+
+// validSignature 校验请求中的签名参数，hasSig 表示请求是否带有签名
+func validSignature(r *http.Request, id string) (valid, hasSig bool) {
+	q := r.URL.Query()
+	sig, expStr := q.Get("sig"), q.Get("exp")
+	if sig == "" && expStr == "" {
+		return false, false
+	}
+	exp, err := strconv.ParseInt(expStr, 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false, true
+	}
+	return hmac.Equal([]byte(sig), []byte(fileSignature(id, exp))), true
+}
+
+// checkSignature 请求带有签名参数时校验签名，签名无效或已过期时返回 403 并返回 false
+func checkSignature(w http.ResponseWriter, r *http.Request, id string) bool {
+	if valid, hasSig := validSignature(r, id); hasSig && !valid {
+		http.Error(w, "Invalid or expired signature", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// Sign 生成带有效期的签名下载地址，expires 为有效秒数，默认 1 小时，最长 30 天
+func Sign(w http.ResponseWriter, r *http.Request, id string) {
+	ttl := signDefaultTTL
+	if v := r.URL.Query().Get("expires"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 || time.Duration(n)*time.Second > signMaxTTL {
+			errJsonMsg(w, r, http.StatusBadRequest, "Invalid expires")
+			return
+		}
+		ttl = time.Duration(n) * time.Second
+	}
+	exp := time.Now().Add(ttl).Unix()
+	writeJson(w, http.StatusOK, conf.SignResponse{
+		Code:    1,
+		Url:     publicBaseUrl(r) + conf.FileRoute + id + "?" + signedQuery(id, exp),
+		Expires: exp,
+	})
+}
@@ -0,0 +1,164 @@
+package control
+
+import (
+	"archive/zip"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// SiteRoute 静态网站访问路径，/site/{name}/{path...}
+const SiteRoute = "/site/"
+
+// SiteApiRoute 部署静态网站的接口路径，/api/site/{name}
+const SiteApiRoute = "/api/site/"
+
+// siteIndex 目录的默认页面
+const siteIndex = "index.html"
+
+// siteRoot 压缩包中的文件都位于同一个顶层目录时返回该目录，如打包 dist 文件夹生成的 dist/
+func siteRoot(files []*zip.File) string {
+	root := ""
+	for _, f := range files {
+		dir, _, ok := strings.Cut(f.Name, "/")
+		if !ok || (root != "" && dir != root) {
+			return ""
+		}
+		root = dir
+	}
+	if root == "" {
+		return ""
+	}
+	return root + "/"
+}
+
+// DeploySite 以请求体中的 zip 压缩包部署静态网站，逐个上传其中的文件，
+// 全部上传成功后才替换已有的网站，内容未变的文件不会重复上传
+func DeploySite(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	name := PathValue(r, "name")
+	if !slugRe.MatchString(name) {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid site name")
+		return
+	}
+	want, ok := uploadChecksum(r)
+	if !ok {
+		invalidChecksum(w, r)
+		return
+	}
+	zr, cleanup, ok := spoolZip(w, r, &countingReader{r: r.Body, limit: maxUploadSize(), want: want})
+	if !ok {
+		return
+	}
+	defer cleanup()
+	var files []*zip.File
+	for _, f := range zr.File {
+		if !zipSkip(f) {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid zip archive")
+		return
+	}
+	if len(files) > zipMaxEntries {
+		writeError(w, r, http.StatusRequestEntityTooLarge, http.StatusOK, "Too many files", map[string]int{"limit": zipMaxEntries})
+		return
+	}
+	root := siteRoot(files)
+	site := store.Site{Name: name, Files: make(map[string]store.SiteFile, len(files))}
+	res := zipResult{Files: []zipEntry{}}
+	failed := 0
+	for _, f := range files {
+		var entry zipEntry
+		p, ok := albumPath(strings.TrimPrefix(f.Name, root))
+		if ok {
+			entry = uploadZipEntry(r, f, uploadExts(), conf.ChannelName, "", "")
+		} else {
+			entry.Error = tr(r, "Invalid file path")
+		}
+		entry.Path = p
+		if entry.Error != "" {
+			failed++
+		} else {
+			site.Files[p] = store.SiteFile{ID: strings.TrimPrefix(entry.Url, conf.FileRoute), Size: entry.Size}
+		}
+		res.Files = append(res.Files, entry)
+	}
+	if failed > 0 {
+		res.Message = tr(r, "Site not published, %d files failed", failed)
+		writeJson(w, http.StatusOK, res)
+		return
+	}
+	if err := store.Default().PutSite(site); err != nil {
+		log.Printf("保存静态网站失败: %v", err)
+		errJsonMsg(w, r, http.StatusInternalServerError, "error")
+		return
+	}
+	auditTarget(r, name, name)
+	link := SiteRoute + name + "/"
+	res.Code, res.Message = 1, link
+	w.Header().Set("Location", publicBaseUrl(r)+link)
+	writeJson(w, http.StatusOK, res)
+}
+
+// Site 访问静态网站中的文件，目录返回其中的 index.html，内容按需从 Telegram 获取并缓存
+func Site(w http.ResponseWriter, r *http.Request) {
+	site, ok := store.Default().GetSite(PathValue(r, "name"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	p := PathValue(r, "path")
+	// 目录以 / 结尾，页面中的相对地址才能正确解析
+	if p == "" && !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+	if p == "" || strings.HasSuffix(p, "/") {
+		p += siteIndex
+	}
+	f, ok := site.Files[p]
+	if !ok {
+		if _, dir := site.Files[p+"/"+siteIndex]; dir {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+	// 相同内容可能以其他文件名上传过，内容类型按网站中的路径确定
+	if rec, ok := store.Default().GetFile(f.ID); ok && rec.ContentType == "" {
+		if mt := utils.TypeByName(path.Base(p)); mt != utils.TypeByName(rec.Name) {
+			w = &siteWriter{ResponseWriter: w, mime: mt}
+		}
+	}
+	serveFile(w, r, f.ID)
+}
+
+// siteWriter 发送响应头前将内容类型替换为按网站路径确定的类型，多段范围响应除外
+type siteWriter struct {
+	http.ResponseWriter
+	mime  string
+	wrote bool
+}
+
+func (sw *siteWriter) WriteHeader(code int) {
+	if !sw.wrote && (code == http.StatusOK || code == http.StatusPartialContent) && !strings.HasPrefix(sw.Header().Get("Content-Type"), "multipart/") {
+		sw.Header().Set("Content-Type", sw.mime)
+	}
+	sw.wrote = true
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *siteWriter) Write(b []byte) (int, error) {
+	if !sw.wrote {
+		sw.WriteHeader(http.StatusOK)
+	}
+	return sw.ResponseWriter.Write(b)
+}
@@ -0,0 +1,57 @@
+package control
+
+import (
+	"encoding/xml"
+	"net/http"
+	"time"
+
+	"csz.net/tgstate/conf"
+)
+
+// SitemapRoute 公开文件预览页面的站点地图地址
+const SitemapRoute = "/sitemap.xml"
+
+// sitemapMaxUrls 单个站点地图最多包含的地址数，协议规定不超过 50000
+const sitemapMaxUrls = 50000
+
+type sitemapUrlset struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Urls    []sitemapUrl `xml:"url"`
+}
+
+type sitemapUrl struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// sitemapEnabled 是否提供站点地图，开启 noindex 时同样不提供
+func sitemapEnabled() bool {
+	return conf.Sitemap && !conf.NoIndex
+}
+
+// Sitemap 输出公开文件的预览页面地址，新的在前
+func Sitemap(w http.ResponseWriter, r *http.Request) {
+	if !sitemapEnabled() {
+		http.NotFound(w, r)
+		return
+	}
+	base := publicBaseUrl(r)
+	set := sitemapUrlset{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9", Urls: []sitemapUrl{}}
+	for _, f := range publicFiles(false) {
+		if len(set.Urls) >= sitemapMaxUrls {
+			break
+		}
+		u := sitemapUrl{Loc: base + conf.ViewRoute + f.ID}
+		if f.CreatedAt > 0 {
+			u.LastMod = time.Unix(f.CreatedAt, 0).UTC().Format("2006-01-02")
+		}
+		set.Urls = append(set.Urls, u)
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(set)
+}
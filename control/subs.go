@@ -0,0 +1,139 @@
+package control
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/tenant"
+	"csz.net/tgstate/utils"
+)
+
+// subsSuffix 字幕的访问路径后缀，/d/{id}/subs
+const subsSuffix = "/subs"
+
+// srtTime 匹配 SRT 时间戳中的毫秒分隔符
+var srtTime = regexp.MustCompile(`(\d{2}:\d{2}:\d{2}),(\d{3})`)
+
+// toWebVTT 将 SRT 字幕转换为浏览器 <track> 支持的 WebVTT，已是 WebVTT 时原样返回
+func toWebVTT(data []byte) ([]byte, bool) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	if bytes.HasPrefix(data, []byte("WEBVTT")) {
+		return data, true
+	}
+	if !bytes.Contains(data, []byte("-->")) {
+		return nil, false
+	}
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if bytes.Contains(line, []byte("-->")) {
+			lines[i] = srtTime.ReplaceAll(line, []byte("$1.$2"))
+		}
+	}
+	return append([]byte("WEBVTT\n\n"), bytes.Join(lines, []byte("\n"))...), true
+}
+
+// Subs POST 以 subs 字段上传 .vtt 或 .srt 字幕并关联到视频，DELETE 删除关联的字幕
+func Subs(w http.ResponseWriter, r *http.Request, id string) {
+	st := store.Default()
+	f, ok := st.GetFile(id)
+	if !ok {
+		errJson(w, r, http.StatusNotFound, "File not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		part, header, err := r.FormFile("subs")
+		if err != nil {
+			errJsonMsg(w, r, http.StatusBadRequest, "Unable to get file")
+			return
+		}
+		defer part.Close()
+		ext := strings.ToLower(filepath.Ext(header.Filename))
+		if ext != ".vtt" && ext != ".srt" {
+			errJsonMsg(w, r, http.StatusUnsupportedMediaType, "Subtitles must be .vtt or .srt")
+			return
+		}
+		data, err := io.ReadAll(part)
+		if err != nil {
+			errJsonMsg(w, r, http.StatusBadRequest, "Unable to get file")
+			return
+		}
+		vtt, ok := toWebVTT(data)
+		if !ok {
+			errJsonMsg(w, r, http.StatusBadRequest, "Invalid subtitle file")
+			return
+		}
+		channel := conf.ChannelName
+		if t, ok := tenant.Get(f.Tenant); ok {
+			channel = t.Target
+		}
+		name := strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename)) + ".vtt"
+		subsID, err := utils.UpDocumentTo(channel, utils.TgFileData(name, bytes.NewReader(vtt)))
+		if err != nil {
+			log.Printf("上传字幕失败: %v", err)
+			errJsonMsg(w, r, http.StatusBadGateway, "Failed to upload to Telegram")
+			return
+		}
+		old := f.Subs
+		f.Subs = subsID
+		if err := st.PutFile(f); err != nil {
+			log.Printf("保存文件记录失败: %v", err)
+		}
+		if old != "" {
+			go discardUpload(old)
+		}
+		link := conf.FileRoute + id + subsSuffix
+		writeJson(w, http.StatusOK, conf.UploadResponse{Code: 1, Message: link, ImgUrl: publicBaseUrl(r) + link})
+	case http.MethodDelete:
+		if f.Subs == "" {
+			errJson(w, r, http.StatusNotFound, "No subtitles")
+			return
+		}
+		old := f.Subs
+		f.Subs = ""
+		if err := st.PutFile(f); err != nil {
+			log.Printf("保存文件记录失败: %v", err)
+		}
+		go discardUpload(old)
+		writeJson(w, http.StatusOK, conf.UploadResponse{Code: 1, Message: "ok"})
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveSubs 返回视频关联的 WebVTT 字幕
+func serveSubs(w http.ResponseWriter, r *http.Request, id string) {
+	if !checkAvailable(w, r, id) {
+		return
+	}
+	f, ok := store.Default().GetFile(id)
+	if !ok || f.Subs == "" {
+		http.NotFound(w, r)
+		return
+	}
+	filePath, err := getFileCache().getCachedFile(r.Context(), f.Subs)
+	if err != nil {
+		if r.Context().Err() == nil {
+			log.Printf("获取字幕失败: %v", err)
+		}
+		fetchError(w, r, err)
+		return
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(data)
+}
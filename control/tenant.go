@@ -0,0 +1,126 @@
+package control
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/tenant"
+)
+
+type tenantKey struct{}
+
+var (
+	tenantRoutes     *Router
+	tenantRoutesOnce sync.Once
+)
+
+// Tenant 处理 /t/{tenant}/ 下的请求，去掉前缀后按租户路由分发
+func Tenant(w http.ResponseWriter, r *http.Request) {
+	t, ok := tenant.Get(PathValue(r, "tenant"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	tenantRoutesOnce.Do(initTenantRoutes)
+	r2 := r.WithContext(context.WithValue(r.Context(), tenantKey{}, t))
+	tenantRoutes.serve(w, r2, "/"+PathValue(r, "path"))
+}
+
+// requestTenant 返回租户路由中的租户
+func requestTenant(r *http.Request) *tenant.Tenant {
+	return r.Context().Value(tenantKey{}).(*tenant.Tenant)
+}
+
+// initTenantRoutes 注册租户路由，下载与默认实例一致，无需认证
+func initTenantRoutes() {
+	rt := NewRouter()
+	rt.Handle(conf.FileRoute+"{id}", Maintenance(MaintenanceDownload, D), http.MethodGet)
+	rt.Handle(conf.FileRoute+"{id}"+subsSuffix, Maintenance(MaintenanceDownload, DSubs), http.MethodGet)
+	rt.Handle(conf.ViewRoute+"{id}", Maintenance(MaintenanceDownload, func(w http.ResponseWriter, r *http.Request) {
+		viewFile(w, r, PathValue(r, "id"), requestTenant(r).Prefix())
+	}), http.MethodGet)
+	rt.Handle(QrRoute+"{id}", func(w http.ResponseWriter, r *http.Request) {
+		qrFile(w, r, PathValue(r, "id"), requestTenant(r).Prefix())
+	}, http.MethodGet)
+	tenantRoutes = rt
+	// 只读镜像模式仅提供下载
+	if conf.Mode == "r" {
+		return
+	}
+	rt.Handle("/pwd", func(w http.ResponseWriter, r *http.Request) {
+		tenantPwd(w, r, requestTenant(r))
+	}, http.MethodGet, http.MethodPost)
+	rt.Handle("/api", tenantUpload, http.MethodPost)
+	rt.Handle(CheckRoute, func(w http.ResponseWriter, r *http.Request) {
+		if !tenantAuthorized(r, requestTenant(r)) {
+			errJsonMsg(w, r, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		RateLimit(SmallBody(Check))(w, r)
+	}, http.MethodPost)
+	rt.Handle("/", func(w http.ResponseWriter, r *http.Request) {
+		t := requestTenant(r)
+		if !tenantAuthorized(r, t) {
+			http.Redirect(w, r, t.Prefix()+"/pwd", http.StatusSeeOther)
+			return
+		}
+		renderIndex(w, r, pageData{Prefix: t.Prefix(), Csrf: sessionCsrf(r, t.CookieName())})
+	}, http.MethodGet)
+}
+
+// tenantUpload 上传到租户频道
+func tenantUpload(w http.ResponseWriter, r *http.Request) {
+	t := requestTenant(r)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if authLocked(r) {
+		lockedResponse(w)
+		return
+	}
+	if !tenantAuthorized(r, t) || !tenantCsrfValid(r, t) {
+		if r.Header.Get("X-Api-Key") != "" || r.URL.Query().Get("key") != "" {
+			authFailed(r, "apikey", t.Name, "invalid credentials")
+		} else if r.URL.Query().Get("pass") != "" {
+			authFailed(r, "pass", t.Name, "wrong password")
+		}
+		errJsonMsg(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	Maintenance(MaintenanceUpload, RateLimit(Audit("upload", UploadBody(func(w http.ResponseWriter, r *http.Request) {
+		auditActor(r, "tenant:"+t.Name)
+		uploadFile(w, r, t)
+	}))))(w, r)
+}
+
+// tenantAuthorized 校验租户密码 cookie、pass 参数或接口密钥
+func tenantAuthorized(r *http.Request, t *tenant.Tenant) bool {
+	if t.Open() {
+		return true
+	}
+	if t.CheckKey(r.Header.Get("X-Api-Key")) || t.CheckKey(r.URL.Query().Get("key")) {
+		return true
+	}
+	if t.CheckPass(r.URL.Query().Get("pass")) {
+		return true
+	}
+	if cookie, err := r.Cookie(t.CookieName()); err == nil && validSession(cookie.Value, "t:"+t.Name, t.Pass) {
+		return true
+	}
+	return false
+}
+
+// tenantCsrfValid 仅凭会话 cookie 认证的修改请求需要校验 CSRF 令牌
+func tenantCsrfValid(r *http.Request, t *tenant.Tenant) bool {
+	// 只有有效的接口密钥或密码参数才能跳过校验，仅携带这些参数不行
+	if t.Open() || t.CheckKey(r.Header.Get("X-Api-Key")) || t.CheckKey(r.URL.Query().Get("key")) || t.CheckPass(r.URL.Query().Get("pass")) {
+		return true
+	}
+	cookie, err := r.Cookie(t.CookieName())
+	return err != nil || csrfValid(r, cookie.Value)
+}
+
+// tenantPwd 租户密码页面
+func tenantPwd(w http.ResponseWriter, r *http.Request, t *tenant.Tenant) {
+	login(w, r, t.Prefix(), t.CookieName(), "t:"+t.Name, t.Pass)
+}
@@ -0,0 +1,193 @@
+package control
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"csz.net/tgstate/assets"
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/i18n"
+)
+
+// StaticRoute 主题静态资源路径
+const StaticRoute = "/static/"
+
+// ThemeLink 页脚链接
+type ThemeLink struct {
+	Name string `json:"name"`
+	Url  string `json:"url"`
+}
+
+// Theme 站点品牌配置，读取自 ThemeDir/theme.json
+type Theme struct {
+	Name        string      `json:"name"`         // 站点名称，用于页面标题
+	Logo        string      `json:"logo"`         // Logo 地址，如 /static/logo.png
+	Color       string      `json:"color"`        // 主色调
+	Css         string      `json:"css"`          // 额外样式表地址
+	FooterLinks []ThemeLink `json:"footer_links"` // 页脚链接
+}
+
+// defaultTheme 未配置主题时使用的默认值
+var defaultTheme = Theme{Name: "tgState", Color: "#007bff"}
+
+var theme = defaultTheme
+
+// LoadTheme 加载 ThemeDir 下的 theme.json，未配置的项使用默认值
+func LoadTheme() error {
+	theme = defaultTheme
+	if conf.ThemeDir == "" {
+		return nil
+	}
+	b, err := os.ReadFile(filepath.Join(conf.ThemeDir, "theme.json"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	t := defaultTheme
+	if err := json.Unmarshal(b, &t); err != nil {
+		return err
+	}
+	if t.Name == "" {
+		t.Name = defaultTheme.Name
+	}
+	if t.Color == "" {
+		t.Color = defaultTheme.Color
+	}
+	theme = t
+	return nil
+}
+
+// FaviconRoute 网站图标路径
+const FaviconRoute = "/favicon.ico"
+
+// staticMaxAge 静态资源的缓存时间，带版本号的地址可长期缓存
+const (
+	staticMaxAge          = 86400
+	staticVersionedMaxAge = 365 * 86400
+)
+
+// embeddedStatic 内置的静态资源
+var embeddedStatic, _ = fs.Sub(assets.Static, "static")
+
+// staticFS 优先读取 ThemeDir/static，不存在时使用内置资源
+type staticFS struct{}
+
+func (staticFS) Open(name string) (http.File, error) {
+	if conf.ThemeDir != "" {
+		if f, err := http.Dir(filepath.Join(conf.ThemeDir, "static")).Open(name); err == nil {
+			return f, nil
+		}
+	}
+	return http.FS(embeddedStatic).Open(name)
+}
+
+// Static 提供静态资源，主题目录中的同名文件覆盖内置资源
+func Static(w http.ResponseWriter, r *http.Request) {
+	name := PathValue(r, "path")
+	serveStatic(w, r, name)
+}
+
+// Favicon 网站图标，可在主题目录的 static 下覆盖
+func Favicon(w http.ResponseWriter, r *http.Request) {
+	serveStatic(w, r, "favicon.ico")
+}
+
+func serveStatic(w http.ResponseWriter, r *http.Request, name string) {
+	if name == "" || strings.HasSuffix(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := staticFS{}.Open(path.Clean("/" + name))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	maxAge := staticMaxAge
+	if r.URL.Query().Get("v") != "" {
+		maxAge = staticVersionedMaxAge
+	}
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(maxAge))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// staticVersions 静态资源内容哈希的缓存
+var staticVersions sync.Map
+
+// staticUrl 返回带内容哈希的静态资源地址，内容变化后地址随之变化
+func staticUrl(name string) string {
+	if v, ok := staticVersions.Load(name); ok {
+		return v.(string)
+	}
+	u := conf.BasePath + StaticRoute + name
+	if f, err := (staticFS{}).Open("/" + name); err == nil {
+		h := sha256.New()
+		io.Copy(h, f)
+		f.Close()
+		u += "?v=" + hex.EncodeToString(h.Sum(nil))[:10]
+	}
+	staticVersions.Store(name, u)
+	return u
+}
+
+// readTemplate 读取模板，ThemeDir/templates 下存在同名文件时优先使用
+func readTemplate(name string) ([]byte, error) {
+	if conf.ThemeDir != "" {
+		b, err := os.ReadFile(filepath.Join(conf.ThemeDir, "templates", name))
+		if err == nil {
+			return b, nil
+		} else if !os.IsNotExist(err) {
+			log.Printf("读取主题模板 %s 失败: %v", name, err)
+		}
+	}
+	return assets.Templates.ReadFile("templates/" + name)
+}
+
+// parseTemplates 依次解析模板，模板中可通过 theme 函数读取站点配置，通过 t 函数翻译为 lang
+func parseTemplates(lang string, names ...string) (*template.Template, error) {
+	tmpl := template.New("html").Funcs(template.FuncMap{
+		"theme":  func() Theme { return theme },
+		"lang":   func() string { return lang },
+		"static": staticUrl,
+		// base 子路径部署时的路径前缀，拼接在应用内的路径之前
+		"base": func() string { return conf.BasePath },
+		"datetime": func(ts int64) string {
+			return time.Unix(ts, 0).Format("2006-01-02 15:04:05")
+		},
+		"size": humanSize,
+		// explore 是否开启了公开图片列表页面
+		"explore": func() bool { return conf.Explore },
+		"t": func(msg string, args ...interface{}) string {
+			return i18n.T(lang, msg, args...)
+		},
+	})
+	for _, name := range names {
+		b, err := readTemplate(name)
+		if err != nil {
+			return nil, err
+		}
+		if tmpl, err = tmpl.Parse(string(b)); err != nil {
+			return nil, err
+		}
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,148 @@
+package control
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/conf"
+)
+
+// 分片大小范围，分片数量尽量控制在 torrentMaxPieces 以内
+const (
+	torrentMinPiece  = 256 * 1024
+	torrentMaxPiece  = 16 * 1024 * 1024
+	torrentMaxPieces = 2048
+)
+
+// Torrent 生成以本站下载地址作为 WebSeed (BEP 19) 的种子文件，生成结果缓存在数据目录
+func Torrent(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	dir := filepath.Join(conf.DataDir, "torrent")
+	path := filepath.Join(dir, id+".torrent")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// 同一文件只生成一次
+		unlock := getFileCache().lockFile("torrent:" + id)
+		defer unlock()
+		if data, err = os.ReadFile(path); err != nil {
+			data, err = makeTorrent(r, id)
+			if err != nil {
+				if r.Context().Err() == nil {
+					log.Printf("生成种子失败: %v", err)
+				}
+				http.Error(w, "Failed to create torrent", http.StatusInternalServerError)
+				return
+			}
+			os.MkdirAll(dir, 0755)
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				log.Printf("保存种子失败: %v", err)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-bittorrent")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+id+`.torrent"`)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+// makeTorrent 读取文件内容计算分片哈希
+func makeTorrent(r *http.Request, id string) ([]byte, error) {
+	content, err := openContent(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	pieceLen := int64(torrentMinPiece)
+	for pieceLen < torrentMaxPiece && content.Size/pieceLen > torrentMaxPieces {
+		pieceLen *= 2
+	}
+	var pieces bytes.Buffer
+	buf := make([]byte, pieceLen)
+	var total int64
+	for {
+		n, err := io.ReadFull(content, buf)
+		if n > 0 {
+			sum := sha1.Sum(buf[:n])
+			pieces.Write(sum[:])
+			total += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if total != content.Size {
+		return nil, fmt.Errorf("size mismatch: read %d, expected %d", total, content.Size)
+	}
+
+	torrent := map[string]interface{}{
+		"created by":    "tgState",
+		"creation date": time.Now().Unix(),
+		"url-list":      []interface{}{publicBaseUrl(r) + conf.FileRoute + id},
+		"info": map[string]interface{}{
+			"name":         content.Name,
+			"length":       total,
+			"piece length": pieceLen,
+			"pieces":       pieces.String(),
+		},
+	}
+	var trackers []interface{}
+	for _, t := range strings.Split(conf.TorrentTrackers, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			trackers = append(trackers, []interface{}{t})
+		}
+	}
+	if len(trackers) > 0 {
+		torrent["announce"] = trackers[0].([]interface{})[0]
+		torrent["announce-list"] = trackers
+	}
+	var out bytes.Buffer
+	bencode(&out, torrent)
+	return out.Bytes(), nil
+}
+
+// bencode 按 BitTorrent 的 bencode 格式编码，字典按键排序
+func bencode(b *bytes.Buffer, v interface{}) {
+	switch v := v.(type) {
+	case string:
+		b.WriteString(strconv.Itoa(len(v)))
+		b.WriteByte(':')
+		b.WriteString(v)
+	case int64:
+		b.WriteString("i" + strconv.FormatInt(v, 10) + "e")
+	case []interface{}:
+		b.WriteByte('l')
+		for _, item := range v {
+			bencode(b, item)
+		}
+		b.WriteByte('e')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b.WriteByte('d')
+		for _, k := range keys {
+			bencode(b, k)
+			bencode(b, v[k])
+		}
+		b.WriteByte('e')
+	}
+}
@@ -0,0 +1,39 @@
+package control
+
+import (
+	"fmt"
+	"net/http"
+
+	"csz.net/tgstate/tracing"
+)
+
+// Trace 为每个请求创建 span，沿用上游 traceparent，路由匹配后以路由模式命名，
+// 缓存、Telegram 请求等在请求的 span 下记录子 span
+func Trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !tracing.Enabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, span := tracing.Start(tracing.Extract(r.Context(), r.Header), r.Method, tracing.Server)
+		if span == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer span.End()
+		span.SetAttr("http.request.method", r.Method)
+		span.SetAttr("url.path", r.URL.Path)
+		span.SetAttr("client.address", clientIP(r))
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		span.SetAttr("http.response.status_code", sw.status)
+		span.SetAttr("http.response.body.size", sw.n)
+		span.SetAttr("request.id", sw.Header().Get("X-Request-Id"))
+		if sw.status >= 500 {
+			span.SetError(fmt.Errorf("HTTP %d", sw.status))
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// TrashRoute 回收站接口路径
+const TrashRoute = "/api/trash"
+
+// isTrashed 文件是否已移入回收站
+func isTrashed(id string) bool {
+	f, ok := store.Default().GetFile(strings.TrimPrefix(id, "blob-"))
+	return ok && f.DeletedAt > 0
+}
+
+// trashFile 将文件移入回收站：保留 Telegram 中的消息，清除缓存，链接返回 410
+func trashFile(id string) error {
+	st := store.Default()
+	f, ok := st.GetFile(id)
+	if !ok {
+		return errFileNotFound
+	}
+	f.DeletedAt = time.Now().Unix()
+	if err := st.PutFile(f); err != nil {
+		return err
+	}
+	for _, fid := range []string{id, f.Subs} {
+		if fid == "" {
+			continue
+		}
+		getFileCache().cleanupFile(fid)
+		getMemCache().remove(fid)
+		st.CacheDel("url:" + fid)
+	}
+	utils.Emit(utils.Event{
+		Event: utils.EventTrash,
+		ID:    id,
+		Name:  f.Name,
+		Size:  f.Size,
+		Url:   strings.TrimSuffix(conf.BaseUrl, "/") + conf.FileRoute + id,
+	})
+	return nil
+}
+
+// trashExpired 列出在回收站中超过保留天数的文件
+func trashExpired(days int) []store.File {
+	now := time.Now().Unix()
+	var files []store.File
+	for _, f := range store.Default().Files() {
+		if f.DeletedAt > 0 && now-f.DeletedAt >= int64(days)*86400 {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// purgeTrash 彻底删除回收站中超过 days 天的文件，返回删除的文件ID
+func purgeTrash(ctx context.Context, days int) []string {
+	var ids []string
+	for _, f := range trashExpired(days) {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := deleteFile(ctx, f.ID); err != nil && !errors.Is(err, utils.ErrNoMessage) {
+			log.Printf("清理回收站失败【%s】: %v", f.ID, err)
+			continue
+		}
+		ids = append(ids, f.ID)
+	}
+	return ids
+}
+
+// StartTrash 定期彻底删除回收站中超过保留天数的文件
+func StartTrash() {
+	if conf.TrashDays <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			if ids := purgeTrash(context.Background(), conf.TrashDays); len(ids) > 0 {
+				log.Printf("回收站：已彻底删除 %d 个文件", len(ids))
+				for _, id := range ids {
+					auditSystem("purge", id, "")
+				}
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Untrash 从回收站恢复文件
+func Untrash(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	st := store.Default()
+	f, ok := st.GetFile(id)
+	if !ok || f.DeletedAt == 0 {
+		errJson(w, r, http.StatusNotFound, "File not in trash")
+		return
+	}
+	f.DeletedAt = 0
+	if err := st.PutFile(f); err != nil {
+		log.Printf("保存文件记录失败: %v", err)
+		errJsonMsg(w, r, http.StatusInternalServerError, "error")
+		return
+	}
+	// 回收站期间哈希可能已指向同内容的新上传，此时保持不变
+	if f.Sha256 != "" {
+		st.PutHash(f.Sha256, id)
+	}
+	utils.Emit(utils.Event{
+		Event: utils.EventRestore,
+		ID:    id,
+		Name:  f.Name,
+		Size:  f.Size,
+		Url:   strings.TrimSuffix(conf.BaseUrl, "/") + conf.FileRoute + id,
+	})
+	writeJson(w, http.StatusOK, fileInfo(r, f))
+}
+
+// Trash GET 列出回收站中的文件（按删除时间倒序），DELETE 清空回收站
+func Trash(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		files := trashExpired(0)
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].DeletedAt > files[j].DeletedAt
+		})
+		res := conf.SearchResponse{Total: len(files), Files: []conf.FileInfo{}}
+		for _, f := range files {
+			res.Files = append(res.Files, fileInfo(r, f))
+		}
+		writeJson(w, http.StatusOK, res)
+	case http.MethodDelete:
+		ids := purgeTrash(r.Context(), 0)
+		auditTarget(r, "", strings.Join(ids, ","))
+		writeJson(w, http.StatusOK, map[string]int{"code": 1, "purged": len(ids)})
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
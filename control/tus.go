@@ -0,0 +1,400 @@
+package control
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// TusRoute tus 断点续传上传路径
+const TusRoute = "/api/tus/"
+
+const tusVersion = "1.0.0"
+
+// 未完成的上传保留时间
+const tusExpire = 24 * time.Hour
+
+var tusIDRe = regexp.MustCompile(`^[a-zA-Z0-9]{32}$`)
+
+// 数据接收完整后转存到 Telegram 的状态
+const (
+	tusStoring = "storing" // 正在转存
+	tusFailed  = "failed"  // 转存失败，HEAD 或空的 PATCH 会重新尝试
+)
+
+// tusUpload 上传状态，与数据文件一同保存在磁盘上
+type tusUpload struct {
+	ID       string `json:"id"`
+	Length   int64  `json:"length"`
+	Offset   int64  `json:"offset"`
+	Filename string `json:"filename"`
+	Url      string `json:"url"`
+	State    string `json:"state,omitempty"`
+	Error    string `json:"error,omitempty"` // 最近一次转存失败的原因
+}
+
+var (
+	tusLocks   = make(map[string]*sync.Mutex)
+	tusLocksMu sync.Mutex
+	tusOnce    sync.Once
+	// tusRunning 正在后台转存的上传，进程重启后状态为 storing 的上传可重新转存
+	tusRunning = make(map[string]bool)
+)
+
+func tusDir() string {
+	return filepath.Join(conf.DataDir, "tus")
+}
+
+// tusLock 获取单个上传的锁，避免同一上传被并发写入
+func tusLock(id string) *sync.Mutex {
+	tusLocksMu.Lock()
+	defer tusLocksMu.Unlock()
+	l, ok := tusLocks[id]
+	if !ok {
+		l = &sync.Mutex{}
+		tusLocks[id] = l
+	}
+	return l
+}
+
+func loadTusUpload(id string) (*tusUpload, error) {
+	b, err := os.ReadFile(filepath.Join(tusDir(), id+".info"))
+	if err != nil {
+		return nil, err
+	}
+	var u tusUpload
+	if err := json.Unmarshal(b, &u); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (u *tusUpload) save() error {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(tusDir(), u.ID+".info"), b, 0644)
+}
+
+func (u *tusUpload) dataPath() string {
+	return filepath.Join(tusDir(), u.ID+".bin")
+}
+
+// Tus 实现 tus 1.0.0 核心协议及 creation、termination 扩展
+func Tus(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", "*")
+	h.Set("Access-Control-Expose-Headers", "Location, Upload-Offset, Upload-Length, Tus-Resumable, Tus-Version, Tus-Extension, X-Tgstate-Url, X-Tgstate-State")
+	h.Set("Tus-Resumable", tusVersion)
+	if r.Method == http.MethodOptions {
+		h.Set("Access-Control-Allow-Methods", "POST, HEAD, PATCH, DELETE, OPTIONS")
+		h.Set("Access-Control-Allow-Headers", "Content-Type, Upload-Length, Upload-Offset, Upload-Metadata, Tus-Resumable, X-HTTP-Method-Override")
+		h.Set("Tus-Version", tusVersion)
+		h.Set("Tus-Extension", "creation,termination")
+		if n := uploadLimit(); n > 0 {
+			h.Set("Tus-Max-Size", strconv.FormatInt(n, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	Auth(AuthUpload, Audit("upload", tusHandle))(w, r)
+}
+
+func tusHandle(w http.ResponseWriter, r *http.Request) {
+	tusOnce.Do(func() {
+		os.MkdirAll(tusDir(), 0755)
+		go tusPeriodicCleanup()
+	})
+	method := r.Method
+	if override := r.Header.Get("X-HTTP-Method-Override"); override != "" && method == http.MethodPost {
+		method = override
+	}
+	if method != http.MethodGet && r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "Unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+	id := PathValue(r, "id")
+	if id == "" {
+		if method != http.MethodPost {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
+		tusCreate(w, r)
+		return
+	}
+	if !tusIDRe.MatchString(id) {
+		http.NotFound(w, r)
+		return
+	}
+	lock := tusLock(id)
+	lock.Lock()
+	defer lock.Unlock()
+	u, err := loadTusUpload(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch method {
+	case http.MethodHead:
+		// 数据已完整但转存失败或被中断时重新尝试
+		if u.Url == "" && u.Offset == u.Length {
+			tusStartFinish(u)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+		w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+		if u.Url != "" {
+			w.Header().Set("X-Tgstate-Url", u.Url)
+		}
+		if u.State != "" {
+			w.Header().Set("X-Tgstate-State", u.State)
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		// 非协议内容，用于查询上传完成后的外链
+		res := conf.UploadResponse{Code: 0, Message: "upload not finished"}
+		switch {
+		case u.Url != "":
+			res = conf.UploadResponse{Code: 1, Message: u.Url, ImgUrl: strings.TrimSuffix(conf.BaseUrl, "/") + u.Url}
+		case u.State == tusStoring:
+			res.Message = "storing"
+		case u.State == tusFailed:
+			res.Message = tr(r, "Failed to upload to Telegram") + ": " + u.Error
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res)
+	case http.MethodPatch:
+		tusPatch(w, r, u)
+		// 只记录传完最后一段的请求
+		if u.Offset < u.Length {
+			auditSkip(r)
+		}
+		auditTarget(r, u.ID, u.Filename)
+	case http.MethodDelete:
+		auditAction(r, "tus-delete")
+		auditTarget(r, u.ID, u.Filename)
+		if tusIsRunning(u.ID) {
+			http.Error(w, "Upload is being stored", http.StatusConflict)
+			return
+		}
+		os.Remove(u.dataPath())
+		os.Remove(filepath.Join(tusDir(), u.ID+".info"))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// tusCreate 创建新的上传
+func tusCreate(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if n := uploadLimit(); n > 0 && length > n {
+		http.Error(w, "Upload-Length exceeds limit", http.StatusRequestEntityTooLarge)
+		return
+	}
+	meta := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	filename := filepath.Base(meta["filename"])
+	if filename == "." || filename == "/" {
+		filename = "file"
+	}
+	u := &tusUpload{ID: utils.RandString(32), Length: length, Filename: filename}
+	f, err := os.Create(u.dataPath())
+	if err != nil {
+		log.Printf("创建上传文件失败: %v", err)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+	if err := u.save(); err != nil {
+		log.Printf("保存上传状态失败: %v", err)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	auditTarget(r, u.ID, filename)
+	w.Header().Set("Location", apiPath(r, TusRoute+u.ID))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// tusPatch 追加数据，上传完成后转存到 Telegram
+func tusPatch(w http.ResponseWriter, r *http.Request, u *tusUpload) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Invalid Content-Type", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != u.Offset {
+		http.Error(w, "Upload-Offset mismatch", http.StatusConflict)
+		return
+	}
+	if u.Url != "" {
+		http.Error(w, "Upload already finished", http.StatusForbidden)
+		return
+	}
+	if u.Offset >= u.Length {
+		// 数据已完整，重新尝试转存失败或被中断的上传
+		tusStartFinish(u)
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	f, err := os.OpenFile(u.dataPath(), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		http.Error(w, "Failed to open upload", http.StatusInternalServerError)
+		return
+	}
+	// 客户端中断时保留已写入的部分，便于续传
+	n, copyErr := io.Copy(f, io.LimitReader(r.Body, u.Length-u.Offset))
+	f.Close()
+	u.Offset += n
+	if err := u.save(); err != nil {
+		http.Error(w, "Failed to save upload", http.StatusInternalServerError)
+		return
+	}
+	if copyErr != nil {
+		log.Printf("接收上传数据中断: %v", copyErr)
+		return
+	}
+	if u.Offset == u.Length {
+		// 大文件转存耗时较长，在后台进行，客户端通过 HEAD 的 X-Tgstate-Url 获取结果
+		tusStartFinish(u)
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tusIsRunning 判断上传是否正在后台转存
+func tusIsRunning(id string) bool {
+	tusLocksMu.Lock()
+	defer tusLocksMu.Unlock()
+	return tusRunning[id]
+}
+
+// tusStartFinish 在后台开始转存，调用方需持有该上传的锁
+func tusStartFinish(u *tusUpload) {
+	tusLocksMu.Lock()
+	if tusRunning[u.ID] {
+		tusLocksMu.Unlock()
+		return
+	}
+	tusRunning[u.ID] = true
+	tusLocksMu.Unlock()
+	u.State, u.Error = tusStoring, ""
+	if err := u.save(); err != nil {
+		log.Printf("保存上传状态失败: %v", err)
+	}
+	go func(id, filename string, length int64) {
+		url, sha, err := tusFinish(id, filename, length)
+		lock := tusLock(id)
+		lock.Lock()
+		defer lock.Unlock()
+		tusLocksMu.Lock()
+		delete(tusRunning, id)
+		tusLocksMu.Unlock()
+		u, loadErr := loadTusUpload(id)
+		if loadErr != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("上传 %s 转存失败: %v", id, err)
+			u.State, u.Error = tusFailed, err.Error()
+		} else {
+			u.Url, u.State, u.Error = url, "", ""
+			if sha != "" {
+				recordUpload(store.File{ID: strings.TrimPrefix(url, conf.FileRoute), Name: filename, Size: length, Sha256: sha}, url)
+			}
+			os.Remove(u.dataPath())
+		}
+		if err := u.save(); err != nil {
+			log.Printf("保存上传状态失败: %v", err)
+		}
+	}(u.ID, u.Filename, u.Length)
+}
+
+// tusFinish 将完成的上传按分块存储到 Telegram，内容已存在时 sha 为空并返回已有文件
+func tusFinish(id, filename string, length int64) (url, sha string, err error) {
+	f, err := os.Open(filepath.Join(tusDir(), id+".bin"))
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	// 内容已存在时直接使用已有的文件
+	if res, ok := dedupResult(sum, "", "", ""); ok {
+		return res.Message, "", nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", "", err
+	}
+	fileID, err := utils.UpBlob(filename, f, length)
+	if err != nil {
+		return "", "", err
+	}
+	return conf.FileRoute + fileID, sum, nil
+}
+
+// parseTusMetadata 解析 Upload-Metadata 头，值为 base64 编码
+func parseTusMetadata(header string) map[string]string {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.Fields(pair)
+		if len(parts) == 0 {
+			continue
+		}
+		value := ""
+		if len(parts) > 1 {
+			if b, err := base64.StdEncoding.DecodeString(parts[1]); err == nil {
+				value = string(b)
+			}
+		}
+		meta[parts[0]] = value
+	}
+	return meta
+}
+
+// tusPeriodicCleanup 定期清理过期的上传
+func tusPeriodicCleanup() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		entries, err := os.ReadDir(tusDir())
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if tusIsRunning(strings.TrimSuffix(name, filepath.Ext(name))) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil || time.Since(info.ModTime()) < tusExpire {
+				continue
+			}
+			os.Remove(filepath.Join(tusDir(), name))
+		}
+	}
+}
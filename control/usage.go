@@ -0,0 +1,127 @@
+package control
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+)
+
+// UsageRoute 接口密钥的流量统计
+const UsageRoute = "/api/usage"
+
+// 统计周期的格式，按 UTC 划分
+const (
+	usageDay   = "2006-01-02"
+	usageMonth = "2006-01"
+)
+
+// usageID 流量记录中密钥的标识，元数据中不保存密钥本身
+func usageID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// countingBody 统计读取的请求体大小
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// KeyUsage 统计携带有效接口密钥的请求上传及下载的字节数，当天或当月的流量已达上限时返回 429；
+// 上限在请求开始时检查，进行中的请求不会被中断
+func KeyUsage(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := matchApiKey(r)
+		if key == "" || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		id, now := usageID(key), time.Now().UTC()
+		day, month := now.Format(usageDay), now.Format(usageMonth)
+		st := store.Default()
+		var period string
+		var limit, used int64
+		var reset time.Time
+		if u := st.GetUsage(id, day).Total(); conf.KeyDailyLimit > 0 && u >= conf.KeyDailyLimit {
+			period, limit, used = "day", conf.KeyDailyLimit, u
+			reset = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		} else if u := st.GetUsage(id, month).Total(); conf.KeyMonthlyLimit > 0 && u >= conf.KeyMonthlyLimit {
+			period, limit, used = "month", conf.KeyMonthlyLimit, u
+			reset = time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		}
+		if period != "" {
+			w.Header().Set("Retry-After", strconv.FormatInt(int64(reset.Sub(now).Seconds())+1, 10))
+			writeError(w, r, http.StatusTooManyRequests, http.StatusTooManyRequests, "Bandwidth quota exceeded", map[string]interface{}{"period": period, "limit": limit, "used": used})
+			return
+		}
+		body := &countingBody{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			st.AddUsage(id, day, body.n, sw.n)
+			st.AddUsage(id, month, body.n, sw.n)
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// Usage 返回接口密钥的流量统计，携带密钥时只返回该密钥，管理员返回全部密钥；
+// day 及 month 参数查询指定的日期（2006-01-02）或月份（2006-01），默认为当前 UTC 日期
+func Usage(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	day, month := now.Format(usageDay), now.Format(usageMonth)
+	if v := r.URL.Query().Get("day"); v != "" {
+		if _, err := time.Parse(usageDay, v); err != nil {
+			errJson(w, r, http.StatusBadRequest, "Invalid date")
+			return
+		}
+		day = v
+	}
+	if v := r.URL.Query().Get("month"); v != "" {
+		if _, err := time.Parse(usageMonth, v); err != nil {
+			errJson(w, r, http.StatusBadRequest, "Invalid date")
+			return
+		}
+		month = v
+	}
+	var keys []string
+	if key := matchApiKey(r); key != "" {
+		keys = []string{key}
+	} else if getAuthChain(AuthAdmin).allow(r) {
+		keys = apiKeys()
+	} else {
+		httpError(w, r, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	st := store.Default()
+	res := make([]conf.KeyUsage, 0, len(keys))
+	for _, k := range keys {
+		d, m := st.GetUsage(usageID(k), day), st.GetUsage(usageID(k), month)
+		res = append(res, conf.KeyUsage{
+			Key:           keyActor(k),
+			Day:           day,
+			DayUpload:     d.Upload,
+			DayDownload:   d.Download,
+			DayLimit:      conf.KeyDailyLimit,
+			Month:         month,
+			MonthUpload:   m.Upload,
+			MonthDownload: m.Download,
+			MonthLimit:    conf.KeyMonthlyLimit,
+		})
+	}
+	writeJson(w, http.StatusOK, res)
+}
@@ -0,0 +1,39 @@
+package control
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ApiV1Route 新版接口前缀，错误始终以 conf.ApiError 及对应的状态码返回
+const ApiV1Route = "/api/v1/"
+
+// V1Path 旧版接口路径对应的 /api/v1 路径，上传接口 /api 对应 /api/v1/upload
+func V1Path(p string) string {
+	if p == "/api" {
+		return ApiV1Route + "upload"
+	}
+	return ApiV1Route + strings.TrimPrefix(p, "/api/")
+}
+
+// isV1 是否为 /api/v1 下的请求
+func isV1(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, ApiV1Route)
+}
+
+// apiPath 返回与请求同一版本的接口路径，用于 Location 等响应头
+func apiPath(r *http.Request, p string) string {
+	if isV1(r) {
+		return V1Path(p)
+	}
+	return p
+}
+
+// Deprecated 标记已弃用的旧版接口，响应中附加 Deprecation 头及指向 /api/v1 的 Link 头
+func Deprecated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+V1Path(r.URL.Path)+`>; rel="successor-version"`)
+		next(w, r)
+	}
+}
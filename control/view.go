@@ -0,0 +1,154 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// fileMeta 文件的名称、大小及内容类型
+type fileMeta struct {
+	Name string
+	Size int64
+	Mime string
+}
+
+// statFile 读取文件元信息，分块文件只下载索引
+func statFile(ctx context.Context, id string) (*fileMeta, error) {
+	filePath, err := getFileCache().getCachedFile(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	if utils.IsBlobIndex(head[:n]) {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		idx, err := utils.ParseBlobIndex(data)
+		if err != nil {
+			return nil, err
+		}
+		mimeType := idx.Mime
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		return &fileMeta{Name: idx.Name, Size: idx.Size, Mime: mimeType}, nil
+	}
+	name := id
+	if rec, ok := store.Default().GetFile(id); ok && rec.Name != "" {
+		name = rec.Name
+	}
+	return &fileMeta{Name: name, Size: info.Size(), Mime: utils.DetectType(name, head[:n])}, nil
+}
+
+// viewData 预览页面数据
+type viewData struct {
+	pageData
+	ID        string
+	Name      string
+	Mime      string
+	Kind      string // image、video、audio、pdf 或 other
+	Size      string
+	Src       string // 下载地址
+	Url       string // 预览页面的完整地址，用于 Open Graph
+	SrcUrl    string // 下载的完整地址
+	Created   string
+	Sha256    string
+	Downloads int64
+	Subs      string // 字幕地址，没有字幕时为空
+}
+
+// View 文件预览页面
+func View(w http.ResponseWriter, r *http.Request) {
+	viewFile(w, r, PathValue(r, "id"), "")
+}
+
+// viewFile 渲染文件预览页面，prefix 为租户路径前缀
+func viewFile(w http.ResponseWriter, r *http.Request, id, prefix string) {
+	if id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if !checkAvailable(w, r, id) {
+		return
+	}
+	meta, err := statFile(r.Context(), id)
+	if err != nil {
+		if r.Context().Err() == nil {
+			log.Printf("读取文件信息失败: %v", err)
+		}
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	data := viewData{
+		pageData: pageData{Prefix: prefix},
+		ID:       id,
+		Name:     meta.Name,
+		Mime:     meta.Mime,
+		Kind:     mediaKind(meta.Mime),
+		Size:     humanSize(meta.Size),
+		Src:      prefix + conf.FileRoute + id,
+		Url:      publicBaseUrl(r) + prefix + conf.ViewRoute + id,
+		SrcUrl:   publicBaseUrl(r) + prefix + conf.FileRoute + id,
+	}
+	if rec, ok := store.Default().GetFile(id); ok {
+		if rec.CreatedAt > 0 {
+			data.Created = time.Unix(rec.CreatedAt, 0).Format("2006-01-02 15:04")
+		}
+		data.Sha256 = rec.Sha256
+		data.Downloads = rec.Downloads
+		if rec.Subs != "" {
+			data.Subs = data.Src + subsSuffix
+		}
+	}
+	renderTemplate(w, r, "view.tmpl", data)
+}
+
+// mediaKind 按内容类型选择预览方式
+func mediaKind(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return "image"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	case strings.HasPrefix(mimeType, "application/pdf"):
+		return "pdf"
+	}
+	return "other"
+}
+
+// humanSize 将字节数转为易读的大小
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 3; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGT"[exp])
+}
@@ -0,0 +1,43 @@
+package control
+
+import (
+	"net/http"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/tenant"
+)
+
+// uploadVisibility 上传请求的 visibility 参数，未指定时使用默认可见性，参数无效时返回 false
+func uploadVisibility(r *http.Request) (string, bool) {
+	v := r.URL.Query().Get("visibility")
+	if v == "" {
+		return defaultVisibility(), true
+	}
+	return v, store.ValidVisibility(v)
+}
+
+// defaultVisibility 配置的默认可见性
+func defaultVisibility() string {
+	if store.ValidVisibility(conf.Visibility) {
+		return conf.Visibility
+	}
+	return store.VisibilityUnlisted
+}
+
+func isPrivate(id string) bool {
+	f, ok := store.Default().GetFile(strings.TrimPrefix(id, "blob-"))
+	return ok && f.Access() == store.VisibilityPrivate
+}
+
+// canReadPrivate 私有文件需要有效的签名链接，或通过上传或管理分组的认证，租户的文件也可使用租户的凭据
+func canReadPrivate(r *http.Request, id string) bool {
+	if valid, _ := validSignature(r, id); valid {
+		return true
+	}
+	if t, ok := r.Context().Value(tenantKey{}).(*tenant.Tenant); ok {
+		return tenantAuthorized(r, t)
+	}
+	return getAuthChain(AuthUpload).allow(r) || getAuthChain(AuthAdmin).allow(r)
+}
@@ -0,0 +1,225 @@
+package control
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/tenant"
+	"csz.net/tgstate/utils"
+)
+
+// zipMaxEntries 单个压缩包最多展开的文件数
+const zipMaxEntries = 1000
+
+// zipEntry 压缩包中单个文件的上传结果
+type zipEntry struct {
+	Path   string `json:"path"`
+	Url    string `json:"url,omitempty"`
+	Slug   string `json:"slug,omitempty"` // 按相对路径生成的短链接
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// zipResult 展开上传的结果清单
+type zipResult struct {
+	Code    int        `json:"code"`
+	Message string     `json:"message"`
+	Files   []zipEntry `json:"files"`
+}
+
+var slugUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// zipSlug 将相对路径转为短链接名称，如 album/cat.jpg 转为 album-cat-jpg
+func zipSlug(name string) string {
+	slug := strings.Trim(slugUnsafe.ReplaceAllString(name, "-"), "-")
+	if len(slug) > 64 {
+		slug = strings.Trim(slug[len(slug)-64:], "-")
+	}
+	return slug
+}
+
+// zipSkip 忽略目录及系统生成的文件
+func zipSkip(f *zip.File) bool {
+	name := f.Name
+	base := path.Base(name)
+	return f.FileInfo().IsDir() || strings.HasPrefix(name, "__MACOSX/") || base == ".DS_Store" || base == "Thumbs.db"
+}
+
+// spoolZip 将压缩包暂存到临时文件后打开，失败时已返回错误响应；成功时调用方需要调用 cleanup 删除临时文件
+func spoolZip(w http.ResponseWriter, r *http.Request, file *countingReader) (zr *zip.Reader, cleanup func(), ok bool) {
+	tmp, err := os.CreateTemp("", "tgstate-zip-*")
+	if err != nil {
+		log.Printf("创建临时文件失败: %v", err)
+		errJsonMsg(w, r, http.StatusInternalServerError, "error")
+		return nil, nil, false
+	}
+	cleanup = func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	size, err := io.Copy(tmp, file)
+	if file.mismatch {
+		cleanup()
+		checksumError(w, r, file)
+		return nil, nil, false
+	}
+	if file.exceeded {
+		cleanup()
+		tooLargeError(w, r, file)
+		return nil, nil, false
+	}
+	if err != nil {
+		cleanup()
+		errJsonMsg(w, r, http.StatusBadRequest, "Unable to get file")
+		return nil, nil, false
+	}
+	if zr, err = zip.NewReader(tmp, size); err != nil {
+		cleanup()
+		errJsonMsg(w, r, http.StatusBadRequest, "Invalid zip archive")
+		return nil, nil, false
+	}
+	return zr, cleanup, true
+}
+
+// expandZip 暂存压缩包后逐个上传其中的文件，slugs=1 时按相对路径创建短链接
+func expandZip(w http.ResponseWriter, r *http.Request, file *countingReader, allowedExts, channel, prefix, tenantName string) {
+	zr, cleanup, ok := spoolZip(w, r, file)
+	if !ok {
+		return
+	}
+	defer cleanup()
+	slugs := r.URL.Query().Get("slugs") == "1"
+	res := zipResult{Files: []zipEntry{}}
+	uploaded := 0
+	for _, f := range zr.File {
+		if zipSkip(f) {
+			continue
+		}
+		if len(res.Files) >= zipMaxEntries {
+			res.Message = tr(r, "Only the first %d files were expanded", zipMaxEntries)
+			break
+		}
+		entry := uploadZipEntry(r, f, allowedExts, channel, prefix, tenantName)
+		if entry.Error == "" {
+			uploaded++
+			if slugs {
+				entry.Slug = zipSlug(path.Clean(f.Name))
+				if err := store.Default().PutLink(store.Link{Slug: entry.Slug, Target: entry.Url}); err != nil {
+					if err != store.ErrExists {
+						log.Printf("保存短链接失败: %v", err)
+					}
+					entry.Slug = ""
+				}
+			}
+		}
+		res.Files = append(res.Files, entry)
+	}
+	if uploaded > 0 {
+		res.Code = 1
+	}
+	if res.Message == "" {
+		res.Message = tr(r, "%d of %d files uploaded", uploaded, len(res.Files))
+	}
+	writeJson(w, http.StatusOK, res)
+}
+
+// uploadZipEntry 上传压缩包中的单个文件，内容已存在时直接返回已有地址
+func uploadZipEntry(r *http.Request, f *zip.File, allowedExts, channel, prefix, tenantName string) zipEntry {
+	name := path.Clean(f.Name)
+	entry := zipEntry{Path: name, Size: int64(f.UncompressedSize64)}
+	base := path.Base(name)
+	if allowedExts != "" && !extAllowed(base, allowedExts) {
+		entry.Error = tr(r, "Invalid file type. Only %s are allowed.", allowedExts)
+		return entry
+	}
+	limit := uploadLimit()
+	// 解压后的大小可能远超压缩包本身，逐个文件按租户的大小上限及剩余配额限制
+	if t, ok := tenant.Get(tenantName); ok {
+		if t.MaxFileSize > 0 && (limit == 0 || t.MaxFileSize < limit) {
+			limit = t.MaxFileSize
+		}
+		if t.Quota > 0 {
+			remaining := t.Quota - store.Default().TenantUsage(t.Name)
+			if remaining <= 0 || entry.Size > remaining {
+				entry.Error = tr(r, "Tenant storage quota exceeded")
+				return entry
+			}
+			if limit == 0 || remaining < limit {
+				limit = remaining
+			}
+		}
+	}
+	// 头部记录的大小不可信，读取时仍按 limit 限制
+	if limit > 0 && entry.Size > limit {
+		entry.Error = tr(r, "File size exceeds limit")
+		return entry
+	}
+	// 先计算内容哈希，重复的文件无需再次上传
+	rc, err := f.Open()
+	if err != nil {
+		entry.Error = tr(r, "Invalid zip archive")
+		return entry
+	}
+	h := sha256.New()
+	var src io.Reader = rc
+	if limit > 0 {
+		src = io.LimitReader(rc, limit+1)
+	}
+	n, err := io.Copy(h, src)
+	rc.Close()
+	if limit > 0 && n > limit {
+		entry.Error = tr(r, "File size exceeds limit")
+		return entry
+	}
+	if err != nil {
+		entry.Error = tr(r, "Invalid zip archive")
+		return entry
+	}
+	entry.Sha256 = hex.EncodeToString(h.Sum(nil))
+	visibility, _ := uploadVisibility(r)
+	if res, ok := dedupResult(entry.Sha256, prefix, tenantName, visibility); ok {
+		entry.Url = res.Message
+		if ifNew(r) {
+			entry.Error = tr(r, "File already exists")
+		}
+		return entry
+	}
+	if rc, err = f.Open(); err != nil {
+		entry.Error = tr(r, "Invalid zip archive")
+		return entry
+	}
+	defer rc.Close()
+	cr := &countingReader{r: rc, limit: limit}
+	job := utils.NewUploadJob(channel, base, cr)
+	job.Ctx = r.Context()
+	if err := utils.SubmitUpload(job); err != nil {
+		entry.Error = tr(r, "Upload queue is full")
+		return entry
+	}
+	select {
+	case <-job.Done():
+	case <-r.Context().Done():
+		// 客户端断开时取消尚未开始的任务
+		if !job.Cancel() {
+			<-job.Done()
+		}
+	}
+	res := uploadResult(store.File{ID: job.FileID, Name: base, Size: cr.n, Sha256: cr.sum(), Tenant: tenantName, Visibility: visibility}, prefix)
+	if res.Code != 1 {
+		entry.Error = tr(r, "Upload failed")
+		return entry
+	}
+	entry.Url = res.Message
+	entry.Size = cr.n
+	return entry
+}
@@ -0,0 +1,173 @@
+package fusefs
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/vfs"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// attrValid 内核缓存属性及目录项的时间
+const attrValid = 30 * time.Second
+
+// node 目录树中的文件或目录，每次访问时按 inode 从最新的目录树中查找，刷新后消失的返回 ENOENT
+type node struct {
+	fs.Inode
+	tree   *vfs.Tree
+	readAt ReadAtFunc
+	ino    uint64
+}
+
+var (
+	_ fs.NodeGetattrer = (*node)(nil)
+	_ fs.NodeLookuper  = (*node)(nil)
+	_ fs.NodeReaddirer = (*node)(nil)
+	_ fs.NodeOpener    = (*node)(nil)
+	_ fs.NodeReader    = (*node)(nil)
+	_ fs.NodeStatfser  = (*node)(nil)
+)
+
+// Mount 以只读方式挂载到 dir，阻塞到被卸载或收到退出信号为止。
+// root 用户直接挂载，其他用户需要安装 fusermount3 或 fusermount
+func Mount(dir string, readAt ReadAtFunc) error {
+	root := &node{tree: vfs.NewTree(store.Snapshot), readAt: readAt, ino: vfs.RootIno}
+	timeout := attrValid
+	server, err := fs.Mount(dir, root, &fs.Options{
+		MountOptions: fuse.MountOptions{
+			FsName:      "tgstate",
+			Name:        "tgstate",
+			Options:     []string{"ro", "default_permissions"},
+			DirectMount: true,
+		},
+		EntryTimeout: &timeout,
+		AttrTimeout:  &timeout,
+		UID:          uint32(os.Getuid()),
+		GID:          uint32(os.Getgid()),
+	})
+	if err != nil {
+		return err
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		for range sig {
+			// 仍有文件在使用时卸载失败，关闭后再次按 Ctrl+C
+			if err := server.Unmount(); err != nil {
+				log.Printf("卸载 %s 失败: %v", dir, err)
+			}
+		}
+	}()
+	log.Printf("已挂载到 %s，按 Ctrl+C 卸载", dir)
+	server.Wait()
+	return nil
+}
+
+func (n *node) get() *vfs.Node {
+	return n.tree.Get(n.ino)
+}
+
+// fillAttr 文件只读，属主为挂载的用户
+func fillAttr(v *vfs.Node, out *fuse.Attr) {
+	out.Ino = v.Ino
+	out.Size = uint64(v.Size)
+	out.Blocks = uint64((v.Size + 511) / 512)
+	out.Blksize = 4096
+	out.Mtime, out.Atime, out.Ctime = uint64(v.Mtime), uint64(v.Mtime), uint64(v.Mtime)
+	out.Mode, out.Nlink = syscall.S_IFREG|0444, 1
+	if v.Dir {
+		out.Mode, out.Nlink = syscall.S_IFDIR|0555, 2
+	}
+	out.Owner = fuse.Owner{Uid: uint32(os.Getuid()), Gid: uint32(os.Getgid())}
+}
+
+func (n *node) Getattr(ctx context.Context, fh fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	v := n.get()
+	if v == nil {
+		return syscall.ENOENT
+	}
+	fillAttr(v, &out.Attr)
+	return 0
+}
+
+func (n *node) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	v := n.get()
+	if v == nil || !v.Dir {
+		return nil, syscall.ENOENT
+	}
+	c := v.Child(name)
+	if c == nil {
+		return nil, syscall.ENOENT
+	}
+	fillAttr(c, &out.Attr)
+	child := &node{tree: n.tree, readAt: n.readAt, ino: c.Ino}
+	return n.NewInode(ctx, child, fs.StableAttr{Mode: out.Attr.Mode & syscall.S_IFMT, Ino: c.Ino}), 0
+}
+
+func (n *node) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	v := n.get()
+	if v == nil {
+		return nil, syscall.ENOENT
+	}
+	if !v.Dir {
+		return nil, syscall.ENOTDIR
+	}
+	entries := make([]fuse.DirEntry, 0, len(v.Children))
+	for _, c := range v.Children {
+		mode := uint32(syscall.S_IFREG)
+		if c.Dir {
+			mode = syscall.S_IFDIR
+		}
+		entries = append(entries, fuse.DirEntry{Name: c.Name, Ino: c.Ino, Mode: mode})
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+func (n *node) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	v := n.get()
+	switch {
+	case v == nil:
+		return nil, 0, syscall.ENOENT
+	case flags&syscall.O_ACCMODE != syscall.O_RDONLY:
+		return nil, 0, syscall.EROFS
+	case v.Dir:
+		return nil, 0, syscall.EISDIR
+	}
+	// 同一文件ID的内容不会变化，允许内核保留页缓存
+	return nil, fuse.FOPEN_KEEP_CACHE, 0
+}
+
+// Read 读取文件内容，可能需要从 Telegram 下载；偏移超过文件大小时返回空内容
+func (n *node) Read(ctx context.Context, fh fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
+	v := n.get()
+	if v == nil || v.Dir {
+		return nil, syscall.ENOENT
+	}
+	if off >= v.Size {
+		return fuse.ReadResultData(nil), 0
+	}
+	if rest := v.Size - off; int64(len(dest)) > rest {
+		dest = dest[:rest]
+	}
+	m, err := n.readAt(ctx, v.ID, dest, off)
+	if err != nil && err != io.EOF {
+		if ctx.Err() == nil {
+			log.Printf("读取 %s 失败: %v", v.Name, err)
+		}
+		return nil, syscall.EIO
+	}
+	return fuse.ReadResultData(dest[:m]), 0
+}
+
+func (n *node) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Errno {
+	out.Bsize, out.Frsize, out.NameLen = 4096, 4096, 255
+	return 0
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package fusefs
+
+import "errors"
+
+// Mount 挂载只支持 Linux
+func Mount(dir string, readAt ReadAtFunc) error {
+	return errors.New("挂载只支持 Linux")
+}
@@ -0,0 +1,7 @@
+// Package fusefs 以只读 FUSE 文件系统挂载元数据中登记的文件，内容按需从 Telegram 下载
+package fusefs
+
+import "context"
+
+// ReadAtFunc 从 off 开始读取文件内容，到达末尾时返回 io.EOF
+type ReadAtFunc func(ctx context.Context, id string, p []byte, off int64) (int, error)
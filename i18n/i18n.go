@@ -0,0 +1,96 @@
+package i18n
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 支持的语言
+const (
+	En = "en"
+	Zh = "zh"
+)
+
+// Default 未能从请求中识别语言时使用的语言，为空时接口返回英文原文
+var Default string
+
+// catalogs 各语言的消息目录，键为英文原文
+var catalogs = map[string]map[string]string{
+	Zh: zh,
+}
+
+// Supported 判断是否支持该语言
+func Supported(lang string) bool {
+	return lang == En || catalogs[lang] != nil
+}
+
+// Normalize 将 zh-CN、en_US 等语言标签转为支持的语言，不支持时返回空
+func Normalize(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	if Supported(tag) {
+		return tag
+	}
+	return ""
+}
+
+// FromRequest 依次按 lang 参数、lang cookie、Accept-Language 与默认语言识别请求的语言
+func FromRequest(r *http.Request) string {
+	if lang := Normalize(r.URL.Query().Get("lang")); lang != "" {
+		return lang
+	}
+	if c, err := r.Cookie("lang"); err == nil {
+		if lang := Normalize(c.Value); lang != "" {
+			return lang
+		}
+	}
+	if lang := acceptLanguage(r.Header.Get("Accept-Language")); lang != "" {
+		return lang
+	}
+	return Default
+}
+
+// acceptLanguage 按权重选出 Accept-Language 中第一个支持的语言
+func acceptLanguage(header string) string {
+	type tag struct {
+		lang string
+		q    float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if lang := Normalize(fields[0]); lang != "" && q > 0 {
+			tags = append(tags, tag{lang, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	if len(tags) == 0 {
+		return ""
+	}
+	return tags[0].lang
+}
+
+// T 将英文原文翻译为指定语言，args 不为空时按格式化字符串处理
+func T(lang, msg string, args ...interface{}) string {
+	if s, ok := catalogs[lang][msg]; ok {
+		msg = s
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
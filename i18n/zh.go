@@ -0,0 +1,180 @@
+package i18n
+
+// zh 中文消息目录
+var zh = map[string]string{
+	// 接口消息
+	"error":                                            "错误",
+	"Unauthorized":                                     "未授权",
+	"Invalid request":                                  "无效的请求",
+	"Invalid file id":                                  "无效的文件 ID",
+	"Unable to get file":                               "无法读取上传的文件",
+	"File size exceeds 20MB limit":                     "文件大小超过 20MB 限制",
+	"File size exceeds limit":                          "文件大小超过限制",
+	"File size exceeds tenant limit":                   "文件大小超过租户限制",
+	"Tenant storage quota exceeded":                    "租户存储配额已用完",
+	"Invalid file type. Only %s are allowed.":          "文件类型无效，仅允许 %s",
+	"Telegram rate limit reached, retry in %d seconds": "已达到 Telegram 发送频率限制，请 %d 秒后重试",
+	"Failed to upload to Telegram":                     "上传到 Telegram 失败",
+	"New anonymous upload waiting for moderation":      "新的匿名上传等待审核",
+	"New upload":                                       "新上传的文件",
+	"Tenant":                                           "租户",
+	"Delete":                                           "删除",
+	"Moved to trash":                                   "已移入回收站",
+	"Deleted":                                          "已删除",
+	"Approve":                                          "通过",
+	"Reject":                                           "拒绝",
+	"Approved":                                         "已通过",
+	"Rejected":                                         "已拒绝",
+	"Already handled":                                  "已处理",
+	"File is not pending moderation":                   "文件不在待审核列表中",
+	"Pending uploads":                                  "待审核的上传",
+	"File":                                             "文件",
+	"No uploads waiting for moderation":                "没有待审核的上传",
+	"Uploads are public only after an administrator approves them": "上传的文件需管理员审核通过后才能公开访问",
+	"File not in trash": "文件不在回收站中",
+	"File not found":    "文件不存在",
+	"Invalid expires":   "无效的有效期",
+	"Deleted locally, the Telegram message is unknown":      "已删除本地记录，Telegram 中的消息位置未知",
+	"Subtitles must be .vtt or .srt":                        "字幕文件必须为 .vtt 或 .srt",
+	"Invalid subtitle file":                                 "字幕文件格式无效",
+	"No subtitles":                                          "没有字幕",
+	"Invalid content type or header":                        "无效的内容类型或响应头",
+	"Failed to delete the Telegram message: %v":             "删除 Telegram 消息失败: %v",
+	"Invalid zip archive":                                   "无效的 zip 压缩包",
+	"Only the first %d files were expanded":                 "仅展开了前 %d 个文件",
+	"%d of %d files migrated":                               "已迁移 %d 个文件，共 %d 个",
+	"No urls to migrate":                                    "没有需要迁移的地址",
+	"At most %d urls per request":                           "每次最多迁移 %d 个地址",
+	"%d of %d files uploaded":                               "已上传 %d 个文件，共 %d 个",
+	"Upload queue is full":                                  "上传队列已满，请稍后重试",
+	"Uploads are paused for maintenance":                    "系统维护中，暂停上传",
+	"Downloads are temporarily unavailable for maintenance": "系统维护中，暂停下载",
+	"Content is empty":                                      "内容为空",
+	"Content exceeds 1MB limit":                             "内容超过 1MB 限制",
+	"Invalid language":                                      "无效的语言",
+	"Invalid slug":                                          "无效的短链接名称",
+	"Invalid target url":                                    "无效的目标地址",
+	"Slug already exists":                                   "短链接名称已存在",
+	"Blob index has no chunk sizes, cannot repair":          "分块清单缺少分块大小，无法修复",
+	"Invalid dump":                                          "无效的导出文件",
+	"IPFS node is not configured":                           "未配置 IPFS 节点",
+	"Failed to fetch content":                               "读取文件内容失败",
+	"No retention policy configured":                        "未配置保留策略",
+	"Unknown setting: %s":                                   "未知的配置项：%s",
+	"Invalid value for %s":                                  "配置项 %s 的值无效",
+
+	// 页面
+	"Upload files to Telegram":               "上传文件到 Telegram",
+	"Upload images to Telegram":              "上传图片到 Telegram",
+	"Drop files here or click to choose":     "拖放文件到此处或点击选择",
+	"Drop images here or click to choose":    "拖放图片到此处或点击选择",
+	"You can also paste from the clipboard":  "也可以直接从剪贴板粘贴",
+	"Toggle dark mode":                       "切换深色模式",
+	"Queued":                                 "等待上传",
+	"Uploading":                              "上传中",
+	"Processing":                             "处理中",
+	"Done":                                   "上传成功",
+	"Upload failed":                          "上传失败",
+	"Copied":                                 "复制成功",
+	"Paste text to Telegram":                 "粘贴文本到 Telegram",
+	"Paste code or logs here":                "在此粘贴代码或日志",
+	"Auto detect":                            "自动识别",
+	"Plain text":                             "纯文本",
+	"Save":                                   "保存",
+	"Saved: ":                                "保存成功：",
+	"Save failed":                            "保存失败",
+	"Download":                               "下载",
+	"Preview":                                "预览",
+	"Size":                                   "大小",
+	"Type":                                   "类型",
+	"Uploaded":                               "上传时间",
+	"Downloads":                              "下载次数",
+	"Subtitles":                              "字幕",
+	"View raw":                               "查看原文",
+	"The form has expired, please try again": "表单已过期，请重试",
+	"Wrong password":                         "密码错误",
+	"Admin":                                  "管理",
+	"Login activity":                         "登录记录",
+	"Time":                                   "时间",
+	"Method":                                 "方式",
+	"User":                                   "用户",
+	"Path":                                   "路径",
+	"Result":                                 "结果",
+	"Success":                                "成功",
+	"No login activity yet":                  "暂无登录记录",
+	"Enter password":                         "请输入密码",
+	"Submit":                                 "提交",
+	"Under maintenance":                      "维护中",
+	"Please try again later":                 "请稍后再试",
+	"only http and https urls are supported": "只支持 http 及 https 地址",
+	"file type not allowed":                  "不允许的文件类型",
+	"file size exceeds limit":                "文件大小超出限制",
+	"upload failed":                          "上传失败",
+	"private address not allowed":            "不允许访问内网地址",
+	"too many redirects":                     "重定向次数过多",
+	"file not found":                         "文件不存在",
+	"invalid schedule time":                  "无效的发送时间",
+	"Schedule not found":                     "定时发送不存在",
+	"Scheduled for":                          "将发送于",
+	"Usage: /schedule <file id or link> <time> [text]":            "用法：/schedule <文件ID或链接> <时间> [说明]",
+	"Disk cache is %s, above the %s threshold":                    "磁盘缓存已达 %s，超过告警阈值 %s",
+	"Sent %s in the last hour, above the %s threshold":            "最近一小时发送了 %s，超过告警阈值 %s",
+	"%d of %d responses in the last 5 minutes were server errors": "最近 5 分钟的 %[2]d 个响应中有 %[1]d 个服务器错误",
+	"404 page not found":         "404 页面不存在",
+	"Invalid request method":     "请求方式无效",
+	"Too many requests":          "请求过于频繁",
+	"Too many ids":               "链接数量过多",
+	"Upload history":             "上传记录",
+	"Clear":                      "清空",
+	"Saved only in this browser": "仅保存在当前浏览器中",
+	"Clear the upload history in this browser?": "清空当前浏览器中的上传记录？",
+	"Available":                           "可访问",
+	"Pending moderation":                  "待审核",
+	"Unavailable":                         "已失效",
+	"Album is empty":                      "相册中没有文件",
+	"Too many files":                      "文件数量过多",
+	"Invalid album name":                  "相册名称无效",
+	"Invalid file path":                   "文件路径无效",
+	"files":                               "个文件",
+	"Download as zip":                     "打包下载",
+	"Upload a folder":                     "上传文件夹",
+	"Album":                               "相册",
+	"Invalid visibility":                  "无效的可见性",
+	"File is private":                     "私有文件",
+	"Visibility":                          "可见性",
+	"Default visibility":                  "默认可见性",
+	"Public":                              "公开",
+	"Unlisted":                            "仅链接可见",
+	"Private":                             "私有",
+	"Explore":                             "发现",
+	"No public images yet":                "还没有公开的图片",
+	"Previous":                            "上一页",
+	"Next":                                "下一页",
+	"Recent public uploads":               "最近上传的公开文件",
+	"File already exists":                 "文件已存在",
+	"Invalid log name":                    "日志名称无效",
+	"Invalid file name":                   "文件名无效",
+	"Version not found":                   "版本不存在",
+	"File was modified by another upload": "文件已被其他上传修改",
+	"Invalid site name":                   "网站名称无效",
+	"Site not published, %d files failed": "网站未发布，%d 个文件上传失败",
+	"Invalid artifact path":               "构建产物路径无效",
+	"Artifact already exists":             "构建产物已发布，不能覆盖",
+	"Artifact not found":                  "构建产物不存在",
+	"File reference expired":              "文件引用已失效",
+	"Telegram no longer accepts the stored reference to this file or it is temporarily unavailable. It is being refreshed, please retry shortly.": "Telegram 不再接受保存的文件引用或文件暂时不可用，正在刷新，请稍后重试。",
+	"File no longer exists on Telegram": "文件在 Telegram 上已不存在",
+	"The Telegram message holding this file was deleted, so its content can no longer be downloaded.": "保存该文件的 Telegram 消息已被删除，无法再下载文件内容。",
+	"File too large to fetch": "文件过大，无法获取",
+	"Telegram refuses to hand files of this size to bots, so it cannot be downloaded through this server.": "Telegram 不允许机器人下载这么大的文件，无法通过本站下载。",
+	"Telegram is rate limiting requests": "Telegram 正在限流",
+	"Too many files were requested from Telegram at once. Please retry after the indicated time.": "同时向 Telegram 请求的文件过多，请在提示的时间后重试。",
+	"Telegram is unreachable": "无法连接 Telegram",
+	"The storage backend could not be reached. This is usually temporary, please retry shortly.": "无法连接存储后端，通常是暂时的，请稍后重试。",
+	"Please try again in %d seconds":                      "请在 %d 秒后重试",
+	"Please contact the site owner if you need this file": "如需该文件请联系站点管理员",
+	"Bandwidth quota exceeded":                            "流量已达上限",
+	"Invalid date":                                        "日期格式无效",
+	"Content checksum mismatch":                           "上传内容与 X-Content-Sha256 不一致",
+	"Invalid X-Content-Sha256 header":                     "X-Content-Sha256 请求头需为 64 位十六进制的 sha256",
+}
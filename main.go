@@ -2,48 +2,291 @@ package main
 
 //go run main.go -token=7722345745:AAF2yXMhJ7S7IdaF2Co7pCXn31LEpAHmSJs -target=@fffileCloudGroup -tgbotapiproxy=https://tgbot.barrierfree.ip-ddns.com
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"csz.net/tgstate/conf"
 	"csz.net/tgstate/control"
+	"csz.net/tgstate/fusefs"
+	"csz.net/tgstate/i18n"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/tenant"
+	"csz.net/tgstate/tracing"
 	"csz.net/tgstate/utils"
+	"github.com/quic-go/quic-go/http3"
 )
 
 var webPort string
+
+// 退出时等待进行中请求完成的最长时间
+const shutdownTimeout = 30 * time.Second
 var OptApi = true
 
+// 导入频道历史记录后退出
+var importFile, importVia string
+
+// 导出、恢复元数据后退出
+var exportFile, restoreFile string
+
+// 从其他图床迁移文件后退出
+var migrateFile, migrateOut string
+
 func main() {
+	// 导出、恢复元数据无需 Bot
+	if exportFile != "" {
+		if err := store.Default().ExportFile(exportFile); err != nil {
+			fmt.Println("导出失败:", err)
+			os.Exit(1)
+		}
+		fmt.Println("已导出到", exportFile)
+		return
+	}
+	if restoreFile != "" {
+		n, err := store.Default().ImportFile(restoreFile)
+		if err != nil {
+			fmt.Println("恢复失败:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("已恢复 %d 条记录\n", n)
+		return
+	}
+	// 只读挂载，只需要 Bot Token 用于下载
+	if flag.Arg(0) == "mount" {
+		if conf.BotToken == "" || flag.NArg() != 2 {
+			fmt.Println("用法: tgstate -token=<Bot Token> [其他选项] mount <挂载目录>")
+			os.Exit(2)
+		}
+		// 与服务进程共用数据目录，重新获取的文件ID等修改不写回 meta.json
+		store.SetReadOnly()
+		if err := fusefs.Mount(flag.Arg(1), control.ReadFileAt); err != nil {
+			fmt.Println("挂载失败:", err)
+			os.Exit(1)
+		}
+		return
+	}
 	//判断是否设置参数
-	if conf.BotToken == "" || conf.ChannelName == "" {
+	if conf.BotToken == "" || (conf.ChannelName == "" && conf.Mode != "r") {
 		fmt.Println("请先设置Bot Token和对象")
 		return
 	}
-	go utils.BotDo()
+	if importFile != "" {
+		n, err := utils.ImportHistory(importFile, importVia)
+		fmt.Printf("已导入 %d 个文件\n", n)
+		if err != nil {
+			fmt.Println("导入失败:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if migrateFile != "" {
+		n, err := control.MigrateList(migrateFile, migrateOut)
+		fmt.Printf("已迁移 %d 个文件，对照表已写入 %s\n", n, migrateOut)
+		if err != nil {
+			fmt.Println("迁移失败:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if conf.TenantsFile != "" {
+		if err := tenant.Load(conf.TenantsFile); err != nil {
+			fmt.Println("加载租户配置失败:", err)
+			return
+		}
+	}
+	if err := control.LoadTheme(); err != nil {
+		fmt.Println("加载主题失败:", err)
+		return
+	}
+	if err := control.LoadAuth(conf.Auth); err != nil {
+		fmt.Println("加载认证配置失败:", err)
+		return
+	}
+	control.LoadSettings()
+	control.CacheGC(conf.CacheGcDryRun)
+	// 只读镜像模式不启动bot，避免与主实例争抢消息及回复
+	if conf.Mode != "r" {
+		control.StartModeration()
+		control.StartInline()
+		control.StartBotUpload()
+		control.StartSchedule()
+		go utils.BotDo()
+		control.StartRetention()
+		control.StartTrash()
+		control.StartAlerts()
+	}
 	web()
 }
 
 func web() {
-	http.HandleFunc(conf.FileRoute, control.D)
+	if conf.OTLPEndpoint != "" {
+		tracing.Init(conf.OTLPEndpoint, envDefault("OTEL_SERVICE_NAME", "tgstate"), conf.OTLPHeaders)
+	}
+	mux := control.NewRouter()
+	get, post := http.MethodGet, http.MethodPost
+	download := func(h http.HandlerFunc) http.HandlerFunc {
+		return control.Compress(control.NoIndex(control.Maintenance(control.MaintenanceDownload, control.Auth(control.AuthDownload, h))))
+	}
+	mux.Handle(conf.FileRoute+"{id}", download(control.D), get)
+	mux.Handle(conf.FileRoute+"{id}/subs", download(control.DSubs), get)
+	mux.Handle(conf.ViewRoute+"{id}", download(control.View), get)
+	mux.Handle(control.HashRoute+"{sha}", download(control.Hash), get)
+	mux.Handle(control.PasteRoute+"{id}", download(control.Paste), get)
+	mux.Handle(control.PasteRoute+"{id}/raw", download(control.PasteRaw), get)
+	if conf.Explore {
+		mux.Handle(control.ExploreRoute, control.Compress(control.Maintenance(control.MaintenanceDownload, control.Auth(control.AuthDownload, control.Explore))), get)
+		mux.Handle(control.FeedRoute, control.Compress(control.Maintenance(control.MaintenanceDownload, control.Auth(control.AuthDownload, control.Feed))), get)
+	}
+	mux.Handle(control.AlbumRoute+"{id}", download(control.Album), get)
+	mux.Handle(control.AlbumRoute+"{id}/zip", download(control.AlbumZip), get)
+	mux.Handle(control.SiteRoute+"{name}/{path...}", download(control.Site), get)
+	mux.Handle(control.ListRoute+"{path...}", download(control.List), get)
+	mux.Handle(control.ArtifactRoute+"{name}", download(control.ArtifactIndex), get)
+	mux.Handle(control.ArtifactRoute+"{name}/{version}", download(control.ArtifactIndex), get)
+	mux.Handle(control.ArtifactRoute+"{name}/{version}/{file}", download(control.Artifact), get)
+	mux.Handle(control.ArtifactRoute+"{name}/{version}/{file}", control.Maintenance(control.MaintenanceUpload, control.RateLimit(control.Auth(control.AuthUpload, control.Audit("artifact", control.UploadBody(control.PublishArtifact))))), http.MethodPut)
+	if conf.RepoAlbum != "" {
+		mux.Handle(control.RepoRoute+"{path...}", download(control.Repo), get)
+	}
+	if conf.OCI {
+		mux.Handle(control.OCIRoute+"{path...}", control.OCI)
+	}
+	mux.Handle(control.ShortRoute+"{slug}", control.Maintenance(control.MaintenanceDownload, control.Auth(control.AuthDownload, control.Short)), get)
+	mux.Handle(control.QrRoute+"{id}", control.Qr, get)
+	mux.Handle(control.BadgeRoute+"{id}", control.Compress(control.Maintenance(control.MaintenanceDownload, control.Badge)), get)
+	// 接口同时注册在 /api/v1 下，旧路径作为弃用的别名保留
+	api := func(pattern string, h http.HandlerFunc, methods ...string) {
+		mux.Handle(control.V1Path(pattern), h, methods...)
+		mux.Handle(pattern, control.Deprecated(h), methods...)
+	}
+	fileApi := func(action string) http.HandlerFunc {
+		return control.Auth(control.AuthAdmin, control.Audit("file", control.FileApi(action)))
+	}
+	api(control.FileApiRoute+"{id}", fileApi(""), get, http.MethodDelete)
+	api(control.FileApiRoute+"{id}/sign", fileApi("sign"), get)
+	api(control.FileApiRoute+"{id}/headers", fileApi("headers"), get, http.MethodPut)
+	api(control.FileApiRoute+"{id}/subs", fileApi("subs"), post, http.MethodDelete)
+	api(control.FileApiRoute+"{id}/torrent", fileApi("torrent"), get)
+	api(control.FileApiRoute+"{id}/cid", fileApi("cid"), get, post)
+	api(control.FileApiRoute+"{id}/restore", fileApi("restore"), post)
+	api(control.FileApiRoute+"{id}/approve", fileApi("approve"), post)
+	api(control.FileApiRoute+"{id}/reject", fileApi("reject"), post)
+	api(control.NamedFileRoute+"{slug}", control.Maintenance(control.MaintenanceUpload, control.RateLimit(control.Auth(control.AuthUpload, control.Audit("overwrite", control.UploadBody(control.PutNamedFile))))), http.MethodPut)
+	api(control.NamedFileRoute+"{slug}/versions", control.Compress(control.Auth(control.AuthUpload, control.NamedFileVersions)), get)
+	api(control.NamedFileRoute+"{slug}/rollback", control.Compress(control.Auth(control.AuthUpload, control.Audit("rollback", control.SmallBody(control.RollbackNamedFile)))), post)
+	mux.Handle(control.StaticRoute+"{path...}", control.Compress(control.Static), get)
+	mux.Handle(control.RobotsRoute, control.Robots, get)
+	mux.Handle(control.SitemapRoute, control.Compress(control.Auth(control.AuthDownload, control.Sitemap)), get)
+	mux.Handle(control.FaviconRoute, control.Favicon, get)
+	api(control.HealthRoute, control.Health, get)
+	// 旧图床地址按迁移对照表跳转或直接返回文件
+	for _, prefix := range control.LegacyPrefixes() {
+		mux.Handle(prefix+"{path...}", download(control.Legacy), get)
+	}
+	if tenant.Enabled() {
+		mux.Handle(tenant.Route+"{tenant}/{path...}", control.Compress(control.NoIndex(control.Tenant)))
+	}
 	if OptApi {
 		if conf.Pass != "" && conf.Pass != "none" {
-			http.HandleFunc("/pwd", control.Pwd)
+			mux.Handle("/pwd", control.Compress(control.SmallBody(control.Pwd)), get, post)
 		}
-		http.HandleFunc("/api", control.Middleware(control.UploadImageAPI))
-		http.HandleFunc("/", control.Middleware(control.Index))
+		api("/api", control.Compress(control.Maintenance(control.MaintenanceUpload, control.RateLimit(control.AnonAuth(control.AuthUpload, control.Audit("upload", control.UploadBody(control.UploadImageAPI)))))), post)
+		api("/api/paste", control.Compress(control.Maintenance(control.MaintenanceUpload, control.RateLimit(control.Auth(control.AuthUpload, control.Audit("paste", control.SmallBody(control.PasteAPI)))))), post)
+		mux.Handle("/paste", control.Compress(control.Auth(control.AuthPage, control.PasteForm)), get)
+		api(control.AlbumApiRoute, control.Compress(control.Maintenance(control.MaintenanceUpload, control.RateLimit(control.AnonAuth(control.AuthUpload, control.Audit("album", control.SmallBody(control.AlbumAPI)))))), post)
+		api(control.AppendRoute+"{name}", control.Compress(control.Maintenance(control.MaintenanceUpload, control.RateLimit(control.Auth(control.AuthUpload, control.Audit("append", control.UploadBody(control.AppendLog)))))), post)
+		api(control.SiteApiRoute+"{name}", control.Compress(control.Maintenance(control.MaintenanceUpload, control.RateLimit(control.Auth(control.AuthUpload, control.Audit("site", control.UploadBody(control.DeploySite)))))), post)
+		api(control.CheckRoute, control.Compress(control.RateLimit(control.AnonAuth(control.AuthPage, control.SmallBody(control.Check)))), post)
+		api("/api/shorten", control.Compress(control.RateLimit(control.Auth(control.AuthUpload, control.Audit("shorten", control.SmallBody(control.ShortenAPI))))), post)
+		tus := control.Maintenance(control.MaintenanceUpload, control.RateLimit(control.Tus))
+		api(control.TusRoute, tus, post, http.MethodOptions)
+		api(control.TusRoute+"{id}", tus, get, http.MethodPatch, http.MethodDelete, post, http.MethodOptions)
+		api(control.PurgeRoute+"{id}", control.Compress(control.Auth(control.AuthAdmin, control.Audit("purge", control.Purge))), post)
+		api(control.RepairRoute+"{id}", control.Compress(control.Auth(control.AuthAdmin, control.Audit("repair", control.Repair))), get, post)
+		api(control.UploadJobRoute+"{job}", control.Compress(control.Auth(control.AuthUpload, control.UploadJob)), get)
+		api(control.ExportRoute, control.Compress(control.Auth(control.AuthAdmin, control.Export)), get)
+		api(control.RestoreRoute, control.Auth(control.AuthAdmin, control.Audit("import", control.Restore)), post)
+		api(control.SearchRoute, control.Compress(control.Auth(control.AuthAdmin, control.Timeout(control.Search))), get)
+		api(control.MigrateRoute, control.Compress(control.Maintenance(control.MaintenanceUpload, control.Auth(control.AuthUpload, control.Audit("migrate", control.SmallBody(control.Migrate))))), post)
+		api(control.SettingsRoute, control.Auth(control.AuthAdmin, control.Audit("settings", control.SmallBody(control.Settings))), get, post, http.MethodPatch)
+		api(control.ScheduleRoute, control.Compress(control.Auth(control.AuthAdmin, control.Audit("schedule", control.SmallBody(control.Schedule)))), get, post, http.MethodDelete)
+		api(control.TrashRoute, control.Compress(control.Auth(control.AuthAdmin, control.Audit("trash", control.Trash))), get, http.MethodDelete)
+		api(control.RetentionRoute, control.Compress(control.Auth(control.AuthAdmin, control.Audit("retention", control.Retention))), get, post)
+		api("/api/metrics", control.Compress(control.Auth(control.AuthAdmin, control.Metrics)), get)
+		api("/api/events", control.Auth(control.AuthAdmin, control.Events), get)
+		api(control.UsageRoute, control.Compress(control.Usage), get)
+		mux.Handle(control.DashboardRoute, control.Compress(control.Auth(control.AuthAdmin, control.Audit("moderate", control.SmallBody(control.Dashboard)))), get, post)
+		api(control.AuthLogRoute, control.Compress(control.Auth(control.AuthAdmin, control.Timeout(control.AuthLog))), get)
+		api(control.ModerationRoute, control.Compress(control.Auth(control.AuthAdmin, control.Timeout(control.Moderation))), get)
+		api(control.DebugRoute+"{name...}", control.Auth(control.AuthAdmin, control.Debug), get)
+		api(control.AuditLogRoute, control.Compress(control.Auth(control.AuthAdmin, control.AuditLog)), get)
+		mux.Handle("/api/openapi.json", control.Compress(control.OpenAPI), get)
+		mux.Handle("/api/docs", control.Compress(control.ApiDocs), get)
+		mux.Handle("/", control.Compress(control.AnonAuth(control.AuthPage, control.Index)), get)
 	}
 
-	if listener, err := net.Listen("tcp", ":"+webPort); err != nil {
-		fmt.Printf("端口 %s 已被占用\n", webPort)
+	mode, _ := strconv.ParseUint(conf.SocketMode, 8, 32)
+	if listener, addr, err := utils.Listen(webPort, conf.Socket, os.FileMode(mode)); err != nil {
+		fmt.Printf("监听 %s 失败: %v\n", addr, err)
 	} else {
 		defer listener.Close()
-		fmt.Printf("启动Web服务器，监听端口 %s\n", webPort)
-		if err := http.Serve(listener, nil); err != nil {
+		fmt.Printf("启动Web服务器，监听 %s\n", addr)
+		server := &http.Server{
+			Handler:           control.BasePath(control.Observe(control.Trace(control.RequestID(control.KeyUsage(control.Recover(mux)))))),
+			ReadHeaderTimeout: time.Duration(conf.ReadHeaderTimeout) * time.Second,
+			ReadTimeout:       time.Duration(conf.ReadTimeout) * time.Second,
+			WriteTimeout:      time.Duration(conf.WriteTimeout) * time.Second,
+			IdleTimeout:       time.Duration(conf.IdleTimeout) * time.Second,
+		}
+		var h3 *http3.Server
+		if conf.HTTP3 {
+			// HTTP/3 使用与 port 相同的 UDP 端口，HTTPS 响应通过 Alt-Svc 告知客户端
+			h3 = &http3.Server{Addr: ":" + webPort, Handler: server.Handler}
+			next := server.Handler
+			server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				h3.SetQuicHeaders(w.Header())
+				next.ServeHTTP(w, r)
+			})
+			go func() {
+				if err := h3.ListenAndServeTLS(conf.TLSCert, conf.TLSKey); err != nil && err != http.ErrServerClosed {
+					fmt.Println("HTTP/3 服务启动失败:", err)
+				}
+			}()
+			fmt.Printf("启动HTTP/3服务，监听 UDP 端口 %s\n", webPort)
+		}
+		// 收到退出信号后停止接受新连接，等待进行中的请求完成，配合套接字激活实现不中断重启
+		done := make(chan struct{})
+		go func() {
+			sig := make(chan os.Signal, 1)
+			signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+			<-sig
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			if err := server.Shutdown(ctx); err != nil {
+				fmt.Println("关闭Web服务器超时:", err)
+			}
+			if h3 != nil {
+				h3.Close()
+			}
+			close(done)
+		}()
+		serve := server.Serve
+		if conf.TLSCert != "" {
+			// 启用 TLS 后 net/http 会自动协商 HTTP/2
+			serve = func(l net.Listener) error { return server.ServeTLS(l, conf.TLSCert, conf.TLSKey) }
+		}
+		if err := serve(listener); err != http.ErrServerClosed {
 			fmt.Println(err)
+			return
 		}
+		<-done
 	}
 }
 
@@ -55,12 +298,160 @@ func init() {
 	flag.StringVar(&conf.Mode, "mode", os.Getenv("mode"), "Run mode")
 	flag.StringVar(&conf.BaseUrl, "url", os.Getenv("url"), "Base Url")
 	flag.StringVar(&conf.TgBotApiProxy, "tgbotapiproxy", os.Getenv("tgbotapiproxy"), "Telegram Bot API Proxy")
+	flag.StringVar(&conf.DataDir, "data", envDefault("data", "data"), "Metadata Directory")
+	flag.StringVar(&conf.WebhookUrl, "webhook", os.Getenv("webhook"), "Webhook Url")
+	flag.StringVar(&conf.WebhookSecret, "webhooksecret", os.Getenv("webhooksecret"), "Webhook HMAC Secret")
+	flag.IntVar(&conf.WebhookDownloads, "webhookdownloads", envInt("webhookdownloads", 0), "Send a download webhook every N downloads")
+	flag.StringVar(&conf.TenantsFile, "tenants", os.Getenv("tenants"), "Tenants Config File")
+	flag.StringVar(&conf.RedisUrl, "redis", os.Getenv("redis"), "Redis Url, e.g. redis://localhost:6379/0")
+	flag.IntVar(&conf.RateLimit, "ratelimit", envInt("ratelimit", 0), "Uploads per minute per IP, 0 for unlimited")
+	flag.BoolVar(&conf.TrustProxy, "trustproxy", os.Getenv("trustproxy") == "true", "Trust X-Forwarded-For from reverse proxy")
+	flag.StringVar(&conf.CfZone, "cfzone", os.Getenv("cfzone"), "Cloudflare Zone ID for cache purge")
+	flag.StringVar(&conf.CfToken, "cftoken", os.Getenv("cftoken"), "Cloudflare API Token for cache purge")
+	flag.StringVar(&conf.Redirect, "redirect", envDefault("redirect", "off"), "Download redirect mode: off, on or param")
+	flag.StringVar(&conf.RedirectProxy, "redirectproxy", os.Getenv("redirectproxy"), "Rewriting proxy for download redirects")
+	flag.IntVar(&conf.UploadConcurrency, "uploadconcurrency", envInt("uploadconcurrency", 4), "Concurrent uploads to Telegram")
+	flag.IntVar(&conf.UploadQueue, "uploadqueue", envInt("uploadqueue", 64), "Upload queue size")
+	flag.IntVar(&conf.UploadWait, "uploadwait", envInt("uploadwait", 30), "Seconds to wait in queue before answering 202, 0 to always wait")
+	flag.Int64Var(&conf.ChunkSize, "chunksize", int64(envInt("chunksize", 19*1024*1024)), "Blob chunk size in bytes, 1MB to 20MB")
+	flag.StringVar(&conf.MirrorChannel, "mirror", os.Getenv("mirror"), "Backup channel to copy every upload to")
+	flag.StringVar(&conf.AdminChat, "adminchat", os.Getenv("adminchat"), "Telegram chat ID of the admin for moderation and notifications")
+	flag.Int64Var(&conf.AlertCache, "alertcache", int64(envInt("alertcache", 0)), "Notify adminchat when the disk cache exceeds N bytes, 0 to disable")
+	flag.Int64Var(&conf.AlertBandwidth, "alertbandwidth", int64(envInt("alertbandwidth", 0)), "Notify adminchat when more than N bytes were sent in the last hour, 0 to disable")
+	flag.IntVar(&conf.AlertErrors, "alerterrors", envInt("alerterrors", 0), "Notify adminchat when more than N percent of responses in 5 minutes are 5xx, 0 to disable")
+	flag.StringVar(&conf.ModChat, "modchat", os.Getenv("modchat"), "Telegram chat to post new uploads to with Approve/Reject/Delete buttons")
+	flag.StringVar(&conf.BotUsers, "botusers", os.Getenv("botusers"), "Comma separated Telegram user IDs allowed to use bot commands and inline queries")
+	flag.BoolVar(&conf.AnonUpload, "anonupload", os.Getenv("anonupload") == "true", "Allow uploads without login, held for moderation until approved")
+	flag.IntVar(&conf.SessionHours, "session", envInt("session", 0), "Hours a password login stays valid, 0 for 7 days")
+	flag.IntVar(&conf.LoginMaxFails, "loginmaxfails", envInt("loginmaxfails", 5), "Failed logins within 15 minutes before an IP is locked out, 0 to disable")
+	flag.IntVar(&conf.LoginLockout, "loginlockout", envInt("loginlockout", 15), "Minutes an IP stays locked out after too many failed logins")
+	flag.StringVar(&conf.Auth, "auth", os.Getenv("auth"), "Auth chain per route group, e.g. upload=pass,apikey;download=sign")
+	flag.StringVar(&conf.ApiKeys, "apikeys", os.Getenv("apikeys"), "Comma separated API keys for the apikey auth method")
+	flag.StringVar(&conf.AuthIPs, "authips", os.Getenv("authips"), "Comma separated addresses or CIDRs for the ip auth method")
+	flag.StringVar(&conf.OidcIssuer, "oidcissuer", os.Getenv("oidcissuer"), "OIDC issuer URL for the oidc auth method")
+	flag.StringVar(&conf.OidcAllow, "oidcallow", os.Getenv("oidcallow"), "Comma separated subjects, verified emails or @domains allowed by the oidc auth method")
+	flag.BoolVar(&conf.LogRanges, "logranges", os.Getenv("logranges") == "true", "Log the requested ranges of every 206 response")
+	flag.Int64Var(&conf.MemCacheSize, "memcache", int64(envInt("memcache", 64*1024*1024)), "Bytes of memory used to cache files under 1MB, 0 to disable")
+	flag.BoolVar(&conf.CacheGcDryRun, "cachegcdryrun", os.Getenv("cachegcdryrun") == "true", "Only log stale cache files on startup instead of deleting them")
+	flag.IntVar(&conf.UpstreamConnectTimeout, "upstreamconnecttimeout", envInt("upstreamconnecttimeout", 10), "Seconds to connect to Telegram file servers")
+	flag.IntVar(&conf.UpstreamReadTimeout, "upstreamreadtimeout", envInt("upstreamreadtimeout", 60), "Seconds without data before an upstream download is aborted, 0 to disable")
+	flag.IntVar(&conf.UpstreamMaxIdleConns, "upstreamidleconns", envInt("upstreamidleconns", 16), "Idle connections kept per upstream host")
+	flag.IntVar(&conf.UpstreamMaxConns, "upstreammaxconns", envInt("upstreammaxconns", 0), "Max connections per upstream host, 0 for unlimited")
+	flag.StringVar(&conf.TorrentTrackers, "trackers", os.Getenv("trackers"), "Comma separated tracker urls written to generated torrents")
+	flag.StringVar(&conf.IpfsApi, "ipfsapi", os.Getenv("ipfsapi"), "IPFS node HTTP API for pinning, e.g. http://127.0.0.1:5001")
+	flag.IntVar(&conf.RetentionDays, "retention", envInt("retention", 0), "Delete files uploaded more than N days ago, 0 to keep forever")
+	flag.IntVar(&conf.RetentionIdleDays, "retentionidle", envInt("retentionidle", 0), "Delete files not downloaded for N days, 0 to keep forever")
+	flag.IntVar(&conf.TrashDays, "trash", envInt("trash", 7), "Keep deleted files in the trash for N days before purging, 0 to delete immediately")
+	flag.BoolVar(&conf.RetentionDryRun, "retentiondryrun", os.Getenv("retentiondryrun") == "true", "Only log files the retention policy would delete")
+	flag.Int64Var(&conf.MaxUploadSize, "maxsize", int64(envInt("maxsize", 0)), "Max upload size in bytes, 0 for unlimited")
+	flag.StringVar(&conf.AllowedExts, "exts", os.Getenv("exts"), "Comma separated allowed upload extensions, e.g. .jpg,.png")
+	flag.StringVar(&conf.Maintenance, "maintenance", envDefault("maintenance", "off"), "Maintenance mode: off, upload, download or all")
+	flag.StringVar(&conf.MaintenanceMessage, "maintenancemsg", os.Getenv("maintenancemsg"), "Message shown while in maintenance mode")
+	flag.StringVar(&conf.Lang, "lang", os.Getenv("lang"), "Default language for pages and API messages: en or zh")
+	flag.BoolVar(&conf.NoIndex, "noindex", os.Getenv("noindex") == "true", "Ask search engines not to index files")
+	flag.StringVar(&conf.RobotsFile, "robots", os.Getenv("robots"), "Custom robots.txt file")
+	flag.IntVar(&conf.ReadHeaderTimeout, "readheadertimeout", envInt("readheadertimeout", 10), "Seconds to read request headers")
+	flag.IntVar(&conf.ReadTimeout, "readtimeout", envInt("readtimeout", 0), "Seconds to read a whole request, 0 for unlimited")
+	flag.IntVar(&conf.WriteTimeout, "writetimeout", envInt("writetimeout", 0), "Seconds to write a response, 0 for unlimited")
+	flag.IntVar(&conf.IdleTimeout, "idletimeout", envInt("idletimeout", 120), "Seconds to keep idle connections open")
+	flag.IntVar(&conf.ApiTimeout, "apitimeout", envInt("apitimeout", 30), "Seconds to handle read-only JSON API requests, 0 for unlimited")
+	flag.IntVar(&conf.TelegramRate, "tgrate", envInt("tgrate", 20), "Files sent to one chat per minute, 0 for unlimited")
+	flag.IntVar(&conf.TelegramMaxWait, "tgmaxwait", envInt("tgmaxwait", 120), "Reject uploads expected to wait longer than N seconds for the send budget, 0 to always queue")
+	flag.StringVar(&conf.SignKey, "signkey", os.Getenv("signkey"), "Secret for signed download urls, derived from the bot token when empty")
+	flag.StringVar(&conf.ThemeDir, "theme", os.Getenv("theme"), "Theme directory overriding templates, static files and branding")
+	flag.BoolVar(&conf.LegacyErrors, "legacyerrors", os.Getenv("legacyerrors") != "false", "Answer errors of unversioned endpoints with HTTP 200 and code 0, /api/v1 always uses status codes")
+	flag.StringVar(&conf.Visibility, "visibility", envDefault("visibility", store.VisibilityUnlisted), "Default visibility of uploads: public, unlisted (link only) or private (requires auth or a signed link)")
+	flag.BoolVar(&conf.Sitemap, "sitemap", os.Getenv("sitemap") != "false", "Serve /sitemap.xml with the viewer pages of public files")
+	flag.BoolVar(&conf.Explore, "explore", os.Getenv("explore") == "true", "Enable the /explore page and /feed.xml listing recent public uploads")
+	flag.BoolVar(&conf.OCI, "oci", os.Getenv("oci") == "true", "Experimental: serve a minimal OCI registry API at /v2/ storing images in Telegram")
+	flag.StringVar(&conf.RepoAlbum, "repo", os.Getenv("repo"), "Album ID whose .deb and .rpm files are served with APT and YUM indexes at /repo/")
+	flag.StringVar(&conf.OTLPEndpoint, "otlp", envDefault("otlp", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")), "OTLP/HTTP endpoint receiving request traces, e.g. http://localhost:4318, empty to disable tracing")
+	flag.StringVar(&conf.OTLPHeaders, "otlpheaders", envDefault("otlpheaders", os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")), "Comma separated key=value headers sent with exported traces")
+	flag.Int64Var(&conf.KeyDailyLimit, "keydaily", int64(envInt("keydaily", 0)), "Daily upload plus download bytes allowed per API key, 0 for unlimited")
+	flag.Int64Var(&conf.KeyMonthlyLimit, "keymonthly", int64(envInt("keymonthly", 0)), "Monthly upload plus download bytes allowed per API key, 0 for unlimited")
+	flag.StringVar(&conf.Socket, "socket", os.Getenv("socket"), "Listen on this Unix socket path instead of the TCP port")
+	flag.StringVar(&conf.SocketMode, "socketmode", envDefault("socketmode", "660"), "Octal permissions of the Unix socket file")
+	flag.StringVar(&conf.TLSCert, "tlscert", os.Getenv("tlscert"), "TLS certificate file, serves HTTPS with HTTP/2 together with tlskey")
+	flag.StringVar(&conf.TLSKey, "tlskey", os.Getenv("tlskey"), "TLS private key file")
+	flag.BoolVar(&conf.HTTP3, "http3", os.Getenv("http3") == "1", "Also serve HTTP/3 over QUIC on the UDP port, requires TLS")
+	flag.StringVar(&conf.DownloadReplicas, "replicas", os.Getenv("replicas"), "Comma separated extra Bot API proxies serving the same files, used for parallel chunk downloads")
+	flag.IntVar(&conf.BlobParallel, "blobparallel", envInt("blobparallel", 1), "Chunks of a large file downloaded in parallel")
+	flag.StringVar(&conf.BasePath, "basepath", os.Getenv("basepath"), "Path prefix when served under a sub-path, e.g. /tg")
+	flag.BoolVar(&conf.Compress, "compress", os.Getenv("compress") != "false", "Enable br/gzip/deflate response compression")
+	flag.StringVar(&importFile, "import", "", "Import files from a Telegram Desktop channel export (result.json) and exit")
+	flag.StringVar(&importVia, "importvia", "", "Chat to forward messages to while importing, defaults to the target channel")
+	flag.StringVar(&exportFile, "export", "", "Export metadata to a JSON (or .csv) file and exit")
+	flag.StringVar(&migrateFile, "migrate", "", "Migrate files from a list of urls (one per line) to Telegram and exit")
+	flag.StringVar(&migrateOut, "migrateout", "migrate.tsv", "Old to new url mapping file written by -migrate")
+	flag.StringVar(&conf.LegacyPaths, "legacy", os.Getenv("legacy"), "Comma separated legacy path prefixes (e.g. /images/) redirected to migrated files, must not overlap built-in routes")
+	flag.BoolVar(&conf.LegacyProxy, "legacyproxy", os.Getenv("legacyproxy") == "true", "Serve migrated files at legacy urls instead of redirecting")
+	flag.BoolVar(&conf.MigratePrivate, "migrateprivate", os.Getenv("migrateprivate") == "true", "Allow migrating from private, loopback and link-local addresses")
+	flag.StringVar(&restoreFile, "restore", "", "Restore metadata from an exported JSON file and exit")
 	flag.Parse()
 	
-	if conf.Mode == "m" {
+	if conf.ChunkSize < utils.MinChunkSize || conf.ChunkSize > utils.MaxChunkSize {
+		fmt.Printf("chunksize 需在 %d 到 %d 字节之间\n", utils.MinChunkSize, utils.MaxChunkSize)
+		os.Exit(1)
+	}
+	if m, ok := control.ParseMaintenance(conf.Maintenance); ok {
+		conf.Maintenance = m
+	} else {
+		fmt.Println("maintenance 需为 off、upload、download 或 all")
+		os.Exit(1)
+	}
+	conf.BasePath = control.CleanBasePath(conf.BasePath)
+	if _, err := strconv.ParseUint(conf.SocketMode, 8, 32); err != nil {
+		fmt.Println("socketmode 需为八进制权限，如 660")
+		os.Exit(1)
+	}
+	if (conf.TLSCert == "") != (conf.TLSKey == "") {
+		fmt.Println("tlscert 与 tlskey 需同时设置")
+		os.Exit(1)
+	}
+	if conf.OidcIssuer != "" && conf.OidcAllow == "" {
+		fmt.Println("oidcissuer 需同时设置 oidcallow，否则该身份提供方的任何账号都能通过认证")
+		os.Exit(1)
+	}
+	if conf.HTTP3 && conf.TLSCert == "" {
+		fmt.Println("http3 需要同时设置 tlscert 与 tlskey")
+		os.Exit(1)
+	}
+	if conf.Lang != "" {
+		if i18n.Default = i18n.Normalize(conf.Lang); i18n.Default == "" {
+			fmt.Println("lang 需为 en 或 zh")
+			os.Exit(1)
+		}
+	}
+	if !store.ValidVisibility(conf.Visibility) {
+		fmt.Println("visibility 需为 public、unlisted 或 private")
+		os.Exit(1)
+	}
+	if conf.Redirect != "on" && conf.Redirect != "param" {
+		conf.Redirect = "off"
+	}
+	if conf.Redirect != "off" && conf.RedirectProxy == "" {
+		fmt.Println("警告：未设置 redirectproxy，重定向地址中将包含 Bot Token")
+	}
+	if conf.Mode == "m" || conf.Mode == "r" {
 		OptApi = false
 	}
-	if conf.Mode != "p" && conf.Mode != "m" {
+	if conf.Mode != "p" && conf.Mode != "m" && conf.Mode != "r" {
 		conf.Mode = "p"
 	}
 }
+
+// envDefault 读取环境变量，未设置时返回默认值
+func envDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envInt 读取整数环境变量，未设置或无效时返回默认值
+func envInt(key string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
+	}
+	return def
+}
@@ -0,0 +1,138 @@
+// Package metrics 简单的运行指标收集，以 Prometheus 文本格式输出
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type metric struct {
+	typ   string
+	help  string
+	value int64
+	fn    func() int64
+}
+
+var (
+	registry = make(map[string]*metric)
+	mu       sync.RWMutex
+)
+
+// baseName 去掉标签部分，如 a_total{code="200"} -> a_total
+func baseName(name string) string {
+	if i := strings.Index(name, "{"); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+func get(name, typ string) *metric {
+	mu.RLock()
+	m, ok := registry[name]
+	mu.RUnlock()
+	if ok {
+		return m
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if m, ok = registry[name]; !ok {
+		m = &metric{typ: typ}
+		registry[name] = m
+	}
+	return m
+}
+
+// Add 计数器增加 n，名称可带标签
+func Add(name string, n int64) {
+	atomic.AddInt64(&get(name, "counter").value, n)
+}
+
+// Inc 计数器加一
+func Inc(name string) {
+	Add(name, 1)
+}
+
+// Gauge 注册实时取值的指标
+func Gauge(name, help string, fn func() int64) {
+	m := get(name, "gauge")
+	mu.Lock()
+	m.help, m.fn = help, fn
+	mu.Unlock()
+}
+
+// Help 设置指标说明
+func Help(name, help string) {
+	m := get(name, "counter")
+	mu.Lock()
+	m.help = help
+	mu.Unlock()
+}
+
+// Value 读取指标当前值
+func Value(name string) int64 {
+	mu.RLock()
+	m, ok := registry[name]
+	mu.RUnlock()
+	if !ok {
+		return 0
+	}
+	if m.fn != nil {
+		return m.fn()
+	}
+	return atomic.LoadInt64(&m.value)
+}
+
+// Values 读取全部指标的当前值
+func Values() map[string]int64 {
+	mu.RLock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	mu.RUnlock()
+	values := make(map[string]int64, len(names))
+	for _, name := range names {
+		values[name] = Value(name)
+	}
+	return values
+}
+
+// Write 以 Prometheus 文本格式输出全部指标
+func Write(w io.Writer) {
+	mu.RLock()
+	names := make([]string, 0, len(registry))
+	labeled := make(map[string]bool)
+	for name := range registry {
+		names = append(names, name)
+		if base := baseName(name); base != name {
+			labeled[base] = true
+		}
+	}
+	mu.RUnlock()
+	sort.Strings(names)
+	described := make(map[string]bool)
+	for _, name := range names {
+		mu.RLock()
+		m := registry[name]
+		typ, help := m.typ, m.help
+		mu.RUnlock()
+		base := baseName(name)
+		if !described[base] {
+			described[base] = true
+			if help != "" {
+				fmt.Fprintf(w, "# HELP %s %s\n", base, help)
+			}
+			fmt.Fprintf(w, "# TYPE %s %s\n", base, typ)
+		}
+		v := Value(name)
+		// 只用于设置说明的无标签计数器，已有带标签的数据时不输出
+		if name == base && labeled[base] && typ == "counter" && v == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%s %d\n", name, v)
+	}
+}
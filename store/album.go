@@ -0,0 +1,60 @@
+package store
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Album 文件夹上传生成的相册，按相对路径记录其中的文件
+type Album struct {
+	ID        string      `json:"id"`
+	Name      string      `json:"name"` // 所选文件夹的名称
+	Tenant    string      `json:"tenant,omitempty"`
+	Files     []AlbumFile `json:"files"`
+	CreatedAt int64       `json:"created_at"`
+}
+
+// AlbumFile 相册中的文件
+type AlbumFile struct {
+	Path string `json:"path"` // 相对于所选文件夹的路径，如 2024/cat.jpg
+	ID   string `json:"id"`
+	Size int64  `json:"size"`
+}
+
+// GetAlbum 获取相册
+func (s *Store) GetAlbum(id string) (Album, bool) {
+	var a Album
+	ok := s.getJSON(kindAlbums, id, &a)
+	return a, ok
+}
+
+// PutAlbum 新增相册，ID 已存在时返回 ErrExists
+func (s *Store) PutAlbum(a Album) error {
+	if a.CreatedAt == 0 {
+		a.CreatedAt = time.Now().Unix()
+	}
+	return s.b.update(kindAlbums, a.ID, func(old []byte) ([]byte, error) {
+		if old != nil {
+			return nil, ErrExists
+		}
+		return json.Marshal(a)
+	}, false)
+}
+
+// Albums 列出全部相册
+func (s *Store) Albums() []Album {
+	m, err := s.b.list(kindAlbums)
+	if err != nil {
+		log.Printf("读取元数据失败: %v", err)
+		return nil
+	}
+	albums := make([]Album, 0, len(m))
+	for _, b := range m {
+		var a Album
+		if json.Unmarshal(b, &a) == nil {
+			albums = append(albums, a)
+		}
+	}
+	return albums
+}
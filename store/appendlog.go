@@ -0,0 +1,69 @@
+package store
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// AppendLog 追加写入的日志文件，每次追加的内容作为新的分块消息，下载时按顺序拼接
+type AppendLog struct {
+	Name      string     `json:"name"`
+	Mime      string     `json:"mime,omitempty"` // 首次追加时的内容类型
+	Size      int64      `json:"size"`
+	Chunks    []LogChunk `json:"chunks"`
+	CreatedAt int64      `json:"created_at"`
+	UpdatedAt int64      `json:"updated_at"`
+}
+
+// LogChunk 日志文件的分块
+type LogChunk struct {
+	ID     string `json:"id"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256,omitempty"`
+}
+
+// GetAppendLog 获取日志文件
+func (s *Store) GetAppendLog(name string) (AppendLog, bool) {
+	var l AppendLog
+	ok := s.getJSON(kindAppend, name, &l)
+	return l, ok
+}
+
+// AppendChunks 将分块追加到日志文件末尾，日志文件不存在时以 mime 创建，返回追加后的记录
+func (s *Store) AppendChunks(name, mime string, chunks []LogChunk) (AppendLog, error) {
+	var l AppendLog
+	now := time.Now().Unix()
+	err := s.b.update(kindAppend, name, func(old []byte) ([]byte, error) {
+		l = AppendLog{Name: name, Mime: mime, CreatedAt: now}
+		if old != nil {
+			if err := json.Unmarshal(old, &l); err != nil {
+				return nil, err
+			}
+		}
+		for _, c := range chunks {
+			l.Chunks = append(l.Chunks, c)
+			l.Size += c.Size
+		}
+		l.UpdatedAt = now
+		return json.Marshal(l)
+	}, false)
+	return l, err
+}
+
+// AppendLogs 列出全部日志文件
+func (s *Store) AppendLogs() []AppendLog {
+	m, err := s.b.list(kindAppend)
+	if err != nil {
+		log.Printf("读取元数据失败: %v", err)
+		return nil
+	}
+	logs := make([]AppendLog, 0, len(m))
+	for _, b := range m {
+		var l AppendLog
+		if json.Unmarshal(b, &l) == nil {
+			logs = append(logs, l)
+		}
+	}
+	return logs
+}
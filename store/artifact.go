@@ -0,0 +1,59 @@
+package store
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Artifact 发布的构建产物，同一名称、版本及文件名只能发布一次
+type Artifact struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	File      string `json:"file"`
+	ID        string `json:"id"`
+	Size      int64  `json:"size"`
+	Sha256    string `json:"sha256"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func artifactKey(name, version, file string) string {
+	return name + "/" + version + "/" + file
+}
+
+// GetArtifact 获取构建产物
+func (s *Store) GetArtifact(name, version, file string) (Artifact, bool) {
+	var a Artifact
+	ok := s.getJSON(kindArtifact, artifactKey(name, version, file), &a)
+	return a, ok
+}
+
+// PutArtifact 发布构建产物，已存在时返回 ErrExists
+func (s *Store) PutArtifact(a Artifact) error {
+	if a.CreatedAt == 0 {
+		a.CreatedAt = time.Now().Unix()
+	}
+	return s.b.update(kindArtifact, artifactKey(a.Name, a.Version, a.File), func(old []byte) ([]byte, error) {
+		if old != nil {
+			return nil, ErrExists
+		}
+		return json.Marshal(a)
+	}, false)
+}
+
+// Artifacts 列出全部构建产物
+func (s *Store) Artifacts() []Artifact {
+	m, err := s.b.list(kindArtifact)
+	if err != nil {
+		log.Printf("读取元数据失败: %v", err)
+		return nil
+	}
+	list := make([]Artifact, 0, len(m))
+	for _, b := range m {
+		var a Artifact
+		if json.Unmarshal(b, &a) == nil {
+			list = append(list, a)
+		}
+	}
+	return list
+}
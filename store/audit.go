@@ -0,0 +1,70 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// AuditEntry 修改操作的审计记录
+type AuditEntry struct {
+	Time   int64  `json:"time"`
+	Action string `json:"action"` // 操作类型，如 upload、delete、shorten、settings
+	Actor  string `json:"actor"`  // 操作者，如 session、apikey:abcd…、anonymous
+	IP     string `json:"ip"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+	Target string `json:"target,omitempty"` // 操作对象，如文件ID或短链接
+	Detail string `json:"detail,omitempty"`
+}
+
+// auditKey 生成按时间排序且不重复的记录键
+func auditKey(t time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%020d-%s", t.UnixNano(), hex.EncodeToString(b))
+}
+
+// AddAudit 追加审计记录，记录只增不改
+func (s *Store) AddAudit(e AuditEntry) {
+	now := time.Now()
+	if e.Time == 0 {
+		e.Time = now.Unix()
+	}
+	err := s.b.update(kindAudit, auditKey(now), func(old []byte) ([]byte, error) {
+		if old != nil {
+			return nil, ErrExists
+		}
+		return json.Marshal(e)
+	}, true)
+	if err != nil {
+		log.Printf("记录审计日志失败: %v", err)
+	}
+}
+
+// AuditEntries 按时间顺序列出全部审计记录
+func (s *Store) AuditEntries() []AuditEntry {
+	m, err := s.b.list(kindAudit)
+	if err != nil {
+		log.Printf("读取元数据失败: %v", err)
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	entries := make([]AuditEntry, 0, len(keys))
+	for _, k := range keys {
+		var e AuditEntry
+		if json.Unmarshal(m[k], &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
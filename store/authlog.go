@@ -0,0 +1,45 @@
+package store
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// 认证日志保留的条数
+const authLogMax = 1000
+
+// AuthEvent 登录及接口密钥认证事件
+type AuthEvent struct {
+	Time   int64  `json:"time"`
+	IP     string `json:"ip"`
+	Method string `json:"method"`          // 认证方式：pass、apikey
+	Actor  string `json:"actor,omitempty"` // 租户名或密钥前缀
+	Path   string `json:"path"`
+	Ok     bool   `json:"ok"`
+	Reason string `json:"reason,omitempty"` // 失败原因，如 wrong password、locked
+}
+
+// AddAuthEvent 追加认证事件，只保留最近的记录
+func (s *Store) AddAuthEvent(e AuthEvent) {
+	err := s.b.update(kindLogs, "auth", func(old []byte) ([]byte, error) {
+		var events []AuthEvent
+		if old != nil {
+			json.Unmarshal(old, &events)
+		}
+		events = append(events, e)
+		if len(events) > authLogMax {
+			events = events[len(events)-authLogMax:]
+		}
+		return json.Marshal(events)
+	}, true)
+	if err != nil {
+		log.Printf("记录认证日志失败: %v", err)
+	}
+}
+
+// AuthEvents 按时间顺序列出认证事件
+func (s *Store) AuthEvents() []AuthEvent {
+	var events []AuthEvent
+	s.getJSON(kindLogs, "auth", &events)
+	return events
+}
@@ -0,0 +1,234 @@
+package store
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DumpVersion 导出格式版本
+const DumpVersion = 1
+
+// Dump 元数据导出内容
+type Dump struct {
+	Version    int    `json:"version"`
+	ExportedAt int64  `json:"exported_at"`
+	Files      []File `json:"files"`
+	Links      []Link `json:"links"`
+	// Mirrors 文件ID到备份频道文件ID的映射
+	Mirrors map[string]string `json:"mirrors,omitempty"`
+	// Messages 文件ID到所在消息的映射
+	Messages map[string]Message `json:"messages,omitempty"`
+	// Legacy 迁移前的地址路径到文件ID的映射
+	Legacy map[string]string `json:"legacy,omitempty"`
+	// Albums 文件夹上传生成的相册
+	Albums []Album `json:"albums,omitempty"`
+	// AppendLogs 追加写入的日志文件
+	AppendLogs []AppendLog `json:"append_logs,omitempty"`
+	// Sites 压缩包部署的静态网站
+	Sites []Site `json:"sites,omitempty"`
+	// OCI 镜像仓库的清单记录
+	OCI []OCIManifest `json:"oci,omitempty"`
+	// Artifacts 发布的构建产物
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+}
+
+// Links 列出全部短链接
+func (s *Store) Links() []Link {
+	m, err := s.b.list(kindLinks)
+	if err != nil {
+		log.Printf("读取元数据失败: %v", err)
+		return nil
+	}
+	links := make([]Link, 0, len(m))
+	for _, b := range m {
+		var l Link
+		if json.Unmarshal(b, &l) == nil {
+			links = append(links, l)
+		}
+	}
+	return links
+}
+
+// Export 导出全部文件及短链接记录，按创建时间排序
+func (s *Store) Export() Dump {
+	d := Dump{Version: DumpVersion, ExportedAt: time.Now().Unix(), Files: s.Files(), Links: s.Links(), Mirrors: s.Mirrors(), Messages: s.Messages(), Legacy: s.Legacy(), Albums: s.Albums(), AppendLogs: s.AppendLogs(), Sites: s.Sites(), OCI: s.OCIManifests(), Artifacts: s.Artifacts()}
+	sort.Slice(d.Files, func(i, j int) bool { return d.Files[i].CreatedAt < d.Files[j].CreatedAt })
+	sort.Slice(d.Links, func(i, j int) bool { return d.Links[i].CreatedAt < d.Links[j].CreatedAt })
+	sort.Slice(d.Albums, func(i, j int) bool { return d.Albums[i].CreatedAt < d.Albums[j].CreatedAt })
+	sort.Slice(d.AppendLogs, func(i, j int) bool { return d.AppendLogs[i].CreatedAt < d.AppendLogs[j].CreatedAt })
+	sort.Slice(d.Sites, func(i, j int) bool { return d.Sites[i].CreatedAt < d.Sites[j].CreatedAt })
+	sort.Slice(d.Artifacts, func(i, j int) bool { return d.Artifacts[i].CreatedAt < d.Artifacts[j].CreatedAt })
+	return d
+}
+
+// Import 恢复导出的记录，已存在的记录会被覆盖，返回恢复的记录数
+func (s *Store) Import(d Dump) (int, error) {
+	n := 0
+	for _, f := range d.Files {
+		if f.ID == "" {
+			continue
+		}
+		if err := s.put(kindFiles, f.ID, f, true); err != nil {
+			return n, err
+		}
+		// 哈希索引由文件记录重建
+		if f.Sha256 != "" {
+			if _, err := s.PutHash(f.Sha256, f.ID); err != nil {
+				return n, err
+			}
+		}
+		n++
+	}
+	for _, l := range d.Links {
+		if l.Slug == "" {
+			continue
+		}
+		if err := s.put(kindLinks, l.Slug, l, true); err != nil {
+			return n, err
+		}
+		n++
+	}
+	for id, m := range d.Mirrors {
+		if err := s.put(kindMirrors, id, m, true); err != nil {
+			return n, err
+		}
+		n++
+	}
+	for id, m := range d.Messages {
+		if err := s.put(kindMessages, id, m, true); err != nil {
+			return n, err
+		}
+		n++
+	}
+	for path, id := range d.Legacy {
+		if err := s.put(kindLegacy, path, id, true); err != nil {
+			return n, err
+		}
+		n++
+	}
+	for _, a := range d.Albums {
+		if a.ID == "" {
+			continue
+		}
+		if err := s.put(kindAlbums, a.ID, a, true); err != nil {
+			return n, err
+		}
+		n++
+	}
+	for _, l := range d.AppendLogs {
+		if l.Name == "" {
+			continue
+		}
+		if err := s.put(kindAppend, l.Name, l, true); err != nil {
+			return n, err
+		}
+		n++
+	}
+	for _, site := range d.Sites {
+		if site.Name == "" {
+			continue
+		}
+		if err := s.put(kindSites, site.Name, site, true); err != nil {
+			return n, err
+		}
+		n++
+	}
+	for _, m := range d.OCI {
+		if m.Repo == "" || m.Reference == "" {
+			continue
+		}
+		if err := s.put(kindOCI, ociKey(m.Repo, m.Reference), m, true); err != nil {
+			return n, err
+		}
+		n++
+	}
+	for _, a := range d.Artifacts {
+		if a.Name == "" || a.Version == "" || a.File == "" {
+			continue
+		}
+		if err := s.put(kindArtifact, artifactKey(a.Name, a.Version, a.File), a, true); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, s.b.flush()
+}
+
+// put 覆盖写入记录
+func (s *Store) put(kind, key string, v interface{}, lazy bool) error {
+	return s.b.update(kind, key, func([]byte) ([]byte, error) {
+		return json.Marshal(v)
+	}, lazy)
+}
+
+// WriteFilesCSV 以 CSV 格式输出文件记录
+func WriteFilesCSV(w io.Writer, files []File) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "name", "size", "sha256", "tenant", "message", "created_at", "downloads"})
+	for _, f := range files {
+		cw.Write([]string{
+			f.ID,
+			f.Name,
+			strconv.FormatInt(f.Size, 10),
+			f.Sha256,
+			f.Tenant,
+			strconv.Itoa(f.Message),
+			strconv.FormatInt(f.CreatedAt, 10),
+			strconv.FormatInt(f.Downloads, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteLinksCSV 以 CSV 格式输出短链接记录
+func WriteLinksCSV(w io.Writer, links []Link) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"slug", "target", "created_at", "hits"})
+	for _, l := range links {
+		cw.Write([]string{l.Slug, l.Target, strconv.FormatInt(l.CreatedAt, 10), strconv.FormatInt(l.Hits, 10)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// ExportFile 导出到文件，扩展名为 .csv 时导出文件记录的 CSV
+func (s *Store) ExportFile(path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	d := s.Export()
+	if strings.HasSuffix(strings.ToLower(path), ".csv") {
+		err = WriteFilesCSV(out, d.Files)
+	} else {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(d)
+	}
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// ImportFile 从导出的 JSON 文件恢复
+func (s *Store) ImportFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var d Dump
+	if err := json.Unmarshal(data, &d); err != nil {
+		return 0, err
+	}
+	return s.Import(d)
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// tempValue 带过期时间的内存值
+type tempValue struct {
+	value   string
+	expires time.Time
+}
+
+// fileBackend 内存存储，记录落盘到 JSON 文件，计数器与临时值仅保存在内存中
+type fileBackend struct {
+	sync.RWMutex
+	path  string
+	data  map[string]map[string]json.RawMessage
+	dirty bool
+	temp  map[string]tempValue
+	// readOnly 修改只保留在内存中
+	readOnly bool
+}
+
+func newFileBackend(path string) *fileBackend {
+	fb := &fileBackend{
+		path: path,
+		data: make(map[string]map[string]json.RawMessage),
+		temp: make(map[string]tempValue),
+	}
+	if b, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &fb.data); err != nil {
+			log.Printf("读取元数据失败: %v", err)
+		}
+	}
+	return fb
+}
+
+func (fb *fileBackend) get(kind, key string) ([]byte, bool, error) {
+	fb.RLock()
+	defer fb.RUnlock()
+	b, ok := fb.data[kind][key]
+	return b, ok, nil
+}
+
+func (fb *fileBackend) update(kind, key string, fn func(old []byte) ([]byte, error), lazy bool) error {
+	fb.Lock()
+	defer fb.Unlock()
+	nv, err := fn(fb.data[kind][key])
+	if err != nil || nv == nil {
+		return err
+	}
+	if fb.data[kind] == nil {
+		fb.data[kind] = make(map[string]json.RawMessage)
+	}
+	fb.data[kind][key] = nv
+	fb.dirty = true
+	if lazy {
+		return nil
+	}
+	return fb.saveLocked()
+}
+
+func (fb *fileBackend) del(kind, key string) error {
+	fb.Lock()
+	defer fb.Unlock()
+	if _, ok := fb.data[kind][key]; !ok {
+		return nil
+	}
+	delete(fb.data[kind], key)
+	fb.dirty = true
+	return fb.saveLocked()
+}
+
+func (fb *fileBackend) list(kind string) (map[string][]byte, error) {
+	fb.RLock()
+	defer fb.RUnlock()
+	m := make(map[string][]byte, len(fb.data[kind]))
+	for k, v := range fb.data[kind] {
+		m[k] = v
+	}
+	return m, nil
+}
+
+func (fb *fileBackend) incr(key string, ttl time.Duration) (int64, error) {
+	fb.Lock()
+	defer fb.Unlock()
+	var n int64
+	v, ok := fb.temp[key]
+	if ok && time.Now().Before(v.expires) {
+		json.Unmarshal([]byte(v.value), &n)
+	} else {
+		v = tempValue{expires: time.Now().Add(ttl)}
+	}
+	n++
+	b, _ := json.Marshal(n)
+	v.value = string(b)
+	fb.temp[key] = v
+	return n, nil
+}
+
+func (fb *fileBackend) getTemp(key string) (string, bool) {
+	fb.RLock()
+	defer fb.RUnlock()
+	v, ok := fb.temp[key]
+	if !ok || time.Now().After(v.expires) {
+		return "", false
+	}
+	return v.value, true
+}
+
+func (fb *fileBackend) setTemp(key, value string, ttl time.Duration) {
+	fb.Lock()
+	defer fb.Unlock()
+	fb.temp[key] = tempValue{value: value, expires: time.Now().Add(ttl)}
+}
+
+func (fb *fileBackend) delTemp(key string) {
+	fb.Lock()
+	defer fb.Unlock()
+	delete(fb.temp, key)
+}
+
+func (fb *fileBackend) flush() error {
+	fb.Lock()
+	defer fb.Unlock()
+	// 顺带清理过期的临时值
+	now := time.Now()
+	for k, v := range fb.temp {
+		if now.After(v.expires) {
+			delete(fb.temp, k)
+		}
+	}
+	if !fb.dirty {
+		return nil
+	}
+	return fb.saveLocked()
+}
+
+func (fb *fileBackend) saveLocked() error {
+	if fb.readOnly {
+		fb.dirty = false
+		return nil
+	}
+	b, err := json.Marshal(fb.data)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fb.path), 0755); err != nil {
+		return err
+	}
+	// 先写临时文件再重命名，避免写入中断导致数据损坏
+	tmp := fb.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fb.path); err != nil {
+		return err
+	}
+	fb.dirty = false
+	return nil
+}
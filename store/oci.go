@@ -0,0 +1,62 @@
+package store
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// OCIManifest 镜像清单，以仓库名加标签（repo:tag）及仓库名加摘要（repo@sha256:...）两个键保存
+type OCIManifest struct {
+	Repo      string `json:"repo"`
+	Reference string `json:"reference"` // 推送时使用的标签或摘要
+	Digest    string `json:"digest"`    // sha256:十六进制
+	MediaType string `json:"media_type"`
+	Size      int64  `json:"size"`
+	ID        string `json:"id"` // 清单内容在 Telegram 中的文件ID
+}
+
+// ociKey 标签以 : 连接，摘要以 @ 连接
+func ociKey(repo, ref string) string {
+	if strings.HasPrefix(ref, "sha256:") {
+		return repo + "@" + ref
+	}
+	return repo + ":" + ref
+}
+
+// GetOCIManifest 按标签或摘要获取镜像清单
+func (s *Store) GetOCIManifest(repo, ref string) (OCIManifest, bool) {
+	var m OCIManifest
+	ok := s.getJSON(kindOCI, ociKey(repo, ref), &m)
+	return m, ok
+}
+
+// PutOCIManifest 保存镜像清单，按标签推送时同时可按摘要获取，同一标签再次推送时指向新的清单
+func (s *Store) PutOCIManifest(m OCIManifest) error {
+	byDigest := m
+	byDigest.Reference = m.Digest
+	if err := s.put(kindOCI, ociKey(m.Repo, m.Digest), byDigest, false); err != nil {
+		return err
+	}
+	if m.Reference == m.Digest {
+		return nil
+	}
+	return s.put(kindOCI, ociKey(m.Repo, m.Reference), m, false)
+}
+
+// OCIManifests 列出全部镜像清单记录，按标签推送的清单同时有一条 Reference 为摘要的记录
+func (s *Store) OCIManifests() []OCIManifest {
+	m, err := s.b.list(kindOCI)
+	if err != nil {
+		log.Printf("读取元数据失败: %v", err)
+		return nil
+	}
+	list := make([]OCIManifest, 0, len(m))
+	for _, b := range m {
+		var o OCIManifest
+		if json.Unmarshal(b, &o) == nil {
+			list = append(list, o)
+		}
+	}
+	return list
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const redisPrefix = "tgstate:"
+
+// 乐观锁冲突时的最大重试次数
+const redisMaxRetry = 10
+
+// redisBackend 基于 Redis 的存储，多个实例可共享元数据、计数器与缓存
+type redisBackend struct {
+	pool *redis.Pool
+}
+
+func newRedisBackend(url string) *redisBackend {
+	return &redisBackend{pool: &redis.Pool{
+		MaxIdle:     8,
+		IdleTimeout: 4 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL(url,
+				redis.DialConnectTimeout(5*time.Second),
+				redis.DialReadTimeout(5*time.Second),
+				redis.DialWriteTimeout(5*time.Second))
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}}
+}
+
+func recordKey(kind, key string) string {
+	return redisPrefix + kind + ":" + key
+}
+
+func indexKey(kind string) string {
+	return redisPrefix + "index:" + kind
+}
+
+func (rb *redisBackend) get(kind, key string) ([]byte, bool, error) {
+	c := rb.pool.Get()
+	defer c.Close()
+	b, err := redis.Bytes(c.Do("GET", recordKey(kind, key)))
+	if err == redis.ErrNil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+func (rb *redisBackend) update(kind, key string, fn func(old []byte) ([]byte, error), lazy bool) error {
+	c := rb.pool.Get()
+	defer c.Close()
+	k := recordKey(kind, key)
+	for i := 0; i < redisMaxRetry; i++ {
+		if _, err := c.Do("WATCH", k); err != nil {
+			return err
+		}
+		old, err := redis.Bytes(c.Do("GET", k))
+		if err != nil && err != redis.ErrNil {
+			c.Do("UNWATCH")
+			return err
+		}
+		nv, err := fn(old)
+		if err != nil || nv == nil {
+			c.Do("UNWATCH")
+			return err
+		}
+		c.Send("MULTI")
+		c.Send("SET", k, nv)
+		c.Send("SADD", indexKey(kind), key)
+		reply, err := c.Do("EXEC")
+		if err != nil {
+			return err
+		}
+		// EXEC 返回 nil 表示期间被其他实例修改，重试
+		if reply != nil {
+			return nil
+		}
+	}
+	return errors.New("redis: too many concurrent updates")
+}
+
+func (rb *redisBackend) del(kind, key string) error {
+	c := rb.pool.Get()
+	defer c.Close()
+	c.Send("MULTI")
+	c.Send("DEL", recordKey(kind, key))
+	c.Send("SREM", indexKey(kind), key)
+	_, err := c.Do("EXEC")
+	return err
+}
+
+func (rb *redisBackend) list(kind string) (map[string][]byte, error) {
+	c := rb.pool.Get()
+	defer c.Close()
+	keys, err := redis.Strings(c.Do("SMEMBERS", indexKey(kind)))
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string][]byte, len(keys))
+	if len(keys) == 0 {
+		return m, nil
+	}
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = recordKey(kind, key)
+	}
+	values, err := redis.ByteSlices(c.Do("MGET", args...))
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range values {
+		if v != nil {
+			m[keys[i]] = v
+		}
+	}
+	return m, nil
+}
+
+func (rb *redisBackend) incr(key string, ttl time.Duration) (int64, error) {
+	c := rb.pool.Get()
+	defer c.Close()
+	k := redisPrefix + "counter:" + key
+	n, err := redis.Int64(c.Do("INCR", k))
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 {
+		c.Do("PEXPIRE", k, ttl.Milliseconds())
+	}
+	return n, nil
+}
+
+func (rb *redisBackend) getTemp(key string) (string, bool) {
+	c := rb.pool.Get()
+	defer c.Close()
+	v, err := redis.String(c.Do("GET", redisPrefix+"cache:"+key))
+	if err != nil {
+		return "", false
+	}
+	return v, true
+}
+
+func (rb *redisBackend) setTemp(key, value string, ttl time.Duration) {
+	c := rb.pool.Get()
+	defer c.Close()
+	c.Do("SET", redisPrefix+"cache:"+key, value, "PX", ttl.Milliseconds())
+}
+
+func (rb *redisBackend) delTemp(key string) {
+	c := rb.pool.Get()
+	defer c.Close()
+	c.Do("DEL", redisPrefix+"cache:"+key)
+}
+
+func (rb *redisBackend) flush() error {
+	return nil
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+)
+
+// Schedule 定时发送到频道的文件链接
+type Schedule struct {
+	ID        string `json:"id"`
+	File      string `json:"file"`           // 文件ID
+	Text      string `json:"text,omitempty"` // 附在链接前的说明，如版本发布公告
+	At        int64  `json:"at"`             // 计划发送的时间
+	CreatedAt int64  `json:"created_at"`
+	Creator   string `json:"creator,omitempty"` // 创建者，格式与审计日志的操作者相同
+}
+
+// PutSchedule 新增或覆盖定时发送
+func (s *Store) PutSchedule(sc Schedule) error {
+	if sc.CreatedAt == 0 {
+		sc.CreatedAt = time.Now().Unix()
+	}
+	return s.put(kindSchedule, sc.ID, sc, false)
+}
+
+// GetSchedule 获取定时发送
+func (s *Store) GetSchedule(id string) (Schedule, bool) {
+	var sc Schedule
+	ok := s.getJSON(kindSchedule, id, &sc)
+	return sc, ok
+}
+
+// DeleteSchedule 删除定时发送
+func (s *Store) DeleteSchedule(id string) error {
+	return s.b.del(kindSchedule, id)
+}
+
+// Schedules 按计划时间顺序列出全部定时发送
+func (s *Store) Schedules() []Schedule {
+	m, err := s.b.list(kindSchedule)
+	if err != nil {
+		log.Printf("读取元数据失败: %v", err)
+		return nil
+	}
+	schedules := make([]Schedule, 0, len(m))
+	for _, b := range m {
+		var sc Schedule
+		if json.Unmarshal(b, &sc) == nil {
+			schedules = append(schedules, sc)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		if schedules[i].At != schedules[j].At {
+			return schedules[i].At < schedules[j].At
+		}
+		return schedules[i].ID < schedules[j].ID
+	})
+	return schedules
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Site 由压缩包部署的静态网站，按相对路径记录其中的文件
+type Site struct {
+	Name      string              `json:"name"`
+	Files     map[string]SiteFile `json:"files"` // 相对路径，如 index.html、assets/app.js
+	CreatedAt int64               `json:"created_at"`
+	UpdatedAt int64               `json:"updated_at"`
+}
+
+// SiteFile 网站中的文件
+type SiteFile struct {
+	ID   string `json:"id"`
+	Size int64  `json:"size"`
+}
+
+// GetSite 获取静态网站
+func (s *Store) GetSite(name string) (Site, bool) {
+	var site Site
+	ok := s.getJSON(kindSites, name, &site)
+	return site, ok
+}
+
+// PutSite 保存静态网站，已存在时整体替换为新部署的文件，保留创建时间
+func (s *Store) PutSite(site Site) error {
+	now := time.Now().Unix()
+	return s.b.update(kindSites, site.Name, func(old []byte) ([]byte, error) {
+		site.CreatedAt = now
+		if old != nil {
+			var prev Site
+			if json.Unmarshal(old, &prev) == nil && prev.CreatedAt > 0 {
+				site.CreatedAt = prev.CreatedAt
+			}
+		}
+		site.UpdatedAt = now
+		return json.Marshal(site)
+	}, false)
+}
+
+// Sites 列出全部静态网站
+func (s *Store) Sites() []Site {
+	m, err := s.b.list(kindSites)
+	if err != nil {
+		log.Printf("读取元数据失败: %v", err)
+		return nil
+	}
+	sites := make([]Site, 0, len(m))
+	for _, b := range m {
+		var site Site
+		if json.Unmarshal(b, &site) == nil {
+			sites = append(sites, site)
+		}
+	}
+	return sites
+}
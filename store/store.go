@@ -0,0 +1,484 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"csz.net/tgstate/conf"
+)
+
+// ErrExists 记录已存在
+var ErrExists = errors.New("record already exists")
+
+// ErrNotFound 记录不存在
+var ErrNotFound = errors.New("record not found")
+
+// ErrModified 记录已被修改，条件更新的前提不成立
+var ErrModified = errors.New("record was modified")
+
+// 记录类型
+const (
+	kindFiles    = "files"
+	kindLinks    = "links"
+	kindMirrors  = "mirrors"
+	kindHashes   = "hashes"
+	kindMessages = "messages"
+	kindSettings = "settings"
+	kindLegacy   = "legacy"
+	kindLogs     = "logs"
+	kindAudit    = "audit"
+	kindSchedule = "schedule"
+	kindAlbums   = "albums"
+	kindAppend   = "append"
+	kindSites    = "sites"
+	kindOCI      = "oci"
+	kindArtifact = "artifacts"
+	kindUsage    = "usage"
+)
+
+// Link 短链接记录
+type Link struct {
+	Slug      string `json:"slug"`
+	Target    string `json:"target"`
+	CreatedAt int64  `json:"created_at"`
+	Hits      int64  `json:"hits"`
+	// Versions 可覆盖的命名文件的各个版本，最后一个为当前版本；普通短链接为空
+	Versions []LinkVersion `json:"versions,omitempty"`
+}
+
+// File 文件记录
+type File struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	Sha256     string `json:"sha256,omitempty"` // 文件内容的 sha256，十六进制
+	Tenant     string `json:"tenant,omitempty"`
+	Message    int    `json:"message,omitempty"` // 频道中的消息ID，导入的历史文件才有
+	CreatedAt  int64  `json:"created_at"`
+	Downloads  int64  `json:"downloads"`
+	LastAccess int64  `json:"last_access,omitempty"` // 最后一次下载的时间
+	Duration   int    `json:"duration,omitempty"`    // 音视频时长（秒），Telegram 消息中有 Audio、Video 等对象时才有
+	Subs       string `json:"subs,omitempty"`        // 关联的 WebVTT 字幕文件ID
+	// ContentType 上传时指定的内容类型，优先于内容检测
+	ContentType string `json:"content_type,omitempty"`
+	// Headers 下载时附加的响应头，如 Cache-Control
+	Headers map[string]string `json:"headers,omitempty"`
+	// DeletedAt 移入回收站的时间，为 0 时文件正常可用
+	DeletedAt int64 `json:"deleted_at,omitempty"`
+	// Pending 匿名上传的文件等待审核，审核通过前不能公开下载
+	Pending bool `json:"pending,omitempty"`
+	// Visibility 可见性，为空的旧记录视为 unlisted
+	Visibility string `json:"visibility,omitempty"`
+}
+
+// 文件可见性
+const (
+	VisibilityPublic   = "public"   // 可以出现在公开的文件列表中
+	VisibilityUnlisted = "unlisted" // 只能通过链接访问
+	VisibilityPrivate  = "private"  // 需要认证或签名链接才能访问
+)
+
+// ValidVisibility 是否为有效的可见性
+func ValidVisibility(v string) bool {
+	return v == VisibilityPublic || v == VisibilityUnlisted || v == VisibilityPrivate
+}
+
+// Access 文件的可见性，旧记录未设置时为 unlisted
+func (f File) Access() string {
+	if f.Visibility == "" {
+		return VisibilityUnlisted
+	}
+	return f.Visibility
+}
+
+// Message 文件所在的 Telegram 消息，用于删除及在文件ID失效后重新获取
+type Message struct {
+	Chat   string `json:"chat"`
+	ID     int    `json:"id"`
+	FileID string `json:"file_id,omitempty"` // 原文件ID失效后从消息重新获取的文件ID，下载时代替原文件ID
+}
+
+// backend 存储后端，记录以 JSON 保存，按类型分组
+type backend interface {
+	// get 读取记录
+	get(kind, key string) ([]byte, bool, error)
+	// update 原子更新记录，fn 接收旧值（不存在时为 nil）返回新值；lazy 为真时允许延迟落盘
+	update(kind, key string, fn func(old []byte) ([]byte, error), lazy bool) error
+	// del 删除记录
+	del(kind, key string) error
+	// list 列出某类型的全部记录
+	list(kind string) (map[string][]byte, error)
+	// incr 计数器自增，首次创建时设置过期时间
+	incr(key string, ttl time.Duration) (int64, error)
+	// getTemp/setTemp 读写带过期时间的临时值
+	getTemp(key string) (string, bool)
+	setTemp(key, value string, ttl time.Duration)
+	delTemp(key string)
+	// flush 将延迟的更新写入持久化存储
+	flush() error
+}
+
+// Store 元数据存储，默认保存在本地 JSON 文件，配置 redis 后可在多个实例间共享
+type Store struct {
+	b backend
+}
+
+var (
+	defaultStore *Store
+	once         sync.Once
+	readOnly     bool
+)
+
+// SetReadOnly 之后 Default 返回的存储只在内存中修改，不写入 meta.json。供挂载等与服务进程共用数据目录的
+// 辅助进程使用，避免用启动时读取的旧记录覆盖服务进程之后写入的记录；需在首次调用 Default 前设置。
+// 使用 Redis 时各记录单独更新，不受影响
+func SetReadOnly() {
+	readOnly = true
+}
+
+// Default 获取元数据存储单例
+func Default() *Store {
+	once.Do(func() {
+		if conf.RedisUrl != "" {
+			defaultStore = &Store{b: newRedisBackend(conf.RedisUrl)}
+			return
+		}
+		if readOnly {
+			fb := newFileBackend(filepath.Join(conf.DataDir, "meta.json"))
+			fb.readOnly = true
+			defaultStore = &Store{b: fb}
+			return
+		}
+		defaultStore = Open(filepath.Join(conf.DataDir, "meta.json"))
+		// 启动定期落盘协程
+		go defaultStore.periodicFlush()
+	})
+	return defaultStore
+}
+
+// Snapshot 重新读取元数据，供只读访问的其他进程看到服务进程之后写入的记录，
+// 使用 Redis 时直接返回 Default
+func Snapshot() *Store {
+	if conf.RedisUrl != "" {
+		return Default()
+	}
+	return Open(filepath.Join(conf.DataDir, "meta.json"))
+}
+
+// Open 从指定路径加载元数据，文件不存在时返回空存储
+func Open(path string) *Store {
+	return &Store{b: newFileBackend(path)}
+}
+
+func (s *Store) getJSON(kind, key string, v interface{}) bool {
+	b, ok, err := s.b.get(kind, key)
+	if err != nil {
+		log.Printf("读取元数据失败: %v", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(b, v) == nil
+}
+
+// GetFile 获取文件记录
+func (s *Store) GetFile(id string) (File, bool) {
+	var f File
+	ok := s.getJSON(kindFiles, id, &f)
+	return f, ok
+}
+
+// PutFile 新增或覆盖文件记录
+func (s *Store) PutFile(f File) error {
+	if f.CreatedAt == 0 {
+		f.CreatedAt = time.Now().Unix()
+	}
+	return s.b.update(kindFiles, f.ID, func(old []byte) ([]byte, error) {
+		// 保留已有的下载次数
+		var prev File
+		if old != nil && json.Unmarshal(old, &prev) == nil && f.Downloads == 0 {
+			f.Downloads = prev.Downloads
+		}
+		return json.Marshal(f)
+	}, false)
+}
+
+// HitFile 增加文件下载次数并返回新的次数，未登记的文件会自动登记，延迟落盘
+func (s *Store) HitFile(id string) int64 {
+	var f File
+	err := s.b.update(kindFiles, id, func(old []byte) ([]byte, error) {
+		f = File{ID: id, CreatedAt: time.Now().Unix()}
+		if old != nil {
+			if err := json.Unmarshal(old, &f); err != nil {
+				return nil, err
+			}
+		}
+		f.Downloads++
+		f.LastAccess = time.Now().Unix()
+		return json.Marshal(f)
+	}, true)
+	if err != nil {
+		log.Printf("更新下载次数失败: %v", err)
+	}
+	return f.Downloads
+}
+
+// DeleteFile 删除文件记录及其哈希、备份、消息索引
+func (s *Store) DeleteFile(id string) error {
+	f, _ := s.GetFile(id)
+	if f.Sha256 != "" {
+		if first, ok := s.GetHash(f.Sha256); ok && first == id {
+			if err := s.b.del(kindHashes, f.Sha256); err != nil {
+				return err
+			}
+		}
+	}
+	for _, kind := range []string{kindMirrors, kindMessages, kindFiles} {
+		if err := s.b.del(kind, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMessage 获取文件所在的消息
+func (s *Store) GetMessage(id string) (Message, bool) {
+	var m Message
+	ok := s.getJSON(kindMessages, id, &m)
+	return m, ok
+}
+
+// PutMessage 记录文件所在的消息
+func (s *Store) PutMessage(id string, m Message) error {
+	return s.put(kindMessages, id, m, true)
+}
+
+// Messages 列出全部消息索引
+func (s *Store) Messages() map[string]Message {
+	m, err := s.b.list(kindMessages)
+	if err != nil {
+		log.Printf("读取元数据失败: %v", err)
+		return nil
+	}
+	messages := make(map[string]Message, len(m))
+	for id, b := range m {
+		var v Message
+		if json.Unmarshal(b, &v) == nil {
+			messages[id] = v
+		}
+	}
+	return messages
+}
+
+// Settings 读取运行时修改的配置
+func (s *Store) Settings() map[string]string {
+	m, err := s.b.list(kindSettings)
+	if err != nil {
+		log.Printf("读取元数据失败: %v", err)
+		return nil
+	}
+	settings := make(map[string]string, len(m))
+	for k, b := range m {
+		var v string
+		if json.Unmarshal(b, &v) == nil {
+			settings[k] = v
+		}
+	}
+	return settings
+}
+
+// PutSetting 保存运行时修改的配置
+func (s *Store) PutSetting(key, value string) error {
+	return s.put(kindSettings, key, value, false)
+}
+
+// Files 列出全部文件记录
+func (s *Store) Files() []File {
+	m, err := s.b.list(kindFiles)
+	if err != nil {
+		log.Printf("读取元数据失败: %v", err)
+		return nil
+	}
+	files := make([]File, 0, len(m))
+	for _, b := range m {
+		var f File
+		if json.Unmarshal(b, &f) == nil {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// TenantUsage 统计租户已使用的存储空间
+func (s *Store) TenantUsage(tenant string) int64 {
+	var total int64
+	for _, f := range s.Files() {
+		if f.Tenant == tenant {
+			total += f.Size
+		}
+	}
+	return total
+}
+
+// GetLink 获取短链接
+func (s *Store) GetLink(slug string) (Link, bool) {
+	var l Link
+	ok := s.getJSON(kindLinks, slug, &l)
+	return l, ok
+}
+
+// PutLink 新增短链接，slug 已存在时返回 ErrExists
+func (s *Store) PutLink(l Link) error {
+	if l.CreatedAt == 0 {
+		l.CreatedAt = time.Now().Unix()
+	}
+	return s.b.update(kindLinks, l.Slug, func(old []byte) ([]byte, error) {
+		if old != nil {
+			return nil, ErrExists
+		}
+		return json.Marshal(l)
+	}, false)
+}
+
+// HitLink 增加短链接访问次数，延迟落盘
+func (s *Store) HitLink(slug string) {
+	err := s.b.update(kindLinks, slug, func(old []byte) ([]byte, error) {
+		if old == nil {
+			return nil, nil
+		}
+		var l Link
+		if err := json.Unmarshal(old, &l); err != nil {
+			return nil, err
+		}
+		l.Hits++
+		return json.Marshal(l)
+	}, true)
+	if err != nil {
+		log.Printf("更新访问次数失败: %v", err)
+	}
+}
+
+// GetLegacy 按旧地址的路径查找迁移后的文件ID
+func (s *Store) GetLegacy(path string) (string, bool) {
+	var id string
+	ok := s.getJSON(kindLegacy, path, &id)
+	return id, ok && id != ""
+}
+
+// PutLegacy 记录迁移前的地址路径对应的文件ID
+func (s *Store) PutLegacy(path, id string) error {
+	return s.put(kindLegacy, path, id, true)
+}
+
+// Legacy 列出全部迁移对照
+func (s *Store) Legacy() map[string]string {
+	m, err := s.b.list(kindLegacy)
+	if err != nil {
+		log.Printf("读取元数据失败: %v", err)
+		return nil
+	}
+	legacy := make(map[string]string, len(m))
+	for path, b := range m {
+		var id string
+		if json.Unmarshal(b, &id) == nil {
+			legacy[path] = id
+		}
+	}
+	return legacy
+}
+
+// GetMirror 获取文件在备份频道中的文件ID
+func (s *Store) GetMirror(id string) (string, bool) {
+	var m string
+	ok := s.getJSON(kindMirrors, id, &m)
+	return m, ok && m != ""
+}
+
+// PutMirror 记录文件在备份频道中的文件ID
+func (s *Store) PutMirror(id, mirror string) error {
+	return s.put(kindMirrors, id, mirror, false)
+}
+
+// Mirrors 列出全部备份文件ID
+func (s *Store) Mirrors() map[string]string {
+	m, err := s.b.list(kindMirrors)
+	if err != nil {
+		log.Printf("读取元数据失败: %v", err)
+		return nil
+	}
+	mirrors := make(map[string]string, len(m))
+	for id, b := range m {
+		var v string
+		if json.Unmarshal(b, &v) == nil {
+			mirrors[id] = v
+		}
+	}
+	return mirrors
+}
+
+// GetHash 按内容哈希查找最先上传的文件ID
+func (s *Store) GetHash(sha string) (string, bool) {
+	var id string
+	ok := s.getJSON(kindHashes, sha, &id)
+	return id, ok && id != ""
+}
+
+// PutHash 登记内容哈希，已登记时保留原有的文件ID并返回
+func (s *Store) PutHash(sha, id string) (string, error) {
+	first := id
+	err := s.b.update(kindHashes, sha, func(old []byte) ([]byte, error) {
+		var prev string
+		if old != nil && json.Unmarshal(old, &prev) == nil && prev != "" {
+			first = prev
+			return nil, nil
+		}
+		return json.Marshal(id)
+	}, false)
+	return first, err
+}
+
+// SetHash 将内容哈希改为指向指定文件，用于原文件已移入回收站时
+func (s *Store) SetHash(sha, id string) error {
+	return s.put(kindHashes, sha, id, false)
+}
+
+// Incr 计数器自增，用于限流等需要在实例间共享的计数
+func (s *Store) Incr(key string, ttl time.Duration) (int64, error) {
+	return s.b.incr(key, ttl)
+}
+
+// CacheGet 读取临时缓存
+func (s *Store) CacheGet(key string) (string, bool) {
+	return s.b.getTemp(key)
+}
+
+// CacheSet 写入临时缓存
+func (s *Store) CacheSet(key, value string, ttl time.Duration) {
+	s.b.setTemp(key, value, ttl)
+}
+
+// CacheDel 删除临时缓存
+func (s *Store) CacheDel(key string) {
+	s.b.delTemp(key)
+}
+
+// Save 立即写入持久化存储
+func (s *Store) Save() error {
+	return s.b.flush()
+}
+
+// periodicFlush 定期将计数等延迟更新写入磁盘
+func (s *Store) periodicFlush() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := s.b.flush(); err != nil {
+			log.Printf("保存元数据失败: %v", err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// Usage 接口密钥在一个统计周期（某天或某月）内的流量，单位字节
+type Usage struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+}
+
+// Total 上传与下载的合计
+func (u Usage) Total() int64 {
+	return u.Upload + u.Download
+}
+
+func usageKey(key, period string) string {
+	return key + "/" + period
+}
+
+// AddUsage 累加密钥在周期内的流量，延迟落盘；key 为密钥的标识，不保存密钥本身
+func (s *Store) AddUsage(key, period string, up, down int64) {
+	err := s.b.update(kindUsage, usageKey(key, period), func(old []byte) ([]byte, error) {
+		var u Usage
+		if old != nil {
+			if err := json.Unmarshal(old, &u); err != nil {
+				return nil, err
+			}
+		}
+		u.Upload += up
+		u.Download += down
+		return json.Marshal(u)
+	}, true)
+	if err != nil {
+		log.Printf("记录流量失败: %v", err)
+	}
+}
+
+// GetUsage 获取密钥在周期内的流量
+func (s *Store) GetUsage(key, period string) Usage {
+	var u Usage
+	s.getJSON(kindUsage, usageKey(key, period), &u)
+	return u
+}
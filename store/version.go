@@ -0,0 +1,94 @@
+package store
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// LinkVersion 命名文件的一个版本
+type LinkVersion struct {
+	Version   int    `json:"version"` // 从 1 开始递增
+	ID        string `json:"id"`
+	Size      int64  `json:"size"`
+	Sha256    string `json:"sha256,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Current 命名文件的当前版本，普通短链接返回 false
+func (l Link) Current() (LinkVersion, bool) {
+	if len(l.Versions) == 0 {
+		return LinkVersion{}, false
+	}
+	return l.Versions[len(l.Versions)-1], true
+}
+
+// PutVersion 将文件设为命名文件的新版本，短链接跳转到 prefix 加文件ID；
+// slug 不存在时创建，已被普通短链接占用时返回 ErrExists；keep 为 false 时不保留之前的版本，
+// match 不为空时在同一次更新中检查当前版本，返回 false 时不修改并返回 ErrModified；
+// 返回更新后的记录及不再保留的版本
+func (s *Store) PutVersion(slug string, v LinkVersion, prefix string, keep bool, match func(cur LinkVersion, ok bool) bool) (Link, []LinkVersion, error) {
+	var l Link
+	var dropped []LinkVersion
+	now := time.Now().Unix()
+	if v.CreatedAt == 0 {
+		v.CreatedAt = now
+	}
+	err := s.b.update(kindLinks, slug, func(old []byte) ([]byte, error) {
+		l = Link{Slug: slug, CreatedAt: now}
+		if old != nil {
+			if err := json.Unmarshal(old, &l); err != nil {
+				return nil, err
+			}
+			if len(l.Versions) == 0 {
+				return nil, ErrExists
+			}
+		}
+		cur, ok := l.Current()
+		if match != nil && !match(cur, ok) {
+			return nil, ErrModified
+		}
+		v.Version = cur.Version + 1
+		dropped = nil
+		if !keep {
+			dropped, l.Versions = l.Versions, nil
+		}
+		l.Versions = append(l.Versions, v)
+		l.Target = prefix + v.ID
+		return json.Marshal(l)
+	}, false)
+	return l, dropped, err
+}
+
+// Version 查找指定版本
+func (l Link) Version(version int) (LinkVersion, bool) {
+	for _, v := range l.Versions {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return LinkVersion{}, false
+}
+
+// RollbackVersion 以指定版本的内容生成新的当前版本，短链接跳转到 prefix 加文件ID，之前的版本仍然保留；
+// 命名文件或版本不存在时返回 ErrNotFound
+func (s *Store) RollbackVersion(slug string, version int, prefix string) (Link, error) {
+	var l Link
+	err := s.b.update(kindLinks, slug, func(old []byte) ([]byte, error) {
+		if old == nil {
+			return nil, ErrNotFound
+		}
+		if err := json.Unmarshal(old, &l); err != nil {
+			return nil, err
+		}
+		v, ok := l.Version(version)
+		if !ok {
+			return nil, ErrNotFound
+		}
+		cur, _ := l.Current()
+		v.Version, v.CreatedAt = cur.Version+1, time.Now().Unix()
+		l.Versions = append(l.Versions, v)
+		l.Target = prefix + v.ID
+		return json.Marshal(l)
+	}, false)
+	return l, err
+}
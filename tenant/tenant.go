@@ -0,0 +1,112 @@
+// Package tenant 多租户配置，每个租户拥有独立的频道、访问路径、密码与配额
+package tenant
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// Route 租户访问路径前缀
+const Route = "/t/"
+
+var nameRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+// Tenant 租户配置
+type Tenant struct {
+	Name        string   `json:"name"`
+	Target      string   `json:"target"`        // 租户使用的频道、群组或个人
+	Pass        string   `json:"pass"`          // 网页访问密码
+	ApiKeys     []string `json:"api_keys"`      // 接口密钥
+	MaxFileSize int64    `json:"max_file_size"` // 单文件大小上限，0 为不限制
+	Quota       int64    `json:"quota"`         // 总存储配额，0 为不限制
+}
+
+type config struct {
+	Tenants []*Tenant `json:"tenants"`
+}
+
+var (
+	tenants = make(map[string]*Tenant)
+	mu      sync.RWMutex
+)
+
+// Load 从 JSON 配置文件加载租户
+func Load(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var c config
+	if err := json.Unmarshal(b, &c); err != nil {
+		return err
+	}
+	m := make(map[string]*Tenant, len(c.Tenants))
+	for _, t := range c.Tenants {
+		if !nameRe.MatchString(t.Name) {
+			return fmt.Errorf("租户名称无效: %q", t.Name)
+		}
+		if t.Target == "" {
+			return fmt.Errorf("租户 %s 未设置 target", t.Name)
+		}
+		if _, ok := m[t.Name]; ok {
+			return fmt.Errorf("租户名称重复: %s", t.Name)
+		}
+		m[t.Name] = t
+	}
+	mu.Lock()
+	tenants = m
+	mu.Unlock()
+	return nil
+}
+
+// Get 获取租户
+func Get(name string) (*Tenant, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	t, ok := tenants[name]
+	return t, ok
+}
+
+// Enabled 是否配置了租户
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(tenants) > 0
+}
+
+// Prefix 租户的访问路径前缀
+func (t *Tenant) Prefix() string {
+	return Route + t.Name
+}
+
+// CookieName 租户密码 cookie 名称
+func (t *Tenant) CookieName() string {
+	return "p_" + t.Name
+}
+
+// Open 租户是否无需认证
+func (t *Tenant) Open() bool {
+	return t.Pass == "" && len(t.ApiKeys) == 0
+}
+
+// CheckPass 校验密码
+func (t *Tenant) CheckPass(pass string) bool {
+	return t.Pass != "" && subtle.ConstantTimeCompare([]byte(pass), []byte(t.Pass)) == 1
+}
+
+// CheckKey 校验接口密钥
+func (t *Tenant) CheckKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, k := range t.ApiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			return true
+		}
+	}
+	return false
+}
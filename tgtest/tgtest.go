@@ -0,0 +1,237 @@
+// Package tgtest 模拟 Telegram Bot API，用于不访问 Telegram 的集成测试
+//
+// 支持 getMe、sendDocument、getFile、forwardMessage、sendMessage、deleteMessage 及文件下载（包括 Range 请求），
+// 其他方法直接返回成功；将 conf.TgBotApiProxy 设置为 Server.URL 即可使用
+package tgtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server 模拟的 Bot API 服务
+type Server struct {
+	*httptest.Server
+	Token string
+
+	mu       sync.Mutex
+	files    map[string][]byte // 文件ID -> 内容
+	names    map[string]string // 文件ID -> 文件名
+	messages map[int]string    // 消息ID -> 文件ID，文本消息为空
+	expired  map[string]bool   // 已失效但消息仍在的文件ID
+	texts    []string          // sendMessage 发送的文本
+	hits     map[string]int    // 方法名 -> 调用次数，下载文件记为 file
+	nextID   int
+}
+
+// NewServer 启动模拟的 Bot API 服务
+func NewServer(token string) *Server {
+	s := &Server{
+		Token:    token,
+		files:    make(map[string][]byte),
+		names:    make(map[string]string),
+		messages: make(map[int]string),
+		expired:  make(map[string]bool),
+		hits:     make(map[string]int),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Put 直接登记文件，返回文件ID
+func (s *Server) Put(name string, data []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, id := s.addFile(name, data)
+	return id
+}
+
+// File 读取已上传的文件内容
+func (s *Server) File(id string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[id]
+	return data, ok
+}
+
+// Name 读取已上传文件的文件名
+func (s *Server) Name(id string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.names[id]
+}
+
+// Texts 返回 sendMessage 发送的全部文本
+func (s *Server) Texts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.texts...)
+}
+
+// Hits 返回方法的调用次数，文件下载为 "file"
+func (s *Server) Hits(method string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits[method]
+}
+
+// Remove 删除文件及其所在的消息，模拟 Telegram 中的消息被删除
+func (s *Server) Remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.files, id)
+	for msgID, fileID := range s.messages {
+		if fileID == id {
+			delete(s.messages, msgID)
+		}
+	}
+}
+
+// Expire 使文件ID失效而消息仍在，转发消息可以得到新的文件ID
+func (s *Server) Expire(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expired[id] = true
+}
+
+// addFile 登记文件并分配消息ID，调用方需持有锁
+func (s *Server) addFile(name string, data []byte) (int, string) {
+	s.nextID++
+	id := "file" + strconv.Itoa(s.nextID)
+	s.files[id] = data
+	s.names[id] = name
+	s.messages[s.nextID] = id
+	return s.nextID, id
+}
+
+// reply 返回成功结果
+func reply(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+// fail 返回 Bot API 错误
+func fail(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error_code": code, "description": description})
+}
+
+// chat 按 chat_id 生成会话，@ 开头时为公开频道
+func chat(id string) map[string]interface{} {
+	if strings.HasPrefix(id, "@") {
+		return map[string]interface{}{"id": -1001, "type": "channel", "username": id[1:]}
+	}
+	n, _ := strconv.ParseInt(id, 10, 64)
+	return map[string]interface{}{"id": n, "type": "channel"}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	if path := strings.TrimPrefix(r.URL.Path, "/file/bot"+s.Token+"/"); path != r.URL.Path {
+		s.serveFile(w, r, path)
+		return
+	}
+	method := strings.TrimPrefix(r.URL.Path, "/bot"+s.Token+"/")
+	if method == r.URL.Path {
+		fail(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+		fail(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hits[method]++
+	switch method {
+	case "getMe":
+		reply(w, map[string]interface{}{"id": 1, "is_bot": true, "first_name": "tgState", "username": "tgstate_test_bot"})
+	case "sendDocument":
+		f, header, err := r.FormFile("document")
+		if err != nil {
+			fail(w, http.StatusBadRequest, "Bad Request: there is no document in the request")
+			return
+		}
+		data, _ := io.ReadAll(f)
+		f.Close()
+		msgID, id := s.addFile(header.Filename, data)
+		reply(w, map[string]interface{}{
+			"message_id": msgID,
+			"date":       time.Now().Unix(),
+			"chat":       chat(r.FormValue("chat_id")),
+			"document": map[string]interface{}{
+				"file_id":        id,
+				"file_unique_id": "u" + id,
+				"file_name":      header.Filename,
+				"file_size":      len(data),
+			},
+		})
+	case "getFile":
+		id := r.FormValue("file_id")
+		data, ok := s.files[id]
+		if !ok {
+			fail(w, http.StatusBadRequest, "Bad Request: invalid file_id")
+			return
+		}
+		if s.expired[id] {
+			fail(w, http.StatusBadRequest, "Bad Request: wrong file_id or the file is temporarily unavailable")
+			return
+		}
+		reply(w, map[string]interface{}{"file_id": id, "file_unique_id": "u" + id, "file_size": len(data), "file_path": "documents/" + id})
+	case "forwardMessage":
+		// 转发得到的文件ID与原文件ID不同，内容相同
+		from, _ := strconv.Atoi(r.FormValue("message_id"))
+		src, ok := s.messages[from]
+		if !ok || src == "" {
+			fail(w, http.StatusBadRequest, "Bad Request: message to forward not found")
+			return
+		}
+		msgID, id := s.addFile(s.names[src], s.files[src])
+		reply(w, map[string]interface{}{
+			"message_id": msgID,
+			"date":       time.Now().Unix(),
+			"chat":       chat(r.FormValue("chat_id")),
+			"document": map[string]interface{}{
+				"file_id":        id,
+				"file_unique_id": "u" + src,
+				"file_name":      s.names[src],
+				"file_size":      len(s.files[src]),
+			},
+		})
+	case "sendMessage":
+		s.nextID++
+		s.messages[s.nextID] = ""
+		s.texts = append(s.texts, r.FormValue("text"))
+		reply(w, map[string]interface{}{"message_id": s.nextID, "date": time.Now().Unix(), "chat": chat(r.FormValue("chat_id")), "text": r.FormValue("text")})
+	case "deleteMessage":
+		id, _ := strconv.Atoi(r.FormValue("message_id"))
+		if _, ok := s.messages[id]; !ok {
+			fail(w, http.StatusBadRequest, "Bad Request: message to delete not found")
+			return
+		}
+		delete(s.messages, id)
+		reply(w, true)
+	default:
+		reply(w, true)
+	}
+}
+
+// serveFile 下载文件，支持 Range 请求
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, path string) {
+	id := strings.TrimPrefix(path, "documents/")
+	s.mu.Lock()
+	s.hits["file"]++
+	data, ok := s.files[id]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
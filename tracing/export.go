@@ -0,0 +1,183 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/metrics"
+)
+
+const (
+	// batchSize 单次导出的最大 span 数
+	batchSize = 512
+	// flushInterval 未攒满一批时的导出间隔
+	flushInterval = 5 * time.Second
+	// queueSize 等待导出的 span 上限，导出跟不上时丢弃新的 span
+	queueSize = 4096
+)
+
+// exporter 后台批量导出 span
+type exporter struct {
+	url     string
+	service string
+	headers map[string]string
+	queue   chan *Span
+	client  *http.Client
+}
+
+var exp *exporter
+
+// Init 开启追踪，endpoint 为 OTLP/HTTP 地址，如 http://localhost:4318，未以 /v1/traces 结尾时自动补全；
+// headers 为 k=v,k2=v2 形式的附加请求头，用于后端认证
+func Init(endpoint, service, headers string) {
+	metrics.Help("tgstate_trace_spans_dropped_total", "Spans dropped because the export queue was full or the export failed")
+	exp = newExporter(endpoint, service, headers)
+	go exp.loop()
+}
+
+func newExporter(endpoint, service, headers string) *exporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	e := &exporter{url: url, service: service, headers: map[string]string{}, queue: make(chan *Span, queueSize), client: &http.Client{Timeout: 10 * time.Second}}
+	for _, kv := range strings.Split(headers, ",") {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.TrimSpace(k) != "" {
+			e.headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return e
+}
+
+func (e *exporter) add(s *Span) {
+	select {
+	case e.queue <- s:
+	default:
+		metrics.Inc("tgstate_trace_spans_dropped_total")
+	}
+}
+
+func (e *exporter) loop() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, batchSize)
+	for {
+		select {
+		case s := <-e.queue:
+			if batch = append(batch, s); len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		}
+		if err := e.export(batch); err != nil {
+			log.Printf("导出追踪数据失败: %v", err)
+			metrics.Add("tgstate_trace_spans_dropped_total", int64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+}
+
+// export 以 OTLP JSON 格式发送一批 span
+func (e *exporter) export(batch []*Span) error {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		spans = append(spans, s.otlp())
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource":   map[string]interface{}{"attributes": []otlpAttr{attr("service.name", e.service)}},
+			"scopeSpans": []interface{}{map[string]interface{}{"scope": map[string]string{"name": "csz.net/tgstate"}, "spans": spans}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("状态码 %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type otlpSpan struct {
+	TraceID      string                 `json:"traceId"`
+	SpanID       string                 `json:"spanId"`
+	ParentSpanID string                 `json:"parentSpanId,omitempty"`
+	Name         string                 `json:"name"`
+	Kind         int                    `json:"kind"`
+	Start        string                 `json:"startTimeUnixNano"`
+	End          string                 `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr             `json:"attributes,omitempty"`
+	Status       map[string]interface{} `json:"status,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// attr 按值的类型生成 OTLP 属性，64 位整数按规范以字符串表示
+func attr(key string, v interface{}) otlpAttr {
+	var val map[string]interface{}
+	switch v := v.(type) {
+	case string:
+		val = map[string]interface{}{"stringValue": v}
+	case bool:
+		val = map[string]interface{}{"boolValue": v}
+	case int:
+		val = map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		val = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		val = map[string]interface{}{"doubleValue": v}
+	default:
+		val = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+	}
+	return otlpAttr{Key: key, Value: val}
+}
+
+func (s *Span) otlp() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := otlpSpan{
+		TraceID: hex.EncodeToString(s.traceID[:]),
+		SpanID:  hex.EncodeToString(s.spanID[:]),
+		Name:    s.name,
+		Kind:    s.kind,
+		Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+		End:     strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parent != [8]byte{} {
+		o.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	for k, v := range s.attrs {
+		o.Attributes = append(o.Attributes, attr(k, v))
+	}
+	if s.errMsg != "" {
+		// STATUS_CODE_ERROR
+		o.Status = map[string]interface{}{"code": 2, "message": s.errMsg}
+	}
+	return o
+}
@@ -0,0 +1,152 @@
+// Package tracing 简单的请求追踪，span 以 OTLP/HTTP JSON 格式批量导出，
+// 可直接发送到 OpenTelemetry Collector、Jaeger、Tempo 等兼容 OTLP 的后端
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Span 类型，与 OTLP 的 SpanKind 一致
+const (
+	Internal = 1
+	Server   = 2
+	Client   = 3
+)
+
+// Span 一次操作的耗时及属性，未开启追踪时为 nil，各方法均可在 nil 上调用
+type Span struct {
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	kind    int
+	start   time.Time
+	end     time.Time
+
+	mu     sync.Mutex
+	name   string
+	attrs  map[string]interface{}
+	errMsg string
+	ended  bool
+}
+
+type spanKey struct{}
+
+// remoteParent 请求头 traceparent 中的上游 span
+type remoteParent struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+// Enabled 是否开启了追踪
+func Enabled() bool {
+	return exp != nil
+}
+
+// Start 在 ctx 中的 span 下开始新的 span，未开启追踪或上游未采样时返回 nil
+func Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if exp == nil || ctx.Value(unsampledKey{}) != nil {
+		return ctx, nil
+	}
+	s := &Span{name: name, kind: kind, start: time.Now()}
+	switch p := ctx.Value(spanKey{}).(type) {
+	case *Span:
+		s.traceID, s.parent = p.traceID, p.spanID
+	case remoteParent:
+		s.traceID, s.parent = p.traceID, p.spanID
+	default:
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// FromContext 返回 ctx 中的 span
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+type unsampledKey struct{}
+
+// Extract 读取 W3C traceparent 请求头，新的 span 作为上游 span 的子 span，上游未采样时不记录
+func Extract(ctx context.Context, h http.Header) context.Context {
+	parts := strings.Split(strings.TrimSpace(h.Get("Traceparent")), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx
+	}
+	var p remoteParent
+	flags, err := hex.DecodeString(parts[3])
+	if _, err1 := hex.Decode(p.traceID[:], []byte(parts[1])); err1 != nil || err != nil {
+		return ctx
+	}
+	if _, err := hex.Decode(p.spanID[:], []byte(parts[2])); err != nil || p.traceID == [16]byte{} || p.spanID == [8]byte{} {
+		return ctx
+	}
+	if flags[0]&1 == 0 {
+		return context.WithValue(ctx, unsampledKey{}, true)
+	}
+	return context.WithValue(ctx, spanKey{}, p)
+}
+
+// TraceID 返回追踪ID，未记录时为空
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// SetName 修改 span 名称，如路由匹配后改为路由模式
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+// SetAttr 设置属性，值可以是字符串、整数、浮点数或布尔值
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.attrs == nil {
+		s.attrs = map[string]interface{}{}
+	}
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+// SetError 将 span 标记为失败，err 为 nil 时不变
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.errMsg = err.Error()
+	s.mu.Unlock()
+}
+
+// End 结束 span 并加入导出队列，重复调用无效
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	exp.add(s)
+}
@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestExtractAndExport(t *testing.T) {
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Authorization") != "Bearer x" {
+			t.Errorf("请求 %s，Authorization: %q", r.URL.Path, r.Header.Get("Authorization"))
+		}
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+	// 不启动后台导出，由测试直接取出队列中的 span
+	exp = newExporter(srv.URL, "tgstate-test", "Authorization=Bearer x")
+	defer func() { exp = nil }()
+
+	h := http.Header{}
+	h.Set("Traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	if _, span := Start(Extract(context.Background(), h), "GET", Server); span != nil {
+		t.Fatal("上游未采样时不应记录")
+	}
+
+	h.Set("Traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	ctx, root := Start(Extract(context.Background(), h), "GET", Server)
+	root.SetName("GET /d/{id}")
+	_, child := Start(ctx, "telegram getFile", Client)
+	child.SetAttr("file.id", "abc")
+	child.SetError(errors.New("超时"))
+	child.End()
+	root.End()
+	if root.TraceID() != "0af7651916cd43dd8448eb211c80319c" || child.TraceID() != root.TraceID() {
+		t.Fatalf("追踪ID %s %s", root.TraceID(), child.TraceID())
+	}
+
+	if err := exp.export([]*Span{<-exp.queue, <-exp.queue}); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []otlpSpan
+			}
+		}
+	}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	spans := got.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("导出 %d 个 span", len(spans))
+	}
+	c, r := spans[0], spans[1]
+	if r.Name != "GET /d/{id}" || r.ParentSpanID != "b7ad6b7169203331" || r.Kind != Server {
+		t.Errorf("根 span %+v", r)
+	}
+	if c.ParentSpanID != r.SpanID || c.Status["code"] != float64(2) || len(c.Attributes) != 1 || c.Attributes[0].Value["stringValue"] != "abc" {
+		t.Errorf("子 span %+v", c)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	ctx, span := Start(context.Background(), "x", Internal)
+	span.SetAttr("k", 1)
+	span.End()
+	if span != nil || FromContext(ctx) != nil {
+		t.Fatal("未开启时不应记录")
+	}
+}
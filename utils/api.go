@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// fileIDRe Telegram 文件ID只包含 base64url 字符，长度留出临时文件后缀的余量
+var fileIDRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,200}$`)
+
+// ErrInvalidFileID 文件ID包含路径分隔符等不允许的字符
+var ErrInvalidFileID = errors.New("invalid file id")
+
+// ValidFileID 文件ID可以安全地用作缓存文件名
+func ValidFileID(id string) bool {
+	return fileIDRe.MatchString(id)
+}
+
+// telegramApi 官方 Bot API 地址
+const telegramApi = "https://api.telegram.org"
+
+// apiBase Bot API 地址，配置了 tgbotapiproxy 时使用代理或自建的 Bot API 服务
+func apiBase() string {
+	if conf.TgBotApiProxy != "" {
+		return strings.TrimSuffix(conf.TgBotApiProxy, "/")
+	}
+	return telegramApi
+}
+
+// NewBot 创建 Bot API 客户端
+func NewBot() (*tgbotapi.BotAPI, error) {
+	return tgbotapi.NewBotAPIWithAPIEndpoint(conf.BotToken, apiBase()+"/bot%s/%s")
+}
+
+// fileLink 文件的下载地址，path 为 getFile 返回的 file_path
+func fileLink(path string) string {
+	return apiBase() + "/file/bot" + conf.BotToken + "/" + path
+}
+
+// replicaBases 额外的文件下载地址，与主地址指向同一 Bot API 的反向代理
+func replicaBases() []string {
+	var bases []string
+	for _, s := range strings.Split(conf.DownloadReplicas, ",") {
+		if s = strings.TrimSuffix(strings.TrimSpace(s), "/"); s != "" {
+			bases = append(bases, s)
+		}
+	}
+	return bases
+}
+
+// Replicas 可以下载文件内容的地址数量，包括主地址
+func Replicas() int {
+	return 1 + len(replicaBases())
+}
+
+// ReplicaUrl 将主地址的下载链接改写为第 n 个地址，0 为主地址，超出数量时循环使用
+func ReplicaUrl(fileURL string, n int) string {
+	bases := replicaBases()
+	prefix := apiBase() + "/file/"
+	if n%(len(bases)+1) == 0 || !strings.HasPrefix(fileURL, prefix) {
+		return fileURL
+	}
+	return bases[n%(len(bases)+1)-1] + "/file/" + fileURL[len(prefix):]
+}
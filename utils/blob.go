@@ -0,0 +1,214 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"csz.net/tgstate/conf"
+)
+
+// BlobHeader 分块索引文件的标识，v1 为首行，v2 为 format 字段
+const BlobHeader = "tgstate-blob"
+
+// 分块及清单上传到 Telegram 时使用的文件名，网页端分块上传也使用相同的名称
+const (
+	BlobChunkName    = "blob"
+	BlobManifestName = "fileAll.json"
+)
+
+// BlobVersion 当前生成的分块索引版本
+const BlobVersion = 2
+
+// 分块大小限制，getFile 只能下载不超过 20MB 的文件
+const (
+	MinChunkSize = 1024 * 1024
+	MaxChunkSize = 20 * 1024 * 1024
+)
+
+// ErrInvalidBlob 分块索引格式错误
+var ErrInvalidBlob = errors.New("invalid blob index")
+
+// BlobChunk 分块信息
+type BlobChunk struct {
+	ID     string `json:"id"`
+	Size   int64  `json:"size"`             // v1 索引未记录分块大小时为 0
+	Sha256 string `json:"sha256,omitempty"` // 十六进制，v1 索引没有
+}
+
+// BlobIndex 分块上传的索引
+//
+// v2 为 JSON 清单：
+//
+//	{"format":"tgstate-blob","version":2,"name":"a.zip","size":123,"mime":"application/zip",
+//	 "chunk_size":19922944,"chunks":[{"id":"...","size":19922944,"sha256":"..."}]}
+//
+// v1 为多行文本，仍可读取：
+//
+//	tgstate-blob
+//	文件名
+//	size<总大小>
+//	chunk<分块大小>（可选）
+//	分块文件ID...
+type BlobIndex struct {
+	Format    string      `json:"format"`
+	Version   int         `json:"version"`
+	Name      string      `json:"name"`
+	Size      int64       `json:"size"`
+	Mime      string      `json:"mime,omitempty"`
+	ChunkSize int64       `json:"chunk_size,omitempty"`
+	Chunks    []BlobChunk `json:"chunks"`
+}
+
+// IsBlobIndex 根据文件头部判断内容是否为分块索引
+//
+// v2 清单由 Manifest 生成，format 总是第一个字段，只按前缀匹配，
+// 避免用户上传的普通 JSON 中恰好包含该字段时被误判
+func IsBlobIndex(head []byte) bool {
+	if bytes.HasPrefix(head, []byte(BlobHeader+"\n")) {
+		return true
+	}
+	return bytes.HasPrefix(bytes.TrimSpace(head), []byte(`{"format":"`+BlobHeader+`"`))
+}
+
+// ParseBlobIndex 解析 v1 或 v2 分块索引
+func ParseBlobIndex(data []byte) (*BlobIndex, error) {
+	data = bytes.TrimSpace(data)
+	var idx *BlobIndex
+	var err error
+	if bytes.HasPrefix(data, []byte("{")) {
+		idx, err = parseBlobManifest(data)
+	} else {
+		idx, err = parseBlobLines(string(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if idx.Mime == "" {
+		idx.Mime = TypeByName(idx.Name)
+	}
+	return idx, nil
+}
+
+func parseBlobManifest(data []byte) (*BlobIndex, error) {
+	var idx BlobIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, ErrInvalidBlob
+	}
+	if idx.Format != BlobHeader || idx.Version < 2 || idx.Size < 0 || len(idx.Chunks) == 0 {
+		return nil, ErrInvalidBlob
+	}
+	var total int64
+	for _, c := range idx.Chunks {
+		// 分块大小之和溢出时可能恰好等于总大小
+		if c.ID == "" || c.Size <= 0 || c.Size > idx.Size-total {
+			return nil, ErrInvalidBlob
+		}
+		total += c.Size
+	}
+	if total != idx.Size {
+		return nil, ErrInvalidBlob
+	}
+	return &idx, nil
+}
+
+func parseBlobLines(data string) (*BlobIndex, error) {
+	lines := strings.Split(data, "\n")
+	if len(lines) < 4 || lines[0] != BlobHeader || !strings.HasPrefix(lines[2], "size") {
+		return nil, ErrInvalidBlob
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(lines[2], "size")), 10, 64)
+	if err != nil || size < 0 {
+		return nil, ErrInvalidBlob
+	}
+	idx := &BlobIndex{Format: BlobHeader, Version: 1, Name: strings.TrimSpace(lines[1]), Size: size}
+	rest := lines[3:]
+	if strings.HasPrefix(rest[0], "chunk") {
+		idx.ChunkSize, err = strconv.ParseInt(strings.TrimSpace(strings.TrimPrefix(rest[0], "chunk")), 10, 64)
+		if err != nil || idx.ChunkSize <= 0 {
+			return nil, ErrInvalidBlob
+		}
+		rest = rest[1:]
+	}
+	for _, line := range rest {
+		if id := strings.ReplaceAll(strings.TrimSpace(line), " ", ""); id != "" {
+			idx.Chunks = append(idx.Chunks, BlobChunk{ID: id})
+		}
+	}
+	n := int64(len(idx.Chunks))
+	if n == 0 {
+		return nil, ErrInvalidBlob
+	}
+	// 记录了分块大小时可推算每个分块的大小，从而支持 Range 请求
+	// 分块大小乘以分块数可能溢出，先确认前 n-1 个分块小于总大小
+	if idx.ChunkSize > 0 && (n == 1 || idx.ChunkSize <= (size-1)/(n-1)) {
+		if last := size - idx.ChunkSize*(n-1); last > 0 && last <= idx.ChunkSize {
+			for i := range idx.Chunks {
+				idx.Chunks[i].Size = idx.ChunkSize
+			}
+			idx.Chunks[n-1].Size = last
+		}
+	}
+	return idx, nil
+}
+
+// Seekable 是否已知每个分块的大小，可以按偏移读取
+func (idx *BlobIndex) Seekable() bool {
+	for _, c := range idx.Chunks {
+		if c.Size <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Manifest 生成 v2 JSON 清单
+func (idx *BlobIndex) Manifest() []byte {
+	m := *idx
+	m.Format, m.Version = BlobHeader, BlobVersion
+	data, _ := json.Marshal(m)
+	return data
+}
+
+// UpBlob 上传文件，超过分块大小时分块上传并生成分块清单
+func UpBlob(fileName string, r io.Reader, size int64) (string, error) {
+	chunkSize := conf.ChunkSize
+	if size <= chunkSize {
+		return UpDocument(TgFileData(fileName, r))
+	}
+	idx := &BlobIndex{
+		Name:      fileName,
+		Size:      size,
+		Mime:      TypeByName(fileName),
+		ChunkSize: chunkSize,
+	}
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			id, err := UpDocument(TgFileData(BlobChunkName, bytes.NewReader(buf[:n])))
+			if err != nil {
+				return "", err
+			}
+			sum := sha256.Sum256(buf[:n])
+			idx.Chunks = append(idx.Chunks, BlobChunk{ID: id, Size: int64(n), Sha256: hex.EncodeToString(sum[:])})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	// 实际读取的大小可能与声明的不同，以实际为准
+	idx.Size = 0
+	for _, c := range idx.Chunks {
+		idx.Size += c.Size
+	}
+	return UpDocument(TgFileData(BlobManifestName, bytes.NewReader(idx.Manifest())))
+}
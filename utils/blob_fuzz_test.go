@@ -0,0 +1,38 @@
+package utils
+
+import "testing"
+
+func FuzzParseBlobIndex(f *testing.F) {
+	f.Add([]byte("tgstate-blob\na.zip\nsize30\nchunk10\nid1\nid2\nid3"))
+	f.Add([]byte("tgstate-blob\na.zip\nsize25\nid1\nid2"))
+	f.Add([]byte(`{"format":"tgstate-blob","version":2,"name":"a.zip","size":15,"chunks":[{"id":"a","size":10},{"id":"b","size":5}]}`))
+	f.Add([]byte(`{"format":"tgstate-blob","version":2,"size":1,"chunks":[{"id":"a","size":9223372036854775807},{"id":"b","size":9223372036854775807},{"id":"c","size":3}]}`))
+	f.Add([]byte("tgstate-blob\na\nsize9223372036854775807\nchunk4611686018427387904\nx\ny\nz"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		idx, err := ParseBlobIndex(data)
+		if err != nil {
+			return
+		}
+		if len(idx.Chunks) == 0 || idx.Size < 0 {
+			t.Fatalf("accepted index without chunks or with negative size: %+v", idx)
+		}
+		if !idx.Seekable() {
+			return
+		}
+		// 可按偏移读取时各分块大小之和必须等于总大小，否则 Range 计算会越界
+		var total int64
+		for _, c := range idx.Chunks {
+			if c.Size <= 0 || total+c.Size < total {
+				t.Fatalf("invalid chunk sizes: %+v", idx.Chunks)
+			}
+			total += c.Size
+		}
+		if total != idx.Size {
+			t.Fatalf("chunk sizes sum to %d, index size %d", total, idx.Size)
+		}
+		again, err := ParseBlobIndex(idx.Manifest())
+		if err != nil || again.Size != idx.Size || len(again.Chunks) != len(idx.Chunks) {
+			t.Fatalf("manifest round trip = %+v, %v", again, err)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseBlobIndex(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		err    bool
+		size   int64
+		chunks []int64 // 期望的各分块大小，0 表示未知
+		mime   string
+	}{
+		{
+			name:   "v1 无 chunk 行",
+			data:   "tgstate-blob\na.zip\nsize300\nid1\nid2\n",
+			size:   300,
+			chunks: []int64{0, 0},
+			mime:   "application/zip",
+		},
+		{
+			name:   "v1 带 chunk 行推算分块大小",
+			data:   "tgstate-blob\na.bin\nsize250\nchunk100\nid1\nid2\nid3",
+			size:   250,
+			chunks: []int64{100, 100, 50},
+		},
+		{
+			name:   "v1 chunk 与总大小不符时不推算",
+			data:   "tgstate-blob\na.bin\nsize500\nchunk100\nid1\nid2",
+			size:   500,
+			chunks: []int64{0, 0},
+		},
+		{
+			name: "v1 chunk 行无效",
+			data: "tgstate-blob\na.bin\nsize250\nchunkx\nid1",
+			err:  true,
+		},
+		{
+			name: "v1 缺少分块",
+			data: "tgstate-blob\na.bin\nsize250\nchunk100\n",
+			err:  true,
+		},
+		{
+			name: "v1 size 行无效",
+			data: "tgstate-blob\na.bin\n250\nid1\nid2",
+			err:  true,
+		},
+		{
+			name:   "v2 正常",
+			data:   `{"format":"tgstate-blob","version":2,"name":"a.mp4","size":30,"mime":"video/mp4","chunks":[{"id":"a","size":20},{"id":"b","size":10}]}`,
+			size:   30,
+			chunks: []int64{20, 10},
+			mime:   "video/mp4",
+		},
+		{
+			name: "v2 总大小不符",
+			data: `{"format":"tgstate-blob","version":2,"name":"a","size":31,"chunks":[{"id":"a","size":20},{"id":"b","size":10}]}`,
+			err:  true,
+		},
+		{
+			name: "v2 分块大小为 0",
+			data: `{"format":"tgstate-blob","version":2,"name":"a","size":20,"chunks":[{"id":"a","size":20},{"id":"b","size":0}]}`,
+			err:  true,
+		},
+		{
+			name: "v2 版本过低",
+			data: `{"format":"tgstate-blob","version":1,"name":"a","size":20,"chunks":[{"id":"a","size":20}]}`,
+			err:  true,
+		},
+		{
+			name: "v2 没有分块",
+			data: `{"format":"tgstate-blob","version":2,"name":"a","size":0,"chunks":[]}`,
+			err:  true,
+		},
+		{
+			name: "普通 JSON",
+			data: `{"format":"other","version":2}`,
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx, err := ParseBlobIndex([]byte(tt.data))
+			if tt.err {
+				if err == nil {
+					t.Fatalf("期望错误，得到 %+v", idx)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if idx.Size != tt.size {
+				t.Errorf("size = %d，期望 %d", idx.Size, tt.size)
+			}
+			if len(idx.Chunks) != len(tt.chunks) {
+				t.Fatalf("分块数 = %d，期望 %d", len(idx.Chunks), len(tt.chunks))
+			}
+			seekable := true
+			for i, c := range idx.Chunks {
+				if c.Size != tt.chunks[i] {
+					t.Errorf("分块 %d 大小 = %d，期望 %d", i, c.Size, tt.chunks[i])
+				}
+				seekable = seekable && tt.chunks[i] > 0
+			}
+			if idx.Seekable() != seekable {
+				t.Errorf("Seekable = %v，期望 %v", idx.Seekable(), seekable)
+			}
+			if tt.mime != "" && idx.Mime != tt.mime {
+				t.Errorf("mime = %q，期望 %q", idx.Mime, tt.mime)
+			}
+		})
+	}
+}
+
+func TestBlobManifestRoundTrip(t *testing.T) {
+	idx := &BlobIndex{Name: "a.txt", Size: 3, Chunks: []BlobChunk{{ID: "x", Size: 1}, {ID: "y", Size: 2}}}
+	data := idx.Manifest()
+	if !IsBlobIndex(data) {
+		t.Fatalf("生成的清单未被识别: %s", data)
+	}
+	got, err := ParseBlobIndex(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != BlobVersion || got.Size != 3 || len(got.Chunks) != 2 {
+		t.Errorf("解析结果不符: %+v", got)
+	}
+}
+
+func TestIsBlobIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		head string
+		want bool
+	}{
+		{"v1 首行", "tgstate-blob\na.zip\nsize1\nid", true},
+		{"v2 清单", `{"format":"tgstate-blob","version":2}`, true},
+		{"v2 清单前有空白", "\n  " + `{"format":"tgstate-blob"`, true},
+		{"v1 首行不完整", "tgstate-blobx\n", false},
+		{"文本中提到标识", "see tgstate-blob\n", false},
+		{"JSON 嵌套字段", `{"data":{"format":"tgstate-blob"}}`, false},
+		{"JSON 字段顺序不同", `{"name":"a","format":"tgstate-blob"}`, false},
+		{"JSON 数组", `[{"format":"tgstate-blob"}]`, false},
+		{"JSON 其他格式", `{"format":"tgstate-blob2"}`, false},
+		{"二进制", strings.Repeat("\x00", 16), false},
+		{"空", "", false},
+	}
+	for _, tt := range tests {
+		if got := IsBlobIndex([]byte(tt.head)); got != tt.want {
+			t.Errorf("%s: IsBlobIndex = %v，期望 %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"encoding/json"
+	"log"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+
+	"csz.net/tgstate/conf"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Button 消息下方的内联按钮，Data 为回调数据，格式为 "{前缀}:{参数}"，不超过 64 字节
+type Button struct {
+	Text string
+	Data string
+}
+
+// CallbackHandler 处理按钮回调，arg 为回调数据中前缀之后的部分，返回提示给操作者的文字
+type CallbackHandler func(arg string) string
+
+var (
+	callbacks   = make(map[string]CallbackHandler)
+	callbacksMu sync.RWMutex
+)
+
+// HandleCallback 注册指定前缀的按钮回调，只处理管理员及审核会话中的按钮
+func HandleCallback(prefix string, h CallbackHandler) {
+	callbacksMu.Lock()
+	callbacks[prefix] = h
+	callbacksMu.Unlock()
+}
+
+// SendChat 发送消息到指定会话，按钮排成一行
+func SendChat(chat, text string, buttons ...Button) error {
+	bot, err := NewBot()
+	if err != nil {
+		return err
+	}
+	params := tgbotapi.Params{
+		"chat_id":                  chat,
+		"text":                     text,
+		"disable_web_page_preview": "true",
+	}
+	if len(buttons) > 0 {
+		row := make([]tgbotapi.InlineKeyboardButton, len(buttons))
+		for i, b := range buttons {
+			row[i] = tgbotapi.NewInlineKeyboardButtonData(b.Text, b.Data)
+		}
+		markup, _ := json.Marshal(tgbotapi.NewInlineKeyboardMarkup(row))
+		params["reply_markup"] = string(markup)
+	}
+	_, err = botRequest(bot, "sendMessage", params)
+	return err
+}
+
+// SendAdmin 发送通知到管理员会话，未配置 adminchat 时忽略
+func SendAdmin(text string) error {
+	if conf.AdminChat == "" {
+		return nil
+	}
+	return SendChat(conf.AdminChat, text)
+}
+
+// isModerator 回调是否来自管理员或审核会话
+func isModerator(q *tgbotapi.CallbackQuery) bool {
+	for _, chat := range []string{conf.AdminChat, conf.ModChat} {
+		if chat == "" {
+			continue
+		}
+		if q.Message != nil && (strconv.FormatInt(q.Message.Chat.ID, 10) == chat || "@"+q.Message.Chat.UserName == chat) {
+			return true
+		}
+		if q.From != nil && strconv.FormatInt(q.From.ID, 10) == chat {
+			return true
+		}
+	}
+	return false
+}
+
+// handleCallback 分发按钮回调，处理后移除按钮并在消息末尾附上结果
+func handleCallback(bot *tgbotapi.BotAPI, q *tgbotapi.CallbackQuery) {
+	prefix, arg, _ := strings.Cut(q.Data, ":")
+	callbacksMu.RLock()
+	h, ok := callbacks[prefix]
+	callbacksMu.RUnlock()
+	if !ok || !isModerator(q) {
+		bot.Request(tgbotapi.NewCallback(q.ID, ""))
+		return
+	}
+	result := h(arg)
+	if _, err := bot.Request(tgbotapi.NewCallback(q.ID, result)); err != nil {
+		log.Printf("回复按钮回调失败: %v", err)
+	}
+	if q.Message != nil {
+		edit := tgbotapi.NewEditMessageText(q.Message.Chat.ID, q.Message.MessageID, q.Message.Text+"\n\n"+result)
+		edit.DisableWebPagePreview = true
+		if _, err := bot.Request(edit); err != nil {
+			log.Printf("更新消息失败: %v", err)
+		}
+	}
+}
+
+// InlineResult 内联查询的结果，用户选择后发送 Text
+type InlineResult struct {
+	ID          string // 结果标识，不超过 64 字节
+	Title       string
+	Description string
+	Url         string
+	Text        string
+}
+
+// InlineHandler 处理内联查询，offset 为分页位置，返回结果及下一页的位置，没有更多结果时为空
+type InlineHandler func(query string, offset int) ([]InlineResult, string)
+
+var inlineHandler InlineHandler
+
+// HandleInline 注册内联查询（@bot 关键字）的处理函数，只响应 botusers 及 adminchat 中的用户
+func HandleInline(h InlineHandler) {
+	callbacksMu.Lock()
+	inlineHandler = h
+	callbacksMu.Unlock()
+}
+
+// AuthorizedUser 是否为允许使用机器人命令及内联查询的 Telegram 用户
+func AuthorizedUser(id int64) bool {
+	uid := strconv.FormatInt(id, 10)
+	if uid == conf.AdminChat {
+		return true
+	}
+	for _, u := range strings.Split(conf.BotUsers, ",") {
+		if strings.TrimSpace(u) == uid {
+			return true
+		}
+	}
+	return false
+}
+
+// handleInline 响应内联查询，未授权的用户返回空结果
+func handleInline(bot *tgbotapi.BotAPI, q *tgbotapi.InlineQuery) {
+	callbacksMu.RLock()
+	h := inlineHandler
+	callbacksMu.RUnlock()
+	answer := tgbotapi.InlineConfig{
+		InlineQueryID: q.ID,
+		Results:       []interface{}{},
+		CacheTime:     10,
+		IsPersonal:    true,
+	}
+	if h != nil && q.From != nil && AuthorizedUser(q.From.ID) {
+		offset, _ := strconv.Atoi(q.Offset)
+		results, next := h(q.Query, offset)
+		for _, res := range results {
+			article := tgbotapi.NewInlineQueryResultArticle(res.ID, res.Title, res.Text)
+			article.Description = res.Description
+			article.URL = res.Url
+			answer.Results = append(answer.Results, article)
+		}
+		answer.NextOffset = next
+	}
+	if _, err := bot.Request(answer); err != nil {
+		log.Printf("回复内联查询失败: %v", err)
+	}
+}
+
+// TextHandler 处理授权用户私聊机器人的消息，user 为发送者的用户ID，返回回复的内容，为空时不回复
+type TextHandler func(user int64, text string) string
+
+var (
+	textHandler TextHandler
+	commands    = make(map[string]TextHandler)
+)
+
+// HandleText 注册私聊消息的处理函数，只处理 botusers 及 adminchat 中的用户发送的消息
+func HandleText(h TextHandler) {
+	callbacksMu.Lock()
+	textHandler = h
+	callbacksMu.Unlock()
+}
+
+// HandleCommand 注册私聊中 "/{name} 参数" 格式的命令，处理函数接收命令之后的参数
+func HandleCommand(name string, h TextHandler) {
+	callbacksMu.Lock()
+	commands[name] = h
+	callbacksMu.Unlock()
+}
+
+// parseCommand 解析 "/name@bot 参数" 格式的命令，不是命令时返回 false
+func parseCommand(text string) (string, string, bool) {
+	if !strings.HasPrefix(text, "/") {
+		return "", "", false
+	}
+	name, args, _ := strings.Cut(text[1:], " ")
+	name, _, _ = strings.Cut(name, "@")
+	return name, strings.TrimSpace(args), name != ""
+}
+
+// handleText 处理私聊消息并回复，处理可能较慢（如下载文件），调用方应在单独的协程中执行
+func handleText(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	defer func() {
+		if v := recover(); v != nil {
+			ReportPanic("bot", v, debug.Stack())
+		}
+	}()
+	text := msg.Text
+	callbacksMu.RLock()
+	h := textHandler
+	if name, args, ok := parseCommand(text); ok {
+		h, text = commands[name], args
+	}
+	callbacksMu.RUnlock()
+	if h == nil {
+		return
+	}
+	reply := h(msg.From.ID, text)
+	if reply == "" {
+		return
+	}
+	m := tgbotapi.NewMessage(msg.Chat.ID, reply)
+	m.ReplyToMessageID = msg.MessageID
+	m.DisableWebPagePreview = true
+	if _, err := bot.Send(m); err != nil {
+		log.Printf("回复消息失败: %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"testing"
+
+	"csz.net/tgstate/conf"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestIsModerator(t *testing.T) {
+	oldAdmin, oldMod := conf.AdminChat, conf.ModChat
+	defer func() { conf.AdminChat, conf.ModChat = oldAdmin, oldMod }()
+	query := func(chat int64, user string, from int64) *tgbotapi.CallbackQuery {
+		return &tgbotapi.CallbackQuery{
+			From:    &tgbotapi.User{ID: from},
+			Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chat, UserName: user}},
+		}
+	}
+	tests := []struct {
+		admin string
+		mod   string
+		q     *tgbotapi.CallbackQuery
+		want  bool
+	}{
+		{"", "", query(1, "", 1), false},
+		{"-1001", "", query(-1001, "", 5), true},
+		{"", "@mods", query(-1002, "mods", 5), true},
+		{"42", "@mods", query(-1003, "", 42), true},
+		{"42", "-1004", query(-1004, "", 43), true},
+		{"42", "-1004", query(-1003, "", 43), false},
+	}
+	for i, tt := range tests {
+		conf.AdminChat, conf.ModChat = tt.admin, tt.mod
+		if got := isModerator(tt.q); got != tt.want {
+			t.Errorf("#%d: %v，期望 %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestAuthorizedUser(t *testing.T) {
+	oldAdmin, oldUsers := conf.AdminChat, conf.BotUsers
+	defer func() { conf.AdminChat, conf.BotUsers = oldAdmin, oldUsers }()
+	conf.AdminChat, conf.BotUsers = "42", "7, 8"
+	for id, want := range map[int64]bool{42: true, 7: true, 8: true, 9: false, -42: false} {
+		if got := AuthorizedUser(id); got != want {
+			t.Errorf("%d: %v，期望 %v", id, got, want)
+		}
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		text, name, args string
+		ok               bool
+	}{
+		{"/schedule abc 2030-01-01T10:00 hi", "schedule", "abc 2030-01-01T10:00 hi", true},
+		{"/schedule@tgstate_bot  abc ", "schedule", "abc", true},
+		{"/start", "start", "", true},
+		{"https://example.com/a.png", "", "", false},
+		{"/", "", "", false},
+	}
+	for _, tt := range tests {
+		name, args, ok := parseCommand(tt.text)
+		if name != tt.name || args != tt.args || ok != tt.ok {
+			t.Errorf("parseCommand(%q) = %q, %q, %v", tt.text, name, args, ok)
+		}
+	}
+}
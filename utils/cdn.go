@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"csz.net/tgstate/conf"
+)
+
+var cdnClient = &http.Client{Timeout: 15 * time.Second}
+
+// PurgeCdn 调用 Cloudflare 接口清除指定地址的 CDN 缓存，未配置时直接返回
+func PurgeCdn(urls []string) error {
+	if conf.CfZone == "" || conf.CfToken == "" || len(urls) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(map[string][]string{"files": urls})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, "https://api.cloudflare.com/client/v4/zones/"+conf.CfZone+"/purge_cache", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+conf.CfToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := cdnClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var res struct {
+		Success bool `json:"success"`
+		Errors  []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return fmt.Errorf("状态码: %d", resp.StatusCode)
+	}
+	if !res.Success {
+		if len(res.Errors) > 0 {
+			return fmt.Errorf("清除 CDN 缓存失败: %s", res.Errors[0].Message)
+		}
+		return fmt.Errorf("清除 CDN 缓存失败，状态码: %d", resp.StatusCode)
+	}
+	return nil
+}
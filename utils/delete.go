@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+
+	"csz.net/tgstate/store"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ErrNoMessage 未记录文件所在的消息，无法从 Telegram 删除
+var ErrNoMessage = errors.New("message of file is unknown")
+
+// DeleteMessage 删除文件所在的 Telegram 消息，备份频道中的副本一并删除
+func DeleteMessage(fileID string) error {
+	st := store.Default()
+	m, ok := st.GetMessage(fileID)
+	if !ok {
+		return ErrNoMessage
+	}
+	bot, err := NewBot()
+	if err != nil {
+		return err
+	}
+	if mirror, ok := st.GetMirror(fileID); ok {
+		if mm, ok := st.GetMessage(mirror); ok {
+			botRequest(bot, "deleteMessage", tgbotapi.Params{"chat_id": mm.Chat, "message_id": strconv.Itoa(mm.ID)})
+			st.DeleteFile(mirror)
+		}
+	}
+	_, err = botRequest(bot, "deleteMessage", tgbotapi.Params{"chat_id": m.Chat, "message_id": strconv.Itoa(m.ID)})
+	return err
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"sync"
+	"time"
+
+	"csz.net/tgstate/conf"
+)
+
+// 事件类型
+const (
+	EventUpload   = "upload"
+	EventDelete   = "delete"
+	EventDownload = "download"
+	EventError    = "error"
+	EventTrash    = "trash"
+	EventRestore  = "restore"
+)
+
+// Event 实例活动事件
+type Event struct {
+	Event     string `json:"event"`
+	ID        string `json:"id,omitempty"`
+	Url       string `json:"url,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	Downloads int64  `json:"downloads,omitempty"`
+	Message   string `json:"message,omitempty"`
+	Time      int64  `json:"time"`
+}
+
+var (
+	subscribers   = make(map[chan Event]struct{})
+	subscribersMu sync.RWMutex
+)
+
+// Subscribe 订阅事件，返回的函数用于取消订阅
+func Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+	subscribersMu.Lock()
+	subscribers[ch] = struct{}{}
+	subscribersMu.Unlock()
+	return ch, func() {
+		subscribersMu.Lock()
+		delete(subscribers, ch)
+		subscribersMu.Unlock()
+	}
+}
+
+// Emit 发布事件到订阅者及 webhook
+func Emit(ev Event) {
+	if ev.Time == 0 {
+		ev.Time = time.Now().Unix()
+	}
+	subscribersMu.RLock()
+	for ch := range subscribers {
+		select {
+		case ch <- ev:
+		default:
+			// 订阅者处理不过来时丢弃，避免阻塞请求
+		}
+	}
+	subscribersMu.RUnlock()
+	if conf.WebhookUrl != "" && webhookWanted(ev) {
+		go sendWebhook(ev)
+	}
+}
+
+// webhookWanted 下载事件按配置的间隔推送，错误事件不推送
+func webhookWanted(ev Event) bool {
+	switch ev.Event {
+	case EventDownload:
+		return conf.WebhookDownloads > 0 && ev.Downloads%int64(conf.WebhookDownloads) == 0
+	case EventError:
+		return false
+	}
+	return true
+}
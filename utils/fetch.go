@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 下载文件内容失败的原因
+const (
+	FetchExpired     = "expired"      // 文件引用已失效或暂时不可用
+	FetchDeleted     = "deleted"      // 消息已删除或文件ID无效
+	FetchTooBig      = "too_big"      // 超过 Bot API 的下载大小限制
+	FetchRateLimited = "rate_limited" // Telegram 限流
+	FetchUnavailable = "unavailable"  // Telegram 或网络故障
+)
+
+// FetchError 从 Telegram 获取文件失败，Reason 为失败原因，RetryAfter 为建议的重试间隔（秒），0 为不建议重试
+type FetchError struct {
+	Reason     string
+	RetryAfter int
+	Err        error
+}
+
+func (e *FetchError) Error() string {
+	return e.Reason + ": " + e.Err.Error()
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// telegramFetchError 按 getFile 的错误判断失败原因
+func telegramFetchError(err error) *FetchError {
+	var tgErr *tgbotapi.Error
+	if !errors.As(err, &tgErr) {
+		// 未收到 Bot API 的响应
+		return &FetchError{Reason: FetchUnavailable, RetryAfter: 30, Err: err}
+	}
+	msg := strings.ToLower(tgErr.Message)
+	switch {
+	case tgErr.Code == http.StatusTooManyRequests || tgErr.RetryAfter > 0:
+		return &FetchError{Reason: FetchRateLimited, RetryAfter: max(tgErr.RetryAfter, 1), Err: err}
+	case tgErr.Code >= 500:
+		return &FetchError{Reason: FetchUnavailable, RetryAfter: 30, Err: err}
+	case strings.Contains(msg, "too big"):
+		return &FetchError{Reason: FetchTooBig, Err: err}
+	case strings.Contains(msg, "temporarily unavailable"):
+		return &FetchError{Reason: FetchExpired, RetryAfter: 60, Err: err}
+	}
+	// invalid file_id、wrong file identifier 等，文件所在的消息已不存在
+	return &FetchError{Reason: FetchDeleted, Err: err}
+}
+
+// CdnFetchError 按下载文件内容时的响应状态码判断失败原因，resp 为 nil 时为网络错误
+func CdnFetchError(resp *http.Response, err error) *FetchError {
+	if resp == nil {
+		return &FetchError{Reason: FetchUnavailable, RetryAfter: 30, Err: err}
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusGone:
+		// 下载链接过期，重新 getFile 可以恢复
+		return &FetchError{Reason: FetchExpired, RetryAfter: 5, Err: err}
+	case resp.StatusCode == http.StatusTooManyRequests:
+		retry, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return &FetchError{Reason: FetchRateLimited, RetryAfter: max(retry, 1), Err: err}
+	}
+	return &FetchError{Reason: FetchUnavailable, RetryAfter: 30, Err: err}
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
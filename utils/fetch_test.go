@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestTelegramFetchError(t *testing.T) {
+	tests := []struct {
+		err    error
+		reason string
+		retry  int
+	}{
+		{errors.New("dial tcp: connection refused"), FetchUnavailable, 30},
+		{&tgbotapi.Error{Code: 429, Message: "Too Many Requests: retry after 7", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 7}}, FetchRateLimited, 7},
+		{&tgbotapi.Error{Code: 400, Message: "Bad Request: file is too big"}, FetchTooBig, 0},
+		{&tgbotapi.Error{Code: 400, Message: "Bad Request: wrong file_id or the file is temporarily unavailable"}, FetchExpired, 60},
+		{&tgbotapi.Error{Code: 400, Message: "Bad Request: invalid file_id"}, FetchDeleted, 0},
+		{&tgbotapi.Error{Code: 502, Message: "Bad Gateway"}, FetchUnavailable, 30},
+	}
+	for _, tt := range tests {
+		if fe := telegramFetchError(tt.err); fe.Reason != tt.reason || fe.RetryAfter != tt.retry {
+			t.Errorf("%v: %s %d", tt.err, fe.Reason, fe.RetryAfter)
+		}
+	}
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"12"}}}
+	if fe := CdnFetchError(resp, errors.New("429")); fe.Reason != FetchRateLimited || fe.RetryAfter != 12 {
+		t.Errorf("CDN 429: %s %d", fe.Reason, fe.RetryAfter)
+	}
+}
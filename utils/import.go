@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// exportMessage Telegram Desktop 导出的 result.json 中的消息
+type exportMessage struct {
+	ID            int    `json:"id"`
+	Type          string `json:"type"`
+	DateUnixtime  string `json:"date_unixtime"`
+	File          string `json:"file"`
+	FileName      string `json:"file_name"`
+	FileSize      int64  `json:"file_size"`
+	Photo         string `json:"photo"`
+	PhotoFileSize int64  `json:"photo_file_size"`
+}
+
+// exportChat 单个聊天的导出结果
+type exportChat struct {
+	Name     string          `json:"name"`
+	Messages []exportMessage `json:"messages"`
+}
+
+// ImportHistory 根据 Telegram Desktop 导出的频道记录登记历史文件
+//
+// Bot API 无法直接读取历史消息，这里将每条带文件的消息转发到 via（为空时转发到频道本身）
+// 以获取 file_id，随后删除转发的消息。已登记过的消息会被跳过，中断后可重复执行。
+func ImportHistory(exportPath, via string) (int, error) {
+	data, err := os.ReadFile(exportPath)
+	if err != nil {
+		return 0, err
+	}
+	var chat exportChat
+	if err := json.Unmarshal(data, &chat); err != nil {
+		return 0, err
+	}
+	if len(chat.Messages) == 0 {
+		return 0, errors.New("no messages in export")
+	}
+	if via == "" {
+		via = conf.ChannelName
+	}
+	bot, err := NewBot()
+	if err != nil {
+		return 0, err
+	}
+	st := store.Default()
+	imported := make(map[int]bool)
+	for _, f := range st.Files() {
+		if f.Message > 0 {
+			imported[f.Message] = true
+		}
+	}
+	count := 0
+	for _, m := range chat.Messages {
+		if m.Type != "message" || (m.File == "" && m.Photo == "") || imported[m.ID] {
+			continue
+		}
+		f, err := forwardFile(bot, via, conf.ChannelName, m.ID)
+		if err != nil {
+			log.Printf("导入消息 %d 失败: %v", m.ID, err)
+			continue
+		}
+		f.Message = m.ID
+		if f.Name == "" {
+			f.Name = exportFileName(m)
+		}
+		if f.Size == 0 {
+			f.Size = m.FileSize + m.PhotoFileSize
+		}
+		if ts, err := strconv.ParseInt(m.DateUnixtime, 10, 64); err == nil {
+			f.CreatedAt = ts
+		}
+		if err := st.PutFile(f); err != nil {
+			return count, err
+		}
+		st.PutMessage(f.ID, store.Message{Chat: conf.ChannelName, ID: m.ID})
+		count++
+		log.Printf("已导入 %s %s", f.Name, strings.TrimSuffix(conf.BaseUrl, "/")+conf.FileRoute+f.ID)
+	}
+	return count, st.Save()
+}
+
+// exportFileName 导出记录中的文件名，照片没有文件名时使用导出路径
+func exportFileName(m exportMessage) string {
+	if m.FileName != "" {
+		return m.FileName
+	}
+	if m.File != "" {
+		return path.Base(m.File)
+	}
+	return path.Base(m.Photo)
+}
+
+// forwardFile 将 from 中的消息转发到 via 获取文件信息，完成后删除转发的消息
+func forwardFile(bot *tgbotapi.BotAPI, via, from string, messageID int) (store.File, error) {
+	params := tgbotapi.Params{
+		"chat_id":              via,
+		"from_chat_id":         from,
+		"message_id":           strconv.Itoa(messageID),
+		"disable_notification": "true",
+	}
+	resp, err := botRequest(bot, "forwardMessage", params)
+	if err != nil {
+		return store.File{}, err
+	}
+	var msg tgbotapi.Message
+	if err := json.Unmarshal(resp.Result, &msg); err != nil {
+		return store.File{}, err
+	}
+	botRequest(bot, "deleteMessage", tgbotapi.Params{"chat_id": via, "message_id": strconv.Itoa(msg.MessageID)})
+
+	var f store.File
+	switch {
+	case msg.Document != nil:
+		f = store.File{ID: msg.Document.FileID, Name: msg.Document.FileName, Size: int64(msg.Document.FileSize)}
+	case msg.Video != nil:
+		f = store.File{ID: msg.Video.FileID, Name: msg.Video.FileName, Size: int64(msg.Video.FileSize), Duration: msg.Video.Duration}
+	case msg.Audio != nil:
+		f = store.File{ID: msg.Audio.FileID, Name: msg.Audio.FileName, Size: int64(msg.Audio.FileSize), Duration: msg.Audio.Duration}
+	case msg.Animation != nil:
+		f = store.File{ID: msg.Animation.FileID, Name: msg.Animation.FileName, Size: int64(msg.Animation.FileSize)}
+	case msg.Voice != nil:
+		f = store.File{ID: msg.Voice.FileID, Size: int64(msg.Voice.FileSize), Duration: msg.Voice.Duration}
+	case msg.Sticker != nil:
+		f = store.File{ID: msg.Sticker.FileID, Size: int64(msg.Sticker.FileSize)}
+	case len(msg.Photo) > 0:
+		// 取最大尺寸
+		p := msg.Photo[len(msg.Photo)-1]
+		f = store.File{ID: p.FileID, Size: int64(p.FileSize)}
+	default:
+		return f, fmt.Errorf("message has no file")
+	}
+	return f, nil
+}
+
+// botRequest 调用 Bot API，遇到限流时按 retry_after 等待后重试
+func botRequest(bot *tgbotapi.BotAPI, method string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	for {
+		resp, err := bot.MakeRequest(method, params)
+		var tgErr *tgbotapi.Error
+		if errors.As(err, &tgErr) && tgErr.RetryAfter > 0 {
+			time.Sleep(time.Duration(tgErr.RetryAfter) * time.Second)
+			continue
+		}
+		return resp, err
+	}
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+)
+
+// systemd 套接字激活时传入的第一个文件描述符
+const listenFdsStart = 3
+
+// Listen 创建 Web 服务的监听，由 systemd 套接字激活启动时使用其传入的套接字，
+// 设置 socket 时监听该路径的 Unix 套接字，否则监听 TCP 端口；返回的 addr 用于日志
+func Listen(port, socket string, mode os.FileMode) (l net.Listener, addr string, err error) {
+	if l, err = systemdListener(); l != nil || err != nil {
+		return l, "systemd socket", err
+	}
+	if socket == "" {
+		l, err = net.Listen("tcp", ":"+port)
+		return l, ":" + port, err
+	}
+	if fi, err := os.Lstat(socket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		// 上次异常退出残留的套接字文件会导致监听失败，仍有进程在监听时不删除
+		if c, err := net.Dial("unix", socket); err == nil {
+			c.Close()
+			return nil, socket, errors.New("socket " + socket + " is in use")
+		}
+		os.Remove(socket)
+	}
+	if l, err = net.Listen("unix", socket); err != nil {
+		return nil, socket, err
+	}
+	if err = os.Chmod(socket, mode); err != nil {
+		l.Close()
+		return nil, socket, err
+	}
+	return l, socket, nil
+}
+
+// systemdListener 按 LISTEN_PID 及 LISTEN_FDS 获取 systemd 传入的套接字，未通过套接字激活启动时返回 nil；
+// 只使用第一个套接字
+func systemdListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	// 避免子进程误用
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if n < 1 {
+		return nil, nil
+	}
+	f := os.NewFile(listenFdsStart, "systemd-socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
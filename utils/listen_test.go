@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	// Unix 套接字路径长度有限，不使用 t.TempDir
+	dir, err := os.MkdirTemp("", "tgs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "web.sock")
+	// 其他进程的套接字激活变量不生效
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+
+	// 残留的套接字文件
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, addr, err := Listen("0", socket, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if addr != socket || l.Addr().Network() != "unix" {
+		t.Errorf("监听 %s %s", addr, l.Addr().Network())
+	}
+	if fi, err := os.Stat(socket); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("权限 %v %v", fi.Mode(), err)
+	}
+	if _, _, err := Listen("0", socket, 0600); err == nil {
+		t.Error("套接字正在使用时应失败")
+	}
+}
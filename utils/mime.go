@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// extTypes 内容检测无法识别或识别错误的常见扩展名，优先于系统的 MIME 表
+var extTypes = map[string]string{
+	".svg":   "image/svg+xml",
+	".json":  "application/json",
+	".css":   "text/css; charset=utf-8",
+	".js":    "text/javascript; charset=utf-8",
+	".mjs":   "text/javascript; charset=utf-8",
+	".wasm":  "application/wasm",
+	".apk":   "application/vnd.android.package-archive",
+	".mkv":   "video/x-matroska",
+	".webm":  "video/webm",
+	".m3u8":  "application/vnd.apple.mpegurl",
+	".mp3":   "audio/mpeg",
+	".m4a":   "audio/mp4",
+	".flac":  "audio/flac",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+}
+
+// TypeByName 按文件扩展名获取内容类型，未知时返回空
+func TypeByName(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if t, ok := extTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// DetectType 优先按扩展名判断内容类型，未知时检测文件头部
+func DetectType(name string, head []byte) string {
+	if t := TypeByName(name); t != "" {
+		return t
+	}
+	return http.DetectContentType(head)
+}
@@ -0,0 +1,24 @@
+package utils
+
+import "testing"
+
+func TestDetectType(t *testing.T) {
+	tests := []struct {
+		name, head, want string
+	}{
+		{"a.svg", "<svg xmlns=\"http://www.w3.org/2000/svg\"></svg>", "image/svg+xml"},
+		{"A.WASM", "\x00asm\x01\x00\x00\x00", "application/wasm"},
+		{"app.apk", "PK\x03\x04", "application/vnd.android.package-archive"},
+		{"movie.mkv", "\x1a\x45\xdf\xa3", "video/x-matroska"},
+		{"data.json", "{\"a\":1}", "application/json"},
+		{"style.css", "body{}", "text/css; charset=utf-8"},
+		{"font.woff2", "wOF2", "font/woff2"},
+		{"noext", "\x89PNG\r\n\x1a\n", "image/png"},
+		{"", "hello", "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		if got := DetectType(tt.name, []byte(tt.head)); got != tt.want {
+			t.Errorf("DetectType(%q) = %q，期望 %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// mirrorFile 将文件复制到备份频道并记录备份的文件ID
+//
+// copyMessage 只返回消息ID，无法得到新的文件ID，这里按文件ID重新发送同类型的消息
+func mirrorFile(kind, fileID string) {
+	bot, err := NewBot()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	params := tgbotapi.Params{
+		"chat_id":              conf.MirrorChannel,
+		kind:                   fileID,
+		"disable_notification": "true",
+	}
+	waitSend(conf.MirrorChannel)
+	resp, err := botRequest(bot, "send"+strings.ToUpper(kind[:1])+kind[1:], params)
+	if err != nil {
+		log.Printf("复制文件到备份频道失败【%s】: %v", fileID, err)
+		return
+	}
+	var msg tgbotapi.Message
+	if err := json.Unmarshal(resp.Result, &msg); err != nil {
+		log.Println(err)
+		return
+	}
+	if _, mirror := messageFile(&msg); mirror != "" {
+		if err := store.Default().PutMirror(fileID, mirror); err != nil {
+			log.Printf("保存备份记录失败: %v", err)
+		}
+		store.Default().PutMessage(mirror, store.Message{Chat: conf.MirrorChannel, ID: msg.MessageID})
+	}
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// panicReportMax 发送到管理员会话的报告长度上限，Telegram 消息不超过 4096 个字符
+const panicReportMax = 3000
+
+// panicReportInterval 两次发送报告的最小间隔，避免持续出错时刷屏，日志中仍会完整记录
+const panicReportInterval = time.Minute
+
+var lastPanicReport int64
+
+// ReportPanic 记录 panic 及调用栈，并将截断后的报告发送到管理员会话
+func ReportPanic(where string, v interface{}, stack []byte) {
+	log.Printf("panic【%s】: %v\n%s", where, v, stack)
+	now := time.Now().Unix()
+	last := atomic.LoadInt64(&lastPanicReport)
+	if now-last < int64(panicReportInterval/time.Second) || !atomic.CompareAndSwapInt64(&lastPanicReport, last, now) {
+		return
+	}
+	text := fmt.Sprintf("💥 panic: %s\n%v\n\n%s", where, v, stack)
+	if len(text) > panicReportMax {
+		text = strings.ToValidUTF8(text[:panicReportMax], "") + "\n…"
+	}
+	go func() {
+		if err := SendAdmin(text); err != nil {
+			log.Printf("发送 panic 报告失败: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/metrics"
+	"csz.net/tgstate/tracing"
+)
+
+// ErrQueueFull 上传队列已满
+var ErrQueueFull = errors.New("upload queue is full")
+
+const (
+	jobPending int32 = iota
+	jobRunning
+	jobCanceled
+)
+
+// UploadJob 排队上传到 Telegram 的任务
+type UploadJob struct {
+	Channel string
+	Name    string
+	Reader  io.Reader
+	FileID  string          // 上传结果，失败时为空
+	Err     error           // 上传失败的原因
+	Ctx     context.Context // 发起上传的请求，用于记录追踪，可为空
+	queued  time.Time
+	state   int32
+	done    chan struct{}
+}
+
+var (
+	uploadQueue    chan *UploadJob
+	uploadInFlight int64
+	queueOnce      sync.Once
+)
+
+// startUploadWorkers 按配置的并发数启动上传协程
+func startUploadWorkers() {
+	size, workers := conf.UploadQueue, conf.UploadConcurrency
+	if size < 1 {
+		size = 1
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	uploadQueue = make(chan *UploadJob, size)
+	metrics.Gauge("tgstate_upload_queue_depth", "Uploads waiting for a Telegram slot", func() int64 {
+		return int64(len(uploadQueue))
+	})
+	metrics.Gauge("tgstate_upload_in_flight", "Uploads currently sent to Telegram", func() int64 {
+		return atomic.LoadInt64(&uploadInFlight)
+	})
+	for i := 0; i < workers; i++ {
+		go func() {
+			for job := range uploadQueue {
+				if !atomic.CompareAndSwapInt32(&job.state, jobPending, jobRunning) {
+					continue
+				}
+				runUploadJob(job)
+			}
+		}()
+	}
+}
+
+// runUploadJob 执行上传，结果及失败原因记录在任务中
+func runUploadJob(job *UploadJob) {
+	atomic.AddInt64(&uploadInFlight, 1)
+	defer func() {
+		atomic.AddInt64(&uploadInFlight, -1)
+		close(job.done)
+	}()
+	ctx := job.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	_, span := tracing.Start(ctx, "telegram sendDocument", tracing.Client)
+	defer span.End()
+	span.SetAttr("file.name", job.Name)
+	span.SetAttr("queue.wait_ms", time.Since(job.queued).Milliseconds())
+	job.FileID, job.Err = UpDocumentTo(job.Channel, TgFileData(job.Name, job.Reader))
+	if job.Err != nil {
+		log.Printf("上传任务失败: %v", job.Err)
+		span.SetError(job.Err)
+	}
+	span.SetAttr("file.id", job.FileID)
+}
+
+// NewUploadJob 创建上传任务
+func NewUploadJob(channel, name string, r io.Reader) *UploadJob {
+	return &UploadJob{Channel: channel, Name: name, Reader: r, done: make(chan struct{})}
+}
+
+// SubmitUpload 提交上传任务，队列已满时返回 ErrQueueFull，预计等待发送额度过久时返回 ErrThrottled
+func SubmitUpload(job *UploadJob) error {
+	queueOnce.Do(startUploadWorkers)
+	if conf.TelegramMaxWait > 0 && SendWait(job.Channel) > time.Duration(conf.TelegramMaxWait)*time.Second {
+		metrics.Inc("tgstate_upload_rejected_total")
+		return ErrThrottled
+	}
+	job.queued = time.Now()
+	select {
+	case uploadQueue <- job:
+		return nil
+	default:
+		metrics.Inc("tgstate_upload_rejected_total")
+		return ErrQueueFull
+	}
+}
+
+// Done 任务完成时关闭
+func (j *UploadJob) Done() <-chan struct{} {
+	return j.done
+}
+
+// Cancel 取消尚未开始的任务，任务已开始时返回 false
+func (j *UploadJob) Cancel() bool {
+	return atomic.CompareAndSwapInt32(&j.state, jobPending, jobCanceled)
+}
+
+// QueueStats 返回排队中的任务数、队列容量及正在上传的任务数
+func QueueStats() (depth, capacity int, inFlight int64) {
+	queueOnce.Do(startUploadWorkers)
+	return len(uploadQueue), cap(uploadQueue), atomic.LoadInt64(&uploadInFlight)
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"csz.net/tgstate/store"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// refreshRetry 同一文件重新获取失败后再次尝试的间隔，避免对已删除的消息反复转发
+const refreshRetry = 10 * time.Minute
+
+// errNoRefresh 没有记录文件所在的消息，或不久前刚尝试过
+var errNoRefresh = errors.New("无法重新获取文件ID")
+
+// refreshFileID 转发文件所在的消息重新获取文件ID，并记录到消息索引中；
+// stale 为刚刚失效的文件ID，重新获取到的仍是它时返回错误
+func refreshFileID(bot *tgbotapi.BotAPI, fileID, stale string) (string, error) {
+	st := store.Default()
+	m, ok := st.GetMessage(fileID)
+	if !ok || m.Chat == "" || m.ID == 0 {
+		return "", errNoRefresh
+	}
+	if _, ok := st.CacheGet("refresh:" + fileID); ok {
+		return "", errNoRefresh
+	}
+	// 转发到消息所在的会话，随后删除转发的消息
+	f, err := forwardFile(bot, m.Chat, m.Chat, m.ID)
+	if err != nil {
+		st.CacheSet("refresh:"+fileID, "1", refreshRetry)
+		return "", err
+	}
+	if f.ID == stale {
+		st.CacheSet("refresh:"+fileID, "1", refreshRetry)
+		return "", errNoRefresh
+	}
+	m.FileID = f.ID
+	if f.ID == fileID {
+		m.FileID = ""
+	}
+	if err := st.PutMessage(fileID, m); err != nil {
+		return "", err
+	}
+	log.Printf("已重新获取文件ID【%s】", fileID)
+	return f.ID, nil
+}
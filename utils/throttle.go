@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"csz.net/tgstate/conf"
+	"csz.net/tgstate/metrics"
+)
+
+// ErrThrottled 预计等待发送额度的时间过长，上传被拒绝
+var ErrThrottled = errors.New("telegram send budget exhausted")
+
+// sendBucket 单个频道的令牌桶，令牌为负数表示已有任务预约了后续额度
+type sendBucket struct {
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time // 收到 429 后暂停到该时间
+}
+
+var (
+	sendMu      sync.Mutex
+	sendBuckets = map[string]*sendBucket{}
+)
+
+// sendRate 每秒补充的令牌数，0 表示不限制
+func sendRate() float64 {
+	if conf.TelegramRate <= 0 {
+		return 0
+	}
+	return float64(conf.TelegramRate) / 60
+}
+
+// bucketFor 返回频道的令牌桶并补充令牌，调用方需持有 sendMu
+func bucketFor(chat string, now time.Time) *sendBucket {
+	capacity := float64(conf.TelegramRate)
+	b, ok := sendBuckets[chat]
+	if !ok {
+		b = &sendBucket{tokens: capacity, last: now}
+		sendBuckets[chat] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * sendRate()
+	if b.tokens > capacity {
+		b.tokens = capacity
+	}
+	b.last = now
+	return b
+}
+
+// waitLocked 计算取得下一个令牌需要等待的时间，调用方需持有 sendMu
+func (b *sendBucket) waitLocked(now time.Time) time.Duration {
+	var wait time.Duration
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / sendRate() * float64(time.Second))
+	}
+	if pause := b.pausedUntil.Sub(now); pause > wait {
+		wait = pause
+	}
+	return wait
+}
+
+// SendWait 预计向频道发送下一个文件前需要等待的时间
+func SendWait(chat string) time.Duration {
+	if sendRate() == 0 {
+		return 0
+	}
+	sendMu.Lock()
+	defer sendMu.Unlock()
+	now := time.Now()
+	return bucketFor(chat, now).waitLocked(now)
+}
+
+// reserveSend 预约一个令牌，返回发送前需要等待的时间
+func reserveSend(chat string) time.Duration {
+	if sendRate() == 0 {
+		return 0
+	}
+	sendMu.Lock()
+	defer sendMu.Unlock()
+	now := time.Now()
+	b := bucketFor(chat, now)
+	wait := b.waitLocked(now)
+	b.tokens--
+	if wait > 0 {
+		metrics.Inc("tgstate_telegram_throttled_total")
+	}
+	return wait
+}
+
+// waitSend 按 Telegram 的发送频率限制等待额度，避免触发 429
+//
+// 所有向频道发送文件的路径（上传队列、分块、粘贴、修复、备份频道）都经过这里
+func waitSend(chat string) {
+	if wait := reserveSend(chat); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// pauseSend 收到 Telegram 的 429 后暂停向该频道发送并清空额度
+func pauseSend(chat string, d time.Duration) {
+	sendMu.Lock()
+	defer sendMu.Unlock()
+	now := time.Now()
+	b := bucketFor(chat, now)
+	if until := now.Add(d); until.After(b.pausedUntil) {
+		b.pausedUntil = until
+	}
+	if b.tokens > 0 {
+		b.tokens = 0
+	}
+	metrics.Inc("tgstate_telegram_429_total")
+}
+
+// SendBudget 频道当前的发送额度
+type SendBudget struct {
+	Chat       string  `json:"chat"`
+	Tokens     float64 `json:"tokens"`      // 可立即发送的文件数，负数表示已排队预约的数量
+	Capacity   int     `json:"capacity"`    // 每分钟的发送上限
+	RetryAfter float64 `json:"retry_after"` // 下一个文件需要等待的秒数
+}
+
+// SendBudgets 返回各频道的发送额度
+func SendBudgets() []SendBudget {
+	sendMu.Lock()
+	defer sendMu.Unlock()
+	now := time.Now()
+	budgets := make([]SendBudget, 0, len(sendBuckets))
+	for chat := range sendBuckets {
+		b := bucketFor(chat, now)
+		var wait time.Duration
+		if sendRate() > 0 {
+			wait = b.waitLocked(now)
+		}
+		budgets = append(budgets, SendBudget{
+			Chat:       chat,
+			Tokens:     float64(int(b.tokens*100)) / 100,
+			Capacity:   conf.TelegramRate,
+			RetryAfter: wait.Seconds(),
+		})
+	}
+	sort.Slice(budgets, func(i, j int) bool { return budgets[i].Chat < budgets[j].Chat })
+	return budgets
+}
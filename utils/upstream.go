@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"csz.net/tgstate/conf"
+)
+
+var (
+	upstreamClient *http.Client
+	upstreamOnce   sync.Once
+)
+
+// Upstream 获取访问 Telegram 文件服务器的共享客户端
+//
+// 不设置整体超时以便传输大文件，连接超过 UpstreamConnectTimeout 未建立、
+// 或超过 UpstreamReadTimeout 未收到任何数据时中止
+func Upstream() *http.Client {
+	upstreamOnce.Do(func() {
+		connect := time.Duration(conf.UpstreamConnectTimeout) * time.Second
+		read := time.Duration(conf.UpstreamReadTimeout) * time.Second
+		dialer := &net.Dialer{Timeout: connect, KeepAlive: 30 * time.Second}
+		var transport http.RoundTripper = &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			TLSHandshakeTimeout:   connect,
+			ResponseHeaderTimeout: read,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   conf.UpstreamMaxIdleConns,
+			MaxConnsPerHost:       conf.UpstreamMaxConns,
+			IdleConnTimeout:       90 * time.Second,
+		}
+		if read > 0 {
+			transport = &idleTimeoutTransport{base: transport, timeout: read}
+		}
+		upstreamClient = &http.Client{Transport: transport}
+	})
+	return upstreamClient
+}
+
+// ErrUpstreamIdle 读取响应时超过 UpstreamReadTimeout 没有收到数据
+var ErrUpstreamIdle = errors.New("upstream read timeout")
+
+// idleTimeoutTransport 为每个响应体单独计时，长时间没有数据时取消该请求
+//
+// 不在连接上设置读超时，空闲在连接池中的连接和 HTTP/2 复用的连接不受影响
+type idleTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *idleTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	body := &idleTimeoutBody{ReadCloser: resp.Body, timeout: t.timeout, cancel: cancel}
+	body.timer = time.AfterFunc(t.timeout, body.expire)
+	body.timer.Stop()
+	resp.Body = body
+	return resp, nil
+}
+
+// idleTimeoutBody 只在读取期间计时，超时后取消请求使阻塞的读取返回
+//
+// 两次读取之间（例如等待下游客户端接收）不计时
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	expired int32
+}
+
+func (b *idleTimeoutBody) expire() {
+	atomic.StoreInt32(&b.expired, 1)
+	b.cancel()
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&b.expired) == 1 {
+		return 0, ErrUpstreamIdle
+	}
+	b.timer.Reset(b.timeout)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	if err != nil && atomic.LoadInt32(&b.expired) == 1 {
+		err = ErrUpstreamIdle
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
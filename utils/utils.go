@@ -1,206 +1,53 @@
 package utils
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"log"
-	"net/http"
-	"os"
-	"path/filepath"
+	"runtime/debug"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"csz.net/tgstate/conf"
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/tracing"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
-// FileCache 文件缓存结构
-type FileCache struct {
-	sync.RWMutex
-	files       map[string]string // fileID -> 本地文件路径
-	lastAccess  map[string]int64  // fileID -> 最后访问时间
-	cacheDir    string            // 缓存目录
-	cleanupLock sync.Mutex        // 清理锁
-}
-
-var (
-	fileCache *FileCache
-	once      sync.Once
-)
-
-// GetFileCache 获取文件缓存单例
-func GetFileCache() *FileCache {
-	once.Do(func() {
-		cacheDir := filepath.Join(".", "file_cache")
-		if _, err := os.Stat(cacheDir); os.IsNotExist(err) {
-			os.MkdirAll(cacheDir, 0755)
-		}
-		fileCache = &FileCache{
-			files:      make(map[string]string),
-			lastAccess: make(map[string]int64),
-			cacheDir:   cacheDir,
-		}
-		// 启动定期清理协程
-		go fileCache.periodicCleanup()
-	})
-	return fileCache
-}
-
-// GetCachedFile 获取缓存文件，如果不存在则下载
-func (fc *FileCache) GetCachedFile(fileID string) (string, error) {
-	// 检查缓存
-	fc.RLock()
-	filePath, exists := fc.files[fileID]
-	fc.RUnlock()
-
-	if exists {
-		// 检查文件是否存在
-		if _, err := os.Stat(filePath); err == nil {
-			// 更新最后访问时间
-			fc.Lock()
-			fc.lastAccess[fileID] = time.Now().Unix()
-			fc.Unlock()
-			return filePath, nil
-		}
-	}
-
-	// 缓存不存在或文件已删除，下载文件
-	fileURL, ok := GetDownloadUrl(fileID)
-	if !ok {
-		return "", fmt.Errorf("获取文件下载链接失败")
-	}
-
-	// 创建缓存文件
-	filePath = filepath.Join(fc.cacheDir, fileID)
-	out, err := os.Create(filePath)
-	if err != nil {
-		return "", err
-	}
-	defer out.Close()
-
-	// 下载文件
-	resp, err := http.Get(fileURL)
-	if err != nil {
-		os.Remove(filePath)
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		os.Remove(filePath)
-		return "", fmt.Errorf("下载文件失败，状态码: %d", resp.StatusCode)
-	}
-
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		os.Remove(filePath)
-		return "", err
+func TgFileData(fileName string, fileData io.Reader) tgbotapi.FileReader {
+	return tgbotapi.FileReader{
+		Name:   fileName,
+		Reader: fileData,
 	}
-
-	// 更新缓存
-	fc.Lock()
-	fc.files[fileID] = filePath
-	fc.lastAccess[fileID] = time.Now().Unix()
-	fc.Unlock()
-
-	return filePath, nil
 }
 
-// MarkFileForCleanup 标记文件可以清理
-func (fc *FileCache) MarkFileForCleanup(fileID string) {
-	fc.Lock()
-	defer fc.Unlock()
-	
-	// 设置最后访问时间为过去时间，使其在下次清理时被删除
-	fc.lastAccess[fileID] = 1
-}
+// ErrReadOnly 只读镜像模式不允许上传文件
+var ErrReadOnly = errors.New("uploads are disabled in read-only mode")
 
-// CleanupFile 立即清理指定文件
-func (fc *FileCache) CleanupFile(fileID string) {
-	fc.Lock()
-	filePath, exists := fc.files[fileID]
-	if exists {
-		delete(fc.files, fileID)
-		delete(fc.lastAccess, fileID)
-	}
-	fc.Unlock()
-	
-	if exists && filePath != "" {
-		os.Remove(filePath)
-	}
-}
-
-// periodicCleanup 定期清理过期缓存
-func (fc *FileCache) periodicCleanup() {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
-	
-	for range ticker.C {
-		fc.cleanupExpiredFiles()
-	}
-}
+// errNoFile Telegram 返回的消息中没有文件
+var errNoFile = errors.New("sent message has no file")
 
-// cleanupExpiredFiles 清理过期文件
-func (fc *FileCache) cleanupExpiredFiles() {
-	fc.cleanupLock.Lock()
-	defer fc.cleanupLock.Unlock()
-	
-	now := time.Now().Unix()
-	expireTime := now - 3600 // 1小时未访问的文件将被清理
-	
-	var filesToDelete []string
-	var idsToDelete []string
-	
-	fc.RLock()
-	for fileID, lastAccess := range fc.lastAccess {
-		if lastAccess < expireTime {
-			if filePath, ok := fc.files[fileID]; ok {
-				filesToDelete = append(filesToDelete, filePath)
-				idsToDelete = append(idsToDelete, fileID)
-			}
-		}
-	}
-	fc.RUnlock()
-	
-	// 删除文件
-	for _, filePath := range filesToDelete {
-		os.Remove(filePath)
-	}
-	
-	// 更新缓存映射
-	fc.Lock()
-	for _, fileID := range idsToDelete {
-		delete(fc.files, fileID)
-		delete(fc.lastAccess, fileID)
-	}
-	fc.Unlock()
-	
-	if len(idsToDelete) > 0 {
-		log.Printf("已清理 %d 个过期缓存文件", len(idsToDelete))
-	}
+func UpDocument(fileData tgbotapi.FileReader) (string, error) {
+	return UpDocumentTo(conf.ChannelName, fileData)
 }
 
-// 以下是原有函数
-
-func TgFileData(fileName string, fileData io.Reader) tgbotapi.FileReader {
-	return tgbotapi.FileReader{
-		Name:   fileName,
-		Reader: fileData,
+// UpDocumentTo 上传文件到指定的频道、群组或个人，返回文件ID
+func UpDocumentTo(chatID string, fileData tgbotapi.FileReader) (string, error) {
+	if conf.Mode == "r" {
+		return "", ErrReadOnly
 	}
-}
-
-func UpDocument(fileData tgbotapi.FileReader) string {
-	bot, err := tgbotapi.NewBotAPI(conf.BotToken)
+	bot, err := NewBot()
 	if err != nil {
-		log.Println(err)
-		return ""
+		return "", err
 	}
+	waitSend(chatID)
 	// Upload the file to Telegram
 	params := tgbotapi.Params{
-		"chat_id": conf.ChannelName, // Replace with the chat ID where you want to send the file
+		"chat_id": chatID,
 	}
 	files := []tgbotapi.RequestFile{
 		{
@@ -210,44 +57,144 @@ func UpDocument(fileData tgbotapi.FileReader) string {
 	}
 	response, err := bot.UploadFiles("sendDocument", params, files)
 	if err != nil {
-		log.Panic(err)
+		if e, ok := err.(*tgbotapi.Error); ok && e.RetryAfter > 0 {
+			pauseSend(chatID, time.Duration(e.RetryAfter)*time.Second)
+		}
+		return "", err
 	}
 	var msg tgbotapi.Message
 	json.Unmarshal([]byte(response.Result), &msg)
-	var resp string
+	kind, resp := messageFile(&msg)
+	if resp == "" {
+		return "", errNoFile
+	}
+	// 记录消息位置，删除文件时使用
+	if err := store.Default().PutMessage(resp, store.Message{Chat: chatID, ID: msg.MessageID}); err != nil {
+		log.Printf("保存消息记录失败: %v", err)
+	}
+	if conf.MirrorChannel != "" {
+		go mirrorFile(kind, resp)
+	}
+	return resp, nil
+}
+
+// messageFile 返回消息中文件的类型及文件ID
+func messageFile(msg *tgbotapi.Message) (string, string) {
 	switch {
 	case msg.Document != nil:
-		resp = msg.Document.FileID
+		return "document", msg.Document.FileID
 	case msg.Audio != nil:
-		resp = msg.Audio.FileID
+		return "audio", msg.Audio.FileID
 	case msg.Video != nil:
-		resp = msg.Video.FileID
+		return "video", msg.Video.FileID
 	case msg.Sticker != nil:
-		resp = msg.Sticker.FileID
+		return "sticker", msg.Sticker.FileID
 	}
-	return resp
+	return "", ""
 }
 
+// Telegram 保证下载链接至少一小时有效，缓存时间留出余量
+const downloadUrlTTL = 50 * time.Minute
+
 func GetDownloadUrl(fileID string) (string, bool) {
-	bot, err := tgbotapi.NewBotAPI(conf.BotToken)
+	fileURL, _, ok := GetDownloadInfo(fileID)
+	return fileURL, ok
+}
+
+// GetDownloadInfo 获取文件下载链接及文件大小
+func GetDownloadInfo(fileID string) (string, int64, bool) {
+	return GetDownloadInfoContext(context.Background(), fileID)
+}
+
+// GetDownloadInfoContext 同 GetDownloadInfo，在 ctx 中的请求下记录 getFile 的耗时
+func GetDownloadInfoContext(ctx context.Context, fileID string) (string, int64, bool) {
+	fileURL, size, err := ResolveDownload(ctx, fileID)
+	return fileURL, size, err == nil
+}
+
+// ResolveDownload 获取文件下载链接及文件大小，失败时返回 *FetchError 说明原因
+func ResolveDownload(ctx context.Context, fileID string) (string, int64, error) {
+	if v, ok := store.Default().CacheGet("url:" + fileID); ok {
+		if i := strings.Index(v, " "); i > 0 {
+			size, _ := strconv.ParseInt(v[:i], 10, 64)
+			return v[i+1:], size, nil
+		}
+	}
+	_, span := tracing.Start(ctx, "telegram getFile", tracing.Client)
+	defer span.End()
+	span.SetAttr("file.id", fileID)
+	bot, err := NewBot()
 	if err != nil {
-		log.Panic(err)
+		// 后台任务也会调用，不能 panic
+		log.Println(err)
+		span.SetError(err)
+		return "", 0, &FetchError{Reason: FetchUnavailable, RetryAfter: 30, Err: err}
+	}
+	// 使用 getFile 方法获取文件信息，文件ID失效后重新获取过时使用新的文件ID
+	ref := fileID
+	if m, ok := store.Default().GetMessage(fileID); ok && m.FileID != "" {
+		ref = m.FileID
 	}
-	// 使用 getFile 方法获取文件信息
-	file, err := bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
+	file, err := bot.GetFile(tgbotapi.FileConfig{FileID: ref})
 	if err != nil {
 		log.Println("获取文件失败【" + fileID + "】")
 		log.Println(err)
-		return "", false
+		span.SetError(err)
+		fe := telegramFetchError(err)
+		// 文件ID失效时从原消息重新获取
+		if fe.Reason == FetchDeleted || fe.Reason == FetchExpired {
+			fresh, rerr := refreshFileID(bot, fileID, ref)
+			switch {
+			case rerr == nil:
+				span.SetAttr("ref.refreshed", true)
+				if file, err = bot.GetFile(tgbotapi.FileConfig{FileID: fresh}); err != nil {
+					fe = telegramFetchError(err)
+				}
+			case rerr != errNoRefresh:
+				log.Printf("重新获取文件ID失败【%s】: %v", fileID, rerr)
+				fe = telegramFetchError(rerr)
+			}
+		}
+		if err != nil {
+			// 主频道的文件失效时尝试备份频道
+			mirror, ok := store.Default().GetMirror(fileID)
+			if !ok {
+				return "", 0, fe
+			}
+			span.SetAttr("mirror", true)
+			if file, err = bot.GetFile(tgbotapi.FileConfig{FileID: mirror}); err != nil {
+				log.Println("获取备份文件失败【" + mirror + "】")
+				return "", 0, telegramFetchError(err)
+			}
+		}
 	}
 	log.Println("获取文件成功【" + fileID + "】")
 	// 获取文件下载链接
-	fileURL := file.Link(conf.BotToken)
-	return fileURL, true
+	fileURL := fileLink(file.FilePath)
+	size := int64(file.FileSize)
+	store.Default().CacheSet("url:"+fileID, strconv.FormatInt(size, 10)+" "+fileURL, downloadUrlTTL)
+	return fileURL, size, nil
+}
+
+// ForgetDownloadUrl 删除缓存的下载链接，链接失效时下次重新获取
+func ForgetDownloadUrl(fileID string) {
+	store.Default().CacheDel("url:" + fileID)
+}
+
+// RedirectUrl 将下载链接改写为重定向地址，配置了代理时去掉链接中的 bot token
+func RedirectUrl(fileURL string) string {
+	if conf.RedirectProxy == "" {
+		return fileURL
+	}
+	prefix := "/file/bot" + conf.BotToken + "/"
+	if i := strings.Index(fileURL, prefix); i >= 0 {
+		return strings.TrimSuffix(conf.RedirectProxy, "/") + "/" + fileURL[i+len(prefix):]
+	}
+	return fileURL
 }
 
 func BotDo() {
-	bot, err := tgbotapi.NewBotAPI(conf.BotToken)
+	bot, err := NewBot()
 	if err != nil {
 		log.Println(err)
 		return
@@ -256,34 +203,72 @@ func BotDo() {
 	u.Timeout = 60
 	updatesChan := bot.GetUpdatesChan(u)
 	for update := range updatesChan {
-		var msg *tgbotapi.Message
-		if update.Message != nil {
-			msg = update.Message
+		handleUpdate(bot, update)
+	}
+}
+
+// handleUpdate 处理一条更新，处理出错导致的 panic 报告给管理员后继续处理后续的更新
+func handleUpdate(bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	defer func() {
+		if v := recover(); v != nil {
+			ReportPanic("bot", v, debug.Stack())
 		}
-		if update.ChannelPost != nil {
-			msg = update.ChannelPost
+	}()
+	if update.CallbackQuery != nil {
+		handleCallback(bot, update.CallbackQuery)
+		return
+	}
+	if update.InlineQuery != nil {
+		handleInline(bot, update.InlineQuery)
+		return
+	}
+	var msg *tgbotapi.Message
+	if update.Message != nil {
+		msg = update.Message
+	}
+	if update.ChannelPost != nil {
+		msg = update.ChannelPost
+	}
+	// 授权用户私聊发送的消息，如通过地址上传文件
+	if msg != nil && msg.Chat != nil && msg.Chat.IsPrivate() && msg.From != nil && AuthorizedUser(msg.From.ID) && msg.Text != "" && msg.Text != "get" {
+		go handleText(bot, msg)
+		return
+	}
+	if msg != nil && msg.Text == "get" && msg.ReplyToMessage != nil {
+		var fileID string
+		switch {
+		case msg.ReplyToMessage.Document != nil && msg.ReplyToMessage.Document.FileID != "":
+			fileID = msg.ReplyToMessage.Document.FileID
+		case msg.ReplyToMessage.Video != nil && msg.ReplyToMessage.Video.FileID != "":
+			fileID = msg.ReplyToMessage.Video.FileID
+		case msg.ReplyToMessage.Sticker != nil && msg.ReplyToMessage.Sticker.FileID != "":
+			fileID = msg.ReplyToMessage.Sticker.FileID
 		}
-		if msg != nil && msg.Text == "get" && msg.ReplyToMessage != nil {
-			var fileID string
-			switch {
-			case msg.ReplyToMessage.Document != nil && msg.ReplyToMessage.Document.FileID != "":
-				fileID = msg.ReplyToMessage.Document.FileID
-			case msg.ReplyToMessage.Video != nil && msg.ReplyToMessage.Video.FileID != "":
-				fileID = msg.ReplyToMessage.Video.FileID
-			case msg.ReplyToMessage.Sticker != nil && msg.ReplyToMessage.Sticker.FileID != "":
-				fileID = msg.ReplyToMessage.Sticker.FileID
-			}
-			if fileID != "" {
-				newMsg := tgbotapi.NewMessage(msg.Chat.ID, strings.TrimSuffix(conf.BaseUrl, "/")+"/d/"+fileID)
-				newMsg.ReplyToMessageID = msg.MessageID
-				if !strings.HasPrefix(conf.ChannelName, "@") {
-					if man, err := strconv.Atoi(conf.ChannelName); err == nil && int(msg.Chat.ID) == man {
-						bot.Send(newMsg)
-					}
-				} else {
+		if fileID != "" {
+			base := strings.TrimSuffix(conf.BaseUrl, "/")
+			newMsg := tgbotapi.NewMessage(msg.Chat.ID, base+conf.FileRoute+fileID+"\n"+base+conf.ViewRoute+fileID)
+			newMsg.ReplyToMessageID = msg.MessageID
+			if !strings.HasPrefix(conf.ChannelName, "@") {
+				if man, err := strconv.Atoi(conf.ChannelName); err == nil && int(msg.Chat.ID) == man {
 					bot.Send(newMsg)
 				}
+			} else {
+				bot.Send(newMsg)
 			}
 		}
 	}
 }
+
+const randAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// RandString 生成指定长度的随机字母数字字符串
+func RandString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Panic(err)
+	}
+	for i := range b {
+		b[i] = randAlphabet[int(b[i])%len(randAlphabet)]
+	}
+	return string(b)
+}
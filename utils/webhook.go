@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"csz.net/tgstate/conf"
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// sendWebhook 推送事件到 webhook，失败时重试
+func sendWebhook(ev Event) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i*i) * 5 * time.Second)
+		}
+		if err = postWebhook(body); err == nil {
+			return
+		}
+	}
+	log.Printf("推送 webhook 失败: %v", err)
+}
+
+func postWebhook(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, conf.WebhookUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tgState-Webhook")
+	if conf.WebhookSecret != "" {
+		req.Header.Set("X-Tgstate-Signature", "sha256="+WebhookSignature(body))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("状态码: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// WebhookSignature 计算请求体的 HMAC-SHA256 签名
+func WebhookSignature(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(conf.WebhookSecret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
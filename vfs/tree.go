@@ -0,0 +1,197 @@
+// Package vfs 将元数据中登记的文件组织为只读的目录树，供 FUSE 挂载及 /list/ 目录列表使用。
+// 根目录下 files/ 为全部文件，albums/ 为各相册按相对路径组成的目录树
+package vfs
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"csz.net/tgstate/store"
+	"csz.net/tgstate/utils"
+)
+
+// refreshInterval 重新生成目录树的间隔，期间上传的文件在下次刷新后出现
+const refreshInterval = 30 * time.Second
+
+// RootIno 根目录的 inode
+const RootIno = 1
+
+// Node 目录树中的文件或目录
+type Node struct {
+	Ino      uint64
+	Name     string
+	Dir      bool
+	ID       string // 文件ID，目录为空
+	Size     int64
+	Mtime    int64   // Unix 秒
+	Children []*Node // 按名称排序
+	byName   map[string]*Node
+}
+
+// Child 按名称查找子项
+func (n *Node) Child(name string) *Node {
+	return n.byName[name]
+}
+
+// addChild 加入子项，生成目录树后统一排序
+func (n *Node) addChild(c *Node) {
+	n.Children = append(n.Children, c)
+	n.byName[c.Name] = c
+}
+
+// Tree 定期按元数据重新生成的目录树，同一路径的 inode 在刷新后保持不变
+type Tree struct {
+	src    func() *store.Store
+	mu     sync.Mutex
+	inodes map[string]uint64
+	nodes  map[uint64]*Node
+	built  time.Time
+}
+
+// NewTree 从 src 返回的元数据生成目录树，服务进程使用 store.Default，其他进程使用 store.Snapshot
+func NewTree(src func() *store.Store) *Tree {
+	return &Tree{src: src, inodes: map[string]uint64{"": RootIno}}
+}
+
+// Get 按 inode 查找，需要时先刷新目录树
+func (t *Tree) Get(ino uint64) *Node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.refresh()
+	return t.nodes[ino]
+}
+
+// Lookup 按以 / 分隔的路径查找，空路径为根目录
+func (t *Tree) Lookup(p string) *Node {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.refresh()
+	n := t.nodes[RootIno]
+	for _, seg := range strings.Split(strings.Trim(p, "/"), "/") {
+		if seg == "" {
+			continue
+		}
+		if n = n.Child(seg); n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+func (t *Tree) refresh() {
+	if time.Since(t.built) > refreshInterval {
+		t.Build(t.src())
+	}
+}
+
+// Build 按元数据重新生成目录树，回收站中、待审核及租户的文件不列出
+func (t *Tree) Build(st *store.Store) {
+	t.nodes = map[uint64]*Node{}
+	root := t.dir("", "", 0)
+	files := t.dir("files", "files", 0)
+	albums := t.dir("albums", "albums", 0)
+	root.addChild(albums)
+	root.addChild(files)
+	// 重名时先上传的保留原名，刷新前后同一路径对应同一文件
+	list := st.Files()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt != list[j].CreatedAt {
+			return list[i].CreatedAt < list[j].CreatedAt
+		}
+		return list[i].ID < list[j].ID
+	})
+	for _, f := range list {
+		if f.DeletedAt > 0 || f.Pending || f.Tenant != "" || f.Name == utils.BlobChunkName {
+			continue
+		}
+		name := f.Name
+		if name == "" {
+			name = f.ID
+		}
+		t.add(files, "files", name, f.ID, f.Size, f.CreatedAt)
+	}
+	albumList := st.Albums()
+	sort.Slice(albumList, func(i, j int) bool {
+		if albumList[i].CreatedAt != albumList[j].CreatedAt {
+			return albumList[i].CreatedAt < albumList[j].CreatedAt
+		}
+		return albumList[i].ID < albumList[j].ID
+	})
+	for _, a := range albumList {
+		if a.Tenant != "" {
+			continue
+		}
+		name := a.Name
+		if name == "" {
+			name = a.ID
+		}
+		name = uniqueName(albums, cleanName(name), a.ID)
+		dir := t.dir("albums/"+name, name, a.CreatedAt)
+		albums.addChild(dir)
+		for _, f := range a.Files {
+			parent, p := dir, "albums/"+name
+			segs := strings.Split(f.Path, "/")
+			for _, seg := range segs[:len(segs)-1] {
+				seg = cleanName(seg)
+				next := parent.Child(seg)
+				if next == nil {
+					next = t.dir(p+"/"+seg, seg, a.CreatedAt)
+					parent.addChild(next)
+				} else if !next.Dir {
+					break
+				}
+				parent, p = next, p+"/"+seg
+			}
+			t.add(parent, p, segs[len(segs)-1], f.ID, f.Size, a.CreatedAt)
+		}
+	}
+	for _, n := range t.nodes {
+		sort.Slice(n.Children, func(i, j int) bool { return n.Children[i].Name < n.Children[j].Name })
+	}
+	t.built = time.Now()
+}
+
+// dir 生成目录
+func (t *Tree) dir(p, name string, mtime int64) *Node {
+	n := &Node{Ino: t.inode(p), Name: name, Dir: true, Mtime: mtime, byName: map[string]*Node{}}
+	t.nodes[n.Ino] = n
+	return n
+}
+
+// add 在目录中加入文件，重名时在扩展名前加上文件ID
+func (t *Tree) add(parent *Node, dirPath, name, id string, size, mtime int64) {
+	name = uniqueName(parent, cleanName(name), id)
+	n := &Node{Ino: t.inode(dirPath + "/" + name), Name: name, ID: id, Size: size, Mtime: mtime}
+	t.nodes[n.Ino] = n
+	parent.addChild(n)
+}
+
+func (t *Tree) inode(p string) uint64 {
+	ino, ok := t.inodes[p]
+	if !ok {
+		ino = uint64(len(t.inodes) + 1)
+		t.inodes[p] = ino
+	}
+	return ino
+}
+
+// cleanName 名称中不能包含 / 及空字符
+func cleanName(name string) string {
+	name = strings.NewReplacer("/", "_", "\x00", "_").Replace(name)
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+	return name
+}
+
+// uniqueName 目录中已有同名项时在扩展名前加上 ID
+func uniqueName(parent *Node, name, id string) string {
+	if parent.Child(name) == nil {
+		return name
+	}
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + " (" + id + ")" + ext
+}
@@ -0,0 +1,40 @@
+package vfs
+
+import (
+	"path/filepath"
+	"testing"
+
+	"csz.net/tgstate/store"
+)
+
+func TestTreeBuild(t *testing.T) {
+	st := store.Open(filepath.Join(t.TempDir(), "meta.json"))
+	st.PutFile(store.File{ID: "a1", Name: "note.txt", Size: 3, CreatedAt: 1})
+	st.PutFile(store.File{ID: "b2", Name: "note.txt", Size: 5, CreatedAt: 2})
+	st.PutFile(store.File{ID: "c3", Name: "gone.txt", DeletedAt: 3})
+	st.PutFile(store.File{ID: "d4", Name: "../x", CreatedAt: 4})
+	st.PutAlbum(store.Album{ID: "al", Name: "pics", Files: []store.AlbumFile{{Path: "2024/cat.jpg", ID: "a1", Size: 3}}})
+	tr := NewTree(func() *store.Store { return st })
+	tr.Build(st)
+	files := tr.nodes[RootIno].Child("files")
+	var names []string
+	for _, c := range files.Children {
+		names = append(names, c.Name)
+	}
+	if want := []string{".._x", "note (b2).txt", "note.txt"}; len(names) != len(want) || names[0] != want[0] || names[1] != want[1] || names[2] != want[2] {
+		t.Errorf("files = %q, want %q", names, want)
+	}
+	if n := files.Child("note.txt"); n == nil || n.ID != "a1" || n.Size != 3 {
+		t.Errorf("note.txt = %+v", n)
+	}
+	cat := tr.Lookup("/albums/pics/2024/cat.jpg")
+	if cat == nil || cat.ID != "a1" {
+		t.Fatalf("album file = %+v", cat)
+	}
+	// 重新生成后同一路径的 inode 不变
+	ino := cat.Ino
+	tr.Build(st)
+	if got := tr.nodes[ino]; got == nil || got.Name != "cat.jpg" {
+		t.Errorf("inode %d after rebuild = %+v", ino, got)
+	}
+}